@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"text/template"
+
+	"github.com/Smithx10/go-smartos-mdata/internal/fsutil"
+	"github.com/Smithx10/go-smartos-mdata/internal/systemd"
+	"github.com/Smithx10/go-smartos-mdata/mdata"
+	"github.com/Smithx10/go-smartos-mdata/mdataagent"
+	"github.com/Smithx10/go-smartos-mdata/mdataserver"
+	"github.com/spf13/cobra"
+)
+
+// agentOptions holds the flags shared by the agent command and its subcommands
+type agentOptions struct {
+	configPath    string
+	controlSocket string
+	serviceName   string // Windows service name, also its event log source
+}
+
+// newAgentCmd builds the "agent" command keeping files in sync with metadata
+func newAgentCmd() *cobra.Command {
+	var (
+		opts         agentOptions
+		once         bool
+		events       string
+		healthListen string
+	)
+
+	cmd := &cobra.Command{
+		Use:         "agent",
+		Annotations: map[string]string{ownSignals: "true"},
+		Short:       "Keep files in sync with metadata and run reload hooks on change",
+		Long: `Agent polls metadata on an interval, writes configured keys and rendered
+templates to their destinations, and runs each file's command when its
+content changes. Hooks run commands when keys matching their patterns change,
+with the key and a file holding its new value in the environment, once the
+keys have settled for the hook's debounce delay, and again after a backoff
+when they fail:
+
+    hooks:
+      - keys: ["nginx:*"]
+        on-change: ["systemctl reload nginx"]
+        debounce: 5s
+
+See the mdataagent package for the config file format.
+
+Under systemd the agent reports readiness, pings the watchdog, and accepts
+its control socket through socket activation. On Windows, "agent
+install-service" registers it as a service logging to the event log.
+
+With --health-listen, the agent serves /healthz and /readyz over HTTP for
+service monitors: /healthz fails after repeated failures to reach the
+metadata service, and /readyz checks that it answers now.
+
+Every value the agent reads is kept under ` + mdata.DefaultLastKnownDir + `, or
+the directory MDATA_LAST_KNOWN_DIR names, for commands run with
+--best-effort to fall back on while the metadata service is down. Values of
+keys that look like secrets (see MDATA_SECRET_PATTERNS) are not kept.`,
+		Args: cobra.ExactArgs(0),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := mdataagent.LoadConfig(opts.configPath)
+			if err != nil {
+				return err
+			}
+
+			// Commands run with --best-effort answer from the values read
+			upstreamCfg := clientConfig().WithMiddleware(newLastKnown().Middleware())
+			store := newUpstreamStore(upstreamCfg, !once)
+			defer store.Close()
+			health := mdataserver.NewHealthStore(store)
+
+			agent := mdataagent.New(cfg, health)
+			if events != "" {
+				var w io.WriteCloser = nopCloser{os.Stdout}
+				if events != "-" {
+					f, err := os.OpenFile(events, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+					if err != nil {
+						return fmt.Errorf("failed to open event log: %w", err)
+					}
+					w = f
+				}
+				defer w.Close()
+				agent.Events = mdata.EventWriter(w)
+			}
+			if once {
+				return agent.RunOnce()
+			}
+
+			control, err := controlListener(opts.controlSocket)
+			if err != nil {
+				return err
+			}
+			if control != nil {
+				defer control.Close()
+				go agent.ServeControl(control)
+			}
+			if healthListen != "" {
+				l, err := net.Listen("tcp", healthListen)
+				if err != nil {
+					return fmt.Errorf("failed to listen on %s: %w", healthListen, err)
+				}
+				srv := &http.Server{Handler: health.Handler()}
+				defer srv.Close()
+				go srv.Serve(l)
+			}
+
+			if isService, err := runAgentService(opts.serviceName, agent); isService || err != nil {
+				return err
+			}
+
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			return agent.Run(ctx)
+		},
+	}
+
+	cmd.PersistentFlags().StringVarP(&opts.configPath, "config", "c", "/etc/mdata/agent.yaml", "Agent config file (YAML or JSON)")
+	cmd.PersistentFlags().StringVar(&opts.controlSocket, "control-socket", defaultControlSocket(), "Agent control socket (empty to disable)")
+	cmd.Flags().BoolVar(&once, "once", false, "Sync once and exit")
+	cmd.Flags().StringVar(&healthListen, "health-listen", "", "Serve /healthz and /readyz on this HTTP address")
+	cmd.Flags().StringVar(&events, "events", "", "Append a JSON line for every file written to this file (- for stdout)")
+	cmd.PersistentFlags().StringVar(&opts.serviceName, "service-name", "mdata-agent", "Name of the agent's Windows service")
+	cmd.AddCommand(newAgentCtlCmd(&opts), newAgentInstallUnitCmd(&opts), newAgentInstallSMFCmd(&opts))
+	cmd.AddCommand(newAgentServiceCmds(&opts)...)
+	return cmd
+}
+
+// defaultControlSocket returns the agent control socket path for this platform
+func defaultControlSocket() string {
+	switch runtime.GOOS {
+	case "illumos", "solaris":
+		return "/var/run/mdata-agent.sock"
+	case "windows":
+		return filepath.Join(os.Getenv("ProgramData"), "mdata", "agent.sock")
+	default:
+		return "/run/mdata-agent.sock"
+	}
+}
+
+// controlListener returns the socket-activated control listener, or listens on
+// address when the agent was started directly. It returns nil if address is empty.
+func controlListener(address string) (net.Listener, error) {
+	activated, err := systemd.Listeners()
+	if err != nil {
+		return nil, err
+	}
+	if len(activated) > 0 {
+		for _, extra := range activated[1:] {
+			extra.Close()
+		}
+		return activated[0], nil
+	}
+	if address == "" {
+		return nil, nil
+	}
+	// Remove a stale socket left behind by a previous run
+	if err := os.Remove(address); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to remove stale socket %s: %w", address, err)
+	}
+	l, err := net.Listen("unix", address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on control socket %s: %w", address, err)
+	}
+	return l, nil
+}
+
+// newAgentCtlCmd builds "agent ctl" sending a command to a running agent
+func newAgentCtlCmd(opts *agentOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:       "ctl status|sync",
+		Short:     "Query or trigger a running agent through its control socket",
+		Args:      cobra.ExactArgs(1),
+		ValidArgs: []string{"status", "sync"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			reply, err := mdataagent.Control(opts.controlSocket, args[0])
+			if err != nil {
+				return err
+			}
+			fmt.Println(reply)
+			return nil
+		},
+	}
+}
+
+// systemdServiceUnit is the service unit written by "agent install-unit"
+var systemdServiceUnit = template.Must(template.New("service").Parse(`[Unit]
+Description=SmartOS metadata sync agent
+Documentation=https://github.com/Smithx10/go-smartos-mdata
+Wants=network-online.target
+After=network-online.target
+{{- if .ControlSocket }}
+Requires={{ .Name }}.socket
+After={{ .Name }}.socket
+{{- end }}
+
+[Service]
+Type=notify
+NotifyAccess=main
+ExecStart={{ .Binary }} agent --config {{ .Config }}{{ if .ControlSocket }} --control-socket {{ .ControlSocket }}{{ end }}
+Restart=on-failure
+RestartSec=5s
+WatchdogSec=5min
+
+[Install]
+WantedBy=multi-user.target
+`))
+
+// systemdSocketUnit is the control socket unit written by "agent install-unit"
+var systemdSocketUnit = template.Must(template.New("socket").Parse(`[Unit]
+Description=SmartOS metadata sync agent control socket
+
+[Socket]
+ListenStream={{ .ControlSocket }}
+SocketMode=0600
+
+[Install]
+WantedBy=sockets.target
+`))
+
+// unitFile is a unit written by "agent install-unit"
+type unitFile struct {
+	path string
+	tmpl *template.Template
+}
+
+// newAgentInstallUnitCmd builds "agent install-unit" writing systemd units for the agent
+func newAgentInstallUnitCmd(opts *agentOptions) *cobra.Command {
+	var (
+		unitDir string
+		name    string
+		binary  string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "install-unit",
+		Short: "Write systemd service and socket units for the agent",
+		Args:  cobra.ExactArgs(0),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if binary == "" {
+				exe, err := os.Executable()
+				if err != nil {
+					return fmt.Errorf("failed to locate mdata binary: %w", err)
+				}
+				binary = exe
+			}
+			config, err := filepath.Abs(opts.configPath)
+			if err != nil {
+				return fmt.Errorf("failed to resolve config path: %w", err)
+			}
+			data := struct {
+				Name, Binary, Config, ControlSocket string
+			}{name, binary, config, opts.controlSocket}
+
+			units := []unitFile{{filepath.Join(unitDir, name+".service"), systemdServiceUnit}}
+			if opts.controlSocket != "" {
+				units = append(units, unitFile{filepath.Join(unitDir, name+".socket"), systemdSocketUnit})
+			}
+
+			for _, u := range units {
+				var buf bytes.Buffer
+				if err := u.tmpl.Execute(&buf, data); err != nil {
+					return fmt.Errorf("failed to render %s: %w", u.path, err)
+				}
+				if err := fsutil.WriteFileAtomic(u.path, buf.Bytes(), 0o644); err != nil {
+					return err
+				}
+				fmt.Printf("Wrote %s\n", u.path)
+			}
+			if opts.controlSocket != "" {
+				fmt.Printf("Run: systemctl daemon-reload && systemctl enable --now %s.socket %s.service\n", name, name)
+			} else {
+				fmt.Printf("Run: systemctl daemon-reload && systemctl enable --now %s.service\n", name)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&unitDir, "unit-dir", "/etc/systemd/system", "Directory to write units to")
+	cmd.Flags().StringVar(&name, "name", "mdata-agent", "Unit name")
+	cmd.Flags().StringVar(&binary, "binary", "", "Path to the mdata binary (default: this executable)")
+	return cmd
+}
@@ -0,0 +1,18 @@
+//go:build !windows
+
+package main
+
+import (
+	"github.com/Smithx10/go-smartos-mdata/mdataagent"
+	"github.com/spf13/cobra"
+)
+
+// newAgentServiceCmds returns no commands, as Windows services exist on Windows only
+func newAgentServiceCmds(opts *agentOptions) []*cobra.Command {
+	return nil
+}
+
+// runAgentService reports that the agent does not run as a Windows service
+func runAgentService(name string, agent *mdataagent.Agent) (bool, error) {
+	return false, nil
+}
@@ -0,0 +1,204 @@
+//go:build windows
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Smithx10/go-smartos-mdata/mdataagent"
+	"github.com/spf13/cobra"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/eventlog"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// newAgentServiceCmds builds the commands managing the agent's Windows service
+func newAgentServiceCmds(opts *agentOptions) []*cobra.Command {
+	return []*cobra.Command{newAgentInstallServiceCmd(opts), newAgentUninstallServiceCmd(opts)}
+}
+
+// newAgentInstallServiceCmd builds "agent install-service" registering the agent as a Windows service
+func newAgentInstallServiceCmd(opts *agentOptions) *cobra.Command {
+	var (
+		binary string
+		start  bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "install-service",
+		Short: "Register the agent as a Windows service",
+		Long: `Install-service registers the agent as a Windows service started
+automatically at boot, restarted 5 seconds after it fails, and logging to the
+Application event log under the service name. The service runs this binary
+with the current --config and --control-socket.`,
+		Args: cobra.ExactArgs(0),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if binary == "" {
+				exe, err := os.Executable()
+				if err != nil {
+					return fmt.Errorf("failed to locate the mdata binary: %w", err)
+				}
+				binary = exe
+			}
+			config, err := filepath.Abs(opts.configPath)
+			if err != nil {
+				return err
+			}
+			if opts.controlSocket != "" {
+				if err := os.MkdirAll(filepath.Dir(opts.controlSocket), 0o755); err != nil {
+					return fmt.Errorf("failed to create the control socket directory: %w", err)
+				}
+			}
+
+			m, err := mgr.Connect()
+			if err != nil {
+				return fmt.Errorf("failed to connect to the service manager: %w", err)
+			}
+			defer m.Disconnect()
+			if s, err := m.OpenService(opts.serviceName); err == nil {
+				s.Close()
+				return fmt.Errorf("service %s already exists", opts.serviceName)
+			}
+
+			s, err := m.CreateService(opts.serviceName, binary, mgr.Config{
+				DisplayName: "SmartOS metadata sync agent",
+				Description: "Keeps files in sync with SmartOS metadata and runs reload hooks on change.",
+				StartType:   mgr.StartAutomatic,
+			}, "agent", "--config", config, "--control-socket", opts.controlSocket, "--service-name", opts.serviceName)
+			if err != nil {
+				return fmt.Errorf("failed to create service %s: %w", opts.serviceName, err)
+			}
+			defer s.Close()
+			if err := s.SetRecoveryActions([]mgr.RecoveryAction{{Type: mgr.ServiceRestart, Delay: 5 * time.Second}}, uint32((24 * time.Hour).Seconds())); err != nil {
+				s.Delete()
+				return fmt.Errorf("failed to set recovery actions of %s: %w", opts.serviceName, err)
+			}
+			// A source left behind by an earlier installation would make this fail
+			eventlog.Remove(opts.serviceName)
+			if err := eventlog.InstallAsEventCreate(opts.serviceName, eventlog.Error|eventlog.Warning|eventlog.Info); err != nil {
+				s.Delete()
+				return fmt.Errorf("failed to register event log source %s: %w", opts.serviceName, err)
+			}
+			fmt.Printf("Installed service %s\n", opts.serviceName)
+
+			if start {
+				if err := s.Start(); err != nil {
+					return fmt.Errorf("failed to start service %s: %w", opts.serviceName, err)
+				}
+				fmt.Printf("Started service %s\n", opts.serviceName)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&binary, "binary", "", "Path of the mdata binary the service runs (default: this binary)")
+	cmd.Flags().BoolVar(&start, "start", false, "Start the service once installed")
+	return cmd
+}
+
+// newAgentUninstallServiceCmd builds "agent uninstall-service" removing the agent's Windows service
+func newAgentUninstallServiceCmd(opts *agentOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "uninstall-service",
+		Short: "Stop and remove the agent's Windows service",
+		Args:  cobra.ExactArgs(0),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			m, err := mgr.Connect()
+			if err != nil {
+				return fmt.Errorf("failed to connect to the service manager: %w", err)
+			}
+			defer m.Disconnect()
+			s, err := m.OpenService(opts.serviceName)
+			if err != nil {
+				return fmt.Errorf("failed to open service %s: %w", opts.serviceName, err)
+			}
+			defer s.Close()
+
+			// Stopping a service that is not running fails, which is fine here
+			s.Control(svc.Stop)
+			if err := s.Delete(); err != nil {
+				return fmt.Errorf("failed to remove service %s: %w", opts.serviceName, err)
+			}
+			eventlog.Remove(opts.serviceName)
+			fmt.Printf("Removed service %s\n", opts.serviceName)
+			return nil
+		},
+	}
+}
+
+// runAgentService runs agent as the Windows service name when the process
+// was started by the service manager, logging to the event log, and reports
+// whether it was
+func runAgentService(name string, agent *mdataagent.Agent) (bool, error) {
+	isService, err := svc.IsWindowsService()
+	if err != nil || !isService {
+		return false, err
+	}
+	elog, err := eventlog.Open(name)
+	if err != nil {
+		return true, fmt.Errorf("failed to open event log source %s: %w", name, err)
+	}
+	defer elog.Close()
+	agent.Logger = log.New(eventLogWriter(elog.Info), "", 0)
+	agent.ErrorLogger = log.New(eventLogWriter(elog.Error), "", 0)
+	return true, svc.Run(name, &agentService{agent: agent})
+}
+
+// eventLogWriter writes each message to the event log with an event log
+// method such as Info or Error
+type eventLogWriter func(eid uint32, msg string) error
+
+// agentEventID is the event ID of the agent's event log entries
+const agentEventID = 1
+
+func (w eventLogWriter) Write(p []byte) (int, error) {
+	if err := w(agentEventID, strings.TrimSuffix(string(p), "\n")); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// agentService runs the agent under the service manager
+type agentService struct {
+	agent *mdataagent.Agent
+}
+
+// Execute implements svc.Handler, running the agent until the service is stopped
+func (s *agentService) Execute(args []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- s.agent.Run(ctx) }()
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+
+	for {
+		select {
+		case err := <-done:
+			if err != nil {
+				s.agent.ErrorLogger.Printf("mdata agent: %v", err)
+				return true, 1
+			}
+			return false, 0
+		case req := <-requests:
+			switch req.Cmd {
+			case svc.Interrogate:
+				status <- req.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending}
+				cancel()
+				if err := <-done; err != nil && !errors.Is(err, context.Canceled) {
+					s.agent.ErrorLogger.Printf("mdata agent: %v", err)
+				}
+				return false, 0
+			}
+		}
+	}
+}
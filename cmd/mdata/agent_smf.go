@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/Smithx10/go-smartos-mdata/internal/fsutil"
+	"github.com/Smithx10/go-smartos-mdata/mdata"
+	"github.com/spf13/cobra"
+)
+
+// smfManifest is the service manifest written by "agent install-smf"
+var smfManifest = template.Must(template.New("manifest").Funcs(template.FuncMap{"xml": xmlEscape}).Parse(`<?xml version="1.0"?>
+<!DOCTYPE service_bundle SYSTEM "/usr/share/lib/xml/dtd/service_bundle.dtd.1">
+<service_bundle type="manifest" name="{{ .Name | xml }}">
+  <service name="site/{{ .Name | xml }}" type="service" version="1">
+    <create_default_instance enabled="false"/>
+    <single_instance/>
+
+    <dependency name="filesystem" grouping="require_all" restart_on="none" type="service">
+      <service_fmri value="svc:/system/filesystem/local"/>
+    </dependency>
+    <dependency name="network" grouping="require_all" restart_on="error" type="service">
+      <service_fmri value="svc:/milestone/network:default"/>
+    </dependency>
+    <!-- Let the platform's own mdata services run first where they exist -->
+    <dependency name="mdata-fetch" grouping="optional_all" restart_on="none" type="service">
+      <service_fmri value="svc:/smartdc/mdata:fetch"/>
+    </dependency>
+{{- if .MetadataSocket }}
+    <dependency name="metadata-socket" grouping="require_all" restart_on="none" type="path">
+      <service_fmri value="file://localhost{{ .MetadataSocket | xml }}"/>
+    </dependency>
+{{- end }}
+
+    <exec_method type="method" name="start" exec="{{ .Method | xml }} start" timeout_seconds="60"/>
+    <exec_method type="method" name="stop" exec=":kill" timeout_seconds="60"/>
+    <exec_method type="method" name="refresh" exec="{{ .Method | xml }} refresh" timeout_seconds="120"/>
+
+    <property_group name="startd" type="framework">
+      <propval name="ignore_error" type="astring" value="core,signal"/>
+    </property_group>
+    <property_group name="mdata" type="application">
+      <propval name="config" type="astring" value="{{ .Config | xml }}"/>
+      <propval name="control_socket" type="astring" value="{{ .ControlSocket | xml }}"/>
+    </property_group>
+
+    <stability value="Evolving"/>
+    <template>
+      <common_name>
+        <loctext xml:lang="C">SmartOS metadata sync agent</loctext>
+      </common_name>
+      <documentation>
+        <doc_link name="go-smartos-mdata" uri="https://github.com/Smithx10/go-smartos-mdata"/>
+      </documentation>
+    </template>
+  </service>
+</service_bundle>
+`))
+
+// smfMethod is the method script written by "agent install-smf"
+var smfMethod = template.Must(template.New("method").Funcs(template.FuncMap{"sh": shellQuote}).Parse(`#!/sbin/sh
+#
+# SMF method for the mdata sync agent, generated by "mdata agent install-smf".
+#
+
+. /lib/svc/share/smf_include.sh
+
+MDATA={{ .Binary | sh }}
+CONFIG="$(svcprop -p mdata/config "$SMF_FMRI")"
+CONTROL_SOCKET="$(svcprop -p mdata/control_socket "$SMF_FMRI")"
+
+case "$1" in
+start)
+	if [ ! -x "$MDATA" ]; then
+		echo "$MDATA is not executable"
+		exit $SMF_EXIT_ERR_CONFIG
+	fi
+	if [ ! -r "$CONFIG" ]; then
+		echo "agent config $CONFIG is not readable"
+		exit $SMF_EXIT_ERR_CONFIG
+	fi
+	"$MDATA" agent --config "$CONFIG" --control-socket "$CONTROL_SOCKET" &
+	;;
+refresh)
+	"$MDATA" agent ctl sync --control-socket "$CONTROL_SOCKET" || exit $SMF_EXIT_ERR_FATAL
+	;;
+*)
+	echo "Usage: $0 {start|refresh}"
+	exit $SMF_EXIT_ERR_CONFIG
+	;;
+esac
+
+exit $SMF_EXIT_OK
+`))
+
+// newAgentInstallSMFCmd builds "agent install-smf" writing an SMF manifest and method script
+func newAgentInstallSMFCmd(opts *agentOptions) *cobra.Command {
+	var (
+		manifestDir string
+		methodDir   string
+		name        string
+		binary      string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "install-smf",
+		Short: "Write an SMF manifest and method script for the agent",
+		Args:  cobra.ExactArgs(0),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if binary == "" {
+				exe, err := os.Executable()
+				if err != nil {
+					return fmt.Errorf("failed to locate mdata binary: %w", err)
+				}
+				binary = exe
+			}
+			config, err := filepath.Abs(opts.configPath)
+			if err != nil {
+				return fmt.Errorf("failed to resolve config path: %w", err)
+			}
+
+			// Only zones reach metadata over a socket; VM guests use a serial device
+			var metadataSocket string
+			if cfg := mdata.DefaultClientConfig(); cfg.SocketConfig != nil && cfg.SocketConfig.Network == "unix" {
+				metadataSocket = cfg.SocketConfig.Address
+			}
+
+			manifestPath := filepath.Join(manifestDir, name+".xml")
+			methodPath := filepath.Join(methodDir, name)
+			data := struct {
+				Name, Binary, Config, ControlSocket, MetadataSocket, Method string
+			}{name, binary, config, opts.controlSocket, metadataSocket, methodPath}
+
+			for _, f := range []struct {
+				path string
+				tmpl *template.Template
+				mode os.FileMode
+			}{
+				{methodPath, smfMethod, 0o755},
+				{manifestPath, smfManifest, 0o444},
+			} {
+				var buf bytes.Buffer
+				if err := f.tmpl.Execute(&buf, data); err != nil {
+					return fmt.Errorf("failed to render %s: %w", f.path, err)
+				}
+				if err := os.MkdirAll(filepath.Dir(f.path), 0o755); err != nil {
+					return fmt.Errorf("failed to create %s: %w", filepath.Dir(f.path), err)
+				}
+				if err := fsutil.WriteFileAtomic(f.path, buf.Bytes(), f.mode); err != nil {
+					return err
+				}
+				fmt.Printf("Wrote %s\n", f.path)
+			}
+			fmt.Printf("Run: svccfg import %s && svcadm enable site/%s\n", manifestPath, name)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&manifestDir, "manifest-dir", "/var/svc/manifest/site", "Directory to write the manifest to")
+	cmd.Flags().StringVar(&methodDir, "method-dir", "/opt/local/lib/svc/method", "Directory to write the method script to")
+	cmd.Flags().StringVar(&name, "name", "mdata-agent", "Service name (under site/)")
+	cmd.Flags().StringVar(&binary, "binary", "", "Path to the mdata binary (default: this executable)")
+	return cmd
+}
+
+// xmlEscape escapes s for use in XML text and attribute values
+func xmlEscape(s string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
+
+// shellQuote quotes s as a single POSIX shell word
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
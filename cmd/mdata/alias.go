@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// expandAlias replaces the command name in args by its expansion when it is
+// an alias of the config file and not a built-in command. Expansions are not
+// expanded again, so an alias may be named after the command it runs.
+func expandAlias(root *cobra.Command, args []string) ([]string, error) {
+	cfg, err := readConfigFile()
+	if err != nil || len(cfg.Aliases) == 0 {
+		// loadSerialSettings reports errors reading the config
+		return args, nil
+	}
+	i := commandIndex(root, args)
+	if i < 0 {
+		return args, nil
+	}
+	name := args[i]
+	expansion, ok := cfg.Aliases[name]
+	if !ok {
+		return args, nil
+	}
+	if cmd, _, err := root.Find([]string{name}); err == nil && cmd != root {
+		return args, nil
+	}
+	words, err := splitWords(expansion)
+	if err != nil {
+		return nil, fmt.Errorf("invalid alias %s: %w", name, err)
+	}
+	if len(words) == 0 {
+		return nil, fmt.Errorf("alias %s is empty", name)
+	}
+	expanded := append(append(args[:i:i], words...), args[i+1:]...)
+	return expanded, nil
+}
+
+// commandIndex returns the index of the command name in args, after the
+// flags of root and their values, or -1 if there is none
+func commandIndex(root *cobra.Command, args []string) int {
+	flags := root.PersistentFlags()
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--":
+			// cobra does not look for commands after it either
+			return -1
+		case strings.HasPrefix(arg, "--"):
+			if f := flags.Lookup(arg[2:]); f != nil && f.NoOptDefVal == "" {
+				i++ // skip its value
+			}
+		case strings.HasPrefix(arg, "-") && len(arg) == 2:
+			if f := flags.ShorthandLookup(arg[1:]); f != nil && f.NoOptDefVal == "" {
+				i++
+			}
+		case strings.HasPrefix(arg, "-"):
+		default:
+			return i
+		}
+	}
+	return -1
+}
+
+// splitWords splits s into words at spaces, as a shell does without
+// expansions: single quotes keep everything up to the next single quote, and
+// double quotes keep everything up to the next double quote except
+// backslashes escaping a double quote or backslash
+func splitWords(s string) ([]string, error) {
+	var (
+		words  []string
+		word   strings.Builder
+		inWord bool
+	)
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		case c == '\'':
+			end := strings.IndexByte(s[i+1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated single quote")
+			}
+			word.WriteString(s[i+1 : i+1+end])
+			i += end + 1
+			inWord = true
+		case c == '"':
+			i++
+			for ; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) && (s[i+1] == '"' || s[i+1] == '\\') {
+					i++
+				}
+				word.WriteByte(s[i])
+			}
+			if i == len(s) {
+				return nil, fmt.Errorf("unterminated double quote")
+			}
+			inWord = true
+		case c == '\\' && i+1 < len(s):
+			i++
+			word.WriteByte(s[i])
+			inWord = true
+		default:
+			word.WriteByte(c)
+			inWord = true
+		}
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
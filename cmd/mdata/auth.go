@@ -0,0 +1,24 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// readSecretFile reads a shared secret or token, ignoring surrounding
+// whitespace. An empty path returns nil, disabling authentication.
+func readSecretFile(path string) ([]byte, error) {
+	if path == "" {
+		return nil, nil
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secret: %w", err)
+	}
+	secret := strings.TrimSpace(string(raw))
+	if secret == "" {
+		return nil, fmt.Errorf("secret file %s is empty", path)
+	}
+	return []byte(secret), nil
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Smithx10/go-smartos-mdata/internal/fsutil"
+	"github.com/Smithx10/go-smartos-mdata/mdata"
+	"github.com/spf13/cobra"
+)
+
+// backupVersion is the version of the backup format written
+const backupVersion = 1
+
+// backup is a snapshot of the customer metadata of an instance
+type backup struct {
+	Version int         `json:"version"`
+	Created time.Time   `json:"created"`
+	UUID    string      `json:"uuid,omitempty"` // sdc:uuid of the instance backed up
+	Keys    []backupKey `json:"keys"`
+}
+
+// backupKey is one key of a backup. The value is base64 encoded so that any
+// bytes survive, and checked against its SHA-256 on restore.
+type backupKey struct {
+	Key    string `json:"key"`
+	Value  string `json:"value"`
+	SHA256 string `json:"sha256"`
+}
+
+// newBackupCmd builds the "backup" command saving all customer metadata
+func newBackupCmd() *cobra.Command {
+	var (
+		output   string
+		parallel int
+	)
+
+	cmd := &cobra.Command{
+		Use:   "backup",
+		Short: "Save all customer metadata to a file",
+		Long: `Backup saves every listed key and its value as JSON, for "mdata restore".
+Values are base64 encoded and stored with their SHA-256. The output is gzip
+compressed when its name ends in .gz, and readable by its owner only, as
+metadata usually holds credentials.
+
+With --parallel N, values are fetched over N connections at once, which is
+much faster for many keys over the zone socket or TCP. Over the serial port
+they are always fetched one at a time.`,
+		Args: cobra.ExactArgs(0),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runCommand(func(client mdata.MetadataClient) (string, error) {
+				b := backup{Version: backupVersion, Created: time.Now().UTC()}
+				if uuid, err := client.Get("sdc:uuid"); err == nil {
+					b.UUID = uuid
+				}
+				values, err := fetchAll(client, parallel)
+				if err != nil {
+					return "", err
+				}
+				for key, value := range values {
+					b.Keys = append(b.Keys, backupKey{
+						Key:    key,
+						Value:  base64.StdEncoding.EncodeToString([]byte(value)),
+						SHA256: mdata.HashValue([]byte(value)),
+					})
+				}
+				sort.Slice(b.Keys, func(i, j int) bool { return b.Keys[i].Key < b.Keys[j].Key })
+
+				data, err := json.MarshalIndent(b, "", "  ")
+				if err != nil {
+					return "", err
+				}
+				data = append(data, '\n')
+				if strings.HasSuffix(output, ".gz") {
+					var buf bytes.Buffer
+					zw := gzip.NewWriter(&buf)
+					zw.Write(data)
+					if err := zw.Close(); err != nil {
+						return "", err
+					}
+					data = buf.Bytes()
+				}
+				if output == "-" {
+					_, err := os.Stdout.Write(data)
+					return "", err
+				}
+				if err := fsutil.WriteFileAtomic(output, data, 0o600); err != nil {
+					return "", err
+				}
+				return fmt.Sprintf("saved %d keys to %s", len(b.Keys), output), nil
+			})
+		},
+	}
+
+	cmd.Flags().StringVarP(&output, "output", "o", "-", "File to write, compressed if it ends in .gz (- for stdout)")
+	cmd.Flags().IntVar(&parallel, "parallel", 1, "Fetch values over this many connections at once (socket transports only)")
+	return cmd
+}
+
+// fetchAll returns every listed key and its value, fetched over up to
+// parallel connections besides client
+func fetchAll(client mdata.MetadataClient, parallel int) (map[string]string, error) {
+	values := make(map[string]string)
+	if parallel <= 1 {
+		all, errf := mdata.All(client)
+		for key, value := range all {
+			values[key] = value
+		}
+		return values, errf()
+	}
+
+	raw, err := client.Keys()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list keys: %w", err)
+	}
+	var keys []string
+	for _, key := range strings.Split(raw, "\n") {
+		if key != "" {
+			keys = append(keys, key)
+		}
+	}
+	pool := mdata.NewPool(clientConfig(), parallel)
+	defer pool.Close()
+	return pool.GetMany(keys)
+}
+
+// newRestoreCmd builds the "restore" command writing back a backup
+func newRestoreCmd() *cobra.Command {
+	var overwrite, skipExisting bool
+
+	cmd := &cobra.Command{
+		Use:   "restore FILE",
+		Short: "Write back metadata saved by backup",
+		Long: `Restore writes the keys in a file saved by "mdata backup" (- for stdin),
+compressed or not. Every checksum is verified before anything is written.
+
+Keys that exist with a different value are conflicts: by default restore
+reports them and writes nothing, --overwrite replaces them and
+--skip-existing keeps them. Keys that are not in the backup are left alone.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			b, err := readBackup(args[0])
+			if err != nil {
+				return err
+			}
+			return runCommand(func(client mdata.MetadataClient) (string, error) {
+				var writes, conflicts []string
+				values := make(map[string]string, len(b.Keys))
+				unchanged, skipped := 0, 0
+				for _, k := range b.Keys {
+					value, _ := base64.StdEncoding.DecodeString(k.Value)
+					values[k.Key] = string(value)
+					current, exists, err := currentValue(client, k.Key)
+					if err != nil {
+						return "", err
+					}
+					switch {
+					case !exists:
+						writes = append(writes, k.Key)
+					case current == string(value):
+						unchanged++
+					case overwrite:
+						writes = append(writes, k.Key)
+					case skipExisting:
+						skipped++
+					default:
+						conflicts = append(conflicts, k.Key)
+					}
+				}
+				if len(conflicts) > 0 {
+					return "", fmt.Errorf("%d keys exist with different values, use --overwrite or --skip-existing: %s", len(conflicts), strings.Join(conflicts, ", "))
+				}
+
+				for _, key := range writes {
+					if err := client.Put(key, values[key]); err != nil {
+						return "", fmt.Errorf("failed to restore %s: %w", key, err)
+					}
+				}
+				return fmt.Sprintf("restored %d keys (%d unchanged, %d skipped)", len(writes), unchanged, skipped), nil
+			})
+		},
+	}
+
+	cmd.Flags().BoolVar(&overwrite, "overwrite", false, "Replace keys that exist with a different value")
+	cmd.Flags().BoolVar(&skipExisting, "skip-existing", false, "Keep keys that exist with a different value")
+	cmd.MarkFlagsMutuallyExclusive("overwrite", "skip-existing")
+	return cmd
+}
+
+// readBackup reads and verifies a backup from path, or stdin for "-"
+func readBackup(path string) (*backup, error) {
+	var (
+		data []byte
+		err  error
+	)
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup: %w", err)
+	}
+	// gzip streams start with 0x1f 0x8b
+	if bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read backup: %w", err)
+		}
+		if data, err = io.ReadAll(zr); err != nil {
+			return nil, fmt.Errorf("failed to read backup: %w", err)
+		}
+	}
+
+	var b backup
+	if err := json.Unmarshal(data, &b); err != nil {
+		return nil, fmt.Errorf("invalid backup: %w", err)
+	}
+	if b.Version != backupVersion {
+		return nil, fmt.Errorf("unsupported backup version %d", b.Version)
+	}
+	var errs []error
+	for _, k := range b.Keys {
+		value, err := base64.StdEncoding.DecodeString(k.Value)
+		switch {
+		case k.Key == "":
+			errs = append(errs, errors.New("backup holds a key without a name"))
+		case err != nil:
+			errs = append(errs, fmt.Errorf("invalid value of %s: %w", k.Key, err))
+		case mdata.HashValue(value) != k.SHA256:
+			errs = append(errs, fmt.Errorf("checksum mismatch for %s", k.Key))
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, fmt.Errorf("backup %s is corrupt: %w", path, err)
+	}
+	return &b, nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Smithx10/go-smartos-mdata/mdata"
+	"github.com/spf13/pflag"
+)
+
+// bestEffortTimeout bounds each wait on the metadata service in best-effort
+// mode, in place of the timeouts of the transport
+const bestEffortTimeout = 3 * time.Second
+
+// bestEffort, set by --best-effort or MDATA_BEST_EFFORT, answers reads from
+// the last-known values while the metadata service is down
+var bestEffort bool
+
+// addBestEffortFlag adds the flag selecting best-effort mode to flags
+func addBestEffortFlag(flags *pflag.FlagSet) {
+	flags.BoolVar(&bestEffort, "best-effort", os.Getenv("MDATA_BEST_EFFORT") != "", "Answer reads from the last-known values when the metadata service does not answer promptly (env MDATA_BEST_EFFORT)")
+}
+
+// lastKnownDir returns the last-known values directory named by
+// MDATA_LAST_KNOWN_DIR, or the default
+func lastKnownDir() string {
+	if dir := os.Getenv("MDATA_LAST_KNOWN_DIR"); dir != "" {
+		return dir
+	}
+	return mdata.DefaultLastKnownDir
+}
+
+// staleWarned holds the keys whose stale values were reported
+var staleWarned sync.Map
+
+// warnStale reports on stderr, once per key, a value answered from the
+// last-known values
+func warnStale(ev mdata.StaleEvent) {
+	what := "the keys"
+	if ev.Code == "GET" {
+		what = "the value of " + ev.Key
+	}
+	if _, warned := staleWarned.LoadOrStore(what, true); warned {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "mdata: using %s last known at %s: %v\n", what, ev.Saved.Local().Format(time.RFC3339), ev.Err)
+}
+
+// applyBestEffort sets cfg to answer from the last-known values, waiting on
+// the metadata service, or any transport it falls back to, no longer than
+// bestEffortTimeout
+func applyBestEffort(cfg *mdata.ClientConfig) {
+	*cfg = cfg.WithMaxTimeout(bestEffortTimeout)
+	cfg.BestEffort = newLastKnown()
+	cfg.BestEffort.Stale = warnStale
+}
+
+// newLastKnown returns the last-known values of lastKnownDir, leaving out
+// those of keys that look like secrets
+func newLastKnown() *mdata.LastKnown {
+	return &mdata.LastKnown{Dir: lastKnownDir(), Redact: mdata.DefaultRedactor()}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Smithx10/go-smartos-mdata/mdatabridge"
+	"github.com/Smithx10/go-smartos-mdata/mdataserver"
+	"github.com/spf13/cobra"
+)
+
+// bridgeOptions holds the flags shared by the bridge subcommands
+type bridgeOptions struct {
+	interval  time.Duration
+	once      bool
+	direction string
+	conflict  string
+	extraKeys []string
+	keyPrefix string
+	dryRun    bool
+}
+
+// newBridgeCmd builds the "bridge" command mirroring metadata into external systems
+func newBridgeCmd() *cobra.Command {
+	var opts bridgeOptions
+
+	cmd := &cobra.Command{
+		Use:         "bridge",
+		Annotations: map[string]string{ownSignals: "true"},
+		Short:       "Mirror metadata into external key/value systems",
+		Long: `Bridge keeps a key/value prefix in an external system in sync with metadata.
+
+One-way bridges copy metadata to the target and remove target keys that are
+no longer in metadata. Bidirectional bridges also copy target changes back into
+metadata; keys changed on both sides between passes are resolved by --conflict
+(metadata, target or skip). Keys named with --key, such as sdc:uuid, are
+mirrored one-way only.
+
+With --dry-run, a single pass logs the changes it would make on either side
+without making them.`,
+	}
+
+	cmd.PersistentFlags().DurationVar(&opts.interval, "interval", 30*time.Second, "Time between sync passes")
+	cmd.PersistentFlags().BoolVar(&opts.once, "once", false, "Sync once and exit")
+	cmd.PersistentFlags().StringVar(&opts.direction, "direction", string(mdatabridge.OneWay), "Sync direction: one-way or bidirectional")
+	cmd.PersistentFlags().StringVar(&opts.conflict, "conflict", string(mdatabridge.PreferMetadata), "Conflict policy for bidirectional sync: metadata, target or skip")
+	cmd.PersistentFlags().StringArrayVar(&opts.extraKeys, "key", nil, "Also mirror this key, e.g. sdc:uuid (repeatable)")
+	cmd.PersistentFlags().StringVar(&opts.keyPrefix, "key-prefix", "", "Only mirror metadata keys starting with this prefix")
+	cmd.PersistentFlags().BoolVar(&opts.dryRun, "dry-run", false, "Log what one pass would change without writing (implies --once)")
+	cmd.AddCommand(newBridgeConsulCmd(&opts), newBridgeEtcdCmd(&opts))
+	return cmd
+}
+
+// runBridge syncs target with the metadata channel as configured by opts.
+// keepAlive, when set, runs alongside the sync loop until shutdown.
+func runBridge(opts *bridgeOptions, target mdataserver.Store, keepAlive func(context.Context, *mdatabridge.Bridge)) error {
+	store := newUpstreamStore(clientConfig(), !opts.once && !opts.dryRun)
+	defer store.Close()
+
+	bridge, err := mdatabridge.New(store, target, mdatabridge.Options{
+		Direction: mdatabridge.Direction(opts.direction),
+		Conflict:  mdatabridge.ConflictPolicy(opts.conflict),
+		ExtraKeys: opts.extraKeys,
+		KeyPrefix: opts.keyPrefix,
+		DryRun:    opts.dryRun,
+	})
+	if err != nil {
+		return err
+	}
+	if opts.once || opts.dryRun {
+		return bridge.Sync()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if keepAlive != nil {
+		go keepAlive(ctx, bridge)
+	}
+	return bridge.Run(ctx, opts.interval)
+}
+
+// newBridgeConsulCmd builds "bridge consul" mirroring metadata into Consul KV
+func newBridgeConsulCmd(opts *bridgeOptions) *cobra.Command {
+	var (
+		cfg       mdatabridge.ConsulConfig
+		tokenFile string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "consul",
+		Short: "Mirror metadata into Consul KV",
+		Args:  cobra.ExactArgs(0),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			token, err := readSecretFile(tokenFile)
+			if err != nil {
+				return err
+			}
+			cfg.Token = string(token)
+			return runBridge(opts, mdatabridge.NewConsulStore(cfg), nil)
+		},
+	}
+
+	cmd.Flags().StringVar(&cfg.Prefix, "prefix", "smartos/", "KV prefix owned by the bridge")
+	cmd.Flags().StringVar(&cfg.Address, "address", "", "Consul HTTP API address (default $CONSUL_HTTP_ADDR or http://127.0.0.1:8500)")
+	cmd.Flags().StringVar(&cfg.Datacenter, "datacenter", "", "Consul datacenter (default: the agent's)")
+	cmd.Flags().StringVar(&tokenFile, "token-file", "", "File containing the ACL token (default $CONSUL_HTTP_TOKEN)")
+	return cmd
+}
+
+// newBridgeEtcdCmd builds "bridge etcd" mirroring metadata into etcd under a lease
+func newBridgeEtcdCmd(opts *bridgeOptions) *cobra.Command {
+	var (
+		cfg          mdatabridge.EtcdConfig
+		prefix       string
+		nodeID       string
+		passwordFile string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "etcd",
+		Short: "Mirror metadata into etcd under a per-node prefix",
+		Long: `Etcd mirrors metadata to PREFIX/NODE-ID/KEY through the etcd v3 JSON gateway.
+NODE-ID defaults to sdc:uuid. Every key is attached to a lease refreshed
+while the bridge runs, so a node's keys disappear within --lease-ttl of the
+bridge stopping, and immediately when it shuts down cleanly.`,
+		Args: cobra.ExactArgs(0),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if nodeID == "" {
+				id, err := lookupKey("sdc:uuid")
+				if err != nil {
+					return fmt.Errorf("failed to determine node ID (set --node-id): %w", err)
+				}
+				nodeID = id
+			}
+			password, err := readSecretFile(passwordFile)
+			if err != nil {
+				return err
+			}
+			cfg.Password = string(password)
+			cfg.Prefix = strings.TrimSuffix(prefix, "/") + "/" + nodeID + "/"
+
+			target := mdatabridge.NewEtcdStore(cfg)
+			if opts.once {
+				return runBridge(opts, target, nil)
+			}
+			defer target.Close()
+			return runBridge(opts, target, func(ctx context.Context, bridge *mdatabridge.Bridge) {
+				target.KeepAlive(ctx, func() {
+					log.Printf("mdata bridge: etcd lease expired, rewriting keys")
+					bridge.Reset()
+					if err := bridge.Sync(); err != nil {
+						log.Printf("mdata bridge: %v", err)
+					}
+				})
+			})
+		},
+	}
+
+	cmd.Flags().StringSliceVar(&cfg.Endpoints, "endpoints", nil, "etcd client URLs (default $ETCDCTL_ENDPOINTS or http://127.0.0.1:2379)")
+	cmd.Flags().StringVar(&prefix, "prefix", "/smartos/nodes", "Key prefix under which each node gets its own directory")
+	cmd.Flags().StringVar(&nodeID, "node-id", "", "Node directory name (default: sdc:uuid)")
+	cmd.Flags().DurationVar(&cfg.LeaseTTL, "lease-ttl", 60*time.Second, "Lifetime of the lease attached to mirrored keys")
+	cmd.Flags().StringVar(&cfg.Username, "user", "", "etcd user name, when authentication is enabled")
+	cmd.Flags().StringVar(&passwordFile, "password-file", "", "File containing the etcd user's password")
+	return cmd
+}
+
+// lookupKey reads a single key from the metadata channel
+func lookupKey(key string) (string, error) {
+	store := newUpstreamStore(clientConfig(), false)
+	defer store.Close()
+	value, ok, err := store.Get(key)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", fmt.Errorf("%s is not set", key)
+	}
+	return value, nil
+}
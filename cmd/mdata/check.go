@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Smithx10/go-smartos-mdata/mdata"
+	"github.com/spf13/cobra"
+)
+
+// newCheckCmd builds the "check" command testing that the metadata service answers
+func newCheckCmd() *cobra.Command {
+	var wait time.Duration
+
+	cmd := &cobra.Command{
+		Use:   "check",
+		Short: "Check that the metadata service answers",
+		Long: `Check connects to the metadata service and exits non-zero when it cannot.
+
+With --wait it first retries, with backoff, for up to the given duration: until
+the zone's metadata socket appears or the host answers on the serial port.
+Services starting early in boot can run "mdata check --wait 2m" before they
+read metadata, rather than racing the metadata service.`,
+		Args: cobra.ExactArgs(0),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if wait > 0 {
+				ctx, cancel := context.WithTimeout(cmd.Context(), wait)
+				defer cancel()
+				var err error
+				if remoteConfig != nil || zoneConfig != nil || serialOverride != nil {
+					err = mdata.WaitForMetadataConfig(ctx, clientConfig())
+				} else {
+					err = mdata.WaitForMetadata(ctx)
+				}
+				if err != nil {
+					return err
+				}
+			}
+			return runCommand(func(client mdata.MetadataClient) (string, error) {
+				return fmt.Sprintf("metadata service available over %s", clientConfig().Transport), nil
+			})
+		},
+	}
+
+	cmd.Flags().DurationVar(&wait, "wait", 0, "Wait up to this long for the metadata service to become available")
+	return cmd
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/Smithx10/go-smartos-mdata/mdatacloudinit"
+	"github.com/spf13/cobra"
+)
+
+// newCloudInitCmd builds the "cloudinit-local" command writing a cloud-init seed from metadata
+func newCloudInitCmd() *cobra.Command {
+	layout := mdatacloudinit.DefaultLayout()
+
+	cmd := &cobra.Command{
+		Use:   "cloudinit-local",
+		Short: "Write metadata as a cloud-init NoCloud seed",
+		Long: `Cloudinit-local lays out metadata the way cloud-init's SmartOS datasource
+would, for images whose cloud-init lacks that datasource. Run it before
+cloud-init's local stage on every boot.
+
+The NoCloud seed gets meta-data, network-config (from sdc:nics, sdc:resolvers
+and sdc:routes), user-data from cloud-init:user-data, and vendor-data from
+sdc:vendor-data. The user-data key is not cloud-init user-data; as with the
+datasource it is written to mdata-user-data in the legacy directory. The
+user-script and sdc:operator-script keys are written to the instance data
+directory and, unless sdc:vendor-data replaces the default vendor-data, run
+on every boot.`,
+		Args: cobra.ExactArgs(0),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			store := newUpstreamStore(clientConfig(), true)
+			defer store.Close()
+
+			seed, err := mdatacloudinit.Build(store, layout)
+			if err != nil {
+				return err
+			}
+			written, err := seed.Write(layout)
+			for _, path := range written {
+				fmt.Printf("Wrote %s\n", path)
+			}
+			return err
+		},
+	}
+
+	cmd.Flags().StringVar(&layout.CloudDir, "cloud-dir", layout.CloudDir, "cloud-init state directory")
+	cmd.Flags().StringVar(&layout.SeedDir, "seed-dir", layout.SeedDir, "Directory to write the NoCloud seed to")
+	cmd.Flags().StringVar(&layout.LegacyDir, "legacy-dir", layout.LegacyDir, "Directory for mdata-user-data and the user-script link")
+	return cmd
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// confirm asks question on the terminal, after listing details, and fails
+// unless the answer is yes. With yes set it succeeds without asking; otherwise
+// stdin must be a terminal, so that scripts fail instead of hanging or taking
+// an answer from a pipe.
+func confirm(question string, details []string, yes bool) error {
+	if yes {
+		return nil
+	}
+	if !isTerminal(os.Stdin) {
+		return fmt.Errorf("refusing to %s without confirmation: stdin is not a terminal, pass --yes", question)
+	}
+	for _, line := range details {
+		fmt.Fprintf(os.Stderr, "  %s\n", line)
+	}
+	fmt.Fprintf(os.Stderr, "%s? [y/N] ", strings.ToUpper(question[:1])+question[1:])
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return nil
+	}
+	return errors.New("aborted")
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"github.com/Smithx10/go-smartos-mdata/mdata"
+	"github.com/Smithx10/go-smartos-mdata/mdataserver"
+	"github.com/spf13/cobra"
+)
+
+// bypassDaemon, set by the daemon itself, keeps clientConfig from
+// connecting through a running daemon
+var bypassDaemon bool
+
+// newDaemonCmd builds the "daemon" command caching metadata for the CLI
+func newDaemonCmd() *cobra.Command {
+	var (
+		socket string
+		ttl    time.Duration
+	)
+
+	cmd := &cobra.Command{
+		Use:         "daemon",
+		Annotations: map[string]string{ownSignals: "true"},
+		Short:       "Keep a metadata connection open and cache answers for the CLI",
+		Long: `Daemon holds one connection to the metadata service open and serves the
+V2 protocol on a unix socket readable by its owner only, answering repeated
+reads from a cache for --ttl. While it runs, other mdata commands connect
+through it instead of the metadata service, which turns round trips of
+seconds over the serial port into local calls of milliseconds. Writes go
+through at once and are seen by later reads; changes made elsewhere show up
+once the cached answers expire.
+
+Commands use the daemon listening on MDATA_DAEMON_SOCKET, or on the default
+socket, unless --zone, a serial setting or MDATA_SOCKET selects the metadata
+service, or MDATA_NO_DAEMON is set.`,
+		Args: cobra.ExactArgs(0),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if ttl < 0 {
+				return fmt.Errorf("invalid --ttl %s", ttl)
+			}
+			bypassDaemon = true
+			cfg := clientConfig()
+			if cfg.SocketConfig != nil && cfg.SocketConfig.Address == socket {
+				return fmt.Errorf("refusing to serve %s from itself; unset MDATA_SOCKET", socket)
+			}
+			// Commands connecting through the daemon audit and record history themselves
+			cfg.Audit, cfg.Middleware = nil, nil
+
+			upstream := newUpstreamStore(cfg, true)
+			defer upstream.Close()
+
+			if err := os.MkdirAll(filepath.Dir(socket), 0o755); err != nil {
+				return fmt.Errorf("failed to create the socket directory: %w", err)
+			}
+			// Remove a stale socket left behind by a previous run
+			if err := os.Remove(socket); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to remove stale socket %s: %w", socket, err)
+			}
+			l, err := net.Listen("unix", socket)
+			if err != nil {
+				return fmt.Errorf("failed to listen on %s: %w", socket, err)
+			}
+			// Metadata usually holds credentials
+			if err := os.Chmod(socket, 0o600); err != nil {
+				l.Close()
+				return fmt.Errorf("failed to restrict access to %s: %w", socket, err)
+			}
+
+			srv := mdataserver.NewServer(mdataserver.NewCacheStore(upstream, ttl))
+			onShutdown(func() { srv.Close() })
+			if err := srv.Serve(l); err != mdataserver.ErrServerClosed {
+				return err
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&socket, "socket", daemonSocket(), "Socket to serve on")
+	cmd.Flags().DurationVar(&ttl, "ttl", 10*time.Second, "How long answers are cached (0 disables caching)")
+	return cmd
+}
+
+// daemonSocket returns the socket of the daemon, MDATA_DAEMON_SOCKET or the
+// default for this platform
+func daemonSocket() string {
+	if socket := os.Getenv("MDATA_DAEMON_SOCKET"); socket != "" {
+		return socket
+	}
+	switch runtime.GOOS {
+	case "illumos", "solaris":
+		return "/var/run/mdata-daemon.sock"
+	case "windows":
+		return filepath.Join(os.Getenv("ProgramData"), "mdata", "daemon.sock")
+	default:
+		return "/run/mdata-daemon.sock"
+	}
+}
+
+// daemonDetected caches the result of detectDaemon
+var daemonDetected *bool
+
+// detectDaemon reports whether commands should connect through a running
+// daemon: one answers on its socket and nothing selects the metadata service
+// explicitly
+func detectDaemon() bool {
+	if daemonDetected != nil {
+		return *daemonDetected
+	}
+	detected := false
+	if os.Getenv("MDATA_SOCKET") == "" && os.Getenv("MDATA_NO_DAEMON") == "" {
+		// A socket left behind by a daemon that exited refuses connections
+		if conn, err := net.DialTimeout("unix", daemonSocket(), time.Second); err == nil {
+			conn.Close()
+			detected = true
+		}
+	}
+	daemonDetected = &detected
+	return detected
+}
+
+// daemonConfig returns cfg connecting through the daemon instead, keeping its
+// audit, redaction and middleware
+func daemonConfig(cfg mdata.ClientConfig) mdata.ClientConfig {
+	daemon := mdata.UnixClientConfig(daemonSocket())
+	daemon.Audit, daemon.Redact, daemon.Middleware = cfg.Audit, cfg.Redact, cfg.Middleware
+	return daemon
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Smithx10/go-smartos-mdata/mdata"
+)
+
+// maxDiffCells bounds the work of lineDiff; larger inputs are shown as a
+// removal of every old line followed by an addition of every new one
+const maxDiffCells = 1 << 22
+
+// lineDiff returns the lines of old and new prefixed with "-" when removed,
+// "+" when added and " " when kept, using a longest common subsequence
+func lineDiff(old, new string) []string {
+	a, b := splitLines(old), splitLines(new)
+	if len(a)*len(b) > maxDiffCells {
+		var out []string
+		for _, line := range a {
+			out = append(out, "-"+line)
+		}
+		for _, line := range b {
+			out = append(out, "+"+line)
+		}
+		return out
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var out []string
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			out = append(out, " "+a[i])
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			out = append(out, "-"+a[i])
+			i++
+		default:
+			out = append(out, "+"+b[j])
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		out = append(out, "-"+a[i])
+	}
+	for ; j < len(b); j++ {
+		out = append(out, "+"+b[j])
+	}
+	return out
+}
+
+// splitLines splits s into lines; an empty string has none
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// prefixLines prefixes every line of s with prefix
+func prefixLines(prefix, s string) []string {
+	lines := splitLines(s)
+	for i, line := range lines {
+		lines[i] = prefix + line
+	}
+	return lines
+}
+
+// currentValue returns the value of key and whether it exists
+func currentValue(client mdata.MetadataClient, key string) (string, bool, error) {
+	value, err := client.Get(key)
+	if errors.Is(err, mdata.ErrNotFound) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return value, true, nil
+}
+
+// describePut reports what writing value to key would change, given the
+// current value if the key exists
+func describePut(key, current string, exists bool, value string) string {
+	redact := mdata.DefaultRedactor()
+	switch {
+	case exists && current == value:
+		return fmt.Sprintf("%s is unchanged", key)
+	case redact.IsSecret(key):
+		if exists {
+			return fmt.Sprintf("would update %s (%s)", key, mdata.Redacted)
+		}
+		return fmt.Sprintf("would create %s (%s)", key, mdata.Redacted)
+	case exists:
+		return strings.Join(append([]string{"would update " + key + ":"}, lineDiff(current, value)...), "\n")
+	default:
+		return strings.Join(append([]string{"would create " + key + ":"}, prefixLines("+", value)...), "\n")
+	}
+}
+
+// describeDelete reports what deleting key would remove
+func describeDelete(key, current string, exists bool) string {
+	switch {
+	case !exists:
+		return fmt.Sprintf("%s does not exist", key)
+	case mdata.DefaultRedactor().IsSecret(key):
+		return fmt.Sprintf("would delete %s (%s)", key, mdata.Redacted)
+	default:
+		return strings.Join(append([]string{"would delete " + key + ":"}, prefixLines("-", current)...), "\n")
+	}
+}
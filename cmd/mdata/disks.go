@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/Smithx10/go-smartos-mdata/mdata"
+	"github.com/spf13/cobra"
+)
+
+// newDisksCmd builds the "disks" command listing the disks from sdc:disks
+func newDisksCmd() *cobra.Command {
+	var asJSON bool
+
+	cmd := &cobra.Command{
+		Use:   "disks",
+		Short: "List the disks of the instance from sdc:disks",
+		Long: `Disks lists the disks of a hardware virtual machine in the order the guest
+sees them, with their sizes in MiB. GROW is "yes" for disks larger than the
+image they were created from, whose last partition and filesystem can be
+grown into the extra space; grow-fs tooling can key off it or off the
+"grown" field of the --json output.`,
+		Args: cobra.ExactArgs(0),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runCommand(func(client mdata.MetadataClient) (string, error) {
+				disks, err := mdata.Disks(client)
+				if err != nil {
+					return "", err
+				}
+				if asJSON {
+					type disk struct {
+						mdata.Disk
+						Grown bool `json:"grown"`
+					}
+					out := make([]disk, len(disks))
+					for i, d := range disks {
+						out[i] = disk{Disk: d, Grown: d.Grown()}
+					}
+					data, err := json.MarshalIndent(out, "", "  ")
+					return string(data), err
+				}
+
+				var buf bytes.Buffer
+				w := tabwriter.NewWriter(&buf, 0, 8, 2, ' ', 0)
+				fmt.Fprintln(w, "INDEX\tSIZE\tBOOT\tMEDIA\tMODEL\tIMAGE\tGROW")
+				for i, d := range disks {
+					image := d.ImageName
+					if image == "" {
+						image = d.ImageUUID
+					}
+					if image == "" {
+						image = "-"
+					}
+					fmt.Fprintf(w, "%d\t%d\t%s\t%s\t%s\t%s\t%s\n", i, d.Size, yesNo(d.Boot), d.Media, d.Model, image, yesNo(d.Grown()))
+				}
+				w.Flush()
+				return strings.TrimSuffix(buf.String(), "\n"), nil
+			})
+		},
+	}
+
+	cmd.Flags().BoolVar(&asJSON, "json", false, "Print the disks as JSON")
+	return cmd
+}
+
+// yesNo formats b for tables
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Smithx10/go-smartos-mdata/internal/fsutil"
+	"github.com/Smithx10/go-smartos-mdata/mdataserver"
+	"github.com/spf13/cobra"
+)
+
+// newDotenvCmd builds the "dotenv" command writing metadata as an environment file
+func newDotenvCmd() *cobra.Command {
+	var (
+		prefix    string
+		envPrefix string
+		keys      []string
+		output    string
+		format    string
+		watch     bool
+		interval  time.Duration
+	)
+
+	cmd := &cobra.Command{
+		Use:         "dotenv",
+		Annotations: map[string]string{ownSignals: "true"},
+		Short:       "Write metadata as an environment file",
+		Long: `Dotenv writes metadata as NAME=value lines for a systemd EnvironmentFile or
+docker --env-file. Keys are selected and named exactly as by "mdata run":
+with --prefix app: the key app:db-host becomes DB_HOST.
+
+The systemd format (the default) double-quotes values that need it and
+escapes backslashes, quotes, backticks and dollar signs, so values may contain
+spaces and newlines. Docker reads env files literally, so the docker format
+writes values unquoted and rejects values containing newlines.
+
+With --watch, dotenv keeps running and rewrites the file whenever the
+exported metadata changes. The file is replaced atomically and readable by
+its owner only, as it usually holds credentials.`,
+		Args: cobra.ExactArgs(0),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if format != "systemd" && format != "docker" {
+				return fmt.Errorf("invalid --format %q: expected systemd or docker", format)
+			}
+			if watch && output == "-" {
+				return fmt.Errorf("--watch requires --output")
+			}
+
+			store := newUpstreamStore(clientConfig(), watch)
+			defer store.Close()
+
+			render := func() ([]byte, error) {
+				return renderDotenv(store, prefix, envPrefix, keys, format)
+			}
+			data, err := render()
+			if err != nil {
+				return err
+			}
+			if output == "-" {
+				_, err := os.Stdout.Write(data)
+				return err
+			}
+			if err := fsutil.WriteFileAtomic(output, data, 0o600); err != nil {
+				return err
+			}
+			if !watch {
+				return nil
+			}
+
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return nil
+				case <-ticker.C:
+				}
+				next, err := render()
+				if err != nil {
+					log.Printf("mdata dotenv: %v", err)
+					continue
+				}
+				if bytes.Equal(next, data) {
+					continue
+				}
+				if err := fsutil.WriteFileAtomic(output, next, 0o600); err != nil {
+					log.Printf("mdata dotenv: %v", err)
+					continue
+				}
+				data = next
+				log.Printf("mdata dotenv: updated %s", output)
+			}
+		},
+	}
+
+	cmd.Flags().StringVar(&prefix, "prefix", "", "Export listed keys starting with this prefix (default: all listed keys)")
+	cmd.Flags().StringVar(&envPrefix, "env-prefix", "", "Prepend this to every generated variable name")
+	cmd.Flags().StringArrayVar(&keys, "key", nil, "Also export this key, as KEY or KEY=NAME (repeatable)")
+	cmd.Flags().StringVarP(&output, "output", "o", "-", "File to write (- for stdout)")
+	cmd.Flags().StringVar(&format, "format", "systemd", "Output format: systemd or docker")
+	cmd.Flags().BoolVar(&watch, "watch", false, "Keep running and rewrite the file when metadata changes")
+	cmd.Flags().DurationVar(&interval, "interval", 30*time.Second, "Time between checks with --watch")
+	return cmd
+}
+
+// renderDotenv formats the exported keys as an environment file
+func renderDotenv(store mdataserver.Store, prefix, envPrefix string, keys []string, format string) ([]byte, error) {
+	vars, err := exportedKeys(store, prefix, envPrefix, keys)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	for _, key := range sortedKeys(vars) {
+		value, ok, err := store.Get(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get %s: %w", key, err)
+		}
+		if !ok {
+			return nil, fmt.Errorf("key %s not found", key)
+		}
+		if format == "docker" {
+			if strings.ContainsAny(value, "\r\n") {
+				return nil, fmt.Errorf("value of %s contains a newline, which docker env files cannot represent", key)
+			}
+		} else {
+			value = quoteEnvValue(value)
+		}
+		fmt.Fprintf(&buf, "%s=%s\n", vars[key], value)
+	}
+	return buf.Bytes(), nil
+}
+
+// quoteEnvValue double-quotes value for a systemd EnvironmentFile when it
+// contains anything other than plain word characters
+func quoteEnvValue(value string) string {
+	if value != "" && strings.Trim(value, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_-.,:/@%+=") == "" {
+		return value
+	}
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range value {
+		switch r {
+		case '\\', '"', '`', '$':
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	b.WriteByte('"')
+	return b.String()
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Smithx10/go-smartos-mdata/mdata"
+	"github.com/spf13/cobra"
+)
+
+// newDumpCmd builds the "dump" command printing every key and its value
+func newDumpCmd() *cobra.Command {
+	var (
+		output   string
+		header   bool
+		prefix   string
+		parallel int
+	)
+
+	cmd := &cobra.Command{
+		Use:   "dump",
+		Short: "Print every key and its value",
+		Long: `Dump prints every listed key and its value, sorted by key: as a JSON object
+by default, or with -o csv or -o tsv as rows of the key and its value, after a
+header row with --header. CSV fields are quoted as in RFC 4180; TSV fields
+have tabs, newlines and backslashes escaped as \t, \n and \\.
+
+With --parallel N, values are fetched over N connections at once, as by
+"mdata backup".`,
+		Args: cobra.ExactArgs(0),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if output != "json" && checkTableFormat(output) != nil {
+				return fmt.Errorf("invalid output format %q: expected json, csv or tsv", output)
+			}
+			return runCommand(func(client mdata.MetadataClient) (string, error) {
+				values, err := fetchAll(client, parallel)
+				if err != nil {
+					return "", err
+				}
+				for key := range values {
+					if !strings.HasPrefix(key, prefix) {
+						delete(values, key)
+					}
+				}
+				if output == "json" {
+					data, err := json.MarshalIndent(values, "", "  ")
+					return string(data), err
+				}
+
+				keys := make([]string, 0, len(values))
+				for key := range values {
+					keys = append(keys, key)
+				}
+				sort.Strings(keys)
+				rows := make([][]string, len(keys))
+				for i, key := range keys {
+					rows[i] = []string{key, values[key]}
+				}
+				return formatTable(output, tableHeader(header, "key", "value"), rows)
+			})
+		},
+	}
+
+	cmd.Flags().StringVarP(&output, "output", "o", "json", "Output format: json, csv or tsv")
+	cmd.Flags().BoolVar(&header, "header", false, "Start csv and tsv output with a header row")
+	cmd.Flags().StringVar(&prefix, "prefix", "", "Only dump keys starting with this prefix")
+	cmd.Flags().IntVar(&parallel, "parallel", 1, "Fetch values over this many connections at once (socket transports only)")
+	return cmd
+}
@@ -0,0 +1,10 @@
+//go:build !windows
+
+package main
+
+import "syscall"
+
+// execCommand replaces the current process with path
+func execCommand(path string, args, env []string) error {
+	return syscall.Exec(path, args, env)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/Smithx10/go-smartos-mdata/mdata"
+	"github.com/spf13/cobra"
+)
+
+// exitTimeout is the exit status of commands giving up waiting, as with timeout(1)
+const exitTimeout = 124
+
+// exitMetadataTimeout is the exit status of commands failing because the
+// metadata service did not answer in time
+const exitMetadataTimeout = 4
+
+// exitError is an error making the command exit with code rather than 1
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string { return e.err.Error() }
+
+func (e *exitError) Unwrap() error { return e.err }
+
+// timeoutExit turns err, if it is a metadata timeout, into an exitError of
+// status exitMetadataTimeout whose message names the phase that timed out
+func timeoutExit(err error) error {
+	var (
+		exit    *exitError
+		timeout *mdata.TimeoutError
+	)
+	if err == nil || errors.As(err, &exit) || !errors.As(err, &timeout) {
+		return err
+	}
+	return &exitError{code: exitMetadataTimeout, err: fmt.Errorf("metadata %s timed out: %w", timeout.Phase, err)}
+}
+
+// mapTimeouts makes cmd and its subcommands return metadata timeouts as by
+// timeoutExit
+func mapTimeouts(cmd *cobra.Command) {
+	if run := cmd.RunE; run != nil {
+		cmd.RunE = func(cmd *cobra.Command, args []string) error {
+			return timeoutExit(run(cmd, args))
+		}
+	}
+	for _, sub := range cmd.Commands() {
+		mapTimeouts(sub)
+	}
+}
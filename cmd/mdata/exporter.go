@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Smithx10/go-smartos-mdata/internal/httpauth"
+	"github.com/Smithx10/go-smartos-mdata/mdataexporter"
+	"github.com/Smithx10/go-smartos-mdata/mdataserver"
+	"github.com/spf13/cobra"
+)
+
+// newExporterCmd builds the "exporter" command serving Prometheus metrics
+func newExporterCmd() *cobra.Command {
+	var (
+		listen    string
+		infos     []string
+		gauges    []string
+		tokenFile string
+	)
+
+	cmd := &cobra.Command{
+		Use:         "exporter",
+		Annotations: map[string]string{ownSignals: "true"},
+		Short:       "Expose metadata and channel health as Prometheus metrics",
+		Long: `Exporter serves Prometheus metrics from metadata on /metrics, protected by
+--token-file if given. Service monitors can use /healthz, which fails after
+repeated failures to reach the metadata service, and /readyz, which checks
+that it answers now.`,
+		Args: cobra.ExactArgs(0),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			token, err := readSecretFile(tokenFile)
+			if err != nil {
+				return err
+			}
+			cfg := mdataexporter.DefaultConfig()
+			for _, info := range infos {
+				label, key, ok := strings.Cut(info, "=")
+				if !ok || label == "" || key == "" {
+					return fmt.Errorf("invalid --info %q: expected label=key", info)
+				}
+				cfg.InfoLabels[label] = key
+			}
+			cfg.GaugeKeys = gauges
+
+			store := newUpstreamStore(clientConfig(), true)
+			defer store.Close()
+			health := mdataserver.NewHealthStore(store)
+
+			mux := http.NewServeMux()
+			mux.Handle("/metrics", httpauth.RequireToken(mdataexporter.New(health, cfg), string(token)))
+			mux.Handle("/healthz", health.Handler())
+			mux.Handle("/readyz", health.Handler())
+			srv := &http.Server{Addr: listen, Handler: mux}
+			onShutdown(func() { srv.Close() })
+			if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				return err
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&listen, "listen", ":9753", "HTTP listen address")
+	cmd.Flags().StringArrayVar(&infos, "info", nil, "Add a label to smartos_mdata_info from a key, as label=key (repeatable)")
+	cmd.Flags().StringArrayVar(&gauges, "gauge", nil, "Export a numeric key as smartos_mdata_value (repeatable)")
+	cmd.Flags().StringVar(&tokenFile, "token-file", "", "Require a bearer token read from this file")
+	return cmd
+}
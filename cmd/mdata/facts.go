@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/Smithx10/go-smartos-mdata/internal/fsutil"
+	"github.com/Smithx10/go-smartos-mdata/mdata"
+	"github.com/Smithx10/go-smartos-mdata/mdatafacts"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// newFactsCmd builds the "facts" command emitting metadata for configuration management
+func newFactsCmd() *cobra.Command {
+	var (
+		opts        mdatafacts.Options
+		format      string
+		file        string
+		showSecrets bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "facts",
+		Short: "Emit instance metadata as configuration management facts",
+		Long: `Facts gathers the sdc: keys describing the instance and its customer
+metadata and prints them for configuration management tools.
+
+The ansible format nests sdc: keys under "sdc", decoding JSON values such as
+sdc:nics, and customer metadata under "metadata", with names turned into valid
+variable names (app:db-host becomes app_db_host). Written to
+/etc/ansible/facts.d/smartos.fact it appears as ansible_local.smartos; it can
+equally be used as a host_vars file. The json format is a flat object of keys
+and their raw values.
+
+The puppet format writes Facter external facts: sdc_uuid, sdc_nics and so on
+for the sdc: keys and a structured smartos_metadata fact for customer
+metadata. It is written as YAML when --file ends in .yaml or .yml and as JSON
+otherwise. The ohai format is an Ohai hint with the ansible structure, read
+by recipes through hint?("smartos").
+
+Facts usually end up in a central store such as PuppetDB, so use --allow to
+include only the customer metadata that is safe to share. Values of keys
+that look like secrets (see MDATA_SECRET_PATTERNS) are replaced by
+[REDACTED] unless --show-secrets is given:
+
+    mdata facts -o ansible --file /etc/ansible/facts.d/smartos.fact
+    mdata facts --output puppet --allow 'app:*' --file /etc/puppetlabs/facter/facts.d/smartos.yaml
+    mdata facts --output ohai --allow 'app:*' --file /etc/chef/ohai/hints/smartos.json`,
+		Args: cobra.ExactArgs(0),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			store := newUpstreamStore(clientConfig(), true)
+			defer store.Close()
+
+			if !showSecrets {
+				opts.Redact = mdata.DefaultRedactor()
+			}
+			facts, err := mdatafacts.Gather(store, opts)
+			if err != nil {
+				return err
+			}
+			var v any
+			switch format {
+			case "ansible":
+				v = facts.Ansible()
+			case "json":
+				v = facts.Flat()
+			case "puppet":
+				v = facts.Puppet()
+			case "ohai":
+				v = facts.Ohai()
+			default:
+				return fmt.Errorf("invalid format %q: expected ansible, json, puppet or ohai", format)
+			}
+
+			var data []byte
+			if ext := filepath.Ext(file); format == "puppet" && (ext == ".yaml" || ext == ".yml") {
+				data, err = yaml.Marshal(v)
+			} else {
+				data, err = json.MarshalIndent(v, "", "  ")
+				data = append(data, '\n')
+			}
+			if err != nil {
+				return fmt.Errorf("failed to encode facts: %w", err)
+			}
+
+			if file == "" {
+				_, err := os.Stdout.Write(data)
+				return err
+			}
+			return fsutil.WriteFileAtomic(file, data, 0o600)
+		},
+	}
+
+	cmd.Flags().StringVarP(&format, "output", "o", "ansible", "Output format: ansible, json, puppet or ohai")
+	cmd.Flags().StringVar(&format, "format", "ansible", "Same as --output")
+	cmd.Flags().MarkDeprecated("format", "use --output instead")
+	cmd.Flags().StringVar(&file, "file", "", "Write the facts to this file instead of stdout")
+	cmd.Flags().StringVar(&opts.Prefix, "prefix", "", "Only include customer metadata keys starting with this prefix")
+	cmd.Flags().StringArrayVar(&opts.Allow, "allow", nil, "Only include customer metadata keys matching this pattern, e.g. 'app:*' (repeatable)")
+	cmd.Flags().BoolVar(&opts.NoMetadata, "no-metadata", false, "Leave customer metadata out")
+	cmd.Flags().BoolVar(&showSecrets, "show-secrets", false, "Include the values of keys that look like secrets")
+	return cmd
+}
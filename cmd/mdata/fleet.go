@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/Smithx10/go-smartos-mdata/mdata"
+	"github.com/spf13/cobra"
+)
+
+// fleetResult is the outcome of an operation in one zone
+type fleetResult struct {
+	Zone  string `json:"zone"`
+	Value string `json:"value,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// newFleetCmd builds the "fleet" command running an operation across zones
+func newFleetCmd() *cobra.Command {
+	var (
+		zones    []string
+		parallel int
+		asJSON   bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "fleet get KEY | fleet keys",
+		Short: "Read metadata across the zones of a host",
+		Long: `Fleet runs from the global zone and reads the metadata of many zones at once,
+for host-level audits. The operation is "get KEY" or "keys"; it runs in every
+selected zone concurrently and the results are printed as a table, or with
+--json as an array of {"zone", "value", "error"} objects.
+
+    mdata fleet --zones all get sdc:alias
+    mdata fleet --zones web01,web02 --json keys
+
+Zones are selected by name or UUID; "all" selects every running zone. Hardware
+virtual machines are reported with an error, as their metadata is not served
+on a socket.`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			switch {
+			case len(args) == 2 && args[0] == "get", len(args) == 1 && args[0] == "keys":
+				return nil
+			}
+			return fmt.Errorf(`expected "get KEY" or "keys"`)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(zones) == 0 {
+				return fmt.Errorf("--zones is required")
+			}
+			if len(zones) == 1 && zones[0] == "all" {
+				listed, err := mdata.ListZones()
+				if err != nil {
+					return err
+				}
+				zones = zones[:0]
+				for _, z := range listed {
+					zones = append(zones, z.Name)
+				}
+			}
+
+			op := func(client mdata.MetadataClient) (string, error) {
+				if args[0] == "keys" {
+					return client.Keys()
+				}
+				return client.Get(args[1])
+			}
+			results := make([]fleetResult, len(zones))
+			sem := make(chan struct{}, max(parallel, 1))
+			var wg sync.WaitGroup
+			for i, zone := range zones {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					sem <- struct{}{}
+					defer func() { <-sem }()
+					results[i] = runInZone(zone, op)
+				}()
+			}
+			wg.Wait()
+
+			if asJSON {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				return enc.Encode(results)
+			}
+			w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+			fmt.Fprintln(w, "ZONE\tVALUE")
+			for _, r := range results {
+				value := strings.ReplaceAll(r.Value, "\n", `\n`)
+				if r.Error != "" {
+					value = "error: " + r.Error
+				}
+				fmt.Fprintf(w, "%s\t%s\n", r.Zone, value)
+			}
+			return w.Flush()
+		},
+	}
+
+	cmd.Flags().StringSliceVar(&zones, "zones", nil, `Zones to query, by name or UUID, or "all" (comma-separated)`)
+	cmd.Flags().IntVar(&parallel, "parallel", 8, "Number of zones queried at once")
+	cmd.Flags().BoolVar(&asJSON, "json", false, "Print results as JSON")
+	return cmd
+}
+
+// runInZone runs op against the metadata of zone
+func runInZone(zone string, op func(mdata.MetadataClient) (string, error)) fleetResult {
+	result := fleetResult{Zone: zone}
+	cfg, err := mdata.ZoneClientConfig(zone)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	cfg.Trace = traceSink
+	skipNegotiation(&cfg)
+	client, err := newClient(cfg)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer client.Close()
+	if result.Value, err = op(client); err != nil {
+		result.Error = err.Error()
+	}
+	return result
+}
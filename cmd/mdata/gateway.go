@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Smithx10/go-smartos-mdata/internal/httpauth"
+	"github.com/Smithx10/go-smartos-mdata/mdataimds"
+	"github.com/Smithx10/go-smartos-mdata/mdataserver"
+	"github.com/spf13/cobra"
+)
+
+// newGatewayCmd builds the "gateway" command serving an EC2 IMDS-compatible HTTP API
+func newGatewayCmd() *cobra.Command {
+	var (
+		listen    string
+		mappings  []string
+		tokenFile string
+		pool      poolSettings
+	)
+
+	cmd := &cobra.Command{
+		Use:         "gateway",
+		Annotations: map[string]string{ownSignals: "true"},
+		Short:       "Serve metadata through an EC2 IMDS-compatible HTTP endpoint",
+		Long: `Gateway answers EC2 instance metadata requests such as
+/latest/meta-data/instance-id from SmartOS metadata. Use --map to serve
+additional paths from metadata keys, or to override the defaults; an empty
+key removes a path.
+
+With --token-file, requests must carry "Authorization: Bearer <token>",
+except those of service monitors to /healthz and /readyz. /healthz fails
+after repeated failures to reach the metadata service; /readyz checks that it
+answers now.
+
+Over the zone socket or TCP, --max-conns serves up to that many requests at
+once over a pool of metadata connections.`,
+		Args: cobra.ExactArgs(0),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			token, err := readSecretFile(tokenFile)
+			if err != nil {
+				return err
+			}
+			mapping := mdataimds.DefaultMapping()
+			for _, m := range mappings {
+				path, key, ok := strings.Cut(m, "=")
+				if !ok || path == "" {
+					return fmt.Errorf("invalid --map %q: expected path=key", m)
+				}
+				path = strings.Trim(path, "/")
+				if key == "" {
+					delete(mapping, path)
+					continue
+				}
+				mapping[path] = mdataimds.Key(key)
+			}
+
+			store := newSharedUpstreamStore(clientConfig(), pool, true)
+			defer store.Close()
+			health := mdataserver.NewHealthStore(store)
+
+			mux := http.NewServeMux()
+			mux.Handle("/healthz", health.Handler())
+			mux.Handle("/readyz", health.Handler())
+			mux.Handle("/", httpauth.RequireToken(mdataimds.NewHandler(health, mapping), string(token)))
+			srv := &http.Server{Addr: listen, Handler: mux}
+			onShutdown(func() { srv.Close() })
+			if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				return err
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&listen, "listen", "169.254.169.254:80", "HTTP listen address")
+	cmd.Flags().StringArrayVar(&mappings, "map", nil, "Serve meta-data path from a metadata key, as path=key (repeatable)")
+	cmd.Flags().StringVar(&tokenFile, "token-file", "", "Require a bearer token read from this file")
+	addPoolFlags(cmd.Flags(), &pool)
+	return cmd
+}
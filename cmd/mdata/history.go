@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Smithx10/go-smartos-mdata/mdata"
+	"github.com/spf13/cobra"
+)
+
+// historyDir returns the history directory named by MDATA_HISTORY_DIR, or the default
+func historyDir() string {
+	if dir := os.Getenv("MDATA_HISTORY_DIR"); dir != "" {
+		return dir
+	}
+	return mdata.DefaultHistoryDir
+}
+
+// newHistoryCmd builds the "history" command listing the previous values of a key
+func newHistoryCmd() *cobra.Command {
+	var (
+		dir         string
+		showSecrets bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "history KEY",
+		Short: "List the previous values of a key",
+		Long: `History lists the values a key held before each change made by this host,
+oldest first. Changes are recorded only while MDATA_HISTORY_DIR names the
+history directory, such as ` + mdata.DefaultHistoryDir + `.
+
+Values of keys that look like secrets (see MDATA_SECRET_PATTERNS) are shown
+as ` + mdata.Redacted + ` unless --show-secrets is given.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			entries, err := (&mdata.History{Dir: dir}).Entries(args[0])
+			if err != nil {
+				return err
+			}
+			if len(entries) == 0 {
+				return fmt.Errorf("no history for %s in %s", args[0], dir)
+			}
+			secret := !showSecrets && mdata.DefaultRedactor().IsSecret(args[0])
+			for _, entry := range entries {
+				previous := "(did not exist)"
+				switch {
+				case !entry.Existed:
+				case secret:
+					previous = mdata.Redacted
+				default:
+					previous = fmt.Sprintf("%q", entry.Previous)
+				}
+				fmt.Printf("%s  %-6s  %s\n", entry.Time.Local().Format(time.RFC3339), entry.Op, previous)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&dir, "dir", historyDir(), "History directory")
+	cmd.Flags().BoolVar(&showSecrets, "show-secrets", false, "Show the values of secret keys")
+	return cmd
+}
+
+// newRollbackCmd builds the "rollback" command undoing the last change to a key
+func newRollbackCmd() *cobra.Command {
+	var dir string
+
+	cmd := &cobra.Command{
+		Use:   "rollback KEY",
+		Short: "Restore the value a key held before its last change",
+		Long: `Rollback restores the value a key held before its last recorded change, or
+deletes the key if it did not exist then. The rollback is itself a change,
+recorded while MDATA_HISTORY_DIR is set, so rolling back twice restores the
+value in between.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			key := args[0]
+			entries, err := (&mdata.History{Dir: dir}).Entries(key)
+			if err != nil {
+				return err
+			}
+			if len(entries) == 0 {
+				return fmt.Errorf("no history for %s in %s", key, dir)
+			}
+			last := entries[len(entries)-1]
+			return runCommand(func(client mdata.MetadataClient) (string, error) {
+				if !last.Existed {
+					if err := client.Delete(key); err != nil {
+						return "", err
+					}
+					return fmt.Sprintf("deleted %s, which did not exist before %s", key, strings.ToLower(last.Op)), nil
+				}
+				if err := client.Put(key, string(last.Previous)); err != nil {
+					return "", err
+				}
+				return fmt.Sprintf("restored %s to its value before the %s at %s", key, strings.ToLower(last.Op), last.Time.Local().Format(time.RFC3339)), nil
+			})
+		},
+	}
+
+	cmd.Flags().StringVar(&dir, "dir", historyDir(), "History directory")
+	return cmd
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"strings"
+	"syscall"
+
+	"github.com/Smithx10/go-smartos-mdata/internal/fsutil"
+	"github.com/Smithx10/go-smartos-mdata/mdatanetconf"
+	"github.com/Smithx10/go-smartos-mdata/mdataserver"
+	"github.com/spf13/cobra"
+)
+
+// Lines delimiting the block of /etc/hosts maintained by "mdata hosts"
+const (
+	hostsBegin = "# BEGIN mdata hosts"
+	hostsEnd   = "# END mdata hosts"
+)
+
+// newHostsCmd builds the "hosts" command maintaining a block of /etc/hosts
+func newHostsCmd() *cobra.Command {
+	var (
+		file       string
+		entriesKey string
+		apply      bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "hosts",
+		Short: "Maintain a block of /etc/hosts from metadata",
+		Long: `Hosts derives /etc/hosts entries from metadata: the hostname (from the
+hostname key, or sdc:hostname) qualified by sdc:dns_domain, and sdc:alias, on
+the first address of the primary NIC in sdc:nics (127.0.1.1 when it has none),
+followed by the lines of the --entries-key key, written as in /etc/hosts.
+
+Without --apply the block is printed. With --apply it replaces the block
+between "` + hostsBegin + `" and "` + hostsEnd + `" in the file, or is appended
+when there is none; the rest of the file is left alone and the file is only
+rewritten when the block changes. A file with a begin line but no end line is
+left alone and reported, rather than guessing where the block stops. The file
+is replaced atomically, or rewritten in place when it is a bind mount that
+cannot be replaced, as /etc/hosts is in containers.`,
+		Args: cobra.ExactArgs(0),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			store := newUpstreamStore(clientConfig(), true)
+			defer store.Close()
+
+			block, err := hostsBlock(store, entriesKey)
+			if err != nil {
+				return err
+			}
+			if !apply {
+				fmt.Print(block)
+				return nil
+			}
+
+			current, err := os.ReadFile(file)
+			if err != nil {
+				return err
+			}
+			updated, err := replaceHostsBlock(current, block)
+			if err != nil {
+				return fmt.Errorf("%s: %w", file, err)
+			}
+			if bytes.Equal(updated, current) {
+				return nil
+			}
+			mode := os.FileMode(0o644)
+			if info, err := os.Stat(file); err == nil {
+				mode = info.Mode().Perm()
+			}
+			if err := writeHostsFile(file, updated, mode); err != nil {
+				return err
+			}
+			fmt.Printf("Updated %s\n", file)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&file, "file", "/etc/hosts", "Hosts file to update with --apply")
+	cmd.Flags().StringVar(&entriesKey, "entries-key", "hosts", "Metadata key holding extra entries in hosts file format")
+	cmd.Flags().BoolVar(&apply, "apply", false, "Update the hosts file instead of printing the block")
+	return cmd
+}
+
+// hostsBlock renders the marked block of hosts entries from metadata
+func hostsBlock(store mdataserver.Store, entriesKey string) (string, error) {
+	values := make(map[string]string)
+	for _, key := range []string{"hostname", "sdc:hostname", "sdc:alias", "sdc:dns_domain", "sdc:nics", entriesKey} {
+		value, ok, err := store.Get(key)
+		if err != nil {
+			return "", fmt.Errorf("failed to get %s: %w", key, err)
+		}
+		if ok {
+			values[key] = strings.TrimSpace(value)
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString(hostsBegin + "\n")
+	var names []string
+	hostname := values["hostname"]
+	if hostname == "" {
+		hostname = values["sdc:hostname"]
+	}
+	if hostname != "" {
+		if domain := values["sdc:dns_domain"]; domain != "" && !strings.Contains(hostname, ".") {
+			names = append(names, hostname+"."+domain)
+		}
+		names = append(names, hostname)
+	}
+	if alias := values["sdc:alias"]; alias != "" && alias != hostname {
+		names = append(names, alias)
+	}
+	if len(names) > 0 {
+		addr, err := primaryAddress(values["sdc:nics"])
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&b, "%s\t%s\n", addr, strings.Join(names, " "))
+	}
+
+	for i, line := range strings.Split(values[entriesKey], "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if _, err := netip.ParseAddr(fields[0]); err != nil || len(fields) < 2 {
+			return "", fmt.Errorf("invalid entry on line %d of %s: expected an address followed by names", i+1, entriesKey)
+		}
+		fmt.Fprintf(&b, "%s\t%s\n", fields[0], strings.Join(fields[1:], " "))
+	}
+	b.WriteString(hostsEnd + "\n")
+	return b.String(), nil
+}
+
+// primaryAddress returns the first static address of the primary NIC, or
+// 127.0.1.1 as Debian uses for hosts without a permanent address
+func primaryAddress(nics string) (netip.Addr, error) {
+	fallback := netip.MustParseAddr("127.0.1.1")
+	if nics == "" {
+		return fallback, nil
+	}
+	network, err := mdatanetconf.Parse(nics, "", "", "")
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	for _, nic := range network.NICs {
+		if nic.Primary && len(nic.Addresses) > 0 {
+			return nic.Addresses[0].Addr(), nil
+		}
+	}
+	return fallback, nil
+}
+
+// replaceHostsBlock returns content with its marked block replaced by block,
+// or with block appended when content has none. A begin line without an end
+// line is an error, as replacing or appending could each lose entries.
+func replaceHostsBlock(content []byte, block string) ([]byte, error) {
+	begin := bytes.Index(content, []byte(hostsBegin+"\n"))
+	if begin >= 0 && (begin == 0 || content[begin-1] == '\n') {
+		end := bytes.Index(content[begin:], []byte(hostsEnd))
+		if end < 0 {
+			return nil, fmt.Errorf("%q has no matching %q", hostsBegin, hostsEnd)
+		}
+		end += begin + len(hostsEnd)
+		if end < len(content) && content[end] == '\n' {
+			end++
+		}
+		return append(append(bytes.Clone(content[:begin]), block...), content[end:]...), nil
+	}
+	out := bytes.Clone(content)
+	if len(out) > 0 && out[len(out)-1] != '\n' {
+		out = append(out, '\n')
+	}
+	return append(out, block...), nil
+}
+
+// writeHostsFile replaces file with data atomically, falling back to
+// rewriting it in place when it is a mount point that cannot be renamed
+// over, as container runtimes bind mount /etc/hosts
+func writeHostsFile(file string, data []byte, mode os.FileMode) error {
+	err := fsutil.WriteFileAtomic(file, data, mode)
+	if !errors.Is(err, syscall.EBUSY) {
+		return err
+	}
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write %s: %w", file, err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to sync %s: %w", file, err)
+	}
+	return f.Close()
+}
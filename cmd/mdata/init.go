@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Smithx10/go-smartos-mdata/internal/fsutil"
+	"github.com/Smithx10/go-smartos-mdata/mdataagent"
+	"github.com/spf13/cobra"
+)
+
+// newInitCmd builds the "init" command preparing files from metadata before a workload starts
+func newInitCmd() *cobra.Command {
+	var manifest, stamp string
+
+	cmd := &cobra.Command{
+		Use:   "init",
+		Short: "Write files from metadata once before the workload starts",
+		Long: `Init performs a single pass over a manifest in the agent config format,
+writing keys and rendered templates to their destinations with the configured
+mode and ownership, then exits. It is meant to run as an init container or a
+oneshot service ordered before the main workload.
+
+Init fails without writing anything if a key listed under "required" is
+missing, and fails if any file cannot be written unless it is marked optional.
+
+    required: [db_password]
+    files:
+      - key: db_password
+        destination: /run/secrets/db_password
+        mode: "0400"
+        owner: app
+      - template: /etc/app/app.conf.tmpl
+        destination: /etc/app/app.conf
+      - key: tls_cert
+        destination: /etc/app/tls.pem
+        optional: true
+
+With --stamp, init records success in the given file and does nothing while it
+exists; a stamp under /run or /var/run therefore limits init to once per boot.`,
+		Args: cobra.ExactArgs(0),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if stamp != "" {
+				if _, err := os.Stat(stamp); err == nil {
+					fmt.Printf("Already initialized (%s exists)\n", stamp)
+					return nil
+				} else if !errors.Is(err, os.ErrNotExist) {
+					return fmt.Errorf("failed to check stamp: %w", err)
+				}
+			}
+
+			cfg, err := mdataagent.LoadConfig(manifest)
+			if err != nil {
+				return err
+			}
+			store := newUpstreamStore(clientConfig(), true)
+			defer store.Close()
+
+			agent := mdataagent.New(cfg, store)
+			if err := agent.CheckRequired(); err != nil {
+				return err
+			}
+			if err := agent.RunOnce(); err != nil {
+				return err
+			}
+
+			if stamp != "" {
+				if err := os.MkdirAll(filepath.Dir(stamp), 0o755); err != nil {
+					return fmt.Errorf("failed to create stamp directory: %w", err)
+				}
+				if err := fsutil.WriteFileAtomic(stamp, []byte(time.Now().UTC().Format(time.RFC3339)+"\n"), 0o644); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&manifest, "manifest", "m", "/etc/mdata/init.yaml", "Manifest file (YAML or JSON)")
+	cmd.Flags().StringVar(&stamp, "stamp", "", "Skip if this file exists, and create it on success")
+	return cmd
+}
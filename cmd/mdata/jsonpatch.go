@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/Smithx10/go-smartos-mdata/internal/jsonpatch"
+	"github.com/Smithx10/go-smartos-mdata/mdata"
+	"github.com/spf13/cobra"
+)
+
+// newJSONDiffCmd builds the "jsondiff" command comparing a JSON value with a file
+func newJSONDiffCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "jsondiff KEY FILE",
+		Short: "Show the JSON Patch from the JSON value of a key to a file",
+		Long: `Jsondiff prints the JSON Patch (RFC 6902) turning the JSON value of the key
+into the JSON document in FILE (- for stdin): the members removed, added and
+replaced, with paths as JSON Pointers. Identical documents, however formatted,
+yield an empty patch. The output can be reviewed, edited and applied with
+"mdata jsonpatch".`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			data, err := readInput(args[1])
+			if err != nil {
+				return err
+			}
+			to, err := decodeJSON(string(data))
+			if err != nil {
+				return fmt.Errorf("%s is not valid JSON: %w", args[1], err)
+			}
+			return runCommand(func(client mdata.MetadataClient) (string, error) {
+				current, err := client.Get(args[0])
+				if err != nil {
+					return "", err
+				}
+				from, err := decodeJSON(current)
+				if err != nil {
+					return "", fmt.Errorf("value of %s is not valid JSON: %w", args[0], err)
+				}
+				out, err := json.MarshalIndent(jsonpatch.Diff(from, to), "", "  ")
+				return string(out), err
+			})
+		},
+	}
+	return cmd
+}
+
+// newJSONPatchCmd builds the "jsonpatch" command applying a JSON Patch to a value
+func newJSONPatchCmd() *cobra.Command {
+	var dryRun bool
+
+	cmd := &cobra.Command{
+		Use:   "jsonpatch KEY PATCH",
+		Short: "Apply a JSON Patch to the JSON value of a key",
+		Long: `Jsonpatch applies the JSON Patch (RFC 6902) in the file PATCH (- for stdin)
+to the JSON value of the key, or to null when it does not exist. Either every
+operation succeeds, including "test" operations guarding the change, or
+nothing is written. As with "mdata put --merge", the patch is applied again
+if another writer changes the value while it is being patched.
+
+With --dry-run, jsonpatch prints the change as a line diff instead.`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			data, err := readInput(args[1])
+			if err != nil {
+				return err
+			}
+			ops, err := jsonpatch.ParsePatch(data)
+			if err != nil {
+				return err
+			}
+			return runCommand(func(client mdata.MetadataClient) (string, error) {
+				return updateJSON(client, args[0], func(v any) (any, error) {
+					return jsonpatch.Apply(v, ops)
+				}, nil, dryRun)
+			})
+		},
+	}
+
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Print what would change without writing")
+	return cmd
+}
+
+// readInput reads the file at path, or stdin for "-"
+func readInput(path string) ([]byte, error) {
+	if path == "-" {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read stdin: %w", err)
+		}
+		return data, nil
+	}
+	return os.ReadFile(path)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Smithx10/go-smartos-mdata/mdata"
+)
+
+// maxUpdateAttempts bounds the attempts of updateJSON on a value other
+// writers keep changing
+const maxUpdateAttempts = 3
+
+// updateJSON rewrites the JSON value of key with update, which receives the
+// decoded value, or nil when the key does not exist. Metadata has no
+// compare-and-swap, so the value is checked again just before the write, and
+// the update retried from the new value when another writer changed it
+// meanwhile. check, if not nil, vets the new value first. With dryRun the
+// change is described rather than made.
+func updateJSON(client mdata.MetadataClient, key string, update func(any) (any, error), check func(string) error, dryRun bool) (string, error) {
+	for range maxUpdateAttempts {
+		current, exists, err := currentValue(client, key)
+		if err != nil {
+			return "", err
+		}
+		var v any
+		if exists {
+			if v, err = decodeJSON(current); err != nil {
+				return "", fmt.Errorf("value of %s is not valid JSON: %w", key, err)
+			}
+		}
+		v, err = update(v)
+		if err != nil {
+			return "", err
+		}
+		value, err := encodeJSON(v)
+		if err != nil {
+			return "", err
+		}
+		if check != nil {
+			if err := check(value); err != nil {
+				return "", err
+			}
+		}
+		if dryRun {
+			return describePut(key, current, exists, value), nil
+		}
+		if exists && value == current {
+			return "", nil
+		}
+
+		changed, err := changedSince(client, key, current, exists)
+		if err != nil {
+			return "", err
+		}
+		if changed {
+			continue
+		}
+		return "", client.Put(key, value)
+	}
+	return "", fmt.Errorf("%s changed during each of %d attempts to update it", key, maxUpdateAttempts)
+}
+
+// changedSince reports whether key no longer holds value, or exists when it
+// did not
+func changedSince(client mdata.MetadataClient, key, value string, exists bool) (bool, error) {
+	if !exists {
+		_, err := client.Get(key)
+		if errors.Is(err, mdata.ErrNotFound) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+	_, _, err := mdata.GetIfChanged(client, key, mdata.ValueChecksum(value))
+	switch {
+	case errors.Is(err, mdata.ErrNotModified):
+		return false, nil
+	case errors.Is(err, mdata.ErrNotFound):
+		return true, nil
+	}
+	return err == nil, err
+}
+
+// decodeJSON decodes a JSON value, keeping numbers as written
+func decodeJSON(s string) (any, error) {
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, fmt.Errorf("unexpected data after the JSON value")
+	}
+	return v, nil
+}
+
+// encodeJSON encodes v compactly, leaving <, > and & unescaped
+func encodeJSON(v any) (string, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
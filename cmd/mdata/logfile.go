@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Smithx10/go-smartos-mdata/mdata"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// logFile, set by --log-file or MDATA_LOG_FILE, receives a record of every
+// invocation
+var logFile string
+
+// usedTransport is the transport of the last client newClient connected,
+// recorded in the log file
+var usedTransport struct {
+	sync.Mutex
+	name string
+}
+
+// recordTransport sets the transport of the invocation to that of cfg
+func recordTransport(cfg mdata.ClientConfig) {
+	usedTransport.Lock()
+	usedTransport.name = string(cfg.Transport)
+	usedTransport.Unlock()
+}
+
+// invocation is the record of one invocation appended to the log file
+type invocation struct {
+	Time      time.Time `json:"time"`
+	Command   string    `json:"command"`
+	Keys      []string  `json:"keys,omitempty"`
+	Transport string    `json:"transport,omitempty"`
+	Duration  float64   `json:"duration_seconds"`
+	Outcome   string    `json:"outcome"` // "ok" or "error"
+	Error     string    `json:"error,omitempty"`
+	ExitCode  int       `json:"exit_code"`
+	PID       int       `json:"pid"`
+	UID       int       `json:"uid"` // -1 on Windows
+}
+
+// keyArgs gives the number of leading arguments of a command that name keys,
+// -1 for all of them. Other arguments, such as the value of put, are never
+// logged.
+var keyArgs = map[string]int{
+	"mdata get":       -1,
+	"mdata delete":    -1,
+	"mdata put":       1,
+	"mdata jsondiff":  1,
+	"mdata jsonpatch": 1,
+	"mdata history":   1,
+	"mdata rollback":  1,
+}
+
+// addLogFileFlag adds the flag naming the log file to flags
+func addLogFileFlag(flags *pflag.FlagSet) {
+	flags.StringVar(&logFile, "log-file", os.Getenv("MDATA_LOG_FILE"), "Append a JSON record of this invocation to this file (env MDATA_LOG_FILE)")
+}
+
+// logInvocation appends the record of running cmd since start, ending with err
+// and exit code, to the log file if one is set. The file is
+// created readable by its owner only and opened for every record, so it may
+// be rotated at any time.
+func logInvocation(cmd *cobra.Command, start time.Time, err error, code int) {
+	if logFile == "" || cmd == nil {
+		return
+	}
+	usedTransport.Lock()
+	transport := usedTransport.name
+	usedTransport.Unlock()
+	rec := invocation{
+		Time:      start.UTC(),
+		Command:   cmd.CommandPath(),
+		Transport: transport,
+		Duration:  time.Since(start).Seconds(),
+		Outcome:   "ok",
+		ExitCode:  code,
+		PID:       os.Getpid(),
+		UID:       os.Getuid(),
+	}
+	if n, ok := keyArgs[rec.Command]; ok {
+		args := cmd.Flags().Args()
+		if n < 0 || n > len(args) {
+			n = len(args)
+		}
+		rec.Keys = args[:n]
+	}
+	if err != nil {
+		rec.Outcome, rec.Error = "error", err.Error()
+	}
+
+	line, merr := json.Marshal(rec)
+	if merr != nil {
+		return
+	}
+	f, ferr := os.OpenFile(logFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if ferr == nil {
+		_, ferr = f.Write(append(line, '\n'))
+		if cerr := f.Close(); ferr == nil {
+			ferr = cerr
+		}
+	}
+	if ferr != nil {
+		fmt.Fprintf(os.Stderr, "mdata: failed to write log file: %v\n", ferr)
+	}
+}
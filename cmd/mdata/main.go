@@ -0,0 +1,568 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/Smithx10/go-smartos-mdata/internal/jsonpatch"
+	"github.com/Smithx10/go-smartos-mdata/mdata"
+	"github.com/Smithx10/go-smartos-mdata/mdatatmpl"
+	"github.com/spf13/cobra"
+)
+
+const (
+	ERROR_NOTFOUND = "request failed with code: NOTFOUND"
+)
+
+func main() {
+	var zone string
+	var rootCmd = &cobra.Command{
+		Use:   "mdata",
+		Short: "SmartOS metadata client",
+		Long: `SmartOS metadata client.
+
+Executables named mdata-<name> on PATH are available as the subcommand
+<name>, unless a built-in command has that name.
+
+The serial channel of hardware VMs defaults to 115200 baud 8N1 on the usual
+port, or to the virtio-serial channel org.smartos.metadata when the guest has
+one, as newer bhyve and KVM guests may have no UART for it. The --serial-*
+flags override these settings, as do the MDATA_SERIAL_* variables and the
+serial section (device, baud, parity, stop_bits, candidates) of
+` + defaultConfigFile + `, or of the file MDATA_CONFIG names, in decreasing
+order of precedence.
+When the port does not answer, the other usual ports (that channel,
+/dev/ttyS1, /dev/ttyS0, /dev/virtio-ports/* and /dev/vport*, or COM1 to COM4
+on Windows) are probed with short timeouts, and the first to answer is used
+and reported on stderr.
+Images with remapped ports list theirs as candidates instead, the first
+replacing the usual port unless a device is given:
+
+    serial:
+      candidates: [/dev/ttyS3, /dev/ttyS2]
+
+The aliases section of the config file defines commands of its own, each
+expanding to the command and arguments it gives, followed by those of the
+invocation, so that common queries read the same on every image:
+
+    aliases:
+      dburl: get app:db --jsonpath .url
+      nics: sdc -o json
+
+Aliases cannot replace built-in commands and do not expand other aliases.
+
+Each new connection starts by negotiating version 2 of the protocol, a round
+trip that one-shot invocations during boot repeat many times. Once a server
+has negotiated, a marker under /run/mdata-negotiated (/var/run on illumos, or
+the directory MDATA_NEGOTIATION_DIR names) lets later invocations reaching it
+through the same socket skip it; --no-negotiate, or MDATA_NO_NEGOTIATE, skips
+it always. A server refusing a request sent without negotiation is
+negotiated with then, and the request sent again.
+
+Commands exit with status 4 when the metadata service does not answer in
+time, whether dialing its socket, negotiating the protocol or waiting for a
+response, and the error names the phase that timed out. Other failures,
+including a key that is not set, exit with status 1, so retry wrappers can
+tell a busy or missing service from missing metadata. Interrupted by SIGINT
+or SIGTERM, commands close their connections, letting a request being
+written finish so that no partial frame is left on the metadata channel, and
+exit with status 130 or 143; servers and watchers shut down gracefully
+instead.
+
+With --remote user@host, or MDATA_REMOTE, commands use the metadata of
+another instance, through ssh and "mdata proxy --stdio" run there, so that
+operators can query it from their workstation:
+
+    mdata --remote root@web0 get sdc:nics --jsonpath '.[0].ips[0]'
+    mdata --remote root@cn3 --zone web0 keys
+
+MDATA_SSH replaces the ssh command, for example with "ssh -i ~/.ssh/triage",
+and MDATA_REMOTE_MDATA the path of mdata on the host. The host uses its own
+serial settings.
+
+With --best-effort, or MDATA_BEST_EFFORT, boot paths that would rather go on
+with stale metadata than hang wait at most 3 seconds on the metadata service.
+Should it not answer, reads are answered from the values last read by the
+agent, or by other best-effort commands, kept under ` + mdata.DefaultLastKnownDir + `
+(or the directory MDATA_LAST_KNOWN_DIR names); stderr tells which values are
+stale, and commands succeed. Keys never read before fail as usual, as do keys
+that look like secrets (see MDATA_SECRET_PATTERNS), whose values are not kept.
+
+With --log-file, or MDATA_LOG_FILE, every invocation appends a JSON line to
+the file recording the command, the keys it named, the transport, how long it
+took and how it ended, as a trail of metadata access for troubleshooting
+boot. Values are never logged.
+
+With --trace-out, or MDATA_TRACE_OUT, every protocol event of the invocation
+appends a JSON line to the file: the negotiation of each connection, each
+request sent and response received with its request ID, code, size and
+timing, retries and failed requests. Trace viewers and jq can then show where
+a slow boot spends its time:
+
+    jq -r 'select(.event == "recv") | [.request_id, .code, .duration_seconds] | @tsv' trace.ndjson`,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			handleSignals(cmd)
+			if err := loadSerialSettings(); err != nil {
+				return err
+			}
+			if err := openTraceOut(); err != nil {
+				return err
+			}
+			if remoteHost != "" {
+				// The remote mdata looks up the zone
+				cfg, err := remoteClientConfig(remoteHost, zone)
+				if err != nil {
+					return err
+				}
+				remoteConfig = &cfg
+				return nil
+			}
+			if zone == "" {
+				return nil
+			}
+			cfg, err := mdata.ZoneClientConfig(zone)
+			if err != nil {
+				return err
+			}
+			zoneConfig = &cfg
+			return nil
+		},
+	}
+	rootCmd.Version = buildVersion()
+	rootCmd.PersistentFlags().StringVar(&zone, "zone", "", "From the global zone, use the metadata of this zone (name or UUID)")
+	addSerialFlags(rootCmd.PersistentFlags())
+	addLogFileFlag(rootCmd.PersistentFlags())
+	addTraceOutFlag(rootCmd.PersistentFlags())
+	addNegotiateFlag(rootCmd.PersistentFlags())
+	addRemoteFlag(rootCmd.PersistentFlags())
+	addBestEffortFlag(rootCmd.PersistentFlags())
+
+	var getBinary bool
+	var getJSONPath string
+	var getWait time.Duration
+	var getTemplate string
+	var getOutput string
+	var getHeader bool
+	var getCmd = &cobra.Command{
+		Use:   "get [key...]",
+		Short: "Get a metadata key",
+		Long: `Get prints the value of a metadata key followed by a newline. With --binary
+the value is written to stdout exactly as stored, without the newline, so
+"mdata get blob --binary > file.tar.gz" recovers the bytes put there.
+
+With --jsonpath, the value must be JSON and only the field the path selects is
+printed: strings as they are, anything else as JSON. Paths are written as in
+jq or JSONPath, for example:
+
+    mdata get sdc:nics --jsonpath '.[0].ips[0]'
+    mdata get sdc:nics --jsonpath '$[*].mac'
+
+With --wait, a key that does not exist yet is waited for, up to the given
+duration, rather than failing at once: "mdata get app:token --wait 5m" returns
+as soon as an operator sets the key. Giving up exits with status 124.
+
+With --template, the value is printed through a Go text/template whose data
+has the fields .Key and .Value, and which can call the functions of agent
+templates (see the mdatatmpl package), such as trim, split, join and jsonpath:
+
+    mdata get app:port --template '{{ .Value | trim }}'
+
+Several keys may then be given; the template is executed for each in turn,
+each result on its own line:
+
+    mdata get sdc:alias sdc:hostname --template '{{ .Key }}={{ .Value }}'
+
+With -o csv or -o tsv, one or more keys are printed as rows of the key and
+its value, after a header row with --header. CSV fields are quoted as in RFC
+4180; TSV fields have tabs, newlines and backslashes escaped as \t, \n and \\.`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if cmd.Flags().Changed("template") || cmd.Flags().Changed("output") {
+				return cobra.MinimumNArgs(1)(cmd, args)
+			}
+			return cobra.ExactArgs(1)(cmd, args)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if getOutput != "" {
+				if err := checkTableFormat(getOutput); err != nil {
+					return err
+				}
+			}
+			var tmpl *template.Template
+			if cmd.Flags().Changed("template") {
+				var err error
+				// Parsed before connecting so mistakes fail fast; the functions are bound to the client below
+				if tmpl, err = template.New("get").Funcs(mdatatmpl.FuncMap(nil)).Parse(getTemplate); err != nil {
+					return fmt.Errorf("invalid template: %w", err)
+				}
+			}
+			return runCommand(func(client mdata.MetadataClient) (string, error) {
+				get := func(key string) (string, error) {
+					var value string
+					var err error
+					if getWait > 0 {
+						if value, err = waitForKey(client, key, getWait); err != nil {
+							return "", err
+						}
+					}
+					if cmd.Flags().Changed("jsonpath") {
+						value, err = getField(client, key, getJSONPath)
+					} else if getWait == 0 {
+						value, err = client.Get(key)
+					}
+					return value, err
+				}
+
+				if tmpl != nil {
+					tmpl.Funcs(mdatatmpl.FuncMap(client))
+					out := make([]string, len(args))
+					for i, key := range args {
+						value, err := get(key)
+						if err != nil {
+							return "", err
+						}
+						var buf strings.Builder
+						if err := tmpl.Execute(&buf, keyValue{Key: key, Value: value}); err != nil {
+							return "", err
+						}
+						out[i] = buf.String()
+					}
+					return strings.Join(out, "\n"), nil
+				}
+
+				if getOutput != "" {
+					rows := make([][]string, len(args))
+					for i, key := range args {
+						value, err := get(key)
+						if err != nil {
+							return "", err
+						}
+						rows[i] = []string{key, value}
+					}
+					return formatTable(getOutput, tableHeader(getHeader, "key", "value"), rows)
+				}
+
+				value, err := get(args[0])
+				if err != nil || !getBinary {
+					return value, err
+				}
+				_, err = os.Stdout.WriteString(value)
+				return "", err
+			})
+		},
+	}
+	getCmd.Flags().BoolVar(&getBinary, "binary", false, "Write the value exactly as stored, without a trailing newline")
+	getCmd.Flags().StringVar(&getJSONPath, "jsonpath", "", "Print the field of the JSON value selected by this path")
+	getCmd.Flags().DurationVar(&getWait, "wait", 0, "Wait up to this long for the key to be set")
+	getCmd.Flags().StringVar(&getTemplate, "template", "", "Print each key through this Go template over .Key and .Value")
+	getCmd.Flags().StringVarP(&getOutput, "output", "o", "", "Print keys and values as rows: csv or tsv")
+	getCmd.Flags().BoolVar(&getHeader, "header", false, "Start csv and tsv output with a header row")
+	getCmd.MarkFlagsMutuallyExclusive("binary", "template", "output")
+
+	var long bool
+	var keysOutput string
+	var keysHeader bool
+	var keysCmd = &cobra.Command{
+		Use:   "keys",
+		Short: "List metadata keys with optional prefix",
+		Long: `Keys lists the metadata keys. With --long, each key is preceded by the size
+of its value in bytes, to spot oversized values, and "json" when the value is
+a JSON object or array, and followed by the start of the value, to find the
+key holding some configuration. Values of keys that look like secrets (see
+MDATA_SECRET_PATTERNS) are not shown. This fetches every value.
+
+With -o csv or -o tsv, keys are printed as rows, with the size, JSON flag and
+start of their value as further columns with --long, after a header row with
+--header.`,
+		Args: cobra.ExactArgs(0),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if keysOutput != "" {
+				if err := checkTableFormat(keysOutput); err != nil {
+					return err
+				}
+			}
+			return runCommand(func(client mdata.MetadataClient) (string, error) {
+				if !long && keysOutput == "" {
+					return client.Keys()
+				}
+				if !long {
+					raw, err := client.Keys()
+					if err != nil {
+						return "", err
+					}
+					var rows [][]string
+					for _, key := range strings.Split(raw, "\n") {
+						if key != "" {
+							rows = append(rows, []string{key})
+						}
+					}
+					return formatTable(keysOutput, tableHeader(keysHeader, "key"), rows)
+				}
+				infos, err := mdata.KeysInfo(client)
+				if err != nil {
+					return "", err
+				}
+				redact := mdata.DefaultRedactor()
+				if keysOutput != "" {
+					rows := make([][]string, len(infos))
+					for i, info := range infos {
+						rows[i] = []string{info.Key, strconv.Itoa(info.Size), strconv.FormatBool(info.JSON), redact.Value(info.Key, info.Preview)}
+					}
+					return formatTable(keysOutput, tableHeader(keysHeader, "key", "size", "json", "preview"), rows)
+				}
+				width := 0
+				for _, info := range infos {
+					width = max(width, len(info.Key))
+				}
+				lines := make([]string, len(infos))
+				for i, info := range infos {
+					kind := "-"
+					if info.JSON {
+						kind = "json"
+					}
+					lines[i] = strings.TrimRight(fmt.Sprintf("%10d  %-4s  %-*s  %s", info.Size, kind, width, info.Key, redact.Value(info.Key, info.Preview)), " ")
+				}
+				return strings.Join(lines, "\n"), nil
+			})
+		},
+	}
+	keysCmd.Flags().BoolVarP(&long, "long", "l", false, "Show the size, kind and start of each value")
+	keysCmd.Flags().StringVarP(&keysOutput, "output", "o", "", "Print keys as rows: csv or tsv")
+	keysCmd.Flags().BoolVar(&keysHeader, "header", false, "Start csv and tsv output with a header row")
+
+	var schemaFile string
+	var dryRun bool
+	var putBinary bool
+	var mergePatch string
+	var putCmd = &cobra.Command{
+		Use:   "put [key] [value]",
+		Short: "Put a metadata key-value pair",
+		Long: `Put writes a metadata key. With --schema, the value must be JSON satisfying
+the JSON Schema in the given file, and nothing is written otherwise.
+
+With --dry-run, put prints how the value would change, as a line diff against
+the current value, without writing it. Values of keys that look like secrets
+(see MDATA_SECRET_PATTERNS) are not shown.
+
+With --binary the value is not given as an argument but read from stdin, byte
+for byte until end of file, as in "mdata put blob --binary < file.tar.gz".
+
+With --merge the value is not given either: the JSON merge patch (RFC 7386) is
+merged into the current JSON value of the key, or into an empty object when
+it does not exist, as in
+
+    mdata put config --merge '{"feature":{"x":true},"obsolete":null}'
+
+where null deletes a member. The value is checked again just before it is
+written and the merge redone if another writer changed it in between.`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if putBinary || cmd.Flags().Changed("merge") {
+				return cobra.ExactArgs(1)(cmd, args)
+			}
+			return cobra.ExactArgs(2)(cmd, args)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			key := args[0]
+			var check func(string) error
+			if schemaFile != "" {
+				schema, err := os.ReadFile(schemaFile)
+				if err != nil {
+					return fmt.Errorf("failed to read schema: %w", err)
+				}
+				var schemas mdata.Schemas
+				if err := schemas.Register(key, schema); err != nil {
+					return err
+				}
+				check = func(value string) error { return schemas.Validate(key, value) }
+			}
+
+			if cmd.Flags().Changed("merge") {
+				patch, err := decodeJSON(mergePatch)
+				if err != nil {
+					return fmt.Errorf("invalid merge patch: %w", err)
+				}
+				return runCommand(func(client mdata.MetadataClient) (string, error) {
+					return updateJSON(client, key, func(v any) (any, error) {
+						return jsonpatch.MergePatch(v, patch), nil
+					}, check, dryRun)
+				})
+			}
+
+			if putBinary {
+				value, err := io.ReadAll(os.Stdin)
+				if err != nil {
+					return fmt.Errorf("failed to read value from stdin: %w", err)
+				}
+				args = append(args, string(value))
+			}
+			if check != nil {
+				if err := check(args[1]); err != nil {
+					return err
+				}
+			}
+			return runCommand(func(client mdata.MetadataClient) (string, error) {
+				if dryRun {
+					current, exists, err := currentValue(client, key)
+					if err != nil {
+						return "", err
+					}
+					return describePut(key, current, exists, args[1]), nil
+				}
+				if err := client.Put(key, args[1]); err != nil {
+					return "", err
+				}
+				return "", nil
+			})
+		},
+	}
+
+	putCmd.Flags().StringVar(&schemaFile, "schema", "", "Validate the value against the JSON Schema in this file")
+	putCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Print what would change without writing")
+	putCmd.Flags().BoolVar(&putBinary, "binary", false, "Read the value from stdin exactly as given")
+	putCmd.Flags().StringVar(&mergePatch, "merge", "", "Merge this JSON merge patch into the current value")
+	putCmd.MarkFlagsMutuallyExclusive("binary", "merge")
+
+	var deletePrefix string
+	var yes bool
+	var deleteCmd = &cobra.Command{
+		Use:   "delete [key...]",
+		Short: "Delete metadata keys",
+		Long: `Delete removes the given keys, or with --prefix every listed key starting with
+the prefix. Deleting more than one key asks for confirmation on the terminal
+first; pass --yes to skip it, as is required when stdin is not a terminal.
+
+With --dry-run, delete prints the values that would be deleted instead.`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if deletePrefix != "" {
+				return cobra.NoArgs(cmd, args)
+			}
+			return cobra.MinimumNArgs(1)(cmd, args)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runCommand(func(client mdata.MetadataClient) (string, error) {
+				keys := args
+				if deletePrefix != "" {
+					raw, err := client.Keys()
+					if err != nil {
+						return "", fmt.Errorf("failed to list keys: %w", err)
+					}
+					keys = nil
+					for _, key := range strings.Split(raw, "\n") {
+						if key != "" && strings.HasPrefix(key, deletePrefix) {
+							keys = append(keys, key)
+						}
+					}
+					if len(keys) == 0 {
+						return "", fmt.Errorf("no keys start with %s", deletePrefix)
+					}
+				}
+				if dryRun {
+					var out []string
+					for _, key := range keys {
+						current, exists, err := currentValue(client, key)
+						if err != nil {
+							return "", err
+						}
+						out = append(out, describeDelete(key, current, exists))
+					}
+					return strings.Join(out, "\n"), nil
+				}
+				if len(keys) > 1 || deletePrefix != "" {
+					if err := confirm(fmt.Sprintf("delete %d keys", len(keys)), keys, yes); err != nil {
+						return "", err
+					}
+				}
+				var errs []error
+				for _, key := range keys {
+					if err := client.Delete(key); err != nil {
+						errs = append(errs, fmt.Errorf("failed to delete %s: %w", key, err))
+					}
+				}
+				return "", errors.Join(errs...)
+			})
+		},
+	}
+	deleteCmd.Flags().StringVar(&deletePrefix, "prefix", "", "Delete every key starting with this prefix")
+	deleteCmd.Flags().BoolVarP(&yes, "yes", "y", false, "Delete without asking for confirmation")
+	deleteCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Print the values that would be deleted without deleting them")
+
+	rootCmd.AddCommand(getCmd, keysCmd, putCmd, deleteCmd, newServeCmd(), newProxyCmd(), newGatewayCmd(), newExporterCmd(), newAgentCmd(), newCloudInitCmd(), newBridgeCmd(), newVaultCmd(), newRunCmd(), newInitCmd(), newDotenvCmd(), newFactsCmd(), newTagsCmd(), newOperatorScriptCmd(), newFleetCmd(), newWatchCmd(), newBackupCmd(), newRestoreCmd(), newHistoryCmd(), newRollbackCmd(), newNetconfCmd(), newHostsCmd(), newVolumesCmd(), newDisksCmd(), newCheckCmd(), newJSONDiffCmd(), newJSONPatchCmd(), newDaemonCmd(), newDumpCmd(), newTemplateCmd(), newSDCCmd())
+	// Aliases may not shadow the help and completion commands either
+	rootCmd.InitDefaultHelpCmd()
+	rootCmd.InitDefaultCompletionCmd()
+	args, err := expandAlias(rootCmd, os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+	rootCmd.SetArgs(args)
+	addPluginCmd(rootCmd, args, &zone)
+	mapTimeouts(rootCmd)
+	rootCmd.SilenceUsage = true
+	// Errors are printed here, except those of interrupted commands
+	rootCmd.SilenceErrors = true
+	start := time.Now()
+	cmd, err := rootCmd.ExecuteC()
+	code := 0
+	if i, ok := interrupted(cmd); ok {
+		code, err = i.code(), i
+	} else if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		code = 1
+		var exit *exitError
+		if errors.As(err, &exit) {
+			code = exit.code
+		}
+	}
+	logInvocation(cmd, start, err, code)
+	os.Exit(code)
+}
+
+// keyValue is the data of the get --template template
+type keyValue struct {
+	Key   string
+	Value string
+}
+
+// getField returns the field of the JSON value of key selected by path,
+// strings unquoted and other values as JSON
+func getField(client mdata.MetadataClient, key, path string) (string, error) {
+	v, err := mdata.GetJSONPath(client, key, path)
+	if err != nil {
+		return "", err
+	}
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+	data, err := json.Marshal(v)
+	return string(data), err
+}
+
+// runCommand executes a metadata operation with the given key and optional value
+func runCommand(op func(mdata.MetadataClient) (string, error)) error {
+	cfg := clientConfig()
+	client, err := newClient(cfg)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	result, err := op(client)
+	if err != nil {
+		switch err.Error() {
+		case ERROR_NOTFOUND:
+			return err
+		}
+		return err
+	}
+	if result != "" {
+		fmt.Println(result)
+	}
+	return nil
+}
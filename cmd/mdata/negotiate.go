@@ -0,0 +1,85 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/Smithx10/go-smartos-mdata/mdata"
+	"github.com/spf13/pflag"
+)
+
+// noNegotiate, set by --no-negotiate or MDATA_NO_NEGOTIATE, skips the
+// negotiation of every connection
+var noNegotiate bool
+
+// addNegotiateFlag adds the flag skipping negotiation to flags
+func addNegotiateFlag(flags *pflag.FlagSet) {
+	flags.BoolVar(&noNegotiate, "no-negotiate", os.Getenv("MDATA_NO_NEGOTIATE") != "", "Send requests without negotiating the protocol first (env MDATA_NO_NEGOTIATE)")
+}
+
+// negotiationDir holds the markers of the sockets whose server negotiated
+// V2. It is cleared on boot, as servers may change between boots.
+func negotiationDir() string {
+	if dir := os.Getenv("MDATA_NEGOTIATION_DIR"); dir != "" {
+		return dir
+	}
+	switch runtime.GOOS {
+	case "illumos", "solaris":
+		return "/var/run/mdata-negotiated"
+	case "windows":
+		return filepath.Join(os.Getenv("ProgramData"), "mdata", "negotiated")
+	default:
+		return "/run/mdata-negotiated"
+	}
+}
+
+// negotiationMarker returns the marker file of the socket of cfg, or "" if
+// cfg does not use a socket
+func negotiationMarker(cfg mdata.ClientConfig) string {
+	if cfg.SocketConfig == nil {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(cfg.SocketConfig.Network + " " + cfg.SocketConfig.Address))
+	return filepath.Join(negotiationDir(), hex.EncodeToString(sum[:16]))
+}
+
+// skipNegotiation sets NoNegotiate in cfg when --no-negotiate is given or
+// the server of its socket negotiated V2 before
+func skipNegotiation(cfg *mdata.ClientConfig) {
+	if noNegotiate {
+		cfg.NoNegotiate = true
+		return
+	}
+	if marker := negotiationMarker(*cfg); marker != "" {
+		if _, err := os.Stat(marker); err == nil {
+			cfg.NoNegotiate = true
+		}
+	}
+}
+
+// newClient connects to the metadata service described by cfg, recording a
+// successful negotiation for skipNegotiation, and returns the client
+// tracked for handleSignals. The negotiation is recorded for the socket the
+// client connected to, which is not the first one of cfg when that could
+// not be connected. Users that cannot write the marker directory simply
+// negotiate every time.
+func newClient(cfg mdata.ClientConfig) (mdata.MetadataClient, error) {
+	client, err := mdata.NewMetadataClient(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create client (%s): %w", cfg.Transport, err)
+	}
+	connected, ok := mdata.ConnectedConfig(client)
+	if ok {
+		recordTransport(connected)
+	}
+	if marker := negotiationMarker(connected); ok && marker != "" && !connected.NoNegotiate {
+		if os.MkdirAll(filepath.Dir(marker), 0o755) == nil {
+			os.WriteFile(marker, nil, 0o644)
+		}
+	}
+	return trackClient(client), nil
+}
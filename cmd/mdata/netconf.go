@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Smithx10/go-smartos-mdata/mdatanetconf"
+	"github.com/spf13/cobra"
+)
+
+// newNetconfCmd builds the "netconf" command rendering network configuration from metadata
+func newNetconfCmd() *cobra.Command {
+	var (
+		format string
+		apply  bool
+		root   string
+	)
+
+	formats := make([]string, len(mdatanetconf.Formats))
+	for i, f := range mdatanetconf.Formats {
+		formats[i] = string(f)
+	}
+
+	cmd := &cobra.Command{
+		Use:   "netconf",
+		Short: "Render network configuration from sdc:nics",
+		Long: `Netconf renders the network configuration described by sdc:nics, sdc:routes,
+sdc:resolvers and sdc:dns_domain as configuration files for the guest's
+network manager: systemd-networkd, netplan, ifcfg (RHEL network scripts and
+NetworkManager) or illumos (/etc/hostname.<interface> and friends).
+
+Static addresses, DHCP ("dhcp") and IPv6 autoconfiguration ("addrconf") are
+supported. Default gateways and resolvers are configured on the primary NIC;
+routes go to the NIC whose subnet holds their gateway. Interfaces are matched
+by MAC address for systemd-networkd and netplan.
+
+Without --apply the files are printed, each preceded by its path. With
+--apply they are written under --root; the network manager picks them up on
+its next restart or at boot.`,
+		Args: cobra.ExactArgs(0),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			store := newUpstreamStore(clientConfig(), true)
+			defer store.Close()
+
+			network, err := mdatanetconf.Read(store)
+			if err != nil {
+				return err
+			}
+			files, err := mdatanetconf.Render(network, mdatanetconf.Format(format))
+			if err != nil {
+				return fmt.Errorf("%w: expected %s", err, strings.Join(formats, ", "))
+			}
+
+			if !apply {
+				for i, f := range files {
+					if i > 0 {
+						fmt.Println()
+					}
+					fmt.Printf("==> %s <==\n", f.Path)
+					os.Stdout.Write(f.Content)
+				}
+				return nil
+			}
+			written, err := mdatanetconf.Install(files, root)
+			for _, path := range written {
+				fmt.Printf("Wrote %s\n", path)
+			}
+			return err
+		},
+	}
+
+	cmd.Flags().StringVar(&format, "format", "", "Configuration format: "+strings.Join(formats, ", "))
+	cmd.Flags().BoolVar(&apply, "apply", false, "Write the files instead of printing them")
+	cmd.Flags().StringVar(&root, "root", "/", "Directory the files are written under with --apply")
+	cmd.MarkFlagRequired("format")
+	return cmd
+}
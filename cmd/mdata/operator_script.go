@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/Smithx10/go-smartos-mdata/mdatascript"
+	"github.com/spf13/cobra"
+)
+
+// newOperatorScriptCmd builds the "operator-script" command running the operator-script from metadata
+func newOperatorScriptCmd() *cobra.Command {
+	var (
+		opts    mdatascript.Options
+		logFile string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "operator-script",
+		Short: "Run sdc:operator-script and record its exit status in metadata",
+		Long: `Operator-script fetches sdc:operator-script and runs it, doing nothing when
+the key is unset. A script without an interpreter line is run by /bin/sh.
+
+The script runs in a fresh temporary directory unless --dir is given, in its
+own process group so that --timeout kills everything it started, and
+optionally as another user and with a clean environment. Its output is
+appended to --log. Afterwards the outcome (exit code, duration, whether it
+timed out and the script's SHA-256) is written as JSON to --status-key, so
+operators can see the result with "mdata get".
+
+The command exits non-zero when the script fails.`,
+		Args: cobra.ExactArgs(0),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var out io.WriteCloser = nopCloser{os.Stdout}
+			if logFile != "-" {
+				f, err := os.OpenFile(logFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+				if err != nil {
+					return fmt.Errorf("failed to open log: %w", err)
+				}
+				out = f
+			}
+			defer out.Close()
+			opts.Output = out
+
+			store := newUpstreamStore(clientConfig(), true)
+			defer store.Close()
+
+			fmt.Fprintf(out, "=== %s started at %s\n", opts.Key, time.Now().UTC().Format(time.RFC3339))
+			status, err := mdatascript.Run(store, opts)
+			if status == nil && err == nil {
+				fmt.Fprintf(out, "=== %s is not set\n", opts.Key)
+				return nil
+			}
+			if status != nil {
+				fmt.Fprintf(out, "=== %s exited with status %d after %s\n", opts.Key, status.ExitCode, status.Duration)
+			}
+			if err != nil {
+				return err
+			}
+			if status.TimedOut {
+				return fmt.Errorf("%s timed out after %s", opts.Key, opts.Timeout)
+			}
+			if status.ExitCode != 0 {
+				return fmt.Errorf("%s exited with status %d", opts.Key, status.ExitCode)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&opts.Key, "key", mdatascript.DefaultKey, "Metadata key holding the script")
+	cmd.Flags().StringVar(&opts.StatusKey, "status-key", mdatascript.DefaultStatusKey, "Metadata key receiving the outcome (- to disable)")
+	cmd.Flags().DurationVar(&opts.Timeout, "timeout", 0, "Kill the script after this long (0 for no limit)")
+	cmd.Flags().StringVar(&opts.User, "user", "", "Run the script as this user")
+	cmd.Flags().StringVar(&opts.Group, "group", "", "Run the script with this group (default: the user's primary group)")
+	cmd.Flags().StringVar(&opts.Dir, "dir", "", "Working directory (default: a temporary directory)")
+	cmd.Flags().BoolVar(&opts.CleanEnv, "clean-env", false, "Do not pass the caller's environment to the script")
+	cmd.Flags().StringArrayVar(&opts.Env, "env", nil, "Set NAME=value in the script's environment (repeatable)")
+	cmd.Flags().StringVar(&logFile, "log", "-", "Append the script's output to this file (- for stdout)")
+	return cmd
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/Smithx10/go-smartos-mdata/mdata"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// pluginPrefix starts the names of executables run as plugin subcommands
+const pluginPrefix = "mdata-"
+
+// pluginFlagEnv names the variables passing the flags of the root command
+// that choose and reach the metadata service on to plugins, so that the mdata
+// commands they run use the same one
+var pluginFlagEnv = map[string]string{
+	"remote":            "MDATA_REMOTE",
+	"serial-device":     "MDATA_SERIAL_DEVICE",
+	"serial-baud":       "MDATA_SERIAL_BAUD",
+	"serial-parity":     "MDATA_SERIAL_PARITY",
+	"serial-stop-bits":  "MDATA_SERIAL_STOP_BITS",
+	"serial-candidates": "MDATA_SERIAL_CANDIDATES",
+	"best-effort":       "MDATA_BEST_EFFORT",
+	"no-negotiate":      "MDATA_NO_NEGOTIATE",
+	"log-file":          "MDATA_LOG_FILE",
+	"trace-out":         "MDATA_TRACE_OUT",
+}
+
+// addPluginCmd exposes the mdata-<name> executable on PATH as the subcommand
+// <name> when the command named in args is not a built-in one, so that PATH
+// is only searched for commands that would otherwise be unknown. args is the
+// command line, after the expansion of aliases.
+func addPluginCmd(root *cobra.Command, args []string, zone *string) {
+	i := commandIndex(root, args)
+	if i < 0 {
+		return
+	}
+	name := args[i]
+	if cmd, _, err := root.Find([]string{name}); err == nil && cmd != root {
+		return
+	}
+	if path, ok := findPlugin(name); ok {
+		root.AddCommand(newPluginCmd(name, path, args, zone))
+	}
+}
+
+// findPlugin returns the plugin executable on PATH providing the subcommand
+// name. As with command lookup, the first directory on PATH providing it wins.
+func findPlugin(name string) (string, bool) {
+	if name == "" || strings.ContainsAny(name, `/\`) {
+		return "", false
+	}
+	path, err := exec.LookPath(pluginPrefix + name)
+	return path, err == nil
+}
+
+// newPluginCmd builds the subcommand running the plugin at path with the
+// arguments following its name in args
+func newPluginCmd(name, path string, args []string, zone *string) *cobra.Command {
+	return &cobra.Command{
+		Use:   name,
+		Short: fmt.Sprintf("Run the %s%s plugin", pluginPrefix, name),
+		Long: fmt.Sprintf(`Runs %s with the remaining arguments. With --zone, the zone and its
+metadata socket are passed in MDATA_ZONE and MDATA_SOCKET. --remote, the
+--serial-* flags, --best-effort, --no-negotiate, --log-file and --trace-out
+are passed in their MDATA_* variables, so that the mdata commands the plugin
+runs use the same metadata service. MDATA_BIN names this mdata binary.`, path),
+		DisableFlagParsing: true,
+		RunE: func(cmd *cobra.Command, pluginArgs []string) error {
+			// Arguments after the plugin name are its own; flags before it are ours
+			root := cmd.Root()
+			if i := commandIndex(root, args); i >= 0 && args[i] == name {
+				if err := root.PersistentFlags().Parse(args[:i]); err != nil {
+					return err
+				}
+				pluginArgs = args[i+1:]
+			}
+			env := os.Environ()
+			if *zone != "" {
+				env = append(env, "MDATA_ZONE="+*zone)
+				if remoteHost == "" {
+					cfg, err := mdata.ZoneClientConfig(*zone)
+					if err != nil {
+						return err
+					}
+					env = append(env, "MDATA_SOCKET="+cfg.SocketConfig.Address)
+				}
+			}
+			root.PersistentFlags().Visit(func(f *pflag.Flag) {
+				if key, ok := pluginFlagEnv[f.Name]; ok {
+					env = append(env, key+"="+flagEnvValue(f))
+				}
+			})
+			if self, err := os.Executable(); err == nil {
+				env = append(env, "MDATA_BIN="+self)
+			}
+			return execCommand(path, append([]string{path}, pluginArgs...), env)
+		},
+	}
+}
+
+// flagEnvValue returns the value of f as its variable takes it: lists
+// comma-separated, and booleans set when true and empty when false
+func flagEnvValue(f *pflag.Flag) string {
+	if v, ok := f.Value.(pflag.SliceValue); ok {
+		return strings.Join(v.GetSlice(), ",")
+	}
+	if f.Value.Type() == "bool" && f.Value.String() != "true" {
+		return ""
+	}
+	return f.Value.String()
+}
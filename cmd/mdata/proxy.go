@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/Smithx10/go-smartos-mdata/mdataserver"
+	"github.com/spf13/cobra"
+)
+
+// newProxyCmd builds the "proxy" command bridging the metadata channel to a local socket
+func newProxyCmd() *cobra.Command {
+	var (
+		network    string
+		address    string
+		persistent bool
+		stdio      bool
+		secret     string
+		pool       poolSettings
+	)
+
+	cmd := &cobra.Command{
+		Use:         "proxy",
+		Annotations: map[string]string{ownSignals: "true"},
+		Short:       "Share the metadata channel with local consumers over a socket",
+		Long: `Proxy owns the metadata channel (typically the serial device on a KVM or
+bhyve guest) and serves the V2 protocol on a local socket, serializing
+requests from any number of clients. Point clients at it with MDATA_SOCKET
+(tcp://host:port for a TCP listener).
+
+With --secret-file, clients must authenticate with the shared secret before
+sending requests; they read it from the file named by MDATA_SECRET_FILE.
+Authentication does not encrypt traffic.
+
+When the upstream is itself a socket, --max-conns lets the proxy forward up to
+that many requests at once over a pool of upstream connections.
+
+With --stdio, the proxy serves a single client on its standard input and
+output instead, until the input ends, as "mdata --remote" runs it over ssh.`,
+		Args: cobra.ExactArgs(0),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			key, err := readSecretFile(secret)
+			if err != nil {
+				return err
+			}
+			cfg := clientConfig()
+			if stdio {
+				store := newUpstreamStore(cfg, persistent)
+				defer store.Close()
+				srv := mdataserver.NewServer(store)
+				srv.Secret = key
+				onShutdown(func() { srv.Close() })
+				return srv.ServeConn(stdioConn{})
+			}
+			if cfg.SocketConfig != nil && cfg.SocketConfig.Address == address {
+				return fmt.Errorf("refusing to proxy %s to itself; unset MDATA_SOCKET", address)
+			}
+
+			store := newSharedUpstreamStore(cfg, pool, persistent)
+			defer store.Close()
+
+			if network == "unix" {
+				// Remove a stale socket left behind by a previous run
+				if err := os.Remove(address); err != nil && !os.IsNotExist(err) {
+					return fmt.Errorf("failed to remove stale socket %s: %w", address, err)
+				}
+			}
+
+			srv := mdataserver.NewServer(store)
+			srv.Secret = key
+			onShutdown(func() { srv.Close() })
+
+			if err := srv.ListenAndServe(network, address); err != mdataserver.ErrServerClosed {
+				return err
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&network, "network", "unix", "Listener network (unix or tcp)")
+	cmd.Flags().StringVar(&address, "address", "/var/run/mdata.sock", "Listener address")
+	cmd.Flags().BoolVar(&persistent, "persistent", false, "Keep one upstream connection open instead of reconnecting per request")
+	cmd.Flags().BoolVar(&stdio, "stdio", false, "Serve one client on standard input and output instead of listening")
+	cmd.Flags().StringVar(&secret, "secret-file", "", "Require clients to authenticate with the shared secret in this file")
+	addPoolFlags(cmd.Flags(), &pool)
+	return cmd
+}
+
+// stdioConn is the connection of a client on the standard input and output
+type stdioConn struct{}
+
+func (stdioConn) Read(b []byte) (int, error) { return os.Stdin.Read(b) }
+
+func (stdioConn) Write(b []byte) (int, error) { return os.Stdout.Write(b) }
+
+// Close closes the standard output, ending the session of the client
+func (stdioConn) Close() error { return os.Stdout.Close() }
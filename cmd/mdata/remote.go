@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/Smithx10/go-smartos-mdata/mdata"
+	"github.com/spf13/pflag"
+)
+
+var (
+	// remoteHost, set by --remote or MDATA_REMOTE, is the host whose
+	// metadata commands use
+	remoteHost string
+	// remoteConfig, set from remoteHost, replaces the default client
+	// configuration
+	remoteConfig *mdata.ClientConfig
+)
+
+// addRemoteFlag adds the flag naming the remote host to flags
+func addRemoteFlag(flags *pflag.FlagSet) {
+	flags.StringVar(&remoteHost, "remote", os.Getenv("MDATA_REMOTE"), "Use the metadata of this host, as [user@]host, through ssh (env MDATA_REMOTE)")
+}
+
+// remoteClientConfig returns the configuration reaching the metadata of host
+// through "mdata proxy --stdio" run there by ssh, in zone if set. MDATA_SSH
+// replaces the ssh command, for example with "ssh -i ~/.ssh/triage", and
+// MDATA_REMOTE_MDATA the path of mdata on the host.
+func remoteClientConfig(host, zone string) (mdata.ClientConfig, error) {
+	ssh := []string{"ssh"}
+	if v := os.Getenv("MDATA_SSH"); v != "" {
+		words, err := splitWords(v)
+		if err != nil {
+			return mdata.ClientConfig{}, fmt.Errorf("invalid MDATA_SSH: %w", err)
+		}
+		if len(words) > 0 {
+			ssh = words
+		}
+	}
+	remote := "mdata"
+	if v := os.Getenv("MDATA_REMOTE_MDATA"); v != "" {
+		remote = v
+	}
+	args := append(ssh[1:], "-T", host, "--", remote)
+	if zone != "" {
+		args = append(args, "--zone", zone)
+	}
+	args = append(args, "proxy", "--stdio")
+	return mdata.ExecClientConfig(ssh[0], args...), nil
+}
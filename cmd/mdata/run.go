@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/Smithx10/go-smartos-mdata/mdataagent"
+	"github.com/Smithx10/go-smartos-mdata/mdataserver"
+	"github.com/spf13/cobra"
+)
+
+// invalidEnvChars matches characters not allowed in environment variable names
+var invalidEnvChars = regexp.MustCompile(`[^A-Z0-9_]`)
+
+// newRunCmd builds the "run" command executing a program configured by metadata
+func newRunCmd() *cobra.Command {
+	var (
+		prefix    string
+		envPrefix string
+		keys      []string
+		templates []string
+		keepEnv   bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "run [flags] -- command [args...]",
+		Short: "Run a command with metadata in its environment",
+		Long: `Run fetches metadata, exports it to the environment, renders templates, and
+then replaces itself with the command, making it usable as a container or
+service entrypoint.
+
+Every listed key starting with --prefix is exported. The variable name is the
+key without the prefix, upper-cased with other characters replaced by "_", so
+with --prefix app: the key app:db-host becomes DB_HOST. --key exports further
+keys such as sdc:uuid, optionally under an explicit name (sdc:uuid=INSTANCE_ID).
+
+--template renders a text/template file the same way the agent does, with the
+functions of the mdatatmpl package, before the command starts.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path, err := exec.LookPath(args[0])
+			if err != nil {
+				return err
+			}
+
+			store := newUpstreamStore(clientConfig(), true)
+			defer store.Close()
+
+			vars, err := exportedKeys(store, prefix, envPrefix, keys)
+			if err != nil {
+				return err
+			}
+
+			env := os.Environ()
+			set := make(map[string]bool)
+			for _, kv := range env {
+				name, _, _ := strings.Cut(kv, "=")
+				set[name] = true
+			}
+			for _, key := range sortedKeys(vars) {
+				name := vars[key]
+				if keepEnv && set[name] {
+					continue
+				}
+				value, ok, err := store.Get(key)
+				if err != nil {
+					return fmt.Errorf("failed to get %s: %w", key, err)
+				}
+				if !ok {
+					return fmt.Errorf("key %s not found", key)
+				}
+				// Later entries win, so appending overrides the inherited value
+				env = append(env, name+"="+value)
+			}
+
+			if len(templates) > 0 {
+				cfg := mdataagent.Config{}
+				for _, t := range templates {
+					src, dest, ok := strings.Cut(t, ":")
+					if !ok || src == "" || dest == "" {
+						return fmt.Errorf("invalid --template %q: expected source:destination", t)
+					}
+					cfg.Files = append(cfg.Files, mdataagent.File{Template: src, Destination: dest})
+				}
+				if err := cfg.Validate(); err != nil {
+					return err
+				}
+				if err := mdataagent.New(cfg, store).RunOnce(); err != nil {
+					return err
+				}
+			}
+
+			// Release the metadata channel before handing the process over
+			store.Close()
+			return execCommand(path, args, env)
+		},
+	}
+
+	// Flags after the command name belong to the command
+	cmd.Flags().SetInterspersed(false)
+	cmd.Flags().StringVar(&prefix, "prefix", "", "Export listed keys starting with this prefix (default: all listed keys)")
+	cmd.Flags().StringVar(&envPrefix, "env-prefix", "", "Prepend this to every generated variable name")
+	cmd.Flags().StringArrayVar(&keys, "key", nil, "Also export this key, as KEY or KEY=NAME (repeatable)")
+	cmd.Flags().StringArrayVar(&templates, "template", nil, "Render a template before starting, as source:destination (repeatable)")
+	cmd.Flags().BoolVar(&keepEnv, "keep-env", false, "Do not override variables already set in the environment")
+	return cmd
+}
+
+// exportedKeys maps the keys exported by the --prefix, --env-prefix and --key
+// flags of run and dotenv to their environment variable names
+func exportedKeys(store mdataserver.Store, prefix, envPrefix string, keys []string) (map[string]string, error) {
+	vars := make(map[string]string)
+	listed, err := store.Keys()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list keys: %w", err)
+	}
+	for _, key := range listed {
+		if name, ok := strings.CutPrefix(key, prefix); ok && name != "" {
+			vars[key] = envName(envPrefix + name)
+		}
+	}
+	for _, k := range keys {
+		key, name, ok := strings.Cut(k, "=")
+		if !ok {
+			name = envName(envPrefix + strings.TrimPrefix(key, prefix))
+		}
+		vars[key] = name
+	}
+	return vars, nil
+}
+
+// sortedKeys returns the keys of vars in order
+func sortedKeys(vars map[string]string) []string {
+	keys := make([]string, 0, len(vars))
+	for key := range vars {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// envName turns s into an environment variable name
+func envName(s string) string {
+	return invalidEnvChars.ReplaceAllString(strings.ToUpper(s), "_")
+}
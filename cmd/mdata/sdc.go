@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/Smithx10/go-smartos-mdata/mdatafacts"
+	"github.com/spf13/cobra"
+)
+
+// newSDCCmd builds the "sdc" command summarizing the sdc: keys of the instance
+func newSDCCmd() *cobra.Command {
+	var output string
+
+	cmd := &cobra.Command{
+		Use:   "sdc",
+		Short: "Print a summary of the instance from its sdc: keys",
+		Long: `Sdc gets the well-known sdc: keys describing the instance, such as
+sdc:uuid, sdc:alias, sdc:hostname, sdc:nics, sdc:routes and sdc:resolvers,
+and prints those present in one summary. Keys the platform or brand does not
+provide are left out.
+
+The table format lists each key with its value, JSON values such as sdc:nics
+on one line. The json format is an object of the keys without their sdc:
+prefix, with JSON values decoded, as in the sdc part of "mdata facts":
+
+    mdata sdc -o json | jq -r '.nics[0].ip'`,
+		Args: cobra.ExactArgs(0),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if output != "table" && output != "json" {
+				return fmt.Errorf("invalid output format %q: expected table or json", output)
+			}
+			store := newUpstreamStore(clientConfig(), true)
+			defer store.Close()
+
+			facts, err := mdatafacts.Gather(store, mdatafacts.Options{NoMetadata: true})
+			if err != nil {
+				return err
+			}
+
+			if output == "json" {
+				data, err := json.MarshalIndent(facts.Ansible()["sdc"], "", "  ")
+				if err != nil {
+					return err
+				}
+				_, err = os.Stdout.Write(append(data, '\n'))
+				return err
+			}
+
+			var buf bytes.Buffer
+			w := tabwriter.NewWriter(&buf, 0, 8, 2, ' ', 0)
+			fmt.Fprintln(w, "KEY\tVALUE")
+			for _, key := range mdatafacts.SDCKeys {
+				value, ok := facts.SDC[key]
+				if !ok {
+					continue
+				}
+				var compact bytes.Buffer
+				if json.Compact(&compact, []byte(value)) == nil {
+					value = compact.String()
+				}
+				fmt.Fprintf(w, "%s\t%s\n", key, strings.ReplaceAll(value, "\n", `\n`))
+			}
+			w.Flush()
+			_, err = os.Stdout.Write(buf.Bytes())
+			return err
+		},
+	}
+
+	cmd.Flags().StringVarP(&output, "output", "o", "table", "Output format: table or json")
+	return cmd
+}
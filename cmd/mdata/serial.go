@@ -0,0 +1,201 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/Smithx10/go-smartos-mdata/mdata"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+// defaultConfigFile holds settings of the mdata command, read unless
+// MDATA_CONFIG names another file
+const defaultConfigFile = "/etc/mdata/mdata.yaml"
+
+// configFile is the mdata command's config file (YAML or JSON)
+type configFile struct {
+	Serial  serialSettings    `yaml:"serial"`
+	Aliases map[string]string `yaml:"aliases"`
+}
+
+// readConfigFile reads the config file, which may be absent unless
+// MDATA_CONFIG names it
+func readConfigFile() (configFile, error) {
+	var cfg configFile
+	path, explicit := os.LookupEnv("MDATA_CONFIG")
+	if !explicit {
+		path = defaultConfigFile
+	}
+	raw, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if err := yaml.Unmarshal(raw, &cfg); err != nil {
+			return cfg, fmt.Errorf("failed to parse config %s: %w", path, err)
+		}
+	case explicit || !errors.Is(err, os.ErrNotExist):
+		return cfg, fmt.Errorf("failed to read config %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// serialSettings override the line settings of the serial channel; empty
+// fields keep the defaults
+type serialSettings struct {
+	Device   string `yaml:"device"`
+	Baud     int    `yaml:"baud"`
+	Parity   string `yaml:"parity"`
+	StopBits string `yaml:"stop_bits"`
+	// Candidates are the ports tried in order, the first replacing the
+	// detected default device
+	Candidates []string `yaml:"candidates"`
+}
+
+// serialFlags are the serial settings given on the command line
+var serialFlags serialSettings
+
+// serialOverride, set from the config file, environment and flags, is
+// applied by clientConfig to the serial transport
+var serialOverride *serialSettings
+
+// addSerialFlags adds the flags overriding the serial settings to flags
+func addSerialFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&serialFlags.Device, "serial-device", "", "Serial device of the metadata channel, which selects the serial transport (env MDATA_SERIAL_DEVICE)")
+	flags.IntVar(&serialFlags.Baud, "serial-baud", 0, "Baud rate of the serial channel (env MDATA_SERIAL_BAUD)")
+	flags.StringVar(&serialFlags.Parity, "serial-parity", "", "Parity of the serial channel: none, odd, even, mark or space (env MDATA_SERIAL_PARITY)")
+	flags.StringVar(&serialFlags.StopBits, "serial-stop-bits", "", "Stop bits of the serial channel: 1, 1.5 or 2 (env MDATA_SERIAL_STOP_BITS)")
+	flags.StringSliceVar(&serialFlags.Candidates, "serial-candidates", nil, "Serial devices that may carry the metadata channel, in the order tried (env MDATA_SERIAL_CANDIDATES, comma-separated)")
+}
+
+// loadSerialSettings resolves the serial settings, flags taking precedence
+// over the environment and the environment over the config file, and checks
+// them
+func loadSerialSettings() error {
+	cfg, err := readConfigFile()
+	if err != nil {
+		return err
+	}
+	s := cfg.Serial
+
+	if v := os.Getenv("MDATA_SERIAL_DEVICE"); v != "" {
+		s.Device = v
+	}
+	if v := os.Getenv("MDATA_SERIAL_BAUD"); v != "" {
+		baud, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid MDATA_SERIAL_BAUD %q", v)
+		}
+		s.Baud = baud
+	}
+	if v := os.Getenv("MDATA_SERIAL_PARITY"); v != "" {
+		s.Parity = v
+	}
+	if v := os.Getenv("MDATA_SERIAL_STOP_BITS"); v != "" {
+		s.StopBits = v
+	}
+	if v := os.Getenv("MDATA_SERIAL_CANDIDATES"); v != "" {
+		s.Candidates = strings.Split(v, ",")
+	}
+
+	if serialFlags.Device != "" {
+		s.Device = serialFlags.Device
+	}
+	if serialFlags.Baud != 0 {
+		s.Baud = serialFlags.Baud
+	}
+	if serialFlags.Parity != "" {
+		s.Parity = serialFlags.Parity
+	}
+	if serialFlags.StopBits != "" {
+		s.StopBits = serialFlags.StopBits
+	}
+	if len(serialFlags.Candidates) > 0 {
+		s.Candidates = serialFlags.Candidates
+	}
+
+	if s.Device == "" && s.Baud == 0 && s.Parity == "" && s.StopBits == "" && len(s.Candidates) == 0 {
+		return nil
+	}
+	if slices.Contains(s.Candidates, "") {
+		return fmt.Errorf("invalid serial candidates %q: device names must not be empty", s.Candidates)
+	}
+	if s.Baud < 0 {
+		return fmt.Errorf("invalid serial baud rate %d", s.Baud)
+	}
+	if _, err := parseParity(s.Parity); err != nil {
+		return err
+	}
+	if _, err := parseStopBits(s.StopBits); err != nil {
+		return err
+	}
+	serialOverride = &s
+	return nil
+}
+
+// apply overrides the serial settings of cfg. A device selects the serial
+// transport whatever was detected; the other settings only apply to it.
+func (s *serialSettings) apply(cfg mdata.ClientConfig) mdata.ClientConfig {
+	if s.Device != "" && cfg.SerialConfig == nil {
+		serial := mdata.SerialClientConfig(s.Device)
+		serial.Audit, serial.Redact, serial.Middleware = cfg.Audit, cfg.Redact, cfg.Middleware
+		cfg = serial
+	}
+	if cfg.SerialConfig == nil {
+		return cfg
+	}
+	sc := *cfg.SerialConfig
+	if s.Device != "" {
+		sc.Name = s.Device
+	} else if len(s.Candidates) > 0 {
+		sc.Name = s.Candidates[0]
+	}
+	if len(s.Candidates) > 0 {
+		sc.Candidates = s.Candidates
+	}
+	if s.Baud != 0 {
+		sc.Baud = s.Baud
+	}
+	// Both were checked by loadSerialSettings
+	if s.Parity != "" {
+		sc.Parity, _ = parseParity(s.Parity)
+	}
+	if s.StopBits != "" {
+		sc.StopBits, _ = parseStopBits(s.StopBits)
+	}
+	cfg.SerialConfig = &sc
+	return cfg
+}
+
+// parseParity parses a parity by name or by its letter in the 8N1 notation
+func parseParity(s string) (mdata.Parity, error) {
+	switch strings.ToLower(s) {
+	case "", "none", "n":
+		return mdata.ParityNone, nil
+	case "odd", "o":
+		return mdata.ParityOdd, nil
+	case "even", "e":
+		return mdata.ParityEven, nil
+	case "mark", "m":
+		return mdata.ParityMark, nil
+	case "space", "s":
+		return mdata.ParitySpace, nil
+	}
+	return 0, fmt.Errorf("invalid serial parity %q: expected none, odd, even, mark or space", s)
+}
+
+// parseStopBits parses a number of stop bits
+func parseStopBits(s string) (mdata.StopBits, error) {
+	switch s {
+	case "", "1":
+		return mdata.Stop1, nil
+	case "1.5":
+		return mdata.Stop1Half, nil
+	case "2":
+		return mdata.Stop2, nil
+	}
+	return 0, fmt.Errorf("invalid serial stop bits %q: expected 1, 1.5 or 2", s)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Smithx10/go-smartos-mdata/mdataserver"
+	"github.com/spf13/cobra"
+)
+
+// newServeCmd builds the "serve" command running an embedded V2 metadata server
+func newServeCmd() *cobra.Command {
+	var (
+		network   string
+		address   string
+		storeSpec string
+		values    []string
+		secret    string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Serve the metadata protocol from a local store",
+		Args:  cobra.ExactArgs(0),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			key, err := readSecretFile(secret)
+			if err != nil {
+				return err
+			}
+			store, err := openStore(storeSpec)
+			if err != nil {
+				return err
+			}
+			for _, kv := range values {
+				key, value, ok := strings.Cut(kv, "=")
+				if !ok {
+					return fmt.Errorf("invalid --set %q: expected key=value", kv)
+				}
+				if err := store.Put(key, value); err != nil {
+					return fmt.Errorf("failed to seed key %q: %w", key, err)
+				}
+			}
+
+			if network == "unix" {
+				// Remove a stale socket left behind by a previous run
+				if err := os.Remove(address); err != nil && !os.IsNotExist(err) {
+					return fmt.Errorf("failed to remove stale socket %s: %w", address, err)
+				}
+			}
+
+			srv := mdataserver.NewServer(store)
+			srv.Secret = key
+			return srv.ListenAndServe(network, address)
+		},
+	}
+
+	cmd.Flags().StringVar(&network, "network", "unix", "Listener network (unix or tcp)")
+	cmd.Flags().StringVar(&address, "address", "/var/run/mdata.sock", "Listener address")
+	cmd.Flags().StringVar(&storeSpec, "store", "memory", "Backing store: memory, dir:PATH, json:PATH or vmadm:PATH")
+	cmd.Flags().StringArrayVar(&values, "set", nil, "Initial key=value pair (repeatable)")
+	cmd.Flags().StringVar(&secret, "secret-file", "", "Require clients to authenticate with the shared secret in this file")
+	return cmd
+}
+
+// openStore creates the store described by spec ("memory", "dir:PATH", "json:PATH" or "vmadm:PATH")
+func openStore(spec string) (mdataserver.Store, error) {
+	kind, path, _ := strings.Cut(spec, ":")
+	switch kind {
+	case "memory":
+		return mdataserver.NewMemoryStore(nil), nil
+	case "dir":
+		if path == "" {
+			return nil, fmt.Errorf("store %q requires a path", spec)
+		}
+		return mdataserver.NewDirStore(path)
+	case "json":
+		if path == "" {
+			return nil, fmt.Errorf("store %q requires a path", spec)
+		}
+		return mdataserver.NewJSONFileStore(path)
+	case "vmadm":
+		if path == "" {
+			return nil, fmt.Errorf("store %q requires a path", spec)
+		}
+		return mdataserver.LoadVMAdmStore(path)
+	default:
+		return nil, fmt.Errorf("unknown store %q", spec)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/Smithx10/go-smartos-mdata/mdata"
+	"github.com/spf13/cobra"
+)
+
+// ownSignals annotates commands handling SIGINT and SIGTERM themselves, such
+// as those shutting down servers gracefully, which handleSignals leaves alone
+// along with their subcommands
+const ownSignals = "mdata.own-signals"
+
+// openClients are the metadata clients of this invocation not yet closed
+var openClients = struct {
+	sync.Mutex
+	m map[*trackedClient]struct{}
+}{m: make(map[*trackedClient]struct{})}
+
+// trackedClient is a client closed by handleSignals on a signal
+type trackedClient struct {
+	mdata.MetadataClient
+}
+
+// trackClient returns client, which handleSignals closes on a signal until
+// it is closed
+func trackClient(client mdata.MetadataClient) mdata.MetadataClient {
+	c := &trackedClient{client}
+	openClients.Lock()
+	openClients.m[c] = struct{}{}
+	openClients.Unlock()
+	return c
+}
+
+// Close implements MetadataClient.Close
+func (c *trackedClient) Close() error {
+	openClients.Lock()
+	delete(openClients.m, c)
+	openClients.Unlock()
+	return c.MetadataClient.Close()
+}
+
+// GetIfChanged implements mdata.ConditionalGetter
+func (c *trackedClient) GetIfChanged(key, lastChecksum string) (string, string, error) {
+	return mdata.GetIfChanged(c.MetadataClient, key, lastChecksum)
+}
+
+// interruption cancels the context of a command interrupted by a signal
+type interruption struct {
+	sig os.Signal
+}
+
+func (i interruption) Error() string {
+	if i.sig == syscall.SIGTERM {
+		return "terminated by SIGTERM"
+	}
+	return "interrupted by SIGINT"
+}
+
+// code is the exit status of a command interrupted by i.sig
+func (i interruption) code() int {
+	if i.sig == syscall.SIGTERM {
+		return 143
+	}
+	return 130
+}
+
+// interrupted returns the interruption of cmd by handleSignals, if any
+func interrupted(cmd *cobra.Command) (interruption, bool) {
+	if cmd == nil || cmd.Context() == nil {
+		return interruption{}, false
+	}
+	i, ok := context.Cause(cmd.Context()).(interruption)
+	return i, ok
+}
+
+// handleSignals makes SIGINT and SIGTERM close the open clients and cancel
+// the context of cmd, which then exits with 130 or 143, as a shell reports
+// commands killed by them, unless cmd handles the signals itself. Closing a
+// client lets a request being written finish, so no partial frame is left on
+// the metadata channel, and fails the request waiting for its response. A
+// second signal kills the process.
+func handleSignals(cmd *cobra.Command) {
+	for c := cmd; c != nil; c = c.Parent() {
+		if c.Annotations[ownSignals] != "" {
+			return
+		}
+	}
+	ctx, cancel := context.WithCancelCause(cmd.Context())
+	cmd.SetContext(ctx)
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-sigs
+		signal.Stop(sigs)
+		openClients.Lock()
+		for c := range openClients.m {
+			c.MetadataClient.Close()
+		}
+		openClients.Unlock()
+		cancel(interruption{sig})
+	}()
+}
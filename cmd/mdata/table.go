@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"strings"
+)
+
+// tsvEscaper escapes the characters that would break a TSV field
+var tsvEscaper = strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`)
+
+// checkTableFormat checks a --output format of rows
+func checkTableFormat(format string) error {
+	switch format {
+	case "csv", "tsv":
+		return nil
+	}
+	return fmt.Errorf("invalid output format %q: expected csv or tsv", format)
+}
+
+// formatTable renders rows, preceded by header unless nil, as CSV quoted as
+// in RFC 4180, or as TSV with backslash escapes for tabs, newlines and
+// backslashes, without the final newline
+func formatTable(format string, header []string, rows [][]string) (string, error) {
+	if header != nil {
+		rows = append([][]string{header}, rows...)
+	}
+	var buf strings.Builder
+	if format == "tsv" {
+		for _, row := range rows {
+			for i, field := range row {
+				if i > 0 {
+					buf.WriteByte('\t')
+				}
+				buf.WriteString(tsvEscaper.Replace(field))
+			}
+			buf.WriteByte('\n')
+		}
+	} else {
+		w := csv.NewWriter(&buf)
+		if err := w.WriteAll(rows); err != nil {
+			return "", err
+		}
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
+
+// tableHeader returns the header row of columns if header is set, else nil
+func tableHeader(header bool, columns ...string) []string {
+	if !header {
+		return nil
+	}
+	return columns
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Smithx10/go-smartos-mdata/mdata"
+	"github.com/spf13/cobra"
+)
+
+// newTagsCmd builds the "tags" command printing the instance tags
+func newTagsCmd() *cobra.Command {
+	var asJSON bool
+
+	cmd := &cobra.Command{
+		Use:   "tags [name]",
+		Short: "Print the instance tags from sdc:tags",
+		Long: `Tags prints the instance tags as name=value lines, or the value of a single
+tag when a name is given. A missing tag is an error, so the command can drive
+shell conditionals:
+
+    if [ "$(mdata tags role)" = "db" ]; then ...`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runCommand(func(client mdata.MetadataClient) (string, error) {
+				tags, err := mdata.Tags(client)
+				if err != nil {
+					return "", err
+				}
+				if len(args) == 1 {
+					value, ok := tags[args[0]]
+					if !ok {
+						return "", fmt.Errorf("tag %s not found", args[0])
+					}
+					return value, nil
+				}
+				if asJSON {
+					data, err := json.MarshalIndent(tags, "", "  ")
+					return string(data), err
+				}
+				names := make([]string, 0, len(tags))
+				for name := range tags {
+					names = append(names, name)
+				}
+				sort.Strings(names)
+				lines := make([]string, len(names))
+				for i, name := range names {
+					lines[i] = name + "=" + tags[name]
+				}
+				return strings.Join(lines, "\n"), nil
+			})
+		},
+	}
+
+	cmd.Flags().BoolVar(&asJSON, "json", false, "Print all tags as a JSON object")
+	return cmd
+}
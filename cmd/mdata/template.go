@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/Smithx10/go-smartos-mdata/mdataagent"
+	"github.com/spf13/cobra"
+)
+
+// newTemplateCmd builds the "template" command rendering a template file
+func newTemplateCmd() *cobra.Command {
+	var (
+		output   string
+		mode     string
+		command  string
+		watch    bool
+		interval time.Duration
+		manifest string
+		path     []string
+	)
+
+	cmd := &cobra.Command{
+		Use:         "template TEMPLATE | template --manifest FILE",
+		Annotations: map[string]string{ownSignals: "true"},
+		Short:       "Render template files with metadata, once or whenever metadata changes",
+		Long: `Template renders a text/template file with the functions of the mdatatmpl
+package, as the agent does, and prints the result or, with -o, writes it to a
+file. The file is replaced atomically and only when its content changes, and
+--exec then runs a command through the shell, such as a reload of the service
+reading the file.
+
+With --watch, template keeps running and renders again every --interval,
+writing and running --exec on every change, like a one-file agent. A render
+that fails, for example because a key is missing, is logged and leaves the
+file as it was until a later render succeeds:
+
+    mdata template --watch app.conf.tmpl -o /etc/app.conf --exec 'systemctl reload app'
+
+With --manifest, the files listed in a manifest are rendered in one pass over
+a single metadata connection. The manifest has the format of the agent config
+file, giving each file its own mode, owner, group and command; commands shared
+by several files run once, and the commands of the top-level commands list
+run once after any file changed:
+
+    files:
+      - template: /etc/app/app.conf.tmpl
+        destination: /etc/app/app.conf
+      - template: /etc/app/tls.pem.tmpl
+        destination: /etc/app/tls.pem
+        mode: "0600"
+        owner: app
+    commands: ["systemctl reload app"]
+
+The interval of the manifest applies with --watch unless --interval is given.
+
+Templates include other template files with {{ include "name" }}, or
+{{ include "name" DATA }} to set their dot, looking for them next to the
+including template, then in the template-path directories of the manifest
+and in those given with --template-path.`,
+		Args: cobra.RangeArgs(0, 1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var cfg mdataagent.Config
+			if manifest != "" {
+				if len(args) > 0 || output != "" || command != "" || cmd.Flags().Changed("mode") {
+					return fmt.Errorf("--manifest replaces the template, -o, --mode and --exec")
+				}
+				var err error
+				if cfg, err = mdataagent.LoadConfig(manifest); err != nil {
+					return err
+				}
+				if cmd.Flags().Changed("interval") {
+					cfg.Interval = mdataagent.Duration(interval)
+				}
+			} else {
+				if len(args) == 0 {
+					return fmt.Errorf("a template or --manifest is required")
+				}
+				if output == "" && (watch || command != "" || cmd.Flags().Changed("mode")) {
+					return fmt.Errorf("--watch, --exec and --mode require -o")
+				}
+				cfg = mdataagent.Config{
+					Interval: mdataagent.Duration(interval),
+					Files:    []mdataagent.File{{Template: args[0], Destination: output, Mode: mode, Command: command}},
+				}
+				if output == "" {
+					// Validate requires a destination, which printing does not use
+					cfg.Files[0].Destination = os.DevNull
+				}
+			}
+			cfg.TemplatePath = append(cfg.TemplatePath, path...)
+			if err := cfg.Validate(); err != nil {
+				return err
+			}
+
+			store := newUpstreamStore(clientConfig(), true)
+			defer store.Close()
+			agent := mdataagent.New(cfg, store)
+
+			if manifest == "" && output == "" {
+				content, err := agent.Render(cfg.Files[0])
+				if err != nil {
+					return err
+				}
+				_, err = os.Stdout.Write(content)
+				return err
+			}
+			if !watch {
+				return agent.RunOnce()
+			}
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			return agent.Run(ctx)
+		},
+	}
+
+	cmd.Flags().StringVarP(&output, "output", "o", "", "Write the result to this file instead of stdout")
+	cmd.Flags().StringVar(&mode, "mode", "0644", "Octal mode of the output file")
+	cmd.Flags().StringVar(&command, "exec", "", "Run this command through the shell after the output file changes")
+	cmd.Flags().BoolVarP(&watch, "watch", "w", false, "Keep running and render again whenever metadata changes")
+	cmd.Flags().DurationVar(&interval, "interval", 5*time.Second, "Time between renders with --watch")
+	cmd.Flags().StringArrayVar(&path, "template-path", nil, "Also look for included templates in this directory (repeatable)")
+	cmd.Flags().StringVarP(&manifest, "manifest", "m", "", "Render the files listed in this manifest (agent config format)")
+	return cmd
+}
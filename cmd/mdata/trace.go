@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/Smithx10/go-smartos-mdata/mdata"
+	"github.com/spf13/pflag"
+)
+
+var (
+	// traceOut, set by --trace-out or MDATA_TRACE_OUT, receives the protocol
+	// events of the clients of this invocation
+	traceOut string
+	// traceSink, set by openTraceOut, is added to every client configuration
+	traceSink func(mdata.TraceEvent)
+)
+
+// addTraceOutFlag adds the flag naming the trace file to flags
+func addTraceOutFlag(flags *pflag.FlagSet) {
+	flags.StringVar(&traceOut, "trace-out", os.Getenv("MDATA_TRACE_OUT"), "Append a JSON line for every protocol event to this file (env MDATA_TRACE_OUT)")
+}
+
+// openTraceOut opens the trace file if one is set. Like the log file, it is
+// created readable by its owner only, and it stays open until the process
+// exits.
+func openTraceOut() error {
+	if traceOut == "" {
+		return nil
+	}
+	f, err := os.OpenFile(traceOut, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open trace file: %w", err)
+	}
+	traceSink = mdata.TraceWriter(f)
+	return nil
+}
@@ -0,0 +1,12 @@
+//go:build !(linux || solaris || darwin || dragonfly || freebsd || netbsd || openbsd || windows)
+
+package main
+
+import "os"
+
+// isTerminal reports whether f is a character device, the best guess at a
+// terminal available here
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,8 @@
+//go:build linux || solaris
+
+package main
+
+import "golang.org/x/sys/unix"
+
+// ioctlReadTermios is the request reading terminal attributes
+const ioctlReadTermios = unix.TCGETS
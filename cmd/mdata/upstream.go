@@ -0,0 +1,95 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/Smithx10/go-smartos-mdata/mdata"
+	"github.com/Smithx10/go-smartos-mdata/mdataserver"
+	"github.com/spf13/pflag"
+)
+
+// newUpstreamStore returns a store forwarding to the metadata channel described by cfg
+func newUpstreamStore(cfg mdata.ClientConfig, persistent bool) *mdataserver.ClientStore {
+	return mdataserver.NewClientStore(func() (mdata.MetadataClient, error) {
+		return newClient(cfg)
+	}, persistent)
+}
+
+// upstream is a store forwarding to the metadata channel
+type upstream interface {
+	mdataserver.Store
+	Close() error
+}
+
+// poolSettings configure the connections of a store serving concurrent callers
+type poolSettings struct {
+	maxConns            int
+	idleTimeout         time.Duration
+	healthCheckInterval time.Duration
+}
+
+// addPoolFlags adds the flags of the upstream connection pool to flags
+func addPoolFlags(flags *pflag.FlagSet, s *poolSettings) {
+	flags.IntVar(&s.maxConns, "max-conns", 1, "Serve up to this many requests at once over separate upstream connections (socket transports only)")
+	flags.DurationVar(&s.idleTimeout, "idle-timeout", 0, "Close pooled upstream connections idle this long (0 keeps them)")
+	flags.DurationVar(&s.healthCheckInterval, "health-check-interval", 0, "Check idle pooled upstream connections this often (0 never)")
+}
+
+// newSharedUpstreamStore returns a store for concurrent callers: over socket
+// transports with more than one connection allowed, a pool of them, else a
+// store forwarding to the metadata channel as newUpstreamStore
+func newSharedUpstreamStore(cfg mdata.ClientConfig, s poolSettings, persistent bool) upstream {
+	if s.maxConns > 1 && cfg.SocketConfig != nil {
+		sc := *cfg.SocketConfig
+		sc.MaxConns, sc.IdleTimeout, sc.HealthCheckInterval = s.maxConns, s.idleTimeout, s.healthCheckInterval
+		pooled := cfg
+		pooled.SocketConfig = &sc
+		pool := mdata.NewPool(pooled, 0)
+		if pool.Size() > 1 {
+			return mdataserver.NewPoolStore(pool)
+		}
+		pool.Close()
+	}
+	return newUpstreamStore(cfg, persistent)
+}
+
+// onShutdown runs fn once when the process receives SIGINT or SIGTERM
+func onShutdown(fn func()) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		fn()
+	}()
+}
+
+// zoneConfig, set by --zone, replaces the default client configuration
+var zoneConfig *mdata.ClientConfig
+
+// clientConfig returns the configuration for reaching the metadata service
+func clientConfig() mdata.ClientConfig {
+	var cfg mdata.ClientConfig
+	switch {
+	case remoteConfig != nil:
+		cfg = *remoteConfig
+	case zoneConfig != nil:
+		cfg = *zoneConfig
+	case serialOverride != nil:
+		cfg = serialOverride.apply(mdata.DefaultClientConfig())
+	case !bypassDaemon && detectDaemon():
+		cfg = daemonConfig(mdata.DefaultClientConfig())
+	default:
+		cfg = mdata.DefaultClientConfig()
+	}
+	cfg.Trace = traceSink
+	cfg.Logger = log.New(os.Stderr, "", 0)
+	skipNegotiation(&cfg)
+	if bestEffort {
+		applyBestEffort(&cfg)
+	}
+	return cfg
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/Smithx10/go-smartos-mdata/mdatabridge"
+	"github.com/spf13/cobra"
+)
+
+// vaultOptions holds the flags shared by the vault subcommands
+type vaultOptions struct {
+	cfg       mdatabridge.VaultConfig
+	tokenFile string
+	auditLog  string
+}
+
+// open creates the Vault client and audit log described by the flags
+func (o *vaultOptions) open() (*mdatabridge.Vault, io.Closer, error) {
+	token, err := readSecretFile(o.tokenFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	cfg := o.cfg
+	cfg.Token = string(token)
+
+	var audit io.WriteCloser = nopCloser{os.Stderr}
+	if o.auditLog != "-" {
+		f, err := os.OpenFile(o.auditLog, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open audit log: %w", err)
+		}
+		audit = f
+	}
+	cfg.Audit = mdatabridge.NewAuditLog(audit)
+	return mdatabridge.NewVault(cfg), audit, nil
+}
+
+// nopCloser keeps the standard streams open when the audit log is closed
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
+
+// newVaultCmd builds the "vault" command moving secrets between metadata and Vault
+func newVaultCmd() *cobra.Command {
+	var opts vaultOptions
+
+	cmd := &cobra.Command{
+		Use:   "vault",
+		Short: "Move secrets between metadata and a Vault KV v2 engine",
+		Long: `Vault bridges the common pattern of bootstrapping Vault credentials through
+instance metadata. "push" copies designated keys into a Vault secret and can
+remove them from metadata afterwards; "publish" writes non-sensitive facts
+about a secret (its version and per-field SHA-256 fingerprints) into metadata.
+
+Every Vault operation is appended to the audit log as a JSON line naming the
+keys involved, never their values.`,
+	}
+
+	cmd.PersistentFlags().StringVar(&opts.cfg.Address, "address", "", "Vault address (default $VAULT_ADDR or https://127.0.0.1:8200)")
+	cmd.PersistentFlags().StringVar(&opts.tokenFile, "token-file", "", "File containing the Vault token (default $VAULT_TOKEN)")
+	cmd.PersistentFlags().StringVar(&opts.cfg.Namespace, "namespace", "", "Vault namespace (default $VAULT_NAMESPACE)")
+	cmd.PersistentFlags().StringVar(&opts.cfg.Mount, "mount", "secret", "Mount path of the KV v2 secrets engine")
+	cmd.PersistentFlags().StringVar(&opts.auditLog, "audit-log", "-", "Audit log file (- for stderr)")
+	cmd.AddCommand(newVaultPushCmd(&opts), newVaultPublishCmd(&opts))
+	return cmd
+}
+
+// newVaultPushCmd builds "vault push" writing metadata keys into a Vault secret
+func newVaultPushCmd(opts *vaultOptions) *cobra.Command {
+	var (
+		path        string
+		keys        []string
+		deleteAfter bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "push",
+		Short: "Write metadata keys into fields of a Vault secret",
+		Args:  cobra.ExactArgs(0),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(keys) == 0 {
+				return fmt.Errorf("at least one --key is required")
+			}
+			vault, audit, err := opts.open()
+			if err != nil {
+				return err
+			}
+			defer audit.Close()
+
+			store := newUpstreamStore(clientConfig(), true)
+			defer store.Close()
+
+			pushed, err := vault.Push(store, path, keys, deleteAfter)
+			if len(pushed) > 0 {
+				fmt.Printf("Pushed %s to %s/%s\n", strings.Join(pushed, ", "), opts.cfg.Mount, path)
+			} else if err == nil {
+				fmt.Println("No keys to push")
+			}
+			return err
+		},
+	}
+
+	cmd.Flags().StringVar(&path, "path", "", "Secret path within the mount")
+	cmd.Flags().StringArrayVar(&keys, "key", nil, "Metadata key to push (repeatable)")
+	cmd.Flags().BoolVar(&deleteAfter, "delete", false, "Delete pushed keys from metadata once written to Vault")
+	cmd.MarkFlagRequired("path")
+	return cmd
+}
+
+// newVaultPublishCmd builds "vault publish" writing facts about a Vault secret into metadata
+func newVaultPublishCmd(opts *vaultOptions) *cobra.Command {
+	var path, prefix string
+
+	cmd := &cobra.Command{
+		Use:   "publish",
+		Short: "Publish a Vault secret's version and field fingerprints into metadata",
+		Args:  cobra.ExactArgs(0),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			vault, audit, err := opts.open()
+			if err != nil {
+				return err
+			}
+			defer audit.Close()
+
+			store := newUpstreamStore(clientConfig(), true)
+			defer store.Close()
+
+			if prefix == "" {
+				prefix = "vault:" + strings.Trim(path, "/") + ":"
+			}
+			return vault.Publish(store, path, prefix)
+		},
+	}
+
+	cmd.Flags().StringVar(&path, "path", "", "Secret path within the mount")
+	cmd.Flags().StringVar(&prefix, "prefix", "", `Metadata key prefix (default "vault:PATH:")`)
+	cmd.MarkFlagRequired("path")
+	return cmd
+}
@@ -0,0 +1,19 @@
+package main
+
+import "runtime/debug"
+
+// version is set by release builds with -ldflags "-X main.version=v1.2.3"
+var version string
+
+// buildVersion returns the version of this build: the one set by the
+// linker, else the module version "go install ...@v1.2.3" records, else
+// "dev"
+func buildVersion() string {
+	if version != "" {
+		return version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	return "dev"
+}
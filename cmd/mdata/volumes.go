@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/Smithx10/go-smartos-mdata/mdata"
+	"github.com/spf13/cobra"
+)
+
+// newVolumesCmd builds the "volumes" command listing the volumes from sdc:volumes
+func newVolumesCmd() *cobra.Command {
+	var asJSON, fstab, vfstab bool
+
+	cmd := &cobra.Command{
+		Use:   "volumes",
+		Short: "List the volumes attached to the instance from sdc:volumes",
+		Long: `Volumes lists the NFS volumes attached to the instance. With --fstab or
+--vfstab it prints them as /etc/fstab (Linux) or /etc/vfstab (illumos) lines
+mounting each volume at its mountpoint on boot, read-only for volumes in "ro"
+mode:
+
+    mdata volumes --fstab >> /etc/fstab && mount -a`,
+		Args: cobra.ExactArgs(0),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runCommand(func(client mdata.MetadataClient) (string, error) {
+				vols, err := mdata.Volumes(client)
+				if err != nil {
+					return "", err
+				}
+				lines := make([]string, 0, len(vols))
+				switch {
+				case asJSON:
+					data, err := json.MarshalIndent(vols, "", "  ")
+					return string(data), err
+				case fstab:
+					for _, v := range vols {
+						lines = append(lines, fmt.Sprintf("%s\t%s\tnfs\t%s,_netdev\t0 0", v.NFSVolume, v.Mountpoint, volumeMode(v)))
+					}
+				case vfstab:
+					for _, v := range vols {
+						lines = append(lines, fmt.Sprintf("%s\t-\t%s\tnfs\t-\tyes\t%s", v.NFSVolume, v.Mountpoint, volumeMode(v)))
+					}
+				default:
+					var buf bytes.Buffer
+					w := tabwriter.NewWriter(&buf, 0, 8, 2, ' ', 0)
+					fmt.Fprintln(w, "NAME\tTYPE\tMODE\tMOUNTPOINT\tNFSVOLUME")
+					for _, v := range vols {
+						fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", v.Name, v.Type, volumeMode(v), v.Mountpoint, v.NFSVolume)
+					}
+					w.Flush()
+					return strings.TrimSuffix(buf.String(), "\n"), nil
+				}
+				return strings.Join(lines, "\n"), nil
+			})
+		},
+	}
+
+	cmd.Flags().BoolVar(&asJSON, "json", false, "Print the volumes as JSON")
+	cmd.Flags().BoolVar(&fstab, "fstab", false, "Print /etc/fstab lines mounting the volumes")
+	cmd.Flags().BoolVar(&vfstab, "vfstab", false, "Print illumos /etc/vfstab lines mounting the volumes")
+	cmd.MarkFlagsMutuallyExclusive("json", "fstab", "vfstab")
+	return cmd
+}
+
+// volumeMode returns the mount option for the mode of v
+func volumeMode(v mdata.Volume) string {
+	if v.ReadOnly() {
+		return "ro"
+	}
+	return "rw"
+}
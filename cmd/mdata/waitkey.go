@@ -0,0 +1,37 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Smithx10/go-smartos-mdata/mdata"
+)
+
+// Longest a WATCH request is held by waitForKey, and the polling interval of
+// servers without WATCH
+const (
+	waitKeyMaxWatch = 30 * time.Second
+	waitKeyInterval = time.Second
+)
+
+// waitForKey returns the value of key once it exists, waiting up to timeout
+// for it to be set. Giving up fails with an exitError of status exitTimeout.
+func waitForKey(client mdata.MetadataClient, key string, timeout time.Duration) (string, error) {
+	deadline := time.Now().Add(timeout)
+	state := ""
+	for {
+		value, err := client.Get(key)
+		if !errors.Is(err, mdata.ErrNotFound) {
+			return value, err
+		}
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return "", &exitError{code: exitTimeout, err: fmt.Errorf("timed out after %s waiting for %s to be set", timeout, key)}
+		}
+		// Keys starting with key include it; the first call only reads the state
+		if state, err = mdata.WaitForChange(client, key, state, min(remaining, waitKeyMaxWatch), waitKeyInterval); err != nil {
+			return "", err
+		}
+	}
+}
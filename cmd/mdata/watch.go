@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Smithx10/go-smartos-mdata/mdata"
+	"github.com/Smithx10/go-smartos-mdata/mdataserver"
+	"github.com/spf13/cobra"
+)
+
+// newWatchCmd builds the "watch" command printing metadata changes as JSON lines
+func newWatchCmd() *cobra.Command {
+	var (
+		prefix      string
+		keys        []string
+		interval    time.Duration
+		maxWait     time.Duration
+		values      bool
+		showSecrets bool
+	)
+
+	cmd := &cobra.Command{
+		Use:         "watch",
+		Annotations: map[string]string{ownSignals: "true"},
+		Short:       "Print a JSON line for every metadata change",
+		Long: `Watch follows metadata and prints one JSON object per line for every key
+that is added, changed or removed, ready to be piped into jq, vector or fluentd:
+
+    {"time":"2024-05-01T12:00:00Z","type":"changed","key":"app:port","old_sha256":"...","new_sha256":"..."}
+
+Listed keys are watched, or only those starting with --prefix; --key adds keys
+that are not listed, such as sdc: keys. The first read sets the baseline and
+prints nothing.
+
+When the metadata server supports the WATCH extension, as "mdata serve" and
+"mdata proxy" do, a request is held open until a key changes, and the keys
+are read again at least every --max-wait. Other servers, including the
+SmartOS metadata agent, are polled every --interval.
+
+Values are included with --values, except for keys that look like secrets
+(see MDATA_SECRET_PATTERNS), which are [REDACTED] unless --show-secrets is
+given.`,
+		Args: cobra.ExactArgs(0),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// The store is not closed on exit, which a held WATCH request would delay
+			store := newUpstreamStore(clientConfig(), true)
+
+			redact := mdata.Redactor{}
+			if !showSecrets {
+				redact = mdata.DefaultRedactor()
+			}
+			emit := mdata.EventWriter(os.Stdout)
+
+			// Unlisted keys are outside the server's view of the prefix
+			watchPrefix := prefix
+			if len(keys) > 0 {
+				watchPrefix = ""
+			}
+			// Learn the server's state first, so a WATCH returns only on changes
+			state, err := store.Watch(watchPrefix, "", 0)
+			watching := !errors.Is(err, mdata.ErrWatchUnsupported)
+
+			current, err := watchSnapshot(store, prefix, keys)
+			if err != nil {
+				return err
+			}
+
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				if watching {
+					result := make(chan error, 1)
+					go func() {
+						var err error
+						state, err = store.Watch(watchPrefix, state, maxWait)
+						result <- err
+					}()
+					select {
+					case <-ctx.Done():
+						return nil
+					case err = <-result:
+					}
+					if errors.Is(err, mdata.ErrWatchUnsupported) {
+						watching = false
+					} else if err != nil {
+						log.Printf("mdata watch: %v", err)
+					}
+				}
+				// Poll, and back off after a failed WATCH
+				if !watching || err != nil {
+					select {
+					case <-ctx.Done():
+						return nil
+					case <-ticker.C:
+					}
+				}
+				next, err := watchSnapshot(store, prefix, keys)
+				if err != nil {
+					log.Printf("mdata watch: %v", err)
+					continue
+				}
+				for _, ev := range diffSnapshots(current, next) {
+					if values && ev.Type != mdata.EventRemoved {
+						value := redact.Value(ev.Key, next[ev.Key])
+						ev.Value = &value
+					}
+					emit(ev)
+				}
+				current = next
+			}
+		},
+	}
+
+	cmd.Flags().StringVar(&prefix, "prefix", "", "Watch listed keys starting with this prefix (default: all listed keys)")
+	cmd.Flags().StringArrayVar(&keys, "key", nil, "Also watch this key (repeatable)")
+	cmd.Flags().DurationVar(&interval, "interval", 5*time.Second, "Time between polls when the server does not support WATCH")
+	cmd.Flags().DurationVar(&maxWait, "max-wait", 30*time.Second, "Longest time a WATCH request is held before reading again")
+	cmd.Flags().BoolVar(&values, "values", false, "Include the new value in events")
+	cmd.Flags().BoolVar(&showSecrets, "show-secrets", false, "Include the values of keys that look like secrets")
+	return cmd
+}
+
+// watchSnapshot reads the watched keys; keys that are not set are left out
+func watchSnapshot(store mdataserver.Store, prefix string, extra []string) (map[string]string, error) {
+	listed, err := store.Keys()
+	if err != nil {
+		return nil, err
+	}
+	snapshot := make(map[string]string)
+	for _, key := range append(listed, extra...) {
+		if !strings.HasPrefix(key, prefix) && !slices.Contains(extra, key) {
+			continue
+		}
+		if _, done := snapshot[key]; done {
+			continue
+		}
+		value, ok, err := store.Get(key)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			snapshot[key] = value
+		}
+	}
+	return snapshot, nil
+}
+
+// diffSnapshots returns the events turning old into next, ordered by key
+func diffSnapshots(old, next map[string]string) []mdata.ChangeEvent {
+	now := time.Now().UTC()
+	var events []mdata.ChangeEvent
+	for key, value := range next {
+		before, existed := old[key]
+		switch {
+		case !existed:
+			events = append(events, mdata.ChangeEvent{Time: now, Type: mdata.EventAdded, Key: key, NewSHA256: mdata.HashValue([]byte(value))})
+		case before != value:
+			events = append(events, mdata.ChangeEvent{Time: now, Type: mdata.EventChanged, Key: key, OldSHA256: mdata.HashValue([]byte(before)), NewSHA256: mdata.HashValue([]byte(value))})
+		}
+	}
+	for key, before := range old {
+		if _, ok := next[key]; !ok {
+			events = append(events, mdata.ChangeEvent{Time: now, Type: mdata.EventRemoved, Key: key, OldSHA256: mdata.HashValue([]byte(before))})
+		}
+	}
+	slices.SortFunc(events, func(a, b mdata.ChangeEvent) int { return strings.Compare(a.Key, b.Key) })
+	return events
+}
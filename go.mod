@@ -4,11 +4,9 @@ go 1.24.0
 
 require (
 	github.com/spf13/cobra v1.9.1
-	github.com/tarm/serial v0.0.0-20180830185346-98f6abe2eb07
+	github.com/spf13/pflag v1.0.6
+	golang.org/x/sys v0.19.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
-require (
-	github.com/inconshreveable/mousetrap v1.1.0 // indirect
-	github.com/spf13/pflag v1.0.6 // indirect
-	golang.org/x/sys v0.19.0 // indirect
-)
+require github.com/inconshreveable/mousetrap v1.1.0 // indirect
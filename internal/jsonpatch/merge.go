@@ -0,0 +1,28 @@
+// Package jsonpatch modifies decoded JSON documents as described by JSON
+// Merge Patch (RFC 7386) and JSON Patch (RFC 6902) documents, and computes
+// the JSON Patch between two documents.
+package jsonpatch
+
+// MergePatch applies the merge patch to target, both values produced by
+// encoding/json, and returns the result. Objects in the patch are merged
+// member by member into those of target, a null member deleting the member
+// of the same name; any other patch value replaces target. Objects of target
+// are modified in place.
+func MergePatch(target, patch any) any {
+	p, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]any)
+	if !ok {
+		t = make(map[string]any, len(p))
+	}
+	for name, value := range p {
+		if value == nil {
+			delete(t, name)
+			continue
+		}
+		t[name] = MergePatch(t[name], value)
+	}
+	return t
+}
@@ -0,0 +1,394 @@
+package jsonpatch
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Operation is an operation of a JSON Patch (RFC 6902) document
+type Operation struct {
+	Op    string // add, remove, replace, move, copy or test
+	Path  string // JSON Pointer (RFC 6901) to the target location
+	From  string // source location of move and copy
+	Value any    // value of add, replace and test, as produced by encoding/json
+}
+
+// MarshalJSON encodes the operation with the members its kind uses
+func (o Operation) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `{"op":%q,`, o.Op)
+	if o.Op == "move" || o.Op == "copy" {
+		from, _ := json.Marshal(o.From)
+		fmt.Fprintf(&buf, `"from":%s,`, from)
+	}
+	path, _ := json.Marshal(o.Path)
+	fmt.Fprintf(&buf, `"path":%s`, path)
+	if o.Op == "add" || o.Op == "replace" || o.Op == "test" {
+		value, err := json.Marshal(o.Value)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&buf, `,"value":%s`, value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// ParsePatch decodes a JSON Patch document, keeping numbers as written
+func ParsePatch(data []byte) ([]Operation, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var raw []map[string]any
+	if err := dec.Decode(&raw); err != nil {
+		return nil, fmt.Errorf("invalid JSON patch: %w", err)
+	}
+	ops := make([]Operation, len(raw))
+	for i, m := range raw {
+		op, _ := m["op"].(string)
+		path, ok := m["path"].(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid JSON patch: operation %d has no path", i)
+		}
+		ops[i] = Operation{Op: op, Path: path, Value: m["value"]}
+		switch op {
+		case "add", "replace", "test":
+			if _, ok := m["value"]; !ok {
+				return nil, fmt.Errorf("invalid JSON patch: %s operation %d has no value", op, i)
+			}
+		case "move", "copy":
+			if ops[i].From, ok = m["from"].(string); !ok {
+				return nil, fmt.Errorf("invalid JSON patch: %s operation %d has no from", op, i)
+			}
+		case "remove":
+		default:
+			return nil, fmt.Errorf("invalid JSON patch: operation %d has unknown op %q", i, op)
+		}
+	}
+	return ops, nil
+}
+
+// Apply applies ops in order to doc, a value produced by encoding/json, and
+// returns the result. It stops at the first operation failing, such as a
+// test whose value differs; doc may have been modified by then.
+func Apply(doc any, ops []Operation) (any, error) {
+	for i, op := range ops {
+		path, err := parsePointer(op.Path)
+		if err != nil {
+			return nil, fmt.Errorf("operation %d: %w", i, err)
+		}
+		switch op.Op {
+		case "add":
+			doc, err = add(doc, path, op.Value)
+		case "remove":
+			doc, err = remove(doc, path)
+		case "replace":
+			if _, err = get(doc, path); err == nil {
+				doc, err = replace(doc, path, op.Value)
+			}
+		case "move", "copy":
+			var from []string
+			if from, err = parsePointer(op.From); err != nil {
+				break
+			}
+			var value any
+			if value, err = get(doc, from); err != nil {
+				break
+			}
+			if op.Op == "copy" {
+				doc, err = add(doc, path, deepCopy(value))
+				break
+			}
+			if len(path) > len(from) && slices.Equal(path[:len(from)], from) {
+				err = fmt.Errorf("cannot move %s into itself", op.From)
+				break
+			}
+			if doc, err = remove(doc, from); err == nil {
+				doc, err = add(doc, path, value)
+			}
+		case "test":
+			var value any
+			if value, err = get(doc, path); err == nil && !Equal(value, op.Value) {
+				err = fmt.Errorf("test failed: value at %q differs", op.Path)
+			}
+		default:
+			err = fmt.Errorf("unknown op %q", op.Op)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("operation %d (%s %s): %w", i, op.Op, op.Path, err)
+		}
+	}
+	return doc, nil
+}
+
+// Diff returns operations transforming from into to: for objects, removing,
+// adding and descending into members; for arrays, descending into the
+// elements both have and adding or removing the rest at the end; otherwise
+// replacing the value. Identical documents yield no operations.
+func Diff(from, to any) []Operation {
+	ops := []Operation{}
+	return diff(ops, "", from, to)
+}
+
+func diff(ops []Operation, path string, from, to any) []Operation {
+	if Equal(from, to) {
+		return ops
+	}
+	switch f := from.(type) {
+	case map[string]any:
+		t, ok := to.(map[string]any)
+		if !ok {
+			break
+		}
+		for _, name := range sortedKeys(f) {
+			if _, ok := t[name]; !ok {
+				ops = append(ops, Operation{Op: "remove", Path: path + "/" + escape(name)})
+			}
+		}
+		for _, name := range sortedKeys(t) {
+			if fv, ok := f[name]; ok {
+				ops = diff(ops, path+"/"+escape(name), fv, t[name])
+			} else {
+				ops = append(ops, Operation{Op: "add", Path: path + "/" + escape(name), Value: t[name]})
+			}
+		}
+		return ops
+	case []any:
+		t, ok := to.([]any)
+		if !ok {
+			break
+		}
+		for i := range min(len(f), len(t)) {
+			ops = diff(ops, path+"/"+strconv.Itoa(i), f[i], t[i])
+		}
+		for i := len(f) - 1; i >= len(t); i-- {
+			ops = append(ops, Operation{Op: "remove", Path: path + "/" + strconv.Itoa(i)})
+		}
+		for i := len(f); i < len(t); i++ {
+			ops = append(ops, Operation{Op: "add", Path: path + "/-", Value: t[i]})
+		}
+		return ops
+	}
+	return append(ops, Operation{Op: "replace", Path: path, Value: to})
+}
+
+// Equal reports whether two decoded JSON values are equal, comparing numbers
+// by value and objects regardless of member order
+func Equal(a, b any) bool {
+	switch a := a.(type) {
+	case map[string]any:
+		b, ok := b.(map[string]any)
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for name, av := range a {
+			bv, ok := b[name]
+			if !ok || !Equal(av, bv) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		b, ok := b.([]any)
+		return ok && slices.EqualFunc(a, b, Equal)
+	case json.Number, float64:
+		x, ok1 := number(a)
+		y, ok2 := number(b)
+		return ok1 && ok2 && x.Cmp(y) == 0
+	}
+	return a == b
+}
+
+// number returns the value of a decoded JSON number
+func number(v any) (*big.Rat, bool) {
+	switch n := v.(type) {
+	case json.Number:
+		return new(big.Rat).SetString(string(n))
+	case float64:
+		r := new(big.Rat)
+		if r.SetFloat64(n) == nil {
+			return nil, false
+		}
+		return r, true
+	}
+	return nil, false
+}
+
+// parsePointer splits a JSON Pointer into its unescaped reference tokens
+func parsePointer(p string) ([]string, error) {
+	if p == "" {
+		return nil, nil
+	}
+	if p[0] != '/' {
+		return nil, fmt.Errorf("invalid JSON pointer %q: must start with /", p)
+	}
+	tokens := strings.Split(p[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(t, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+// escape escapes a member name for use as a JSON Pointer reference token
+func escape(name string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(name, "~", "~0"), "/", "~1")
+}
+
+// get returns the value at path
+func get(doc any, path []string) (any, error) {
+	for i, token := range path {
+		switch c := doc.(type) {
+		case map[string]any:
+			v, ok := c[token]
+			if !ok {
+				return nil, fmt.Errorf("no member %q at %s", token, pointer(path[:i]))
+			}
+			doc = v
+		case []any:
+			idx, err := index(token, len(c)-1)
+			if err != nil {
+				return nil, fmt.Errorf("%w at %s", err, pointer(path[:i]))
+			}
+			doc = c[idx]
+		default:
+			return nil, fmt.Errorf("%s is not an object or array", pointer(path[:i]))
+		}
+	}
+	return doc, nil
+}
+
+// add inserts value at path, replacing an existing member of an object
+func add(doc any, path []string, value any) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	parent, last := path[:len(path)-1], path[len(path)-1]
+	container, err := get(doc, parent)
+	if err != nil {
+		return nil, err
+	}
+	switch c := container.(type) {
+	case map[string]any:
+		c[last] = value
+		return doc, nil
+	case []any:
+		idx := len(c)
+		if last != "-" {
+			if idx, err = index(last, len(c)); err != nil {
+				return nil, err
+			}
+		}
+		return replace(doc, parent, slices.Insert(c, idx, value))
+	}
+	return nil, fmt.Errorf("%s is not an object or array", pointer(parent))
+}
+
+// remove deletes the value at path, which must exist
+func remove(doc any, path []string) (any, error) {
+	if len(path) == 0 {
+		return nil, nil
+	}
+	parent, last := path[:len(path)-1], path[len(path)-1]
+	container, err := get(doc, parent)
+	if err != nil {
+		return nil, err
+	}
+	switch c := container.(type) {
+	case map[string]any:
+		if _, ok := c[last]; !ok {
+			return nil, fmt.Errorf("no member %q at %s", last, pointer(parent))
+		}
+		delete(c, last)
+		return doc, nil
+	case []any:
+		idx, err := index(last, len(c)-1)
+		if err != nil {
+			return nil, err
+		}
+		return replace(doc, parent, slices.Delete(c, idx, idx+1))
+	}
+	return nil, fmt.Errorf("%s is not an object or array", pointer(parent))
+}
+
+// replace sets the value at path, whose parent must exist
+func replace(doc any, path []string, value any) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	parent, last := path[:len(path)-1], path[len(path)-1]
+	container, err := get(doc, parent)
+	if err != nil {
+		return nil, err
+	}
+	switch c := container.(type) {
+	case map[string]any:
+		c[last] = value
+		return doc, nil
+	case []any:
+		idx, err := index(last, len(c)-1)
+		if err != nil {
+			return nil, err
+		}
+		c[idx] = value
+		return doc, nil
+	}
+	return nil, fmt.Errorf("%s is not an object or array", pointer(parent))
+}
+
+// index parses an array index token, which must not exceed max
+func index(token string, max int) (int, error) {
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || (len(token) > 1 && token[0] == '0') {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+	if i > max {
+		return 0, fmt.Errorf("array index %d out of range", i)
+	}
+	return i, nil
+}
+
+// pointer formats tokens as a JSON Pointer, the root shown as /
+func pointer(tokens []string) string {
+	if len(tokens) == 0 {
+		return "/"
+	}
+	escaped := make([]string, len(tokens))
+	for i, t := range tokens {
+		escaped[i] = escape(t)
+	}
+	return "/" + strings.Join(escaped, "/")
+}
+
+// deepCopy copies the objects and arrays of a decoded JSON value
+func deepCopy(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		c := make(map[string]any, len(v))
+		for name, value := range v {
+			c[name] = deepCopy(value)
+		}
+		return c
+	case []any:
+		c := make([]any, len(v))
+		for i, value := range v {
+			c[i] = deepCopy(value)
+		}
+		return c
+	}
+	return v
+}
+
+// sortedKeys returns the member names of an object in order
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
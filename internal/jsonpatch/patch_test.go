@@ -0,0 +1,169 @@
+package jsonpatch
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+// decode decodes a JSON document as ParsePatch does, keeping numbers as written
+func decode(t *testing.T, doc string) any {
+	t.Helper()
+	dec := json.NewDecoder(bytes.NewReader([]byte(doc)))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		t.Fatalf("invalid test document %s: %v", doc, err)
+	}
+	return v
+}
+
+// rfc6902Examples are the examples of RFC 6902 appendix A. An empty want
+// marks a patch that must fail. A.13, an operation with two op members, is
+// left out: encoding/json keeps the last member of a name.
+var rfc6902Examples = []struct {
+	name  string
+	doc   string
+	patch string
+	want  string
+}{
+	{
+		name:  "A.1 adding an object member",
+		doc:   `{"foo": "bar"}`,
+		patch: `[{"op": "add", "path": "/baz", "value": "qux"}]`,
+		want:  `{"baz": "qux", "foo": "bar"}`,
+	},
+	{
+		name:  "A.2 adding an array element",
+		doc:   `{"foo": ["bar", "baz"]}`,
+		patch: `[{"op": "add", "path": "/foo/1", "value": "qux"}]`,
+		want:  `{"foo": ["bar", "qux", "baz"]}`,
+	},
+	{
+		name:  "A.3 removing an object member",
+		doc:   `{"baz": "qux", "foo": "bar"}`,
+		patch: `[{"op": "remove", "path": "/baz"}]`,
+		want:  `{"foo": "bar"}`,
+	},
+	{
+		name:  "A.4 removing an array element",
+		doc:   `{"foo": ["bar", "qux", "baz"]}`,
+		patch: `[{"op": "remove", "path": "/foo/1"}]`,
+		want:  `{"foo": ["bar", "baz"]}`,
+	},
+	{
+		name:  "A.5 replacing a value",
+		doc:   `{"baz": "qux", "foo": "bar"}`,
+		patch: `[{"op": "replace", "path": "/baz", "value": "boo"}]`,
+		want:  `{"baz": "boo", "foo": "bar"}`,
+	},
+	{
+		name:  "A.6 moving a value",
+		doc:   `{"foo": {"bar": "baz", "waldo": "fred"}, "qux": {"corge": "grault"}}`,
+		patch: `[{"op": "move", "from": "/foo/waldo", "path": "/qux/thud"}]`,
+		want:  `{"foo": {"bar": "baz"}, "qux": {"corge": "grault", "thud": "fred"}}`,
+	},
+	{
+		name:  "A.7 moving an array element",
+		doc:   `{"foo": ["all", "grass", "cows", "eat"]}`,
+		patch: `[{"op": "move", "from": "/foo/1", "path": "/foo/3"}]`,
+		want:  `{"foo": ["all", "cows", "eat", "grass"]}`,
+	},
+	{
+		name:  "A.8 testing a value: success",
+		doc:   `{"baz": "qux", "foo": ["a", 2, "c"]}`,
+		patch: `[{"op": "test", "path": "/baz", "value": "qux"}, {"op": "test", "path": "/foo/1", "value": 2}]`,
+		want:  `{"baz": "qux", "foo": ["a", 2, "c"]}`,
+	},
+	{
+		name:  "A.9 testing a value: error",
+		doc:   `{"baz": "qux"}`,
+		patch: `[{"op": "test", "path": "/baz", "value": "bar"}]`,
+	},
+	{
+		name:  "A.10 adding a nested member object",
+		doc:   `{"foo": "bar"}`,
+		patch: `[{"op": "add", "path": "/child", "value": {"grandchild": {}}}]`,
+		want:  `{"foo": "bar", "child": {"grandchild": {}}}`,
+	},
+	{
+		name:  "A.11 ignoring unrecognized elements",
+		doc:   `{"foo": "bar"}`,
+		patch: `[{"op": "add", "path": "/baz", "value": "qux", "xyz": 123}]`,
+		want:  `{"foo": "bar", "baz": "qux"}`,
+	},
+	{
+		name:  "A.12 adding to a nonexistent target",
+		doc:   `{"foo": "bar"}`,
+		patch: `[{"op": "add", "path": "/baz/bat", "value": "qux"}]`,
+	},
+	{
+		name:  "A.14 ~ escape ordering",
+		doc:   `{"/": 9, "~1": 10}`,
+		patch: `[{"op": "test", "path": "/~01", "value": 10}]`,
+		want:  `{"/": 9, "~1": 10}`,
+	},
+	{
+		name:  "A.15 comparing strings and numbers",
+		doc:   `{"/": 9, "~1": 10}`,
+		patch: `[{"op": "test", "path": "/~01", "value": "10"}]`,
+	},
+	{
+		name:  "A.16 adding an array value",
+		doc:   `{"foo": ["bar"]}`,
+		patch: `[{"op": "add", "path": "/foo/-", "value": ["abc", "def"]}]`,
+		want:  `{"foo": ["bar", ["abc", "def"]]}`,
+	},
+}
+
+func TestApply(t *testing.T) {
+	for _, tt := range rfc6902Examples {
+		t.Run(tt.name, func(t *testing.T) {
+			ops, err := ParsePatch([]byte(tt.patch))
+			if err != nil {
+				t.Fatalf("ParsePatch: %v", err)
+			}
+			got, err := Apply(decode(t, tt.doc), ops)
+			if tt.want == "" {
+				if err == nil {
+					t.Fatalf("Apply = %v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Apply: %v", err)
+			}
+			if want := decode(t, tt.want); !Equal(got, want) {
+				t.Errorf("Apply = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestDiff(t *testing.T) {
+	for _, tt := range rfc6902Examples {
+		if tt.want == "" {
+			continue
+		}
+		t.Run(tt.name, func(t *testing.T) {
+			from, to := decode(t, tt.doc), decode(t, tt.want)
+			ops := Diff(from, to)
+			// Operations survive encoding as a patch document
+			data, err := json.Marshal(ops)
+			if err != nil {
+				t.Fatalf("Marshal: %v", err)
+			}
+			parsed, err := ParsePatch(data)
+			if err != nil {
+				t.Fatalf("ParsePatch(%s): %v", data, err)
+			}
+			got, err := Apply(from, parsed)
+			if err != nil {
+				t.Fatalf("Apply(%s): %v", data, err)
+			}
+			if !Equal(got, to) {
+				t.Errorf("Apply(%s) = %v, want %v", data, got, to)
+			}
+		})
+	}
+}
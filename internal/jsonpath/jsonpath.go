@@ -0,0 +1,168 @@
+// Package jsonpath evaluates a small subset of JSONPath against decoded JSON:
+// an optional leading "$", member access (.name or ['name']), array indexes
+// ([0], [-1] counting from the end) and wildcards (.* or [*]). A dot may
+// precede brackets, so jq paths such as .[0].name are accepted as well.
+package jsonpath
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Eval evaluates expr against v, a value produced by encoding/json. Without
+// wildcards it returns the single selected value; with wildcards it returns a
+// []any of every match.
+func Eval(expr string, v any) (any, error) {
+	segments, wildcard, err := parse(expr)
+	if err != nil {
+		return nil, err
+	}
+	nodes := []any{v}
+	for _, seg := range segments {
+		var next []any
+		for _, node := range nodes {
+			matched, err := seg.apply(node, wildcard)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", expr, err)
+			}
+			next = append(next, matched...)
+		}
+		nodes = next
+	}
+	if wildcard {
+		if nodes == nil {
+			nodes = []any{}
+		}
+		return nodes, nil
+	}
+	return nodes[0], nil
+}
+
+// segment is one step of a path
+type segment struct {
+	name     string
+	index    int
+	isIndex  bool
+	wildcard bool
+}
+
+// apply selects the children of node matching the segment. Missing members are
+// errors for single-value paths and skipped under a wildcard path.
+func (s segment) apply(node any, lenient bool) ([]any, error) {
+	switch {
+	case s.wildcard:
+		switch n := node.(type) {
+		case []any:
+			return n, nil
+		case map[string]any:
+			keys := make([]string, 0, len(n))
+			for k := range n {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			out := make([]any, 0, len(n))
+			for _, k := range keys {
+				out = append(out, n[k])
+			}
+			return out, nil
+		}
+	case s.isIndex:
+		if arr, ok := node.([]any); ok {
+			i := s.index
+			if i < 0 {
+				i += len(arr)
+			}
+			if i >= 0 && i < len(arr) {
+				return []any{arr[i]}, nil
+			}
+			if lenient {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("index %d out of range", s.index)
+		}
+	default:
+		if obj, ok := node.(map[string]any); ok {
+			if child, ok := obj[s.name]; ok {
+				return []any{child}, nil
+			}
+			if lenient {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("no member %q", s.name)
+		}
+	}
+	if lenient {
+		return nil, nil
+	}
+	return nil, fmt.Errorf("cannot apply %s to %T", s, node)
+}
+
+func (s segment) String() string {
+	switch {
+	case s.wildcard:
+		return "[*]"
+	case s.isIndex:
+		return "[" + strconv.Itoa(s.index) + "]"
+	default:
+		return strconv.Quote(s.name)
+	}
+}
+
+// parse splits expr into segments and reports whether it contains a wildcard
+func parse(expr string) ([]segment, bool, error) {
+	rest := strings.TrimPrefix(strings.TrimSpace(expr), "$")
+	var segments []segment
+	wildcard := false
+	for rest != "" {
+		var seg segment
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			name := rest[:end]
+			rest = rest[end:]
+			// A dot before a bracket or alone, as in jq's .[0] and .
+			if name == "" && (rest == "" || rest[0] == '[') {
+				continue
+			}
+			if name == "" {
+				return nil, false, fmt.Errorf("invalid path %q: empty member name", expr)
+			}
+			seg = segment{name: name, wildcard: name == "*"}
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, false, fmt.Errorf("invalid path %q: unterminated [", expr)
+			}
+			inner := rest[1:end]
+			rest = rest[end+1:]
+			switch {
+			case inner == "*":
+				seg = segment{wildcard: true}
+			case len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0]:
+				seg = segment{name: inner[1 : len(inner)-1]}
+			default:
+				i, err := strconv.Atoi(inner)
+				if err != nil {
+					return nil, false, fmt.Errorf("invalid path %q: bad index %q", expr, inner)
+				}
+				seg = segment{index: i, isIndex: true}
+			}
+		default:
+			if len(segments) > 0 {
+				return nil, false, fmt.Errorf("invalid path %q: unexpected %q", expr, rest[:1])
+			}
+			// Allow a leading member name without a dot, e.g. "a.b"
+			rest = "." + rest
+			continue
+		}
+		wildcard = wildcard || seg.wildcard
+		segments = append(segments, seg)
+	}
+	return segments, wildcard, nil
+}
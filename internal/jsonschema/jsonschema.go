@@ -0,0 +1,379 @@
+// Package jsonschema validates decoded JSON against a subset of JSON Schema
+// (draft 2020-12) covering what configuration values need: type, enum,
+// const, properties, required, additionalProperties, items, the length,
+// size and range bounds, pattern, multipleOf, and the allOf, anyOf, oneOf
+// and not combinators. Other keywords, including $ref and format, are
+// ignored.
+package jsonschema
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Schema is a compiled schema
+type Schema struct {
+	always *bool // set for the boolean schemas true and false
+
+	types    []string
+	enum     []any
+	constant any
+	hasConst bool
+
+	properties map[string]*Schema
+	required   []string
+	additional *Schema
+	items      *Schema
+
+	minItems, maxItems   *int
+	minLength, maxLength *int
+	pattern              *regexp.Regexp
+
+	minimum, maximum                   *float64
+	exclusiveMinimum, exclusiveMaximum *float64
+	multipleOf                         *float64
+
+	allOf, anyOf, oneOf []*Schema
+	not                 *Schema
+}
+
+// Violation is one way a value fails its schema
+type Violation struct {
+	Path    string // JSON Pointer to the offending value; empty for the value itself
+	Message string
+}
+
+// Compile parses a schema written in JSON
+func Compile(data []byte) (*Schema, error) {
+	var raw any
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("invalid schema: %w", err)
+	}
+	return compile(raw, "")
+}
+
+// compile builds the schema found at the JSON Pointer at
+func compile(raw any, at string) (*Schema, error) {
+	if b, ok := raw.(bool); ok {
+		return &Schema{always: &b}, nil
+	}
+	obj, ok := raw.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("schema%s: must be an object or a boolean", where(at))
+	}
+
+	s := &Schema{}
+	var err error
+	if t, ok := obj["type"]; ok {
+		if s.types, err = stringList(t, at+"/type"); err != nil {
+			return nil, err
+		}
+		for _, name := range s.types {
+			if !slices.Contains([]string{"null", "boolean", "object", "array", "number", "integer", "string"}, name) {
+				return nil, fmt.Errorf("schema%s: unknown type %q", where(at+"/type"), name)
+			}
+		}
+	}
+	if e, ok := obj["enum"]; ok {
+		if s.enum, ok = e.([]any); !ok {
+			return nil, fmt.Errorf("schema%s: must be an array", where(at+"/enum"))
+		}
+	}
+	s.constant, s.hasConst = obj["const"]
+
+	if p, ok := obj["properties"]; ok {
+		props, ok := p.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("schema%s: must be an object", where(at+"/properties"))
+		}
+		s.properties = make(map[string]*Schema, len(props))
+		for name, sub := range props {
+			if s.properties[name], err = compile(sub, at+"/properties/"+escape(name)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if r, ok := obj["required"]; ok {
+		if s.required, err = stringList(r, at+"/required"); err != nil {
+			return nil, err
+		}
+	}
+	if a, ok := obj["additionalProperties"]; ok {
+		if s.additional, err = compile(a, at+"/additionalProperties"); err != nil {
+			return nil, err
+		}
+	}
+	if i, ok := obj["items"]; ok {
+		if s.items, err = compile(i, at+"/items"); err != nil {
+			return nil, err
+		}
+	}
+
+	for name, dst := range map[string]**int{
+		"minItems": &s.minItems, "maxItems": &s.maxItems,
+		"minLength": &s.minLength, "maxLength": &s.maxLength,
+	} {
+		if v, ok := obj[name]; ok {
+			n, ok := v.(float64)
+			if !ok || n < 0 || n != math.Trunc(n) {
+				return nil, fmt.Errorf("schema%s: must be a non-negative integer", where(at+"/"+name))
+			}
+			i := int(n)
+			*dst = &i
+		}
+	}
+	for name, dst := range map[string]**float64{
+		"minimum": &s.minimum, "maximum": &s.maximum,
+		"exclusiveMinimum": &s.exclusiveMinimum, "exclusiveMaximum": &s.exclusiveMaximum,
+		"multipleOf": &s.multipleOf,
+	} {
+		if v, ok := obj[name]; ok {
+			n, ok := v.(float64)
+			if !ok || (name == "multipleOf" && n <= 0) {
+				return nil, fmt.Errorf("schema%s: must be a number", where(at+"/"+name))
+			}
+			*dst = &n
+		}
+	}
+	if p, ok := obj["pattern"]; ok {
+		expr, ok := p.(string)
+		if !ok {
+			return nil, fmt.Errorf("schema%s: must be a string", where(at+"/pattern"))
+		}
+		if s.pattern, err = regexp.Compile(expr); err != nil {
+			return nil, fmt.Errorf("schema%s: %w", where(at+"/pattern"), err)
+		}
+	}
+
+	for name, dst := range map[string]*[]*Schema{"allOf": &s.allOf, "anyOf": &s.anyOf, "oneOf": &s.oneOf} {
+		v, ok := obj[name]
+		if !ok {
+			continue
+		}
+		list, ok := v.([]any)
+		if !ok || len(list) == 0 {
+			return nil, fmt.Errorf("schema%s: must be a non-empty array", where(at+"/"+name))
+		}
+		for i, sub := range list {
+			compiled, err := compile(sub, at+"/"+name+"/"+strconv.Itoa(i))
+			if err != nil {
+				return nil, err
+			}
+			*dst = append(*dst, compiled)
+		}
+	}
+	if n, ok := obj["not"]; ok {
+		if s.not, err = compile(n, at+"/not"); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Validate checks v, a value produced by encoding/json, and returns every
+// violation found, or nil if it satisfies the schema
+func (s *Schema) Validate(v any) []Violation {
+	var out []Violation
+	s.validate(v, "", &out)
+	return out
+}
+
+func (s *Schema) validate(v any, path string, out *[]Violation) {
+	fail := func(format string, args ...any) {
+		*out = append(*out, Violation{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if s.always != nil {
+		if !*s.always {
+			fail("no value is allowed here")
+		}
+		return
+	}
+	if len(s.types) > 0 && !slices.ContainsFunc(s.types, func(t string) bool { return hasType(v, t) }) {
+		fail("must be of type %s, not %s", strings.Join(s.types, " or "), typeOf(v))
+		return
+	}
+	if s.enum != nil && !slices.ContainsFunc(s.enum, func(e any) bool { return equal(e, v) }) {
+		fail("must be one of %s", compact(s.enum))
+	}
+	if s.hasConst && !equal(s.constant, v) {
+		fail("must be %s", compact(s.constant))
+	}
+
+	switch v := v.(type) {
+	case map[string]any:
+		for _, name := range s.required {
+			if _, ok := v[name]; !ok {
+				fail("missing required property %q", name)
+			}
+		}
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			child := path + "/" + escape(name)
+			if sub, ok := s.properties[name]; ok {
+				sub.validate(v[name], child, out)
+			} else if s.additional != nil {
+				if s.additional.always != nil && !*s.additional.always {
+					*out = append(*out, Violation{Path: child, Message: "property is not allowed"})
+					continue
+				}
+				s.additional.validate(v[name], child, out)
+			}
+		}
+	case []any:
+		if s.minItems != nil && len(v) < *s.minItems {
+			fail("must have at least %d items", *s.minItems)
+		}
+		if s.maxItems != nil && len(v) > *s.maxItems {
+			fail("must have at most %d items", *s.maxItems)
+		}
+		if s.items != nil {
+			for i, item := range v {
+				s.items.validate(item, path+"/"+strconv.Itoa(i), out)
+			}
+		}
+	case string:
+		n := utf8.RuneCountInString(v)
+		if s.minLength != nil && n < *s.minLength {
+			fail("must be at least %d characters long", *s.minLength)
+		}
+		if s.maxLength != nil && n > *s.maxLength {
+			fail("must be at most %d characters long", *s.maxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			fail("must match %s", s.pattern)
+		}
+	case float64:
+		if s.minimum != nil && v < *s.minimum {
+			fail("must be >= %v", *s.minimum)
+		}
+		if s.maximum != nil && v > *s.maximum {
+			fail("must be <= %v", *s.maximum)
+		}
+		if s.exclusiveMinimum != nil && v <= *s.exclusiveMinimum {
+			fail("must be > %v", *s.exclusiveMinimum)
+		}
+		if s.exclusiveMaximum != nil && v >= *s.exclusiveMaximum {
+			fail("must be < %v", *s.exclusiveMaximum)
+		}
+		if s.multipleOf != nil {
+			if q := v / *s.multipleOf; q != math.Trunc(q) {
+				fail("must be a multiple of %v", *s.multipleOf)
+			}
+		}
+	}
+
+	for _, sub := range s.allOf {
+		sub.validate(v, path, out)
+	}
+	if s.anyOf != nil && count(s.anyOf, v) == 0 {
+		fail("must match at least one schema in anyOf")
+	}
+	if s.oneOf != nil {
+		if n := count(s.oneOf, v); n != 1 {
+			fail("must match exactly one schema in oneOf, matched %d", n)
+		}
+	}
+	if s.not != nil && len(s.not.Validate(v)) == 0 {
+		fail("must not match the schema in not")
+	}
+}
+
+// count returns how many of schemas v satisfies
+func count(schemas []*Schema, v any) int {
+	n := 0
+	for _, s := range schemas {
+		if len(s.Validate(v)) == 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// hasType reports whether v is of the JSON Schema type t
+func hasType(v any, t string) bool {
+	if t == "integer" {
+		n, ok := v.(float64)
+		return ok && n == math.Trunc(n)
+	}
+	return typeOf(v) == t
+}
+
+// typeOf names the JSON type of v
+func typeOf(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
+
+// equal compares two decoded JSON values
+func equal(a, b any) bool {
+	return reflect.DeepEqual(a, b)
+}
+
+// compact renders v as JSON for messages
+func compact(v any) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
+
+// stringList accepts a string or an array of strings
+func stringList(v any, at string) ([]string, error) {
+	if s, ok := v.(string); ok {
+		return []string{s}, nil
+	}
+	list, ok := v.([]any)
+	if !ok {
+		return nil, fmt.Errorf("schema%s: must be a string or an array of strings", where(at))
+	}
+	out := make([]string, 0, len(list))
+	for _, item := range list {
+		s, ok := item.(string)
+		if !ok {
+			return nil, fmt.Errorf("schema%s: must be a string or an array of strings", where(at))
+		}
+		out = append(out, s)
+	}
+	return out, nil
+}
+
+// escape encodes a property name as a JSON Pointer token
+func escape(name string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(name, "~", "~0"), "/", "~1")
+}
+
+// where formats a schema location for errors
+func where(at string) string {
+	if at == "" {
+		return ""
+	}
+	return " at " + at
+}
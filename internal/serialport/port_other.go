@@ -0,0 +1,31 @@
+//go:build !(linux || solaris || darwin || dragonfly || freebsd || netbsd || openbsd || windows)
+
+package serialport
+
+import (
+	"fmt"
+	"runtime"
+	"time"
+)
+
+// errUnsupported is returned by Open on platforms without serial support
+var errUnsupported = fmt.Errorf("serial ports are not supported on %s", runtime.GOOS)
+
+// Port is an open serial port, which cannot be opened on this platform
+type Port struct{}
+
+func openPort(c *Config) (*Port, error) {
+	return nil, errUnsupported
+}
+
+// Read implements io.Reader
+func (p *Port) Read(b []byte) (int, error) { return 0, errUnsupported }
+
+// Write implements io.Writer
+func (p *Port) Write(b []byte) (int, error) { return 0, errUnsupported }
+
+// SetReadDeadline sets the deadline for reads
+func (p *Port) SetReadDeadline(t time.Time) error { return errUnsupported }
+
+// Close implements io.Closer
+func (p *Port) Close() error { return nil }
@@ -0,0 +1,126 @@
+//go:build linux || solaris || darwin || dragonfly || freebsd || netbsd || openbsd
+
+package serialport
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Port is an open serial port. The file is in non-blocking mode and served by
+// the runtime poller, which implements its read deadlines.
+type Port struct {
+	f *os.File
+}
+
+func openPort(c *Config) (*Port, error) {
+	f, err := os.OpenFile(c.Name, os.O_RDWR|unix.O_NOCTTY|unix.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := f.SyscallConn()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	// Fd would switch the file back to blocking mode, losing deadlines
+	var configErr error
+	if err := raw.Control(func(fd uintptr) { configErr = configure(int(fd), c) }); err != nil {
+		configErr = err
+	}
+	if configErr != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to configure %s: %w", c.Name, configErr)
+	}
+	return &Port{f: f}, nil
+}
+
+// configure puts the terminal fd in raw mode with the line settings of c.
+// Devices that are not terminals, such as virtio-serial ports, have no line
+// settings and are left as they are.
+func configure(fd int, c *Config) error {
+	t, err := unix.IoctlGetTermios(fd, ioctlGetTermios)
+	if errors.Is(err, unix.ENOTTY) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// Raw mode, as cfmakeraw, and no software flow control
+	t.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL |
+		unix.IXON | unix.IXOFF | unix.IXANY | unix.INPCK
+	t.Oflag &^= unix.OPOST
+	t.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+	// No modem control lines or hardware flow control
+	t.Cflag &^= unix.CSIZE | unix.PARENB | unix.PARODD | unix.CSTOPB | unix.CRTSCTS | stickParity
+	t.Cflag |= unix.CREAD | unix.CLOCAL
+	t.Cc[unix.VMIN] = 1
+	t.Cc[unix.VTIME] = 0
+
+	switch c.Size {
+	case 5:
+		t.Cflag |= unix.CS5
+	case 6:
+		t.Cflag |= unix.CS6
+	case 7:
+		t.Cflag |= unix.CS7
+	default:
+		t.Cflag |= unix.CS8
+	}
+
+	switch c.Parity {
+	case ParityNone:
+	case ParityOdd:
+		t.Cflag |= unix.PARENB | unix.PARODD
+		t.Iflag |= unix.INPCK
+	case ParityEven:
+		t.Cflag |= unix.PARENB
+		t.Iflag |= unix.INPCK
+	case ParityMark, ParitySpace:
+		if err := setStickParity(t, c.Parity == ParityMark); err != nil {
+			return err
+		}
+		t.Iflag |= unix.INPCK
+	default:
+		return ErrBadParity
+	}
+
+	switch c.StopBits {
+	case Stop1:
+	case Stop2:
+		t.Cflag |= unix.CSTOPB
+	default:
+		return ErrBadStopBits
+	}
+
+	if err := setSpeed(t, c.Baud); err != nil {
+		return err
+	}
+	return unix.IoctlSetTermios(fd, ioctlSetTermios, t)
+}
+
+// Read reads from the port, returning os.ErrDeadlineExceeded once the read
+// deadline passes
+func (p *Port) Read(b []byte) (int, error) {
+	return p.f.Read(b)
+}
+
+// Write writes to the port
+func (p *Port) Write(b []byte) (int, error) {
+	return p.f.Write(b)
+}
+
+// SetReadDeadline sets the deadline for reads; the zero time disables it
+func (p *Port) SetReadDeadline(t time.Time) error {
+	return p.f.SetReadDeadline(t)
+}
+
+// Close closes the port, unblocking pending reads
+func (p *Port) Close() error {
+	return p.f.Close()
+}
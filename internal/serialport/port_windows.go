@@ -0,0 +1,177 @@
+package serialport
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// DCB flags, from the fBinary..fAbortOnError bit fields of the C structure.
+// Those left clear disable flow control.
+const (
+	dcbBinary           = 0x0001
+	dcbParity           = 0x0002
+	dcbDTRControlEnable = 0x0010
+	dcbRTSControlEnable = 0x1000
+)
+
+// Timeouts of the communications API, in milliseconds. maxTimeout is the
+// longest read timeout it accepts, standing in for no deadline.
+const (
+	maxDWORD   = 0xFFFFFFFF
+	maxTimeout = maxDWORD - 1
+)
+
+// Port is an open serial port, opened for overlapped I/O so Close can cancel
+// a pending read. The read deadline is applied as the port's read timeout
+// when each read starts.
+type Port struct {
+	h windows.Handle
+
+	mu       sync.Mutex
+	deadline time.Time
+}
+
+func openPort(c *Config) (*Port, error) {
+	name := c.Name
+	// COM10 and above are only reachable through the device namespace
+	if !strings.HasPrefix(name, `\\.\`) {
+		name = `\\.\` + name
+	}
+	path, err := windows.UTF16PtrFromString(name)
+	if err != nil {
+		return nil, err
+	}
+	h, err := windows.CreateFile(path, windows.GENERIC_READ|windows.GENERIC_WRITE, 0, nil,
+		windows.OPEN_EXISTING, windows.FILE_ATTRIBUTE_NORMAL|windows.FILE_FLAG_OVERLAPPED, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: c.Name, Err: err}
+	}
+	if err := configure(h, c); err != nil {
+		windows.CloseHandle(h)
+		return nil, err
+	}
+	return &Port{h: h}, nil
+}
+
+// configure applies the line settings of c to h
+func configure(h windows.Handle, c *Config) error {
+	var dcb windows.DCB
+	dcb.DCBlength = uint32(unsafe.Sizeof(dcb))
+	if err := windows.GetCommState(h, &dcb); err != nil {
+		return err
+	}
+	dcb.BaudRate = uint32(c.Baud)
+	dcb.ByteSize = c.Size
+	dcb.Flags = dcbBinary | dcbDTRControlEnable | dcbRTSControlEnable
+
+	switch c.Parity {
+	case ParityNone:
+		dcb.Parity = windows.NOPARITY
+	case ParityOdd:
+		dcb.Parity = windows.ODDPARITY
+	case ParityEven:
+		dcb.Parity = windows.EVENPARITY
+	case ParityMark:
+		dcb.Parity = windows.MARKPARITY
+	case ParitySpace:
+		dcb.Parity = windows.SPACEPARITY
+	default:
+		return ErrBadParity
+	}
+	if c.Parity != ParityNone {
+		dcb.Flags |= dcbParity
+	}
+
+	switch c.StopBits {
+	case Stop1:
+		dcb.StopBits = windows.ONESTOPBIT
+	case Stop1Half:
+		dcb.StopBits = windows.ONE5STOPBITS
+	case Stop2:
+		dcb.StopBits = windows.TWOSTOPBITS
+	default:
+		return ErrBadStopBits
+	}
+
+	if err := windows.SetCommState(h, &dcb); err != nil {
+		return err
+	}
+	return windows.PurgeComm(h, windows.PURGE_RXCLEAR|windows.PURGE_TXCLEAR)
+}
+
+// Read reads from the port, returning os.ErrDeadlineExceeded once the read
+// deadline passes. A deadline set while a read is pending applies to the
+// next read.
+func (p *Port) Read(b []byte) (int, error) {
+	p.mu.Lock()
+	deadline := p.deadline
+	p.mu.Unlock()
+
+	timeout := uint32(maxTimeout)
+	if !deadline.IsZero() {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return 0, os.ErrDeadlineExceeded
+		}
+		timeout = uint32(min(max(remaining.Milliseconds(), 1), maxTimeout))
+	}
+	// Return as soon as any data is available, waiting for it up to timeout
+	if err := windows.SetCommTimeouts(p.h, &windows.CommTimeouts{
+		ReadIntervalTimeout:        maxDWORD,
+		ReadTotalTimeoutMultiplier: maxDWORD,
+		ReadTotalTimeoutConstant:   timeout,
+	}); err != nil {
+		return 0, err
+	}
+
+	n, err := p.overlapped(b, windows.ReadFile)
+	if err == nil && n == 0 && len(b) > 0 {
+		return 0, os.ErrDeadlineExceeded
+	}
+	return n, err
+}
+
+// Write writes to the port
+func (p *Port) Write(b []byte) (int, error) {
+	return p.overlapped(b, windows.WriteFile)
+}
+
+// overlapped runs an overlapped read or write of b and waits for it
+func (p *Port) overlapped(b []byte, op func(windows.Handle, []byte, *uint32, *windows.Overlapped) error) (int, error) {
+	event, err := windows.CreateEvent(nil, 1, 0, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer windows.CloseHandle(event)
+	ov := windows.Overlapped{HEvent: event}
+
+	var n uint32
+	err = op(p.h, b, &n, &ov)
+	if errors.Is(err, windows.ERROR_IO_PENDING) {
+		err = windows.GetOverlappedResult(p.h, &ov, &n, true)
+	}
+	if errors.Is(err, windows.ERROR_OPERATION_ABORTED) {
+		return int(n), os.ErrClosed
+	}
+	return int(n), err
+}
+
+// SetReadDeadline sets the deadline for reads; the zero time disables it
+func (p *Port) SetReadDeadline(t time.Time) error {
+	p.mu.Lock()
+	p.deadline = t
+	p.mu.Unlock()
+	return nil
+}
+
+// Close closes the port, cancelling pending reads
+func (p *Port) Close() error {
+	windows.CancelIoEx(p.h, nil)
+	return windows.CloseHandle(p.h)
+}
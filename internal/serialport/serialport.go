@@ -0,0 +1,73 @@
+// Package serialport opens serial ports in raw mode with the termios
+// interface on Unix systems, including illumos, and the communications API
+// on Windows. Unlike tarm/serial, which it replaces, it applies parity and
+// stop bits on every platform, always disables flow control, whatever the
+// port was left with, and supports read deadlines. On Unix systems, devices
+// that are not terminals, such as virtio-serial ports, open without line
+// settings.
+package serialport
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Parity is the parity bit of each character
+type Parity byte
+
+// Parity settings, named by the letters of the customary 8N1 notation
+const (
+	ParityNone  Parity = 'N'
+	ParityOdd   Parity = 'O'
+	ParityEven  Parity = 'E'
+	ParityMark  Parity = 'M' // parity bit always 1
+	ParitySpace Parity = 'S' // parity bit always 0
+)
+
+// StopBits is the number of stop bits after each character
+type StopBits byte
+
+// Stop bit settings; Stop1Half is supported on Windows only
+const (
+	Stop1     StopBits = 1
+	Stop1Half StopBits = 15
+	Stop2     StopBits = 2
+)
+
+// Errors returned by Open for settings the platform cannot apply
+var (
+	ErrBadSize     = errors.New("unsupported serial data size")
+	ErrBadParity   = errors.New("unsupported serial parity")
+	ErrBadStopBits = errors.New("unsupported serial stop bits")
+)
+
+// Config holds the line settings of a port
+type Config struct {
+	Name     string // device, such as /dev/ttyS1 or COM2
+	Baud     int
+	Size     byte     // data bits, 8 when zero
+	Parity   Parity   // ParityNone when zero
+	StopBits StopBits // Stop1 when zero
+}
+
+// Open opens the port c names with its line settings. Reads on the port
+// block until data arrives or the read deadline passes.
+func Open(c *Config) (*Port, error) {
+	config := *c
+	if config.Size == 0 {
+		config.Size = 8
+	}
+	if config.Parity == 0 {
+		config.Parity = ParityNone
+	}
+	if config.StopBits == 0 {
+		config.StopBits = Stop1
+	}
+	if config.Size < 5 || config.Size > 8 {
+		return nil, ErrBadSize
+	}
+	if config.Baud <= 0 {
+		return nil, fmt.Errorf("invalid baud rate %d", config.Baud)
+	}
+	return openPort(&config)
+}
@@ -0,0 +1,32 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package serialport
+
+import "golang.org/x/sys/unix"
+
+// Requests reading and writing terminal attributes
+const (
+	ioctlGetTermios = unix.TIOCGETA
+	ioctlSetTermios = unix.TIOCSETA
+)
+
+// stickParity is zero as the BSDs have no mark or space parity
+const stickParity = 0
+
+// setSpeed sets the input and output speed of t to baud; BSD speeds are the
+// baud rates themselves, checked by the driver
+func setSpeed(t *unix.Termios, baud int) error {
+	setField(&t.Ispeed, baud)
+	setField(&t.Ospeed, baud)
+	return nil
+}
+
+// setField assigns v to a termios field, whose type varies between systems
+func setField[T ~int32 | ~uint32 | ~uint64](field *T, v int) {
+	*field = T(v)
+}
+
+// setStickParity fails, as the BSDs have no mark or space parity
+func setStickParity(t *unix.Termios, mark bool) error {
+	return ErrBadParity
+}
@@ -0,0 +1,58 @@
+package serialport
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// Requests reading and writing terminal attributes
+const (
+	ioctlGetTermios = unix.TCGETS
+	ioctlSetTermios = unix.TCSETS
+)
+
+// stickParity is the control flag turning parity into mark or space parity
+const stickParity = unix.CMSPAR
+
+// speeds maps baud rates to their termios speed codes
+var speeds = map[int]uint32{
+	1200:    unix.B1200,
+	2400:    unix.B2400,
+	4800:    unix.B4800,
+	9600:    unix.B9600,
+	19200:   unix.B19200,
+	38400:   unix.B38400,
+	57600:   unix.B57600,
+	115200:  unix.B115200,
+	230400:  unix.B230400,
+	460800:  unix.B460800,
+	921600:  unix.B921600,
+	1000000: unix.B1000000,
+	1500000: unix.B1500000,
+	2000000: unix.B2000000,
+	3000000: unix.B3000000,
+	4000000: unix.B4000000,
+}
+
+// setSpeed sets the input and output speed of t to baud
+func setSpeed(t *unix.Termios, baud int) error {
+	speed, ok := speeds[baud]
+	if !ok {
+		return fmt.Errorf("unsupported baud rate %d", baud)
+	}
+	t.Cflag &^= unix.CBAUD
+	t.Cflag |= speed
+	t.Ispeed = speed
+	t.Ospeed = speed
+	return nil
+}
+
+// setStickParity sets mark or space parity, a parity bit fixed at 1 or 0
+func setStickParity(t *unix.Termios, mark bool) error {
+	t.Cflag |= unix.PARENB | stickParity
+	if mark {
+		t.Cflag |= unix.PARODD
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package serialport
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// Requests reading and writing terminal attributes
+const (
+	ioctlGetTermios = unix.TCGETS
+	ioctlSetTermios = unix.TCSETS
+)
+
+// Control flags extending the speed codes beyond CBAUD, from illumos
+// <sys/termios.h>; x/sys/unix does not define them
+const (
+	cbaudext  = 0x200000
+	cibaudext = 0x400000
+)
+
+// stickParity is zero as illumos has no mark or space parity
+const stickParity = 0
+
+// speeds maps baud rates to their termios speed codes
+var speeds = map[int]uint32{
+	1200:   unix.B1200,
+	2400:   unix.B2400,
+	4800:   unix.B4800,
+	9600:   unix.B9600,
+	19200:  unix.B19200,
+	38400:  unix.B38400,
+	57600:  unix.B57600,
+	115200: unix.B115200,
+	230400: unix.B230400,
+	460800: unix.B460800,
+	921600: unix.B921600,
+}
+
+// setSpeed sets the output speed of t to baud, and the input speed to match
+// it by leaving it zero. Codes above CBAUD are stored, as cfsetospeed does,
+// less CBAUD+1 with CBAUDEXT set.
+func setSpeed(t *unix.Termios, baud int) error {
+	speed, ok := speeds[baud]
+	if !ok {
+		return fmt.Errorf("unsupported baud rate %d", baud)
+	}
+	t.Cflag &^= unix.CBAUD | cbaudext | unix.CIBAUD | cibaudext
+	if speed > unix.CBAUD {
+		t.Cflag |= cbaudext
+		speed -= unix.CBAUD + 1
+	}
+	t.Cflag |= speed
+	return nil
+}
+
+// setStickParity fails, as illumos has no mark or space parity
+func setStickParity(t *unix.Termios, mark bool) error {
+	return ErrBadParity
+}
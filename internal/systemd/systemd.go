@@ -0,0 +1,89 @@
+// Package systemd implements the parts of the systemd service protocol used by
+// long-running mdata commands: sd_notify(3) messages, the watchdog interval,
+// and socket activation. Every function is a no-op outside systemd.
+package systemd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// listenFDsStart is the first file descriptor passed by socket activation (SD_LISTEN_FDS_START)
+const listenFDsStart = 3
+
+// Notify sends state (e.g. "READY=1") to the service manager. It reports false
+// when the process was not started by systemd with NOTIFY_SOCKET set.
+func Notify(state string) (bool, error) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return false, nil
+	}
+	// A leading "@" denotes a socket in the abstract namespace
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return false, fmt.Errorf("failed to connect to notify socket: %w", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, fmt.Errorf("failed to notify: %w", err)
+	}
+	return true, nil
+}
+
+// WatchdogInterval returns the interval at which WATCHDOG=1 must be sent, or
+// zero when the watchdog is not enabled for this process. It returns half of
+// WATCHDOG_USEC, as sd_watchdog_enabled(3) recommends.
+func WatchdogInterval() time.Duration {
+	// As with sd_watchdog_enabled, an unset WATCHDOG_PID applies to any process
+	if os.Getenv("WATCHDOG_PID") != "" && !forThisProcess("WATCHDOG_PID") {
+		return 0
+	}
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond / 2
+}
+
+// Listeners returns the sockets passed by socket activation, in order, or nil
+// when the process was not socket activated.
+func Listeners() ([]net.Listener, error) {
+	if !forThisProcess("LISTEN_PID") {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return nil, nil
+	}
+	// Don't pass the sockets on to children
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	listeners := make([]net.Listener, 0, n)
+	for fd := listenFDsStart; fd < listenFDsStart+n; fd++ {
+		f := os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd))
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			for _, opened := range listeners {
+				opened.Close()
+			}
+			return nil, fmt.Errorf("failed to use activated socket %d: %w", fd, err)
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
+
+// forThisProcess reports whether the named variable holds our PID
+func forThisProcess(name string) bool {
+	pid, err := strconv.Atoi(os.Getenv(name))
+	return err == nil && pid == os.Getpid()
+}
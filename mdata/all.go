@@ -0,0 +1,52 @@
+package mdata
+
+import (
+	"errors"
+	"fmt"
+	"iter"
+	"strings"
+)
+
+// All returns an iterator over the keys of c and their values. Keys are
+// listed once when iteration starts and each value is fetched only when its
+// turn comes, so a large namespace is never held in memory as a whole. Keys
+// deleted meanwhile are skipped. A failure ends the iteration; call the
+// returned function afterwards to tell it from the end of the keys.
+//
+//	values, errf := mdata.All(client)
+//	for key, value := range values {
+//		...
+//	}
+//	if err := errf(); err != nil {
+//		...
+//	}
+func All(c MetadataClient) (iter.Seq2[string, string], func() error) {
+	var iterErr error
+	seq := func(yield func(string, string) bool) {
+		iterErr = nil
+		raw, err := c.Keys()
+		if err != nil {
+			iterErr = fmt.Errorf("failed to list keys: %w", err)
+			return
+		}
+		for rest := raw; rest != ""; {
+			var key string
+			key, rest, _ = strings.Cut(rest, "\n")
+			if key == "" {
+				continue
+			}
+			value, err := c.Get(key)
+			if errors.Is(err, ErrNotFound) {
+				continue
+			}
+			if err != nil {
+				iterErr = fmt.Errorf("failed to get %s: %w", key, err)
+				return
+			}
+			if !yield(key, value) {
+				return
+			}
+		}
+	}
+	return seq, func() error { return iterErr }
+}
@@ -0,0 +1,74 @@
+package mdata
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// AuditEvent records one metadata mutation made by a client
+type AuditEvent struct {
+	Time time.Time `json:"time"`
+	// Op is the request code, PUT or DELETE
+	Op  string `json:"op"`
+	Key string `json:"key"`
+	// ValueSHA256 is the hex SHA-256 of the value written by a PUT, so
+	// changes can be traced without recording values. It is left out for
+	// secret keys, whose values could be guessed from it.
+	ValueSHA256 string `json:"value_sha256,omitempty"`
+	Caller      Caller `json:"caller"`
+	// Error is empty when the mutation succeeded
+	Error string `json:"error,omitempty"`
+}
+
+// Caller identifies the process making a request
+type Caller struct {
+	PID  int    `json:"pid"`
+	PPID int    `json:"ppid"`
+	UID  int    `json:"uid"` // -1 on Windows
+	Exe  string `json:"exe"`
+}
+
+// currentCaller describes this process
+var currentCaller = func() Caller {
+	exe, err := os.Executable()
+	if err != nil {
+		exe = filepath.Base(os.Args[0])
+	}
+	return Caller{PID: os.Getpid(), PPID: os.Getppid(), UID: os.Getuid(), Exe: exe}
+}()
+
+// AuditFile returns an audit sink appending each event as a JSON line to the
+// file at path, which is created readable by its owner only. The file is
+// opened for every event, so it may be rotated at any time. Events that
+// cannot be written are dropped.
+func AuditFile(path string) func(AuditEvent) {
+	return func(ev AuditEvent) {
+		line, err := json.Marshal(ev)
+		if err != nil {
+			return
+		}
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+		if err != nil {
+			return
+		}
+		defer f.Close()
+		f.Write(append(line, '\n'))
+	}
+}
+
+// audit reports a mutation to the client's audit sink, if any
+func (c *MetadataClientImpl) audit(op, key string, value *string, err error) {
+	if c.auditSink == nil {
+		return
+	}
+	ev := AuditEvent{Time: time.Now().UTC(), Op: op, Key: key, Caller: currentCaller}
+	if value != nil && !c.redact.IsSecret(key) {
+		ev.ValueSHA256 = HashValue([]byte(*value))
+	}
+	if err != nil {
+		ev.Error = err.Error()
+	}
+	c.auditSink(ev)
+}
@@ -0,0 +1,76 @@
+package mdata
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Smithx10/go-smartos-mdata/mdata/protocol"
+)
+
+// Shared-secret authentication handshake, performed before negotiation when
+// the server requires it. The client asks for a challenge, the server replies
+// with a random nonce, and the client answers with HMAC-SHA256(secret, nonce)
+// so the secret itself never crosses the network. The handshake does not
+// encrypt the requests that follow.
+const (
+	AuthReq             = "AUTH HMAC-SHA256\n"
+	AuthChallengePrefix = "AUTH_CHALLENGE "
+	AuthResponsePrefix  = "AUTH_RESPONSE "
+	AuthOK              = "AUTH_OK\n"
+	AuthFailed          = "AUTH_FAILED\n"
+	AuthRequired        = protocol.AuthRequired
+)
+
+var (
+	// ErrAuthFailed is returned when the server rejects the client's credentials
+	ErrAuthFailed = errors.New("authentication failed")
+	// ErrAuthRequired is returned when the server requires authentication but no secret is configured
+	ErrAuthRequired = protocol.ErrAuthRequired
+)
+
+// AuthMAC returns the hex encoded response to a challenge nonce
+func AuthMAC(secret []byte, nonce string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(nonce))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Authenticate performs the client side of the authentication handshake
+func Authenticate(conn *bufio.ReadWriter, secret []byte) error {
+	if _, err := conn.WriteString(AuthReq); err != nil {
+		return fmt.Errorf("failed to send authentication request: %w", err)
+	}
+	if err := conn.Flush(); err != nil {
+		return fmt.Errorf("failed to flush authentication request: %w", err)
+	}
+
+	challenge, err := protocol.ReadLine(conn.Reader, protocol.MaxNegotiationLength)
+	if err != nil {
+		return fmt.Errorf("failed to read authentication challenge: %w", err)
+	}
+	nonce, ok := strings.CutPrefix(strings.TrimSuffix(challenge, "\n"), AuthChallengePrefix)
+	if !ok || nonce == "" {
+		return fmt.Errorf("server does not support authentication")
+	}
+
+	if _, err := conn.WriteString(AuthResponsePrefix + AuthMAC(secret, nonce) + "\n"); err != nil {
+		return fmt.Errorf("failed to send authentication response: %w", err)
+	}
+	if err := conn.Flush(); err != nil {
+		return fmt.Errorf("failed to flush authentication response: %w", err)
+	}
+
+	result, err := protocol.ReadLine(conn.Reader, protocol.MaxNegotiationLength)
+	if err != nil {
+		return fmt.Errorf("failed to read authentication result: %w", err)
+	}
+	if result != AuthOK {
+		return ErrAuthFailed
+	}
+	return nil
+}
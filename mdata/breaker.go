@@ -0,0 +1,136 @@
+package mdata
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned without sending the request while a circuit
+// breaker client is open
+var ErrCircuitOpen = errors.New("metadata circuit open: too many transport failures")
+
+// breakerClient stops sending requests after repeated transport failures
+type breakerClient struct {
+	MetadataClient
+	threshold int
+	cooldown  time.Duration
+
+	mu        sync.Mutex
+	failures  int       // consecutive transport failures
+	openUntil time.Time // zero while closed
+	probing   bool      // a request is testing an open circuit
+}
+
+// NewCircuitBreakerClient wraps inner so that after threshold consecutive
+// transport failures (see TransportError) it fails fast with ErrCircuitOpen
+// instead of sending requests. Once cooldown has passed, a single request is
+// let through as a probe: its success closes the circuit, its failure opens it
+// for another cooldown. Replies from the server, including NOTFOUND and
+// FAILURE, count as successes.
+//
+// This keeps retry loops, such as those of services starting at boot, from
+// hammering a metadata device that is not answering.
+func NewCircuitBreakerClient(inner MetadataClient, threshold int, cooldown time.Duration) MetadataClient {
+	return &breakerClient{MetadataClient: inner, threshold: max(threshold, 1), cooldown: cooldown}
+}
+
+// allow reports whether a request may be sent
+func (c *breakerClient) allow() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.openUntil.IsZero() {
+		return true
+	}
+	if c.probing || time.Now().Before(c.openUntil) {
+		return false
+	}
+	c.probing = true
+	return true
+}
+
+// record updates the circuit with the outcome of a request and returns err
+func (c *breakerClient) record(err error) error {
+	var transportErr *TransportError
+	failed := errors.As(err, &transportErr)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.probing = false
+	if !failed {
+		c.failures = 0
+		c.openUntil = time.Time{}
+		return err
+	}
+	c.failures++
+	if c.failures >= c.threshold {
+		c.openUntil = time.Now().Add(c.cooldown)
+	}
+	return err
+}
+
+// Get implements MetadataClient.Get
+func (c *breakerClient) Get(key string) (string, error) {
+	if !c.allow() {
+		return "", ErrCircuitOpen
+	}
+	value, err := c.MetadataClient.Get(key)
+	return value, c.record(err)
+}
+
+// GetIfChanged implements ConditionalGetter
+func (c *breakerClient) GetIfChanged(key, lastChecksum string) (string, string, error) {
+	if !c.allow() {
+		return "", "", ErrCircuitOpen
+	}
+	value, checksum, err := GetIfChanged(c.MetadataClient, key, lastChecksum)
+	return value, checksum, c.record(err)
+}
+
+// Keys implements MetadataClient.Keys
+func (c *breakerClient) Keys() (string, error) {
+	if !c.allow() {
+		return "", ErrCircuitOpen
+	}
+	keys, err := c.MetadataClient.Keys()
+	return keys, c.record(err)
+}
+
+// Put implements MetadataClient.Put
+func (c *breakerClient) Put(key, value string) error {
+	if !c.allow() {
+		return ErrCircuitOpen
+	}
+	return c.record(c.MetadataClient.Put(key, value))
+}
+
+// Delete implements MetadataClient.Delete
+func (c *breakerClient) Delete(key string) error {
+	if !c.allow() {
+		return ErrCircuitOpen
+	}
+	return c.record(c.MetadataClient.Delete(key))
+}
+
+// Watch implements Watcher
+func (c *breakerClient) Watch(prefix, state string, timeout time.Duration) (string, error) {
+	if !c.allow() {
+		return "", ErrCircuitOpen
+	}
+	current, err := watch(c.MetadataClient, prefix, state, timeout)
+	return current, c.record(err)
+}
+
+// SendRaw implements RawSender
+func (c *breakerClient) SendRaw(code string, payload []byte) (ResponseCode, []byte, error) {
+	if !c.allow() {
+		return "", nil, ErrCircuitOpen
+	}
+	resp, value, err := sendRaw(c.MetadataClient, code, payload)
+	return resp, value, c.record(err)
+}
+
+// unwrap implements wrapper
+func (c *breakerClient) unwrap() MetadataClient {
+	return c.MetadataClient
+}
@@ -0,0 +1,62 @@
+package mdata
+
+import "strings"
+
+// codecClient transforms the values of selected keys on their way to and
+// from the metadata service, for wrappers such as NewEncryptedClient
+type codecClient struct {
+	MetadataClient
+	prefixes []string
+	encode   func(key, value string) string
+	decode   func(key, value string) (string, error)
+}
+
+// selected reports whether values of key are transformed: keys starting with
+// one of the prefixes, or with none, every key outside the sdc: namespace
+func (c *codecClient) selected(key string) bool {
+	if strings.HasPrefix(key, "sdc:") {
+		return false
+	}
+	if len(c.prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range c.prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Get implements MetadataClient.Get, decoding selected keys
+func (c *codecClient) Get(key string) (string, error) {
+	value, err := c.MetadataClient.Get(key)
+	if err != nil || !c.selected(key) {
+		return value, err
+	}
+	return c.decode(key, value)
+}
+
+// GetIfChanged implements ConditionalGetter, decoding selected
+// keys. The checksum is that of the encoded value as stored.
+func (c *codecClient) GetIfChanged(key, lastChecksum string) (string, string, error) {
+	value, checksum, err := GetIfChanged(c.MetadataClient, key, lastChecksum)
+	if err != nil || !c.selected(key) {
+		return value, checksum, err
+	}
+	value, err = c.decode(key, value)
+	return value, checksum, err
+}
+
+// Put implements MetadataClient.Put, encoding selected keys
+func (c *codecClient) Put(key, value string) error {
+	if c.selected(key) {
+		value = c.encode(key, value)
+	}
+	return c.MetadataClient.Put(key, value)
+}
+
+// unwrap implements wrapper
+func (c *codecClient) unwrap() MetadataClient {
+	return c.MetadataClient
+}
@@ -0,0 +1,211 @@
+package mdata
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Configurations are logged by most programs that use them, so their String
+// and MarshalJSON methods describe the transport but never print a secret:
+// the Secret is reported only as set, and credentials embedded in a socket
+// address are masked.
+
+// redacted replaces sensitive values in printed configurations
+const redacted = "[REDACTED]"
+
+// String describes the transport of the configuration, for example
+// "unix /run/mdata.sock (timeout 5s) [secret set]"
+func (c ClientConfig) String() string {
+	var b strings.Builder
+	b.WriteString(string(c.Transport))
+	switch {
+	case c.SocketConfig != nil:
+		b.WriteString(" " + c.SocketConfig.String())
+	case c.SerialConfig != nil:
+		b.WriteString(" " + c.SerialConfig.String())
+	case c.ExecConfig != nil:
+		b.WriteString(" " + c.ExecConfig.String())
+	}
+	var notes []string
+	if len(c.Secret) > 0 {
+		notes = append(notes, "secret set")
+	}
+	if c.Audit != nil {
+		notes = append(notes, "audited")
+	}
+	if c.Trace != nil {
+		notes = append(notes, "traced")
+	}
+	if c.NoNegotiate {
+		notes = append(notes, "no negotiation")
+	}
+	if c.BestEffort != nil {
+		notes = append(notes, "best effort")
+	}
+	if n := len(c.Middleware); n > 0 {
+		notes = append(notes, fmt.Sprintf("%d middleware", n))
+	}
+	if len(notes) > 0 {
+		fmt.Fprintf(&b, " [%s]", strings.Join(notes, ", "))
+	}
+	// Without a transport, the description starts with a space
+	return strings.TrimSpace(b.String())
+}
+
+// MarshalJSON implements json.Marshaler, leaving out the secret and the
+// functions of the configuration
+func (c ClientConfig) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Transport   string        `json:"transport"`
+		Socket      *SocketConfig `json:"socket,omitempty"`
+		Serial      *SerialConfig `json:"serial,omitempty"`
+		Exec        *ExecConfig   `json:"exec,omitempty"`
+		Secret      string        `json:"secret,omitempty"`
+		Audit       bool          `json:"audit,omitempty"`
+		Trace       bool          `json:"trace,omitempty"`
+		NoNegotiate bool          `json:"no_negotiate,omitempty"`
+		BestEffort  string        `json:"best_effort,omitempty"`
+		Middleware  int           `json:"middleware,omitempty"`
+		Redact      []string      `json:"redact,omitempty"`
+	}{
+		Transport:   string(c.Transport),
+		Socket:      c.SocketConfig,
+		Serial:      c.SerialConfig,
+		Exec:        c.ExecConfig,
+		Secret:      redactedIf(len(c.Secret) > 0),
+		Audit:       c.Audit != nil,
+		Trace:       c.Trace != nil,
+		NoNegotiate: c.NoNegotiate,
+		BestEffort:  bestEffortDir(c.BestEffort),
+		Middleware:  len(c.Middleware),
+		Redact:      c.Redact.Patterns,
+	})
+}
+
+// String describes the socket, for example "/run/mdata.sock (timeout 5s)"
+func (c SocketConfig) String() string {
+	s := maskAddress(c.Address)
+	var notes []string
+	if c.Timeout > 0 {
+		notes = append(notes, "timeout "+c.Timeout.String())
+	}
+	if c.MaxConns > 0 {
+		notes = append(notes, "max "+strconv.Itoa(c.MaxConns)+" conns")
+	}
+	if len(notes) > 0 {
+		s += " (" + strings.Join(notes, ", ") + ")"
+	}
+	return s
+}
+
+// MarshalJSON implements json.Marshaler, masking credentials in the address
+// and writing durations as strings such as "5s"
+func (c SocketConfig) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Network             string `json:"network"`
+		Address             string `json:"address"`
+		Timeout             string `json:"timeout,omitempty"`
+		MaxConns            int    `json:"max_conns,omitempty"`
+		IdleTimeout         string `json:"idle_timeout,omitempty"`
+		HealthCheckInterval string `json:"health_check_interval,omitempty"`
+	}{
+		Network:             c.Network,
+		Address:             maskAddress(c.Address),
+		Timeout:             durationString(c.Timeout),
+		MaxConns:            c.MaxConns,
+		IdleTimeout:         durationString(c.IdleTimeout),
+		HealthCheckInterval: durationString(c.HealthCheckInterval),
+	})
+}
+
+// String describes the command, for example
+// "ssh -T admin@web0 mdata proxy --stdio (timeout 30s)"
+func (c ExecConfig) String() string {
+	s := strings.Join(append([]string{c.Command}, c.Args...), " ")
+	if c.Timeout > 0 {
+		s += " (timeout " + c.Timeout.String() + ")"
+	}
+	return s
+}
+
+// MarshalJSON implements json.Marshaler, writing the timeout as a string
+// such as "30s"
+func (c ExecConfig) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Command string   `json:"command"`
+		Args    []string `json:"args,omitempty"`
+		Timeout string   `json:"timeout,omitempty"`
+	}{c.Command, c.Args, durationString(c.Timeout)})
+}
+
+// String describes the serial port, for example "/dev/ttyS1 115200 8N1"
+func (c SerialConfig) String() string {
+	return fmt.Sprintf("%s %d %s", c.Name, c.Baud, c.lineSettings())
+}
+
+// MarshalJSON implements json.Marshaler, writing the line settings in the
+// 8N1 notation and the read timeout as a string such as "60s"
+func (c SerialConfig) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Name        string   `json:"name"`
+		Baud        int      `json:"baud"`
+		Line        string   `json:"line"`
+		ReadTimeout string   `json:"read_timeout,omitempty"`
+		Candidates  []string `json:"candidates,omitempty"`
+	}{c.Name, c.Baud, c.lineSettings(), durationString(c.ReadTimeout), c.Candidates})
+}
+
+// lineSettings returns the data bits, parity and stop bits in the 8N1
+// notation, filling in the defaults of zero values
+func (c SerialConfig) lineSettings() string {
+	size, parity, stop := c.Size, c.Parity, "1"
+	if size == 0 {
+		size = 8
+	}
+	if parity == 0 {
+		parity = ParityNone
+	}
+	switch c.StopBits {
+	case Stop1Half:
+		stop = "1.5"
+	case Stop2:
+		stop = "2"
+	}
+	return fmt.Sprintf("%d%c%s", size, parity, stop)
+}
+
+// maskAddress masks the credentials of an address of the form
+// user:password@host
+func maskAddress(address string) string {
+	if i := strings.LastIndexByte(address, '@'); i > 0 && strings.Contains(address[:i], ":") {
+		return redacted + address[i:]
+	}
+	return address
+}
+
+// durationString returns d as a string such as "5s", or "" if it is zero
+func durationString(d time.Duration) string {
+	if d == 0 {
+		return ""
+	}
+	return d.String()
+}
+
+// redactedIf returns the mark of a redacted value if set, else ""
+func redactedIf(set bool) string {
+	if set {
+		return redacted
+	}
+	return ""
+}
+
+// bestEffortDir returns the directory of l, or "" if l is nil
+func bestEffortDir(l *LastKnown) string {
+	if l == nil {
+		return ""
+	}
+	return l.Dir
+}
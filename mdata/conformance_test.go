@@ -0,0 +1,11 @@
+package mdata_test
+
+import (
+	"testing"
+
+	"github.com/Smithx10/go-smartos-mdata/mdatatest"
+)
+
+func TestClientConformance(t *testing.T) {
+	mdatatest.RunClientConformance(t, nil)
+}
@@ -0,0 +1,40 @@
+package mdata
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// DeleteAll deletes every key of c starting with prefix and returns the keys
+// it deleted, in listing order. A failure to delete one key does not stop the
+// others; the failures are returned joined, each naming its key. The prefix
+// must be non-empty, and keys in the read-only sdc: namespace are left alone.
+func DeleteAll(c MetadataClient, prefix string) ([]string, error) {
+	if prefix == "" {
+		return nil, fmt.Errorf("refusing to delete all keys: prefix must not be empty")
+	}
+	if strings.HasPrefix(prefix, "sdc:") {
+		return nil, fmt.Errorf("refusing to delete keys in the read-only sdc: namespace")
+	}
+
+	raw, err := c.Keys()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list keys: %w", err)
+	}
+	var (
+		deleted []string
+		errs    []error
+	)
+	for _, key := range strings.Split(raw, "\n") {
+		if key == "" || !strings.HasPrefix(key, prefix) || strings.HasPrefix(key, "sdc:") {
+			continue
+		}
+		if err := c.Delete(key); err != nil {
+			errs = append(errs, fmt.Errorf("failed to delete %s: %w", key, err))
+			continue
+		}
+		deleted = append(deleted, key)
+	}
+	return deleted, errors.Join(errs...)
+}
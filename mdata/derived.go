@@ -0,0 +1,243 @@
+package mdata
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Smithx10/go-smartos-mdata/mdata/protocol"
+)
+
+// ErrReadOnly is returned by clients derived with ReadOnly for requests that
+// would change metadata
+var ErrReadOnly = errors.New("metadata client is read-only")
+
+// Option sets the behavior of a client derived with With
+type Option func(*derivedOptions)
+
+// derivedOptions are the settings of a derived client
+type derivedOptions struct {
+	timeout    time.Duration
+	setTimeout bool // timeout replaces the read timeout of the connection
+	retries    int
+	retryDelay time.Duration
+	prefix     string
+	readOnly   bool
+}
+
+// WithTimeout sets the read timeout of the requests of the derived client,
+// in place of the one the connection was configured with. A timeout of zero
+// waits for responses without limit.
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *derivedOptions) { o.timeout, o.setTimeout = timeout, true }
+}
+
+// WithRetries makes the derived client send a GET, KEYS or GETIFCHANGED
+// request again, up to n times and delay apart, when it fails with a
+// TransportError, such as ErrTimeout. As with the replay of requests on a new
+// connection, only requests that read are retried: a PUT or DELETE may have
+// been applied before the failure.
+func WithRetries(n int, delay time.Duration) Option {
+	return func(o *derivedOptions) { o.retries, o.retryDelay = max(n, 0), delay }
+}
+
+// WithPrefix makes the derived client see only the keys starting with
+// prefix, by their names without it: Get("port") gets prefix+"port", and
+// Keys lists the keys starting with prefix with the prefix removed. Tags,
+// UserData, VendorData, Volumes, Disks and SendRaw are not affected.
+func WithPrefix(prefix string) Option {
+	return func(o *derivedOptions) { o.prefix = prefix }
+}
+
+// ReadOnly makes the derived client refuse PUT and DELETE requests, and raw
+// requests other than those that read, with ErrReadOnly
+func ReadOnly() Option {
+	return func(o *derivedOptions) { o.readOnly = true }
+}
+
+// derivedClient sends the requests of a derived client through the client
+// it was derived from
+type derivedClient struct {
+	MetadataClient
+	impl *MetadataClientImpl // owner of the connection, nil if unknown
+	opts derivedOptions
+}
+
+// With returns a client sending its requests over the connection of c, with
+// the behavior set by opts, so that one process can hold differently tuned
+// views of the metadata without opening more connections:
+//
+//	config := mdata.With(client, mdata.WithPrefix("app:"), mdata.ReadOnly())
+//	slow := mdata.With(client, mdata.WithTimeout(time.Minute), mdata.WithRetries(3, time.Second))
+//
+// The derived client shares the connection, so like c it must not be used
+// concurrently with c or other clients derived from it, and closing it does
+// not close the connection. A client derived from a derived client has the
+// options of the latter changed by opts.
+func With(c MetadataClient, opts ...Option) MetadataClient {
+	d := &derivedClient{MetadataClient: c}
+	if from, ok := c.(*derivedClient); ok {
+		*d = *from
+	} else {
+		d.impl, _ = baseClient(c)
+	}
+	for _, opt := range opts {
+		opt(&d.opts)
+	}
+	return d
+}
+
+// baseClient returns the client beneath the wrappers of c
+func baseClient(c MetadataClient) (*MetadataClientImpl, bool) {
+	for {
+		w, ok := c.(wrapper)
+		if !ok {
+			break
+		}
+		c = w.unwrap()
+	}
+	impl, ok := c.(*MetadataClientImpl)
+	return impl, ok
+}
+
+// do runs the requests of op with the timeout of the client, retrying them
+// as configured if retry is set
+func (c *derivedClient) do(retry bool, op func() error) error {
+	if c.opts.setTimeout && c.impl != nil {
+		prev := c.impl.readTimeout
+		if err := c.impl.conn.SetReadTimeout(c.opts.timeout); err != nil {
+			return &TransportError{Err: fmt.Errorf("failed to set read timeout: %w", err)}
+		}
+		c.impl.readTimeout = c.opts.timeout
+		defer func() {
+			c.impl.readTimeout = prev
+			c.impl.conn.SetReadTimeout(prev)
+		}()
+	}
+	var transportErr *TransportError
+	for attempt := 0; ; attempt++ {
+		err := op()
+		if err == nil || !retry || attempt >= c.opts.retries || !errors.As(err, &transportErr) {
+			return err
+		}
+		if c.impl != nil {
+			c.impl.trace(TraceEvent{Event: TraceRetry, Error: err.Error()})
+		}
+		time.Sleep(c.opts.retryDelay)
+	}
+}
+
+// Get implements MetadataClient.Get
+func (c *derivedClient) Get(key string) (value string, err error) {
+	err = c.do(true, func() error {
+		value, err = c.MetadataClient.Get(c.opts.prefix + key)
+		return err
+	})
+	return value, err
+}
+
+// GetIfChanged implements ConditionalGetter
+func (c *derivedClient) GetIfChanged(key, lastChecksum string) (value, checksum string, err error) {
+	err = c.do(true, func() error {
+		value, checksum, err = GetIfChanged(c.MetadataClient, c.opts.prefix+key, lastChecksum)
+		return err
+	})
+	return value, checksum, err
+}
+
+// Keys implements MetadataClient.Keys, listing only the keys starting with
+// the prefix of the client, without it
+func (c *derivedClient) Keys() (keys string, err error) {
+	err = c.do(true, func() error {
+		keys, err = c.MetadataClient.Keys()
+		return err
+	})
+	if err != nil || c.opts.prefix == "" {
+		return keys, err
+	}
+	var b strings.Builder
+	for _, key := range strings.Split(keys, "\n") {
+		if name, ok := strings.CutPrefix(key, c.opts.prefix); ok && name != "" {
+			b.WriteString(name)
+			b.WriteByte('\n')
+		}
+	}
+	return strings.TrimSuffix(b.String(), "\n"), nil
+}
+
+// Put implements MetadataClient.Put
+func (c *derivedClient) Put(key, value string) error {
+	if c.opts.readOnly {
+		return ErrReadOnly
+	}
+	return c.do(false, func() error {
+		return c.MetadataClient.Put(c.opts.prefix+key, value)
+	})
+}
+
+// Delete implements MetadataClient.Delete
+func (c *derivedClient) Delete(key string) error {
+	if c.opts.readOnly {
+		return ErrReadOnly
+	}
+	return c.do(false, func() error {
+		return c.MetadataClient.Delete(c.opts.prefix + key)
+	})
+}
+
+// Watch implements Watcher. The timeout of the client is added
+// to the time the server may hold the request.
+func (c *derivedClient) Watch(prefix, state string, timeout time.Duration) (current string, err error) {
+	err = c.do(false, func() error {
+		current, err = watch(c.MetadataClient, c.opts.prefix+prefix, state, timeout)
+		return err
+	})
+	return current, err
+}
+
+// SendRaw implements RawSender. A read-only client only sends
+// the codes of requests that read: GET, KEYS, GETIFCHANGED, WATCH and
+// extensions registered as idempotent.
+func (c *derivedClient) SendRaw(code string, payload []byte) (respCode ResponseCode, resp []byte, err error) {
+	if c.opts.readOnly && !c.reads(code) {
+		return "", nil, ErrReadOnly
+	}
+	err = c.do(false, func() error {
+		respCode, resp, err = sendRaw(c.MetadataClient, code, payload)
+		return err
+	})
+	return respCode, resp, err
+}
+
+// reads reports whether requests with code only read
+func (c *derivedClient) reads(code string) bool {
+	switch code {
+	case protocol.CodeGet, protocol.CodeKeys, protocol.CodeGetIfChanged, protocol.CodeWatch:
+		return true
+	}
+	return c.impl != nil && c.impl.extensions[code]
+}
+
+// unprefixed returns c without the prefix of a derived client, for the
+// requests of instance data not affected by it
+func unprefixed(c MetadataClient) MetadataClient {
+	d, ok := c.(*derivedClient)
+	if !ok || d.opts.prefix == "" {
+		return c
+	}
+	u := *d
+	u.opts.prefix = ""
+	return &u
+}
+
+// Close implements MetadataClient.Close without closing the shared
+// connection
+func (c *derivedClient) Close() error {
+	return nil
+}
+
+// unwrap implements wrapper
+func (c *derivedClient) unwrap() MetadataClient {
+	return c.MetadataClient
+}
@@ -0,0 +1,57 @@
+package mdata_test
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Smithx10/go-smartos-mdata/mdata"
+	"github.com/Smithx10/go-smartos-mdata/mdataserver"
+	"github.com/Smithx10/go-smartos-mdata/mdatatest"
+)
+
+// gatedStore is a store holding every GET and PUT until release is closed,
+// counting the requests it sees
+type gatedStore struct {
+	mdataserver.Store
+	release    chan struct{}
+	gets, puts atomic.Int32
+}
+
+func (s *gatedStore) Get(key string) (string, bool, error) {
+	s.gets.Add(1)
+	<-s.release
+	return s.Store.Get(key)
+}
+
+func (s *gatedStore) Put(key, value string) error {
+	s.puts.Add(1)
+	<-s.release
+	return s.Store.Put(key, value)
+}
+
+func TestWithRetriesOnlyRetriesReads(t *testing.T) {
+	store := &gatedStore{Store: mdataserver.NewMemoryStore(nil), release: make(chan struct{})}
+	_, base := mdatatest.StartServerWithStore(t, store)
+	client := mdata.With(base, mdata.WithTimeout(20*time.Millisecond), mdata.WithRetries(2, 0))
+
+	if err := client.Put("k", "v"); !errors.Is(err, mdata.ErrTimeout) {
+		t.Fatalf("Put: %v, want ErrTimeout", err)
+	}
+	if _, err := client.Get("k"); !errors.Is(err, mdata.ErrTimeout) {
+		t.Fatalf("Get: %v, want ErrTimeout", err)
+	}
+	// The server answers the requests of a connection in order, so once the
+	// held ones are released, the answer to KEYS follows every one sent
+	close(store.release)
+	if _, err := base.Keys(); err != nil {
+		t.Fatalf("Keys: %v", err)
+	}
+	if n := store.puts.Load(); n != 1 {
+		t.Errorf("PUT sent %d times, want once", n)
+	}
+	if n := store.gets.Load(); n != 3 {
+		t.Errorf("GET sent %d times, want 3", n)
+	}
+}
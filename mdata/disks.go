@@ -0,0 +1,53 @@
+package mdata
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// DisksKey is the key describing the disks of a hardware virtual machine
+const DisksKey = "sdc:disks"
+
+// Disk is a disk of a hardware virtual machine, in the order the guest sees
+// its devices. Sizes are in MiB.
+type Disk struct {
+	Path      string `json:"path"` // the zvol on the host
+	Size      int64  `json:"size"`
+	Boot      bool   `json:"boot"`
+	Model     string `json:"model"` // e.g. "virtio"
+	Media     string `json:"media"` // "disk" or "cdrom"
+	BlockSize int    `json:"block_size"`
+	ImageUUID string `json:"image_uuid"`
+	ImageName string `json:"image_name"`
+	ImageSize int64  `json:"image_size"` // size of the image the disk was created from
+}
+
+// Grown reports whether the disk is larger than the image it was created
+// from, so the partition and filesystem the image laid out can be grown into
+// the extra space
+func (d Disk) Grown() bool {
+	return d.ImageUUID != "" && d.ImageSize > 0 && d.Size > d.ImageSize
+}
+
+// Disks returns the disks of the instance. Instances other than hardware
+// virtual machines have none.
+func Disks(c MetadataClient) ([]Disk, error) {
+	raw, err := unprefixed(c).Get(DisksKey)
+	if errors.Is(err, ErrNotFound) {
+		return []Disk{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ParseDisks(raw)
+}
+
+// ParseDisks decodes the value of sdc:disks
+func ParseDisks(raw string) ([]Disk, error) {
+	var d []Disk
+	if err := json.Unmarshal([]byte(raw), &d); err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", DisksKey, err)
+	}
+	return d, nil
+}
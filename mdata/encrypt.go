@@ -0,0 +1,71 @@
+package mdata
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// EncryptedPrefix starts every value written by an encrypted client. The
+// version allows the format to change without misreading older values.
+const EncryptedPrefix = "mdenc:v1:"
+
+// NewEncryptedClient wraps inner so that values of keys starting with one of
+// prefixes are encrypted with AES-GCM on Put and decrypted on Get; with no
+// prefixes, every key outside the sdc: namespace is. Customer metadata is
+// readable by anyone with access to the instance and its operator, so this
+// keeps secrets opaque to both without the key.
+//
+// key must be 16, 24 or 32 bytes, selecting AES-128, AES-192 or AES-256.
+// Values are stored as EncryptedPrefix followed by the BASE64 nonce and
+// ciphertext, and bound to their key name, so a value copied to another key
+// fails to decrypt. Reading a selected key whose value is not encrypted is an
+// error rather than a silent fallback.
+func NewEncryptedClient(inner MetadataClient, key []byte, prefixes ...string) (MetadataClient, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption key: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &codecClient{
+		MetadataClient: inner,
+		prefixes:       prefixes,
+		encode:         func(key, value string) string { return sealValue(aead, key, value) },
+		decode:         func(key, value string) (string, error) { return openValue(aead, key, value) },
+	}, nil
+}
+
+// sealValue encrypts value, authenticating the key it is stored under
+func sealValue(aead cipher.AEAD, key, value string) string {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(value)+aead.Overhead())
+	rand.Read(nonce)
+	sealed := aead.Seal(nonce, nonce, []byte(value), []byte(key))
+	return EncryptedPrefix + base64.StdEncoding.EncodeToString(sealed)
+}
+
+// openValue decrypts a value written by sealValue under key
+func openValue(aead cipher.AEAD, key, value string) (string, error) {
+	encoded, ok := strings.CutPrefix(value, EncryptedPrefix)
+	if !ok {
+		if strings.HasPrefix(value, "mdenc:") {
+			return "", fmt.Errorf("value of %s uses an unsupported encryption version", key)
+		}
+		return "", fmt.Errorf("value of %s is not encrypted", key)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", fmt.Errorf("value of %s is not a valid encrypted value", key)
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plain, err := aead.Open(nil, nonce, ciphertext, []byte(key))
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt %s: wrong key or tampered value", key)
+	}
+	return string(plain), nil
+}
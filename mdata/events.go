@@ -0,0 +1,54 @@
+package mdata
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// Change event types
+const (
+	EventAdded   = "added"
+	EventChanged = "changed"
+	EventRemoved = "removed"
+)
+
+// ChangeEvent reports a change to a key, or to a file kept in sync with
+// metadata. Events are written as JSON lines, ready for jq or a log shipper.
+type ChangeEvent struct {
+	Time time.Time `json:"time"`
+	Type string    `json:"type"`
+	Key  string    `json:"key,omitempty"`
+	File string    `json:"file,omitempty"`
+	// OldSHA256 and NewSHA256 are hex SHA-256 hashes of the content before
+	// and after the change; OldSHA256 is empty when it was added and
+	// NewSHA256 when it was removed
+	OldSHA256 string `json:"old_sha256,omitempty"`
+	NewSHA256 string `json:"new_sha256,omitempty"`
+	// Value is the new value, when requested
+	Value *string `json:"value,omitempty"`
+}
+
+// HashValue returns the hex SHA-256 of value, as used in events
+func HashValue(value []byte) string {
+	sum := sha256.Sum256(value)
+	return hex.EncodeToString(sum[:])
+}
+
+// EventWriter returns a function writing each event to w as one JSON line.
+// It is safe for concurrent use; write errors are dropped.
+func EventWriter(w io.Writer) func(ChangeEvent) {
+	var mu sync.Mutex
+	return func(ev ChangeEvent) {
+		line, err := json.Marshal(ev)
+		if err != nil {
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		w.Write(append(line, '\n'))
+	}
+}
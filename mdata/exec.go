@@ -0,0 +1,118 @@
+package mdata
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// execCloseGrace is how long Close waits for the command to exit after its
+// standard input is closed before killing it, and how long a read reaching
+// the end of its output waits for its exit status
+const execCloseGrace = 2 * time.Second
+
+// ExecConfig holds configuration for exec connections
+type ExecConfig struct {
+	Command string        // Program started for each connection (e.g., "ssh")
+	Args    []string      // Its arguments
+	Timeout time.Duration // Read timeout (e.g., 30s), including the start of the command
+}
+
+// ExecClientConfig returns a ClientConfig for a metadata server speaking the
+// protocol on the standard input and output of a command, such as
+// "mdata proxy --stdio" run on another host through ssh:
+//
+//	config := mdata.ExecClientConfig("ssh", "-T", "admin@web0", "mdata", "proxy", "--stdio")
+//
+// The command is started for every connection, and its standard error is
+// that of the process, so that it can report why it failed.
+func ExecClientConfig(command string, args ...string) ClientConfig {
+	return ClientConfig{
+		Transport: transportExec,
+		ExecConfig: &ExecConfig{
+			Command: command,
+			Args:    args,
+			Timeout: 30 * time.Second,
+		},
+	}
+}
+
+// execConn is a connection over the standard input and output of a command
+type execConn struct {
+	*fileConnWrapper // standard output of the command
+	stdin            *os.File
+	cmd              *exec.Cmd
+
+	exited  chan struct{} // closed once the command exited
+	waitErr error         // why the command exited, once exited is closed
+}
+
+// startExec starts the command of config and returns the connection to it
+func startExec(config *ExecConfig) (*execConn, error) {
+	stdinR, stdinW, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	stdoutR, stdoutW, err := os.Pipe()
+	if err != nil {
+		stdinR.Close()
+		stdinW.Close()
+		return nil, err
+	}
+	cmd := exec.Command(config.Command, config.Args...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = stdinR, stdoutW, os.Stderr
+	err = cmd.Start()
+	// The command holds its own copies of its ends
+	stdinR.Close()
+	stdoutW.Close()
+	if err != nil {
+		stdinW.Close()
+		stdoutR.Close()
+		return nil, fmt.Errorf("failed to start %s: %w", config.Command, err)
+	}
+	conn := &execConn{fileConnWrapper: &fileConnWrapper{File: stdoutR}, stdin: stdinW, cmd: cmd, exited: make(chan struct{})}
+	conn.SetReadTimeout(config.Timeout)
+	go func() {
+		conn.waitErr = cmd.Wait()
+		close(conn.exited)
+	}()
+	return conn, nil
+}
+
+// Read implements Conn.Read, adding to the end of the output of the command
+// why it exited, such as ssh failing to connect
+func (c *execConn) Read(b []byte) (int, error) {
+	n, err := c.fileConnWrapper.Read(b)
+	if errors.Is(err, io.EOF) {
+		select {
+		case <-c.exited:
+			if c.waitErr != nil {
+				err = fmt.Errorf("%w (%s: %v)", err, c.cmd.Args[0], c.waitErr)
+			}
+		case <-time.After(execCloseGrace):
+		}
+	}
+	return n, err
+}
+
+// Write implements Conn.Write, writing to the standard input of the command
+func (c *execConn) Write(b []byte) (int, error) {
+	return c.stdin.Write(b)
+}
+
+// Close implements Conn.Close, closing the standard input of the command and
+// waiting for it to exit, or killing it if it does not in time
+func (c *execConn) Close() error {
+	err := c.stdin.Close()
+	c.File.Close()
+	select {
+	case <-c.exited:
+	case <-time.After(execCloseGrace):
+		c.cmd.Process.Kill()
+		<-c.exited
+	}
+	return err
+}
@@ -0,0 +1,129 @@
+package mdata
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/Smithx10/go-smartos-mdata/mdata/protocol"
+)
+
+// Extension describes a request code added to the protocol, for servers that
+// implement more than the client models, and how its responses are handled
+type Extension[T any] struct {
+	// Code is the request code, such as "PING"
+	Code string
+	// Idempotent marks requests that only read, which are sent again on a
+	// new connection when the server closed the old one, as GET requests are
+	Idempotent bool
+	// Validate, if set, checks the payload of every request before it is sent
+	Validate func(payload []byte) error
+	// Decode turns the code and payload of a response into the result of the
+	// request, or into an error for a response reporting a failure. It is
+	// called for every response, so it decides which codes succeed;
+	// DecodeSuccess and DecodeJSON cover the usual cases.
+	Decode func(code ResponseCode, payload []byte) (T, error)
+}
+
+// ExtensionClient sends the requests of an extension registered on a client
+type ExtensionClient[T any] struct {
+	client MetadataClient
+	ext    Extension[T]
+}
+
+// wrapper is implemented by clients wrapping another, such as those of
+// NewRateLimitedClient, so extensions are registered on the client beneath
+type wrapper interface {
+	unwrap() MetadataClient
+}
+
+// RegisterExtension registers the request code of ext on client and returns
+// an ExtensionClient sending its requests through client, so that wrappers
+// such as rate limits and circuit breakers apply to them:
+//
+//	type Status struct{ Uptime int64 }
+//	status, err := mdata.RegisterExtension(client, mdata.Extension[Status]{
+//		Code:       "STATUS",
+//		Idempotent: true,
+//		Decode:     mdata.DecodeJSON[Status],
+//	})
+//	...
+//	s, err := status.Send(nil)
+//
+// A code can be registered once per client, and the codes of the protocol
+// and of the extensions the client implements itself cannot be registered.
+func RegisterExtension[T any](client MetadataClient, ext Extension[T]) (*ExtensionClient[T], error) {
+	if ext.Code == "" || strings.ContainsFunc(ext.Code, isSpaceOrControl) {
+		return nil, fmt.Errorf("invalid request code %q", ext.Code)
+	}
+	if ext.Decode == nil {
+		return nil, fmt.Errorf("extension %s has no Decode function", ext.Code)
+	}
+	switch ext.Code {
+	case protocol.CodeGet, protocol.CodeKeys, protocol.CodePut, protocol.CodeDelete, protocol.CodeGetIfChanged, protocol.CodeWatch:
+		return nil, fmt.Errorf("request code %s is built in", ext.Code)
+	}
+
+	impl, ok := baseClient(client)
+	if !ok {
+		return nil, fmt.Errorf("client %T does not support extensions", client)
+	}
+	if _, ok := impl.extensions[ext.Code]; ok {
+		return nil, fmt.Errorf("request code %s is already registered", ext.Code)
+	}
+	if impl.extensions == nil {
+		impl.extensions = make(map[string]bool)
+	}
+	impl.extensions[ext.Code] = ext.Idempotent
+	return &ExtensionClient[T]{client: client, ext: ext}, nil
+}
+
+// Code returns the request code of the extension
+func (e *ExtensionClient[T]) Code() string {
+	return e.ext.Code
+}
+
+// Send sends a request of the extension with payload and returns the decoded
+// response
+func (e *ExtensionClient[T]) Send(payload []byte) (T, error) {
+	var zero T
+	if e.ext.Validate != nil {
+		if err := e.ext.Validate(payload); err != nil {
+			return zero, fmt.Errorf("invalid %s request: %w", e.ext.Code, err)
+		}
+	}
+	code, value, err := sendRaw(e.client, e.ext.Code, payload)
+	if err != nil {
+		return zero, err
+	}
+	return e.ext.Decode(code, value)
+}
+
+// DecodeSuccess is an Extension.Decode function returning the payload of a
+// SUCCESS response, ErrNotFound for NOTFOUND, ErrFailure for FAILURE, and an
+// error naming the code of any other response
+func DecodeSuccess(code ResponseCode, payload []byte) ([]byte, error) {
+	switch code {
+	case protocol.CodeSuccess:
+		return payload, nil
+	case protocol.CodeNotFound:
+		return nil, ErrNotFound
+	case protocol.CodeFailure:
+		return nil, ErrFailure
+	}
+	return nil, fmt.Errorf("request failed with code: %s", code)
+}
+
+// DecodeJSON is an Extension.Decode function decoding the JSON payload of a
+// SUCCESS response into a T, failing as DecodeSuccess otherwise
+func DecodeJSON[T any](code ResponseCode, payload []byte) (T, error) {
+	var v T
+	payload, err := DecodeSuccess(code, payload)
+	if err != nil {
+		return v, err
+	}
+	if err := json.Unmarshal(payload, &v); err != nil {
+		return v, fmt.Errorf("invalid JSON response: %w", err)
+	}
+	return v, nil
+}
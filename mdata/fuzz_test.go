@@ -0,0 +1,85 @@
+package mdata_test
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/Smithx10/go-smartos-mdata/mdata/protocol"
+	"github.com/Smithx10/go-smartos-mdata/mdatatest"
+)
+
+// FuzzParseFrame checks that protocol.ParseFrame never panics and that every
+// frame it accepts is in canonical form, encoding back to the same bytes.
+func FuzzParseFrame(f *testing.F) {
+	for _, seed := range mdatatest.FrameSeeds {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, data string) {
+		fr, err := protocol.ParseFrame(data)
+		if err != nil {
+			return
+		}
+		if got, want := fr.Encode(), strings.TrimSuffix(data, "\n")+"\n"; got != want {
+			t.Fatalf("accepted frame does not round-trip:\n got %q\nwant %q", got, want)
+		}
+	})
+}
+
+// FuzzDecoder checks that protocol.Decoder, which validates frames as they
+// stream in, accepts exactly the lines protocol.ParseFrame accepts and
+// decodes them identically, and that it stays in sync with the stream after
+// rejecting a frame.
+func FuzzDecoder(f *testing.F) {
+	for _, seed := range mdatatest.FrameSeeds {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, data string) {
+		line, _, _ := strings.Cut(data, "\n")
+		line += "\n"
+		next := protocol.NewFrame("dc4fae17", "SUCCESS", []byte("next")).Encode()
+		// A small buffer exercises frames split across reads
+		fr := protocol.NewDecoder(bufio.NewReaderSize(strings.NewReader(line+next), 16))
+
+		want, wantErr := protocol.ParseFrame(line)
+		got, err := fr.Decode()
+		if (err == nil) != (wantErr == nil) {
+			t.Fatalf("Decoder error %v, ParseFrame error %v", err, wantErr)
+		}
+		if err == nil && (got.RequestID != want.RequestID || got.Code != want.Code ||
+			!bytes.Equal(got.Payload, want.Payload) || got.BodyChecksum != want.BodyChecksum) {
+			t.Fatalf("Decoder read %+v, ParseFrame %+v", got, want)
+		}
+		if got, err := fr.Decode(); err != nil || string(got.Payload) != "next" {
+			t.Fatalf("frame after %q: %v", line, err)
+		}
+	})
+}
+
+// FuzzNegotiate checks the client's negotiation reader against arbitrary
+// server replies: it must not panic and must only report V2 support for
+// exactly protocol.NegotiationResp.
+func FuzzNegotiate(f *testing.F) {
+	f.Add(protocol.NegotiationResp)
+	f.Add(protocol.AuthRequired)
+	f.Add("V2_OK")
+	f.Add("V2_OK\nextra")
+	f.Add("invalid command\n")
+	f.Add(strings.Repeat("V", 4096) + "\n")
+	f.Add("")
+	f.Fuzz(func(t *testing.T, reply string) {
+		var sent bytes.Buffer
+		rw := bufio.NewReadWriter(bufio.NewReader(strings.NewReader(reply)), bufio.NewWriter(&sent))
+		supported, err := protocol.Negotiate(rw)
+		if supported && !strings.HasPrefix(reply, protocol.NegotiationResp) {
+			t.Fatalf("reply %q accepted as V2 support", reply)
+		}
+		if supported && err != nil {
+			t.Fatalf("supported with error: %v", err)
+		}
+		if sent.String() != protocol.NegotiationReq {
+			t.Fatalf("client sent %q", sent.String())
+		}
+	})
+}
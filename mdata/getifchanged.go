@@ -0,0 +1,89 @@
+package mdata
+
+import (
+	"errors"
+
+	"github.com/Smithx10/go-smartos-mdata/mdata/protocol"
+)
+
+// GetIfChangedCode is the request code of the conditional GET protocol
+// extension. The request payload is
+//
+//	CHECKSUM SP KEY
+//
+// where CHECKSUM is the ValueChecksum of the value the client holds. The
+// server answers NOTMODIFIED without payload when the value still has that
+// checksum, and otherwise like GET. Servers without the extension answer with
+// a failure, after which clients fall back to GET.
+const GetIfChangedCode = protocol.CodeGetIfChanged
+
+// ErrNotModified is returned by GetIfChanged when the value has not changed
+var ErrNotModified = errors.New("value not modified")
+
+// ConditionalGetter is implemented by clients that can ask the server for a
+// value only when it has changed, such as MetadataClientImpl
+type ConditionalGetter interface {
+	GetIfChanged(key, lastChecksum string) (string, string, error)
+}
+
+// GetIfChanged returns the value of key and its checksum unless the value
+// still has lastChecksum, failing with ErrNotModified, as the GetIfChanged
+// method of c. Clients without one are asked with GET and the checksum is
+// compared here.
+func GetIfChanged(c MetadataClient, key, lastChecksum string) (string, string, error) {
+	if g, ok := c.(ConditionalGetter); ok {
+		return g.GetIfChanged(key, lastChecksum)
+	}
+	return getAndCompare(c, key, lastChecksum)
+}
+
+// ValueChecksum returns the checksum of value used by GetIfChanged: its
+// CRC32 as 8 lowercase hex digits, like a frame checksum
+func ValueChecksum(value string) string {
+	var b [8]byte
+	return string(protocol.AppendHex32(b[:0], protocol.Checksum([]byte(value))))
+}
+
+// GetIfChanged returns the value of key and its checksum unless the value
+// still has lastChecksum, as returned by an earlier call, in which case it
+// fails with ErrNotModified. An empty lastChecksum always fetches the value.
+//
+// Against servers implementing the extension an unchanged value is not
+// sent, which saves bandwidth when polling large values. Other servers are
+// asked with GET and the checksum is compared by the client; once a server has
+// refused the extension, it is not asked again.
+func (c *MetadataClientImpl) GetIfChanged(key, lastChecksum string) (string, string, error) {
+	if key == "" {
+		return "", "", ErrEmptyKey
+	}
+	if lastChecksum != "" && !c.getIfChangedUnsupported {
+		c.reqBuf = append(c.reqBuf[:0], lastChecksum...)
+		c.reqBuf = append(c.reqBuf, ' ')
+		c.reqBuf = append(c.reqBuf, key...)
+		value, err := c.roundTrip(GetIfChangedCode, key, c.reqBuf)
+		var transportErr *TransportError
+		switch {
+		case err == nil:
+			return string(value), ValueChecksum(string(value)), nil
+		case errors.Is(err, ErrNotModified):
+			return "", lastChecksum, err
+		case errors.Is(err, ErrNotFound), errors.As(err, &transportErr):
+			return "", "", err
+		}
+		c.getIfChangedUnsupported = true
+	}
+	return getAndCompare(c, key, lastChecksum)
+}
+
+// getAndCompare implements GetIfChanged with a GET request
+func getAndCompare(c MetadataClient, key, lastChecksum string) (string, string, error) {
+	value, err := c.Get(key)
+	if err != nil {
+		return "", "", err
+	}
+	checksum := ValueChecksum(value)
+	if checksum == lastChecksum {
+		return "", checksum, ErrNotModified
+	}
+	return value, checksum, nil
+}
@@ -0,0 +1,130 @@
+package mdata
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Smithx10/go-smartos-mdata/internal/fsutil"
+)
+
+// DefaultHistoryDir is where the mdata command keeps history unless
+// MDATA_HISTORY_DIR names another directory
+const DefaultHistoryDir = "/var/lib/mdata/history"
+
+// DefaultHistoryLimit is the number of entries kept per key when
+// History.Limit is zero
+const DefaultHistoryLimit = 50
+
+// HistoryEntry records the value a key held before a mutation
+type HistoryEntry struct {
+	Time time.Time `json:"time"`
+	// Op is the request code of the mutation, PUT or DELETE
+	Op  string `json:"op"`
+	Key string `json:"key"`
+	// Existed is false when the key did not exist before the mutation
+	Existed  bool   `json:"existed"`
+	Previous []byte `json:"previous,omitempty"`
+}
+
+// History keeps the previous value of every key changed through a client,
+// in a directory holding one file of JSON lines per key. As the values of
+// secret keys are kept too, the directory is created readable by its owner
+// only.
+type History struct {
+	Dir   string
+	Limit int // entries kept per key, oldest dropped first; DefaultHistoryLimit when zero
+}
+
+// Middleware returns middleware recording the value each successful PUT or
+// DELETE replaces, read with a GET just before the mutation. Entries that
+// cannot be read or written are dropped rather than failing the mutation.
+func (h *History) Middleware() Middleware {
+	return func(next RoundTripper) RoundTripper {
+		return RoundTripperFunc(func(req *Request) ([]byte, error) {
+			if req.Code != "PUT" && req.Code != "DELETE" {
+				return next.RoundTrip(req)
+			}
+			entry := HistoryEntry{Op: req.Code, Key: req.Key}
+			prev, err := next.RoundTrip(&Request{Code: "GET", Key: req.Key, Payload: []byte(req.Key)})
+			record := err == nil || errors.Is(err, ErrNotFound)
+			if err == nil {
+				entry.Existed = true
+				entry.Previous = bytes.Clone(prev)
+			}
+
+			reply, err := next.RoundTrip(req)
+			if err == nil && record {
+				entry.Time = time.Now().UTC()
+				h.record(entry)
+			}
+			return reply, err
+		})
+	}
+}
+
+// Entries returns the recorded history of key, oldest first. A key without
+// history has no entries.
+func (h *History) Entries(key string) ([]HistoryEntry, error) {
+	data, err := os.ReadFile(h.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history of %s: %w", key, err)
+	}
+	var entries []HistoryEntry
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		var entry HistoryEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return nil, fmt.Errorf("invalid history of %s: %w", key, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// record appends entry to the history of its key, dropping the oldest
+// entries beyond the limit
+func (h *History) record(entry HistoryEntry) {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(h.Dir, 0o700); err != nil {
+		return
+	}
+	path := h.path(entry.Key)
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	var lines [][]byte
+	for _, l := range bytes.SplitAfter(data, []byte("\n")) {
+		if len(l) > 0 {
+			lines = append(lines, l)
+		}
+	}
+	lines = append(lines, append(line, '\n'))
+	limit := h.Limit
+	if limit <= 0 {
+		limit = DefaultHistoryLimit
+	}
+	if len(lines) > limit {
+		lines = lines[len(lines)-limit:]
+	}
+	fsutil.WriteFileAtomic(path, bytes.Join(lines, nil), 0o600)
+}
+
+// path returns the file holding the history of key, named by its hash as keys
+// may be long and contain any character
+func (h *History) path(key string) string {
+	return filepath.Join(h.Dir, HashValue([]byte(key))+".jsonl")
+}
@@ -0,0 +1,25 @@
+package mdata
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/Smithx10/go-smartos-mdata/internal/jsonpath"
+)
+
+// GetJSONPath returns the field of the JSON value of key in c selected by path, as
+// decoded by encoding/json. Paths take the form of JSONPath or jq, such as
+// .[0].ips[0] or $.name: member access (.name or ['name']), array indexes
+// ([0], [-1] counting from the end) and wildcards (.* or [*]), which select a
+// []any of every match.
+func GetJSONPath(c MetadataClient, key, path string) (any, error) {
+	raw, err := c.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	var v any
+	if err := json.Unmarshal([]byte(raw), &v); err != nil {
+		return nil, fmt.Errorf("value of %s is not valid JSON: %w", key, err)
+	}
+	return jsonpath.Eval(path, v)
+}
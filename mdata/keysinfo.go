@@ -0,0 +1,80 @@
+package mdata
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// previewLength is the most characters of a value KeyInfo.Preview shows
+const previewLength = 40
+
+// KeyInfo describes a listed key
+type KeyInfo struct {
+	Key string
+	// Size is the length of the value in bytes
+	Size int
+	// JSON reports whether the value is a JSON object or array, as documents
+	// such as configuration are; bare strings and numbers are not counted
+	JSON bool
+	// Preview is the start of the value on one line, white space collapsed,
+	// non-printable characters shown as ? and an ellipsis marking truncation
+	Preview string
+}
+
+// KeysInfo lists the keys of c with the size, kind and start of their
+// values. The protocol has no way to ask for these alone, so each value is
+// fetched. Keys deleted between listing and fetching are
+// skipped.
+func KeysInfo(c MetadataClient) ([]KeyInfo, error) {
+	raw, err := c.Keys()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list keys: %w", err)
+	}
+	var infos []KeyInfo
+	for _, key := range strings.Split(raw, "\n") {
+		if key == "" {
+			continue
+		}
+		value, err := c.Get(key)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get %s: %w", key, err)
+		}
+		infos = append(infos, KeyInfo{Key: key, Size: len(value), JSON: isJSONDocument(value), Preview: preview(value)})
+	}
+	return infos, nil
+}
+
+// isJSONDocument reports whether value is a JSON object or array
+func isJSONDocument(value string) bool {
+	trimmed := strings.TrimSpace(value)
+	return trimmed != "" && (trimmed[0] == '{' || trimmed[0] == '[') && json.Valid([]byte(trimmed))
+}
+
+// preview returns the start of value for KeyInfo.Preview
+func preview(value string) string {
+	// Only the start matters, however long the value
+	truncated := len(value) > 4*previewLength
+	if truncated {
+		value = value[:4*previewLength]
+	}
+	value = strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) || unicode.IsPrint(r) {
+			return r
+		}
+		return '?'
+	}, strings.ToValidUTF8(value, "?"))
+	runes := []rune(strings.Join(strings.Fields(value), " "))
+	if len(runes) > previewLength {
+		return string(runes[:previewLength-1]) + "…"
+	}
+	if truncated {
+		return string(runes) + "…"
+	}
+	return string(runes)
+}
@@ -0,0 +1,172 @@
+package mdata
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Smithx10/go-smartos-mdata/internal/fsutil"
+)
+
+// DefaultLastKnownDir is where the mdata command keeps the last-known values
+// unless MDATA_LAST_KNOWN_DIR names another directory
+const DefaultLastKnownDir = "/var/lib/mdata/last-known"
+
+// lastKnownValue is the file of a key in a LastKnown directory
+type lastKnownValue struct {
+	Key   string    `json:"key"`
+	Time  time.Time `json:"time"` // when the value was read from the service
+	Value []byte    `json:"value"`
+}
+
+// StaleEvent reports a request answered from a LastKnown directory because
+// the metadata service could not be reached
+type StaleEvent struct {
+	Code string // GET or KEYS
+	Key  string // the key of a GET
+	// Saved is when the value answered was read from the service, or for
+	// KEYS when the newest of the values was
+	Saved time.Time
+	Err   error // why the service did not answer
+}
+
+// LastKnown keeps the last value read of every key through a client, in a
+// directory holding one file per key, for best-effort clients to answer from
+// when the metadata service is down (see ClientConfig.BestEffort). The
+// directory is created readable by its owner only.
+type LastKnown struct {
+	Dir string
+	// Redact covers the keys whose values are not kept, such as secrets
+	// that should not outlive the metadata service on disk. Best-effort
+	// clients cannot answer for them while the service is down.
+	Redact Redactor
+	// Stale is called for every request a best-effort client answered from
+	// Dir (optional)
+	Stale func(StaleEvent)
+}
+
+// Middleware returns middleware keeping the value of every successful GET of
+// a key Redact does not cover.
+// Keys found missing, deleted or changed are forgotten until read again.
+// Values that cannot be written are dropped rather than failing the request.
+func (l *LastKnown) Middleware() Middleware {
+	return func(next RoundTripper) RoundTripper {
+		return RoundTripperFunc(func(req *Request) ([]byte, error) {
+			reply, err := next.RoundTrip(req)
+			l.observe(req, reply, err)
+			return reply, err
+		})
+	}
+}
+
+// bestEffort returns middleware keeping values as Middleware does, and
+// answering GET and KEYS requests that fail on the connection from Dir
+func (l *LastKnown) bestEffort() Middleware {
+	return func(next RoundTripper) RoundTripper {
+		return RoundTripperFunc(func(req *Request) ([]byte, error) {
+			reply, err := next.RoundTrip(req)
+			l.observe(req, reply, err)
+			var transportErr *TransportError
+			if err == nil || !errors.As(err, &transportErr) {
+				return reply, err
+			}
+			ev := StaleEvent{Code: req.Code, Key: req.Key, Err: err}
+			switch req.Code {
+			case "GET":
+				saved, ok := l.load(req.Key)
+				if !ok {
+					return nil, err
+				}
+				reply, ev.Saved = saved.Value, saved.Time
+			case "KEYS":
+				saved := l.loadAll()
+				if len(saved) == 0 {
+					return nil, err
+				}
+				keys := make([]string, len(saved))
+				for i, v := range saved {
+					keys[i] = v.Key
+					if v.Time.After(ev.Saved) {
+						ev.Saved = v.Time
+					}
+				}
+				reply = []byte(strings.Join(keys, "\n"))
+			default:
+				return nil, err
+			}
+			if l.Stale != nil {
+				l.Stale(ev)
+			}
+			return reply, nil
+		})
+	}
+}
+
+// observe keeps or forgets the value of the key of req after its reply. A
+// secret read successfully forgets any value kept before Redact covered it.
+func (l *LastKnown) observe(req *Request, reply []byte, err error) {
+	switch {
+	case req.Code == "GET" && err == nil && !l.Redact.IsSecret(req.Key):
+		l.save(lastKnownValue{Key: req.Key, Time: time.Now().UTC(), Value: reply})
+	case req.Code == "GET" && (err == nil || errors.Is(err, ErrNotFound)),
+		(req.Code == "PUT" || req.Code == "DELETE") && err == nil:
+		os.Remove(l.path(req.Key))
+	}
+}
+
+// save writes v to the file of its key
+func (l *LastKnown) save(v lastKnownValue) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(l.Dir, 0o700); err != nil {
+		return
+	}
+	fsutil.WriteFileAtomic(l.path(v.Key), data, 0o600)
+}
+
+// load reads the last-known value of key
+func (l *LastKnown) load(key string) (lastKnownValue, bool) {
+	if l.Redact.IsSecret(key) {
+		return lastKnownValue{}, false
+	}
+	data, err := os.ReadFile(l.path(key))
+	if err != nil {
+		return lastKnownValue{}, false
+	}
+	var v lastKnownValue
+	if err := json.Unmarshal(data, &v); err != nil || v.Key != key {
+		return lastKnownValue{}, false
+	}
+	return v, true
+}
+
+// loadAll reads every last-known value of a key Redact does not cover,
+// ordered by key
+func (l *LastKnown) loadAll() []lastKnownValue {
+	paths, _ := filepath.Glob(filepath.Join(l.Dir, "*.json"))
+	var values []lastKnownValue
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var v lastKnownValue
+		if json.Unmarshal(data, &v) == nil && !l.Redact.IsSecret(v.Key) {
+			values = append(values, v)
+		}
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i].Key < values[j].Key })
+	return values
+}
+
+// path returns the file holding the value of key, named by its hash as keys
+// may be long and contain any character
+func (l *LastKnown) path(key string) string {
+	return filepath.Join(l.Dir, HashValue([]byte(key))+".json")
+}
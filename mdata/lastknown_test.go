@@ -0,0 +1,55 @@
+package mdata_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Smithx10/go-smartos-mdata/mdata"
+	"github.com/Smithx10/go-smartos-mdata/mdataserver"
+	"github.com/Smithx10/go-smartos-mdata/mdatatest"
+)
+
+func TestLastKnownLeavesOutSecrets(t *testing.T) {
+	srv, _ := mdatatest.StartServerWithStore(t, mdataserver.NewMemoryStore(map[string]string{
+		"app:name":   "web",
+		"app:secret": "hunter2",
+	}))
+	var stale []string
+	cfg := srv.Config
+	cfg.BestEffort = &mdata.LastKnown{
+		Dir:    t.TempDir(),
+		Redact: mdata.Redactor{Patterns: []string{"*secret*"}},
+		Stale:  func(ev mdata.StaleEvent) { stale = append(stale, ev.Key) },
+	}
+
+	up, err := mdata.NewMetadataClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"app:name", "app:secret"} {
+		if _, err := up.Get(key); err != nil {
+			t.Fatalf("Get %s: %v", key, err)
+		}
+	}
+	up.Close()
+	srv.Close()
+
+	down, err := mdata.NewMetadataClient(cfg)
+	if err != nil {
+		t.Fatalf("best-effort client failed without the service: %v", err)
+	}
+	defer down.Close()
+	if value, err := down.Get("app:name"); err != nil || value != "web" {
+		t.Errorf("Get app:name: %q, %v; want the last-known value", value, err)
+	}
+	var transportErr *mdata.TransportError
+	if value, err := down.Get("app:secret"); !errors.As(err, &transportErr) {
+		t.Errorf("Get app:secret: %q, %v; want the connection error", value, err)
+	}
+	if keys, err := down.Keys(); err != nil || keys != "app:name" {
+		t.Errorf("Keys: %q, %v; want app:name alone", keys, err)
+	}
+	if len(stale) != 2 || stale[0] != "app:name" {
+		t.Errorf("stale events for %q", stale)
+	}
+}
@@ -2,20 +2,95 @@ package mdata
 
 import (
 	"bufio"
-	"crypto/rand"
+	"bytes"
+	"context"
 	"encoding/base64"
-	"encoding/hex"
+	"errors"
 	"fmt"
-	"hash/crc32"
+	"io"
+	"log"
+	mathrand "math/rand/v2"
 	"net"
 	"os"
 	"runtime"
+	"slices"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
-	"github.com/tarm/serial"
+	"github.com/Smithx10/go-smartos-mdata/internal/serialport"
+	"github.com/Smithx10/go-smartos-mdata/mdata/protocol"
 )
 
+// ErrNotFound is returned when the requested key does not exist
+var ErrNotFound = errors.New("request failed with code: NOTFOUND")
+
+// ErrFailure is returned when the server answers FAILURE, as it does for
+// requests it refuses and for request codes it does not know
+var ErrFailure = errors.New("request failed with code: FAILURE")
+
+// ErrEmptyKey is returned for requests naming an empty key, which the
+// protocol cannot carry: an empty payload is omitted from the frame
+var ErrEmptyKey = errors.New("key must not be empty")
+
+// TransportError reports a request that failed on the connection rather than
+// being answered by the server, including replies too corrupt to parse
+type TransportError struct {
+	Err error
+}
+
+func (e *TransportError) Error() string { return e.Err.Error() }
+
+func (e *TransportError) Unwrap() error { return e.Err }
+
+// ErrTimeout is returned, wrapped in a *TransportError, when no complete
+// response arrives within the read timeout. It wraps context.DeadlineExceeded.
+// Errors of connections timing out before they could be used match it too;
+// a *TimeoutError tells which phase timed out.
+var ErrTimeout error = timeoutError{}
+
+// Phases of reaching the metadata service that may time out
+const (
+	PhaseDial      = "dial"
+	PhaseNegotiate = "negotiate"
+	PhaseRequest   = "request"
+)
+
+// TimeoutError is a timeout of one phase of reaching the metadata service:
+// dialing a socket, authenticating and negotiating the protocol on a new
+// connection, or waiting for the response to a request. It matches
+// ErrTimeout.
+type TimeoutError struct {
+	Phase string // PhaseDial, PhaseNegotiate or PhaseRequest
+	Err   error
+}
+
+func (e *TimeoutError) Error() string { return e.Err.Error() }
+
+func (e *TimeoutError) Unwrap() error { return e.Err }
+
+// Is reports whether target is ErrTimeout
+func (e *TimeoutError) Is(target error) bool { return target == ErrTimeout }
+
+// Timeout reports true, as for net.Error
+func (e *TimeoutError) Timeout() bool { return true }
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string { return "timed out waiting for response" }
+
+func (timeoutError) Unwrap() error { return context.DeadlineExceeded }
+
+// Timeout reports true, as for net.Error
+func (timeoutError) Timeout() bool { return true }
+
+// isTimeout reports whether err is a read timing out
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, os.ErrDeadlineExceeded) || errors.As(err, &netErr) && netErr.Timeout()
+}
+
 // transportType defines the connection type for the metadata client
 type transportType string
 
@@ -23,73 +98,265 @@ const (
 	transportSerial transportType = "serial"
 	transportTCP    transportType = "tcp"
 	transportUnix   transportType = "unix"
+	transportPipe   transportType = "pipe"
+	transportExec   transportType = "exec"
+
+	// transportGlobalZone marks the global zone, which has no metadata
+	transportGlobalZone transportType = "global-zone"
 )
 
 // SocketConfig holds configuration for socket connections
 type SocketConfig struct {
-	Network string        // Network type ("tcp" or "unix")
+	Network string        // Network type ("tcp", "unix" or "pipe")
 	Address string        // Address (e.g., "localhost:12345" for TCP, "/var/run/mdata.sock" for Unix)
 	Timeout time.Duration // Dial and read timeout (e.g., 5s)
+
+	// Connections of a Pool (see NewPool)
+	MaxConns            int           // Most connections open at once, DefaultMaxConns when zero
+	IdleTimeout         time.Duration // Idle connections are closed after this long; zero keeps them
+	HealthCheckInterval time.Duration // How often idle connections are checked; zero never
 }
 
+// SerialConfig holds configuration for serial connections
+type SerialConfig struct {
+	Name        string        // Device (e.g., "/dev/ttyS1", "COM2"), or SerialAuto
+	Baud        int           // Baud rate (e.g., 115200)
+	ReadTimeout time.Duration // Read timeout (e.g., 60s); zero waits indefinitely
+	Size        byte          // Data bits, 8 when zero
+	Parity      Parity        // ParityNone when zero
+	StopBits    StopBits      // Stop1 when zero
+	// Candidates are the ports probed, in order, when Name is SerialAuto or
+	// does not answer; the usual ports of the platform when empty
+	Candidates []string
+}
+
+// Parity is the parity bit of each character on a serial line
+type Parity = serialport.Parity
+
+// Parity settings; mark and space parity are not supported on illumos and the BSDs
+const (
+	ParityNone  = serialport.ParityNone
+	ParityOdd   = serialport.ParityOdd
+	ParityEven  = serialport.ParityEven
+	ParityMark  = serialport.ParityMark
+	ParitySpace = serialport.ParitySpace
+)
+
+// StopBits is the number of stop bits after each character on a serial line
+type StopBits = serialport.StopBits
+
+// Stop bit settings; Stop1Half is supported on Windows only
+const (
+	Stop1     = serialport.Stop1
+	Stop1Half = serialport.Stop1Half
+	Stop2     = serialport.Stop2
+)
+
 // ClientConfig holds configuration for the metadata client
 type ClientConfig struct {
-	Transport    transportType  // Connection type (serial, tcp, unix)
-	SerialConfig *serial.Config // Serial configuration (if Transport == TransportSerial)
-	SocketConfig *SocketConfig  // Socket configuration (if Transport == TransportTCP or TransportUnix)
+	Transport    transportType    // Connection type (serial, tcp, unix, pipe, exec)
+	SerialConfig *SerialConfig    // Serial configuration (if Transport == TransportSerial)
+	SocketConfig *SocketConfig    // Socket configuration (if Transport == TransportTCP or TransportUnix)
+	ExecConfig   *ExecConfig      // Command configuration (if Transport == TransportExec, see ExecClientConfig)
+	Secret       []byte           // Shared secret for servers requiring authentication (optional)
+	Audit        func(AuditEvent) // Called after every Put and Delete (optional)
+	Redact       Redactor         // Keys whose values are kept out of audit events
+	Middleware   []Middleware     // Wrapped around every request, the first outermost (see WithMiddleware)
+	Trace        func(TraceEvent) // Called for every protocol event (optional, see TraceWriter)
+	// NoNegotiate skips the negotiation of new connections, saving a round
+	// trip, for servers known to accept V2 requests without it, such as one
+	// that negotiated V2 earlier. Should the server refuse the first request
+	// of a connection, the client negotiates then and sends it again.
+	NoNegotiate bool
+	// Logger receives notices, such as the serial port chosen when the
+	// configured one does not answer (optional)
+	Logger *log.Logger
+	// BestEffort, if set, keeps the values the client reads and, while the
+	// metadata service cannot be reached, answers GET requests from the
+	// values kept and KEYS requests with their keys, reporting each to
+	// BestEffort.Stale, instead of failing. Even the connection failing does
+	// not fail NewMetadataClient then. Boot paths that would rather go on
+	// with stale values than wait should pair it with short timeouts (see
+	// WithMaxTimeout).
+	BestEffort *LastKnown
+
+	// fallback, set by detection, is the transport tried when this one
+	// cannot be connected to
+	fallback *ClientConfig
 }
 
-// DefaultClientConfig returns a ClientConfig with defaults based on the environment
+// DefaultClientConfig returns a ClientConfig with defaults based on the
+// environment. Mutations are audited to the file named by MDATA_AUDIT_LOG, if
+// set, with secrets redacted as by DefaultRedactor, and the values they
+// replace are kept in the History directory named by MDATA_HISTORY_DIR, if set.
 func DefaultClientConfig() ClientConfig {
+	config := detectTransport()
+	config.Redact = DefaultRedactor()
+	if path := os.Getenv("MDATA_AUDIT_LOG"); path != "" {
+		config.Audit = AuditFile(path)
+	}
+	if dir := os.Getenv("MDATA_HISTORY_DIR"); dir != "" {
+		config.Middleware = append(config.Middleware, (&History{Dir: dir}).Middleware())
+	}
+	return config
+}
+
+// WithMaxTimeout returns config with every wait on the metadata service
+// bounded by limit, including unbounded ones: the timeouts of its transport
+// and of the transports detection falls back to when it cannot be connected
+// to, as best-effort clients would rather answer stale values than wait.
+func (config ClientConfig) WithMaxTimeout(limit time.Duration) ClientConfig {
+	bound := func(timeout time.Duration) time.Duration {
+		if timeout == 0 || timeout > limit {
+			return limit
+		}
+		return timeout
+	}
+	if config.SerialConfig != nil {
+		sc := *config.SerialConfig
+		sc.ReadTimeout = bound(sc.ReadTimeout)
+		config.SerialConfig = &sc
+	}
+	if config.SocketConfig != nil {
+		sc := *config.SocketConfig
+		sc.Timeout = bound(sc.Timeout)
+		config.SocketConfig = &sc
+	}
+	if config.ExecConfig != nil {
+		ec := *config.ExecConfig
+		ec.Timeout = bound(ec.Timeout)
+		config.ExecConfig = &ec
+	}
+	if config.fallback != nil {
+		fb := config.fallback.WithMaxTimeout(limit)
+		config.fallback = &fb
+	}
+	return config
+}
+
+// detectTransport chooses the transport for the environment
+func detectTransport() ClientConfig {
 	config := ClientConfig{}
 
-	// Check for SmartOS zone Unix sockets
-	if _, err := os.Stat("/native/.zonecontrol/metadata.sock"); err == nil {
+	// An explicit socket (e.g. one exposed by "mdata proxy") overrides detection.
+	// A tcp:// prefix selects a TCP server instead of a Unix socket.
+	if sock := os.Getenv("MDATA_SOCKET"); sock != "" {
 		config.Transport = transportUnix
 		config.SocketConfig = &SocketConfig{
 			Network: "unix",
-			Address: "/native/.zonecontrol/metadata.sock", // LX-branded zone
+			Address: sock,
 			Timeout: 5 * time.Second,
 		}
-		// fmt.Printf("Detected LX-branded zone socket: %s\n", config.SocketConfig.Address)
+		if addr, ok := strings.CutPrefix(sock, "tcp://"); ok {
+			config.Transport = transportTCP
+			config.SocketConfig.Network = "tcp"
+			config.SocketConfig.Address = addr
+		}
+		// A Windows named pipe, as Hyper-V exposes a guest's COM port
+		if strings.HasPrefix(sock, `\\.\pipe\`) {
+			config.Transport = transportPipe
+			config.SocketConfig.Network = "pipe"
+		}
+		if path := os.Getenv("MDATA_SECRET_FILE"); path != "" {
+			// An unreadable file leaves Secret empty, which servers requiring authentication reject
+			secret, _ := os.ReadFile(path)
+			config.Secret = []byte(strings.TrimSpace(string(secret)))
+		}
 		return config
 	}
-	if _, err := os.Stat("/.zonecontrol/metadata.sock"); err == nil {
-		config.Transport = transportUnix
-		config.SocketConfig = &SocketConfig{
-			Network: "unix",
-			Address: "/.zonecontrol/metadata.sock", // Native SmartOS zone
-			Timeout: 5 * time.Second,
+
+	// In a zone, its brand decides where the metadata socket is
+	var sockets []string
+	zone, inZone := DetectZone()
+	if inZone {
+		switch {
+		case zone.Global && smartOSHost():
+			config.Transport = transportGlobalZone
+			return config
+		case zone.Global:
+			// An illumos guest of a virtual machine, using the serial port
+			inZone = false
+		default:
+			sockets = append(sockets, zone.MetadataSocket())
 		}
-		// fmt.Printf("Detected native SmartOS zone socket: %s\n", config.SocketConfig.Address)
-		return config
 	}
 
-	// Fallback to serial for VM guests (e.g., KVM)
-	config.Transport = transportSerial
-	config.SerialConfig = &serial.Config{
+	// Without the zone tools, look for the SmartOS zone Unix sockets. A
+	// socket that exists but cannot be connected to, such as one under a
+	// /native mount a nested container inherited, falls through to the next.
+	for _, path := range []string{
+		"/native/.zonecontrol/metadata.sock", // LX-branded zone
+		"/.zonecontrol/metadata.sock",        // Native SmartOS zone
+	} {
+		if _, err := os.Stat(path); err == nil && !slices.Contains(sockets, path) {
+			sockets = append(sockets, path)
+		}
+	}
+
+	// Fallback to serial for VM guests (e.g., KVM); zones have no serial
+	// channel, so their error names the sockets tried
+	var next *ClientConfig
+	if !inZone {
+		serial := SerialClientConfig("")
+		next = &serial
+	}
+	for i := len(sockets) - 1; i >= 0; i-- {
+		socket := UnixClientConfig(sockets[i])
+		socket.fallback = next
+		next = &socket
+	}
+	return *next
+}
+
+// SerialClientConfig returns a ClientConfig for the serial port name at the
+// default line settings, 115200 baud 8N1. An empty name selects the
+// virtio-serial channel named VirtioChannel if the guest has one, or else the
+// port SmartOS usually attaches the metadata channel to on the guest OS.
+func SerialClientConfig(name string) ClientConfig {
+	config := ClientConfig{Transport: transportSerial}
+	config.SerialConfig = &SerialConfig{
+		Name:        name,
 		Baud:        115200,
 		ReadTimeout: 60 * time.Second,
 		Size:        8,
-		Parity:      serial.ParityNone,
-		StopBits:    serial.Stop1,
+		Parity:      ParityNone,
+		StopBits:    Stop1,
+	}
+	if name != "" {
+		return config
+	}
+	// Newer bhyve and KVM guests may have no UART for it
+	if channel, ok := virtioChannel(VirtioChannel); ok {
+		config.SerialConfig.Name = channel
+		return config
 	}
 	// Set default port based on guest OS
 	switch runtime.GOOS {
 	case "linux":
 		config.SerialConfig.Name = "/dev/ttyS1" // Common for SmartOS metadata
 	case "windows":
-		config.SerialConfig.Name = "COM1" // Typical for Windows
-	case "solaris":
+		// Usually COM1, but hypervisors differ; probe the ports Windows lists
+		config.SerialConfig.Name = SerialAuto
+	case "illumos", "solaris":
 		config.SerialConfig.Name = "/dev/ttyb" // Common for SmartOS/Solaris
-	default:
-		fmt.Printf("Warning: unsupported OS %s, Port field left empty\n", runtime.GOOS)
 	}
-
-	// fmt.Printf("Detected VM guest, using serial port: %s\n", config.SerialConfig.Name)
+	// Elsewhere the name is left empty, which NewMetadataClient reports
 	return config
 }
 
+// UnixClientConfig returns a ClientConfig for a metadata server listening on
+// the unix socket at path, such as "mdata proxy" or "mdata daemon"
+func UnixClientConfig(path string) ClientConfig {
+	return ClientConfig{
+		Transport: transportUnix,
+		SocketConfig: &SocketConfig{
+			Network: "unix",
+			Address: path,
+			Timeout: 5 * time.Second,
+		},
+	}
+}
+
 // Conn abstracts the connection interface for serial and socket
 type Conn interface {
 	Write([]byte) (int, error)
@@ -101,22 +368,68 @@ type Conn interface {
 // netConnWrapper wraps net.Conn to implement SetReadTimeout
 type netConnWrapper struct {
 	net.Conn
+	timeout time.Duration
 }
 
-// SetReadTimeout implements Conn.SetReadTimeout using SetReadDeadline
+// Read implements Conn.Read, applying the read timeout to each call like a serial port does
+func (w *netConnWrapper) Read(b []byte) (int, error) {
+	if w.timeout > 0 {
+		if err := w.SetReadDeadline(time.Now().Add(w.timeout)); err != nil {
+			return 0, err
+		}
+	}
+	return w.Conn.Read(b)
+}
+
+// SetReadTimeout implements Conn.SetReadTimeout; a zero timeout disables it
 func (w *netConnWrapper) SetReadTimeout(timeout time.Duration) error {
+	w.timeout = timeout
 	if timeout == 0 {
 		return w.SetReadDeadline(time.Time{})
 	}
-	return w.SetReadDeadline(time.Now().Add(timeout))
+	return nil
 }
 
 // MetadataClientImpl implements MetadataClient for serial or socket communication
 type MetadataClientImpl struct {
 	conn Conn
 	rw   *bufio.ReadWriter
+	enc  *protocol.Encoder
+	dec  *protocol.Decoder
+
+	// writeMu is held while a request is written and while the connection
+	// is replaced or closed, so Close never cuts a frame short
+	writeMu sync.Mutex
+
+	// Buffers reused across requests
+	reqBuf    []byte // request payload before encoding
+	requestID [8]byte
+
+	auditSink func(AuditEvent)
+	traceSink func(TraceEvent)
+	redact    Redactor
+
+	transport RoundTripper // the middleware chain, nil without middleware
+
+	connected *ClientConfig // the transport connected to, nil for clients made from a connection
+
+	redial      func() (Conn, error) // reconnects after the server closed the connection, nil if impossible
+	secret      []byte               // authenticates redialed connections
+	noNegotiate bool                 // redialed connections are not negotiated either
+
+	readTimeout             time.Duration // configured read timeout, restored after Watch
+	stale                   bool          // a request timed out, so a late response may precede the next
+	unnegotiated            bool          // NoNegotiate skipped negotiation and no response has shown it unneeded
+	watchUnsupported        bool          // the server refused WATCH
+	getIfChangedUnsupported bool          // the server refused GETIFCHANGED
+
+	extensions map[string]bool // codes registered by RegisterExtension, true if idempotent
 }
 
+// MetadataClient sends the requests of the protocol. Features not every
+// client has, such as Watch, are optional interfaces (see Watcher,
+// ConditionalGetter and RawSender), and those built from these requests, such
+// as UserData and All, are functions taking a MetadataClient.
 type MetadataClient interface {
 	Get(payload string) (string, error)
 	Keys() (string, error)
@@ -125,21 +438,79 @@ type MetadataClient interface {
 	Close() error
 }
 
+// fileConnWrapper wraps an opened named pipe to implement Conn. Read timeouts
+// apply only where the platform supports deadlines on the file.
+type fileConnWrapper struct {
+	*os.File
+	timeout time.Duration
+}
+
+// Read implements Conn.Read, applying the read timeout to each call
+func (w *fileConnWrapper) Read(b []byte) (int, error) {
+	if w.timeout > 0 {
+		if err := w.SetReadDeadline(time.Now().Add(w.timeout)); err != nil && !errors.Is(err, os.ErrNoDeadline) {
+			return 0, err
+		}
+	}
+	return w.File.Read(b)
+}
+
+// SetReadTimeout implements Conn.SetReadTimeout; a zero timeout disables it
+func (w *fileConnWrapper) SetReadTimeout(timeout time.Duration) error {
+	w.timeout = timeout
+	return nil
+}
+
 type serialConnWrapper struct {
-	*serial.Port
+	*serialport.Port
+	timeout time.Duration
 }
 
-// SetReadTimeout sets the read timeout for the serial port
+// openSerialPort opens the port config names with its line settings
+func openSerialPort(config *SerialConfig) (*serialConnWrapper, error) {
+	port, err := serialport.Open(&serialport.Config{
+		Name:     config.Name,
+		Baud:     config.Baud,
+		Size:     config.Size,
+		Parity:   config.Parity,
+		StopBits: config.StopBits,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &serialConnWrapper{Port: port, timeout: config.ReadTimeout}, nil
+}
+
+// Read implements Conn.Read, applying the read timeout to each call
+func (w *serialConnWrapper) Read(b []byte) (int, error) {
+	if w.timeout > 0 {
+		if err := w.SetReadDeadline(time.Now().Add(w.timeout)); err != nil {
+			return 0, err
+		}
+	}
+	return w.Port.Read(b)
+}
+
+// SetReadTimeout implements Conn.SetReadTimeout; a zero timeout disables it
 func (w *serialConnWrapper) SetReadTimeout(timeout time.Duration) error {
-	// tarm/serial handles timeout via Config.ReadTimeout, set during OpenPort
-	// No-op here as timeout is already set
+	w.timeout = timeout
+	if timeout == 0 {
+		return w.SetReadDeadline(time.Time{})
+	}
 	return nil
 }
 
 // NewMetadataClient creates a new MetadataClient based on the config
 func NewMetadataClient(config ClientConfig) (MetadataClient, error) {
+	if config.BestEffort != nil {
+		return newBestEffortClient(config)
+	}
 	var conn Conn
 	var err error
+	// redial reconnects to a socket server that restarted
+	var redial func() (Conn, error)
+	// probed is set once the serial port was chosen by probing
+	var probed bool
 
 	switch config.Transport {
 	case transportSerial:
@@ -149,249 +520,490 @@ func NewMetadataClient(config ClientConfig) (MetadataClient, error) {
 		if config.SerialConfig.Name == "" {
 			return nil, fmt.Errorf("serial port not specified in config")
 		}
-		port, err := serial.OpenPort(config.SerialConfig)
+		// Fail fast rather than wait on a port no metadata service is behind
+		if p := DetectPlatform(); !p.HasMetadataChannel() {
+			return nil, &UnsupportedPlatformError{Platform: p}
+		}
+		if config.SerialConfig.Name == SerialAuto {
+			serialConfig := *config.SerialConfig
+			if serialConfig.Name, err = probeSerialPorts(&serialConfig, ""); err != nil {
+				return nil, fmt.Errorf("failed to find metadata serial port: %w", err)
+			}
+			config.SerialConfig = &serialConfig
+			logf(config.Logger, "mdata: using serial port %s", serialConfig.Name)
+			probed = true
+		}
+		conn, err = openSerialPort(config.SerialConfig)
 		if err != nil {
-			return nil, fmt.Errorf("failed to open serial port %s: %w", config.SerialConfig.Name, err)
+			err = fmt.Errorf("failed to open serial port %s: %w", config.SerialConfig.Name, err)
+			if probed {
+				return nil, err
+			}
+			if conn, err = fallBackSerialPort(&config, err); err != nil {
+				return nil, err
+			}
+			probed = true
 		}
-		conn = &serialConnWrapper{Port: port}
 	case transportTCP, transportUnix:
 		if config.SocketConfig == nil {
 			return nil, fmt.Errorf("socket config required for %s transport", config.Transport)
 		}
-		dialer := &net.Dialer{Timeout: config.SocketConfig.Timeout}
-		var netConn net.Conn
-		netConn, err = dialer.Dial(config.SocketConfig.Network, config.SocketConfig.Address)
+		sc := *config.SocketConfig
+		redial = func() (Conn, error) {
+			dialer := &net.Dialer{Timeout: sc.Timeout}
+			netConn, err := dialer.Dial(sc.Network, sc.Address)
+			if err != nil {
+				err = fmt.Errorf("failed to dial %s %s: %w", sc.Network, sc.Address, err)
+				if isTimeout(err) {
+					err = &TimeoutError{Phase: PhaseDial, Err: err}
+				}
+				return nil, err
+			}
+			conn := &netConnWrapper{Conn: netConn}
+			if err := conn.SetReadTimeout(sc.Timeout); err != nil {
+				conn.Close()
+				return nil, fmt.Errorf("failed to set read timeout: %w", err)
+			}
+			return conn, nil
+		}
+		if conn, err = redial(); err != nil {
+			if config.fallback != nil {
+				return fallBackTransport(config, err)
+			}
+			return nil, err
+		}
+	case transportGlobalZone:
+		return nil, ErrGlobalZone
+	case transportPipe:
+		if config.SocketConfig == nil {
+			return nil, fmt.Errorf("socket config required for %s transport", config.Transport)
+		}
+		f, err := os.OpenFile(config.SocketConfig.Address, os.O_RDWR, 0)
 		if err != nil {
-			return nil, fmt.Errorf("failed to dial %s %s: %w", config.SocketConfig.Network, config.SocketConfig.Address, err)
+			return nil, fmt.Errorf("failed to open pipe %s: %w", config.SocketConfig.Address, err)
 		}
-		conn = &netConnWrapper{Conn: netConn}
+		conn = &fileConnWrapper{File: f}
 		if err := conn.SetReadTimeout(config.SocketConfig.Timeout); err != nil {
 			conn.Close()
 			return nil, fmt.Errorf("failed to set read timeout: %w", err)
 		}
+	case transportExec:
+		if config.ExecConfig == nil {
+			return nil, fmt.Errorf("exec config required for exec transport")
+		}
+		ec := *config.ExecConfig
+		// A new command replaces one that exited, such as ssh losing its connection
+		redial = func() (Conn, error) { return startExec(&ec) }
+		if conn, err = redial(); err != nil {
+			return nil, err
+		}
 	default:
 		return nil, fmt.Errorf("unsupported transport: %s", config.Transport)
 	}
 
-	rw := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
-	if supported, err := Negotiate(rw); err != nil || !supported {
-		conn.Close()
-		if err != nil {
-			return nil, fmt.Errorf("protocol negotiation failed: %w", err)
+	start := time.Now()
+	c, err := newClientWithConn(conn, config.Secret, !config.NoNegotiate)
+	if !config.NoNegotiate {
+		traceNegotiate(config.Trace, start, err)
+	}
+	if err != nil && config.Transport == transportSerial && !probed {
+		// Another port may carry the metadata channel on this guest
+		if conn, err = fallBackSerialPort(&config, err); err != nil {
+			return nil, err
 		}
-		return nil, fmt.Errorf("server does not support Version 2 protocol")
+		c, err = newClientWithConn(conn, config.Secret, !config.NoNegotiate)
 	}
-	return &MetadataClientImpl{conn: conn, rw: rw}, nil
+	if err != nil {
+		return nil, err
+	}
+	c.auditSink = config.Audit
+	c.traceSink = config.Trace
+	c.redact = config.Redact
+	c.redial, c.secret, c.noNegotiate = redial, config.Secret, config.NoNegotiate
+	c.connected = &config
+	c.use(config.Middleware)
+	switch {
+	case config.Transport == transportSerial:
+		c.readTimeout = config.SerialConfig.ReadTimeout
+	case config.SocketConfig != nil:
+		c.readTimeout = config.SocketConfig.Timeout
+	case config.ExecConfig != nil:
+		c.readTimeout = config.ExecConfig.Timeout
+	}
+	return c, nil
 }
 
-// Get sends a GET request with the given payload
-func (c *MetadataClientImpl) Get(payload string) (string, error) {
-	return c.sendRequest("GET", payload)
+// ConnectedConfig returns the configuration of the transport client was
+// connected through by NewMetadataClient: the one it was given, with the
+// serial port found by probing, or the one it fell back to when that could
+// not be connected. It returns false for clients made from a connection and
+// for best-effort clients that could not connect at all.
+func ConnectedConfig(client MetadataClient) (ClientConfig, bool) {
+	c, ok := baseClient(client)
+	if !ok || c.connected == nil {
+		return ClientConfig{}, false
+	}
+	return *c.connected, true
 }
 
-// Keys sends a KEYS request with the given payload
-func (c *MetadataClientImpl) Keys() (string, error) {
-	return c.sendRequest("KEYS", "")
+// newBestEffortClient returns a client for config.BestEffort, answering from
+// the last-known values alone when the connection fails
+func newBestEffortClient(config ClientConfig) (MetadataClient, error) {
+	mw := append([]Middleware{config.BestEffort.bestEffort()}, config.Middleware...)
+	config.BestEffort, config.Middleware = nil, mw
+	c, err := NewMetadataClient(config)
+	if err == nil {
+		return c, nil
+	}
+	down, _ := newClientWithConn(unreachableConn{fmt.Errorf("not connected: %w", err)}, nil, false)
+	down.traceSink = config.Trace
+	down.use(mw)
+	return down, nil
 }
 
-// Delete sends a DELETE request with the given payload
-func (c *MetadataClientImpl) Delete(payload string) error {
-	if _, err := c.sendRequest("DELETE", payload); err != nil {
-		return err
-	}
-	return nil
+// unreachableConn stands for the connection to a metadata service that could
+// not be reached, failing every request with the reason
+type unreachableConn struct {
+	err error
 }
 
-func (c *MetadataClientImpl) Put(key, value string) error {
-	if strings.HasPrefix(key, "sdc:") {
-		return fmt.Errorf("cannot update keys in the read-only sdc: namespace")
+func (c unreachableConn) Read([]byte) (int, error)  { return 0, c.err }
+func (c unreachableConn) Write([]byte) (int, error) { return 0, c.err }
+func (c unreachableConn) Close() error              { return nil }
+
+// SetReadTimeout implements Conn.SetReadTimeout
+func (c unreachableConn) SetReadTimeout(time.Duration) error { return nil }
+
+// fallBackTransport connects to the fallback transport of config, which
+// could not be connected to because of cause
+func fallBackTransport(config ClientConfig, cause error) (MetadataClient, error) {
+	next := config
+	fb := config.fallback
+	next.Transport, next.SocketConfig, next.SerialConfig, next.fallback = fb.Transport, fb.SocketConfig, fb.SerialConfig, fb.fallback
+	logf(config.Logger, "mdata: %v; trying %s", cause, fb)
+	c, err := NewMetadataClient(next)
+	if err != nil {
+		return nil, fmt.Errorf("%w (%v)", err, cause)
 	}
-	// Encode key and value separately with BASE64
-	encodedKey := base64.StdEncoding.EncodeToString([]byte(key))
-	encodedValue := base64.StdEncoding.EncodeToString([]byte(value))
-	// Concatenate with a space
-	concatenated := encodedKey + " " + encodedValue
-	if _, err := c.sendRequest("PUT", concatenated); err != nil {
-		return err
+	return c, nil
+}
+
+// NewMetadataClientWithConn negotiates the V2 protocol over an established
+// connection and returns a MetadataClient using it. The connection is closed
+// if negotiation fails.
+func NewMetadataClientWithConn(conn Conn) (MetadataClient, error) {
+	c, err := newClientWithConn(conn, nil, true)
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	return c, nil
 }
 
-// sendRequest sends a request with the given code and payload
-func (c *MetadataClientImpl) sendRequest(code, payload string) (string, error) {
-	frame, err := newFrameWithString(code, payload)
+// NewAuthenticatedClientWithConn is like NewMetadataClientWithConn but first
+// authenticates to the server with secret
+func NewAuthenticatedClientWithConn(conn Conn, secret []byte) (MetadataClient, error) {
+	if len(secret) == 0 {
+		conn.Close()
+		return nil, fmt.Errorf("authentication secret is empty")
+	}
+	c, err := newClientWithConn(conn, secret, true)
 	if err != nil {
-		return "", fmt.Errorf("failed to create frame: %w", err)
+		return nil, err
 	}
-	if _, err := c.rw.WriteString(frame.Encode()); err != nil {
-		return "", fmt.Errorf("failed to send frame: %w", err)
+	return c, nil
+}
+
+// newClientWithConn authenticates when secret is set, then negotiates if
+// negotiate is set
+func newClientWithConn(conn Conn, secret []byte, negotiate bool) (*MetadataClientImpl, error) {
+	rw := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+	if len(secret) > 0 {
+		if err := Authenticate(rw, secret); err != nil {
+			conn.Close()
+			if isTimeout(err) {
+				err = &TimeoutError{Phase: PhaseNegotiate, Err: err}
+			}
+			return nil, err
+		}
 	}
-	if err := c.rw.Flush(); err != nil {
-		return "", fmt.Errorf("failed to flush frame: %w", err)
+	if negotiate {
+		if err := negotiateV2(rw); err != nil {
+			conn.Close()
+			return nil, err
+		}
 	}
-	response, err := c.rw.ReadString('\n')
+	return &MetadataClientImpl{conn: conn, rw: rw, enc: protocol.NewEncoder(rw.Writer), dec: protocol.NewDecoder(rw.Reader), unnegotiated: !negotiate}, nil
+}
+
+// negotiateV2 negotiates the V2 protocol on a new connection
+func negotiateV2(rw *bufio.ReadWriter) error {
+	supported, err := protocol.Negotiate(rw)
 	if err != nil {
-		return "", fmt.Errorf("failed to read response: %w", err)
+		err = fmt.Errorf("protocol negotiation failed: %w", err)
+		if isTimeout(err) {
+			err = &TimeoutError{Phase: PhaseNegotiate, Err: err}
+		}
+		return err
 	}
-	respFrame, err := ParseFrame(response)
-	if err != nil {
-		return "", fmt.Errorf("failed to parse response: %w", err)
+	if !supported {
+		return fmt.Errorf("server does not support Version 2 protocol")
 	}
-	if respFrame.Code != "SUCCESS" {
-		return "", fmt.Errorf("request failed with code: %s", respFrame.Code)
+	return nil
+}
+
+// Get sends a GET request with the given payload. A key set to the empty
+// string returns "" and a nil error, while a missing key returns ErrNotFound.
+func (c *MetadataClientImpl) Get(payload string) (string, error) {
+	if payload == "" {
+		return "", ErrEmptyKey
 	}
-	return string(respFrame.Payload), nil
+	return c.sendRequest("GET", payload)
 }
 
-// Close closes the serial connection
-func (c *MetadataClientImpl) Close() error {
-	return c.conn.Close()
+// Keys sends a KEYS request with the given payload
+func (c *MetadataClientImpl) Keys() (string, error) {
+	return c.sendRequest("KEYS", "")
 }
 
-// frame represents a Version 2 protocol frame
-type frame struct {
-	RequestID    string
-	Code         string
-	Payload      []byte // Raw payload bytes (not BASE64 encoded)
-	BodyLength   int
-	BodyChecksum string
+// Delete sends a DELETE request with the given payload
+func (c *MetadataClientImpl) Delete(payload string) (err error) {
+	defer func() { c.audit("DELETE", payload, nil, err) }()
+	if payload == "" {
+		return ErrEmptyKey
+	}
+	_, err = c.sendRequest("DELETE", payload)
+	return err
 }
 
-// Protocol constants
-const (
-	ProtocolPrefix  = "V2 "
-	NegotiationReq  = "NEGOTIATE V2\n"
-	NegotiationResp = "V2_OK\n"
-	CRCPolynomial   = 0xEDB88320
-)
+// Put sends a PUT request setting key to value. The value may be empty; as
+// with the metadata agent, it is stored as an empty string rather than
+// deleting the key.
+func (c *MetadataClientImpl) Put(key, value string) (err error) {
+	defer func() { c.audit("PUT", key, &value, err) }()
+	if key == "" {
+		return ErrEmptyKey
+	}
+	if strings.HasPrefix(key, "sdc:") {
+		return fmt.Errorf("cannot update keys in the read-only sdc: namespace")
+	}
+	// Encode key and value separately with BASE64, separated by a space
+	c.reqBuf = appendEncodeString(c.reqBuf[:0], key)
+	c.reqBuf = append(c.reqBuf, ' ')
+	c.reqBuf = appendEncodeString(c.reqBuf, value)
+	_, err = c.roundTrip("PUT", key, c.reqBuf)
+	return err
+}
 
-// newFrameWithString creates a new protocol frame with a string payload
-func newFrameWithString(code, payload string) (*frame, error) {
-	return newFrame(code, []byte(payload))
+// appendEncodeString appends the BASE64 encoding of s to dst without
+// converting s to a byte slice first
+func appendEncodeString(dst []byte, s string) []byte {
+	// A multiple of three, so chunks encode without intermediate padding
+	var chunk [48]byte
+	for len(s) > 0 {
+		n := copy(chunk[:], s)
+		dst = base64.StdEncoding.AppendEncode(dst, chunk[:n])
+		s = s[n:]
+	}
+	return dst
 }
 
-// newFrame creates a new protocol frame
-func newFrame(code string, payload []byte) (*frame, error) {
-	// Generate random request ID
-	randBytes := make([]byte, 4)
-	_, err := rand.Read(randBytes)
+// sendRequest sends a request with the given code and payload, which is the
+// key it names, if any
+func (c *MetadataClientImpl) sendRequest(code, payload string) (string, error) {
+	c.reqBuf = append(c.reqBuf[:0], payload...)
+	value, err := c.roundTrip(code, payload, c.reqBuf)
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate request ID: %w", err)
+		return "", err
 	}
+	return string(value), nil
+}
 
-	// Format request ID as 8-char zero-padded lowercase hex
-	requestID := hex.EncodeToString(randBytes)
-
-	// Create frame
-	f := &frame{
-		RequestID: requestID,
-		Code:      strings.ToUpper(code),
-		Payload:   payload,
+// roundTrip sends a request about key through the configured middleware and
+// returns the response payload, which is only valid until the next request
+func (c *MetadataClientImpl) roundTrip(code, key string, payload []byte) ([]byte, error) {
+	if c.transport == nil {
+		return c.send(code, payload)
 	}
-
-	// Calculate body length and checksum
-	f.updateBodyMetadata()
-
-	return f, nil
+	return c.transport.RoundTrip(&Request{Code: code, Key: key, Payload: payload})
 }
 
-// updateBodyMetadata calculates body length and checksum
-func (f *frame) updateBodyMetadata() {
-	body := f.buildBodyString()
-	f.BodyLength = len(body)
-	f.BodyChecksum = fmt.Sprintf("%08x", crc32.Checksum([]byte(body), crc32.MakeTable(CRCPolynomial)))
+// send exchanges a request, replaying it once on a new connection if the
+// server closed the old one, as it does when it restarts. Only requests
+// that read are replayed: a PUT or DELETE may have been applied before the
+// connection was lost, so their failure is returned as it is.
+func (c *MetadataClientImpl) send(code string, payload []byte) ([]byte, error) {
+	resp, err := c.exchange(code, payload)
+	if err == nil || !c.replays(code, err) {
+		return resp, err
+	}
+	c.trace(TraceEvent{Event: TraceRetry, Code: code, Error: err.Error()})
+	if rerr := c.reconnect(); rerr != nil {
+		return nil, &TransportError{Err: fmt.Errorf("%w (reconnecting failed: %v)", err, rerr)}
+	}
+	return c.exchange(code, payload)
 }
 
-// buildBodyString constructs the body string for checksum calculation
-func (f *frame) buildBodyString() string {
-	parts := []string{f.RequestID, f.Code}
-	if len(f.Payload) > 0 {
-		parts = append(parts, base64.StdEncoding.EncodeToString(f.Payload))
+// replays reports whether a request with code that failed with err is sent
+// again on a new connection: the server closed the connection and the
+// request only reads, being a GET, a KEYS, a GETIFCHANGED or an extension
+// registered as idempotent
+func (c *MetadataClientImpl) replays(code string, err error) bool {
+	if c.redial == nil || !connectionLost(err) {
+		return false
+	}
+	switch code {
+	case "GET", "KEYS", GetIfChangedCode:
+		return true
 	}
-	return strings.Join(parts, " ")
+	return c.extensions[code]
 }
 
-// Encode converts frame to wire format
-func (f *frame) Encode() string {
-	return fmt.Sprintf("%s%d %s %s\n",
-		ProtocolPrefix,
-		f.BodyLength,
-		f.BodyChecksum,
-		f.buildBodyString())
+// connectionLost reports whether err shows the server closed the connection
+func connectionLost(err error) bool {
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE)
 }
 
-// Negotiate performs V2 protocol negotiation
-func Negotiate(conn *bufio.ReadWriter) (bool, error) {
-	// Send negotiation request
-	if _, err := conn.WriteString(NegotiationReq); err != nil {
-		return false, fmt.Errorf("failed to send negotiation: %w", err)
+// reconnect replaces the connection with a newly dialed and negotiated one
+func (c *MetadataClientImpl) reconnect() error {
+	conn, err := c.redial()
+	if err != nil {
+		return err
 	}
-	if err := conn.Flush(); err != nil {
-		return false, fmt.Errorf("failed to flush negotiation: %w", err)
+	start := time.Now()
+	fresh, err := newClientWithConn(conn, c.secret, !c.noNegotiate)
+	if !c.noNegotiate {
+		traceNegotiate(c.traceSink, start, err)
 	}
-
-	// Read response
-	resp, err := conn.ReadString('\n')
 	if err != nil {
-		return false, fmt.Errorf("failed to read negotiation response: %w", err)
+		return err
 	}
-
-	return resp == NegotiationResp, nil
-}
-
-// ParseFrame parses a wire format frame
-func ParseFrame(data string) (*frame, error) {
-	if !strings.HasPrefix(data, ProtocolPrefix) {
-		return nil, fmt.Errorf("invalid frame prefix")
+	if c.readTimeout > 0 {
+		// The timeout in effect, which a derived client may have changed
+		if err := conn.SetReadTimeout(c.readTimeout); err != nil {
+			conn.Close()
+			return err
+		}
 	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.conn.Close()
+	c.conn, c.rw, c.enc, c.dec, c.stale = fresh.conn, fresh.rw, fresh.enc, fresh.dec, false
+	c.unnegotiated = fresh.unnegotiated
+	return nil
+}
 
-	// Remove prefix and trailing newline
-	trimmed := strings.TrimPrefix(strings.TrimSuffix(data, "\n"), ProtocolPrefix)
-
-	// Split into fields
-	parts := strings.Split(trimmed, " ")
-	if len(parts) < 3 {
-		return nil, fmt.Errorf("invalid frame format")
+// exchange sends a request on the connection and returns the payload of a
+// SUCCESS response, turning other responses into errors
+func (c *MetadataClientImpl) exchange(code string, payload []byte) ([]byte, error) {
+	resp, err := c.exchangeRaw(code, payload)
+	if err != nil {
+		return nil, err
 	}
-
-	// Parse body length
-	var bodyLength int
-	if _, err := fmt.Sscanf(parts[0], "%d", &bodyLength); err != nil {
-		return nil, fmt.Errorf("invalid body length: %w", err)
+	switch string(resp.Code) {
+	case protocol.CodeSuccess:
+		return resp.Payload, nil
+	case protocol.CodeNotFound:
+		return nil, ErrNotFound
+	case protocol.CodeNotModified:
+		return nil, ErrNotModified
+	case protocol.CodeFailure:
+		return nil, ErrFailure
+	default:
+		return nil, fmt.Errorf("request failed with code: %s", resp.Code)
 	}
+}
 
-	// Validate checksum format
-	checksum := parts[1]
-	if len(checksum) != 8 {
-		return nil, fmt.Errorf("invalid checksum format")
+// exchangeRaw sends a request on the connection and reads its response,
+// which is only valid until the next request
+func (c *MetadataClientImpl) exchangeRaw(code string, payload []byte) (protocol.RawFrame, error) {
+	// Format request ID as 8-char zero-padded lowercase hex
+	requestID := protocol.AppendHex32(c.requestID[:0], mathrand.Uint32())
+	if c.traceSink == nil {
+		return c.exchangeFrame(requestID, code, payload)
 	}
 
-	// Parse body fields
-	bodyParts := parts[2:]
-	if len(bodyParts) < 2 {
-		return nil, fmt.Errorf("invalid body format")
+	id := string(requestID)
+	c.trace(TraceEvent{Event: TraceSend, RequestID: id, Code: code, Bytes: len(payload)})
+	start := time.Now()
+	resp, err := c.exchangeFrame(requestID, code, payload)
+	if err != nil {
+		c.trace(TraceEvent{Event: TraceError, RequestID: id, Code: code, Duration: time.Since(start).Seconds(), Error: err.Error()})
+	} else {
+		c.trace(TraceEvent{Event: TraceRecv, RequestID: id, Code: string(resp.Code), Bytes: len(resp.Payload), Duration: time.Since(start).Seconds()})
 	}
+	return resp, err
+}
 
-	f := &frame{
-		BodyLength:   bodyLength,
-		BodyChecksum: checksum,
-		RequestID:    bodyParts[0],
-		Code:         bodyParts[1],
+// exchangeFrame writes the request with requestID and reads its response
+func (c *MetadataClientImpl) exchangeFrame(requestID []byte, code string, payload []byte) (protocol.RawFrame, error) {
+	if err := c.writeFrame(requestID, code, payload); err != nil {
+		return protocol.RawFrame{}, err
 	}
 
-	// Parse payload if present
-	if len(bodyParts) > 2 {
-		payload, err := base64.StdEncoding.DecodeString(bodyParts[2])
+	var resp protocol.RawFrame
+	for {
+		var err error
+		resp, err = c.dec.DecodeRaw()
 		if err != nil {
-			return nil, fmt.Errorf("invalid payload encoding: %w", err)
+			if isTimeout(err) {
+				// The response, or the rest of it, may still arrive
+				c.stale = true
+				return protocol.RawFrame{}, &TransportError{Err: &TimeoutError{Phase: PhaseRequest, Err: fmt.Errorf("failed to read response: %w", ErrTimeout)}}
+			}
+			var frameErr *protocol.FrameError
+			if !errors.As(err, &frameErr) {
+				return protocol.RawFrame{}, &TransportError{Err: fmt.Errorf("failed to read response: %w", err)}
+			}
+			if c.stale {
+				// The rest of a response cut short by a timeout, consumed up to its newline
+				continue
+			}
+			if c.unnegotiated {
+				// The server refused a V2 request on a connection that
+				// NoNegotiate left unnegotiated: negotiate and send it again
+				c.unnegotiated = false
+				start := time.Now()
+				err := negotiateV2(c.rw)
+				traceNegotiate(c.traceSink, start, err)
+				if err != nil {
+					return protocol.RawFrame{}, &TransportError{Err: err}
+				}
+				return c.exchangeFrame(requestID, code, payload)
+			}
+			return protocol.RawFrame{}, &TransportError{Err: fmt.Errorf("failed to parse response: %w", err)}
 		}
-		f.Payload = payload
+		if bytes.Equal(resp.RequestID, requestID) {
+			break
+		}
+		if !c.stale {
+			return protocol.RawFrame{}, &TransportError{Err: fmt.Errorf("response request ID %s does not match request %s", resp.RequestID, requestID)}
+		}
+		// A late response to a request that timed out
 	}
+	c.stale, c.unnegotiated = false, false
+	return resp, nil
+}
 
-	// Verify checksum
-	if actualChecksum := fmt.Sprintf("%08x", crc32.Checksum([]byte(f.buildBodyString()), crc32.MakeTable(CRCPolynomial))); actualChecksum != checksum {
-		return nil, fmt.Errorf("checksum mismatch")
+// writeFrame writes the request with requestID to the connection
+func (c *MetadataClientImpl) writeFrame(requestID []byte, code string, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if err := c.enc.Encode(string(requestID), code, payload); err != nil {
+		return &TransportError{Err: fmt.Errorf("failed to send frame: %w", err)}
 	}
+	if err := c.rw.Flush(); err != nil {
+		return &TransportError{Err: fmt.Errorf("failed to flush frame: %w", err)}
+	}
+	return nil
+}
 
-	return f, nil
+// Close closes the connection. Unlike other methods, it may be called while
+// a request is in flight, as on a signal, making the request fail; a request
+// being written is written in full first, so that no partial frame is left
+// on a channel other clients share, such as a serial line.
+func (c *MetadataClientImpl) Close() error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.conn.Close()
 }
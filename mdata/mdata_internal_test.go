@@ -0,0 +1,29 @@
+package mdata
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWithMaxTimeoutBoundsFallbacks(t *testing.T) {
+	serial := SerialClientConfig("/dev/ttyS1")
+	socket := UnixClientConfig("/native/.zonecontrol/metadata.sock")
+	socket.fallback = &serial
+	config := UnixClientConfig("/.zonecontrol/metadata.sock")
+	config.SocketConfig.Timeout = time.Second
+	config.fallback = &socket
+
+	bounded := config.WithMaxTimeout(3 * time.Second)
+	if got := bounded.SocketConfig.Timeout; got != time.Second {
+		t.Errorf("shorter timeout changed to %v", got)
+	}
+	if got := bounded.fallback.SocketConfig.Timeout; got != 3*time.Second {
+		t.Errorf("fallback socket timeout %v, want 3s", got)
+	}
+	if got := bounded.fallback.fallback.SerialConfig.ReadTimeout; got != 3*time.Second {
+		t.Errorf("fallback serial timeout %v, want 3s", got)
+	}
+	if serial.SerialConfig.ReadTimeout != 60*time.Second || socket.SocketConfig.Timeout == 3*time.Second {
+		t.Error("WithMaxTimeout changed the original config")
+	}
+}
@@ -0,0 +1,269 @@
+package mdata_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/Smithx10/go-smartos-mdata/mdata"
+	"github.com/Smithx10/go-smartos-mdata/mdataserver"
+	"github.com/Smithx10/go-smartos-mdata/mdatatest"
+)
+
+func TestEmptyValue(t *testing.T) {
+	client := mdatatest.StartPipe(t, mdataserver.NewMemoryStore(nil))
+	if err := client.Put("empty", ""); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	value, err := client.Get("empty")
+	if err != nil || value != "" {
+		t.Fatalf("Get of empty value: %q, %v", value, err)
+	}
+	if _, err := client.Get("missing"); !errors.Is(err, mdata.ErrNotFound) {
+		t.Fatalf("Get of missing key: %v, want ErrNotFound", err)
+	}
+}
+
+func TestEmptyKey(t *testing.T) {
+	client := mdatatest.StartPipe(t, mdataserver.NewMemoryStore(map[string]string{"": "x"}))
+	if _, err := client.Get(""); !errors.Is(err, mdata.ErrEmptyKey) {
+		t.Errorf("Get: %v, want ErrEmptyKey", err)
+	}
+	if err := client.Put("", "x"); !errors.Is(err, mdata.ErrEmptyKey) {
+		t.Errorf("Put: %v, want ErrEmptyKey", err)
+	}
+	if err := client.Delete(""); !errors.Is(err, mdata.ErrEmptyKey) {
+		t.Errorf("Delete: %v, want ErrEmptyKey", err)
+	}
+}
+
+// tamper flips a bit of the last byte of an encrypted value as stored
+func tamper(t *testing.T, stored string) string {
+	t.Helper()
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(stored, mdata.EncryptedPrefix))
+	if err != nil {
+		t.Fatalf("stored value %q is not BASE64: %v", stored, err)
+	}
+	sealed[len(sealed)-1] ^= 1
+	return mdata.EncryptedPrefix + base64.StdEncoding.EncodeToString(sealed)
+}
+
+func TestEncryptedClient(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+	for _, tt := range []struct {
+		name    string
+		readKey []byte
+		// change, if set, changes the stored values before they are read
+		change  func(t *testing.T, store *mdataserver.MemoryStore)
+		get     string
+		want    string
+		wantErr string
+	}{
+		{
+			name:    "round trip",
+			readKey: key,
+			get:     "secret:password",
+			want:    "hunter2",
+		},
+		{
+			name:    "wrong key",
+			readKey: bytes.Repeat([]byte{2}, 32),
+			get:     "secret:password",
+			wantErr: "wrong key or tampered value",
+		},
+		{
+			name:    "tampered ciphertext",
+			readKey: key,
+			change: func(t *testing.T, store *mdataserver.MemoryStore) {
+				stored, _, _ := store.Get("secret:password")
+				store.Put("secret:password", tamper(t, stored))
+			},
+			get:     "secret:password",
+			wantErr: "wrong key or tampered value",
+		},
+		{
+			name:    "value moved to another key",
+			readKey: key,
+			change: func(t *testing.T, store *mdataserver.MemoryStore) {
+				stored, _, _ := store.Get("secret:password")
+				store.Put("secret:token", stored)
+			},
+			get:     "secret:token",
+			wantErr: "wrong key or tampered value",
+		},
+		{
+			name:    "unencrypted value",
+			readKey: key,
+			change: func(t *testing.T, store *mdataserver.MemoryStore) {
+				store.Put("secret:password", "hunter2")
+			},
+			get:     "secret:password",
+			wantErr: "is not encrypted",
+		},
+		{
+			name:    "unselected key",
+			readKey: key,
+			get:     "public",
+			want:    "hello",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			store := mdataserver.NewMemoryStore(nil)
+			raw := mdatatest.StartPipe(t, store)
+			writer, err := mdata.NewEncryptedClient(raw, key, "secret:")
+			if err != nil {
+				t.Fatalf("NewEncryptedClient: %v", err)
+			}
+			if err := writer.Put("secret:password", "hunter2"); err != nil {
+				t.Fatalf("Put: %v", err)
+			}
+			if err := writer.Put("public", "hello"); err != nil {
+				t.Fatalf("Put: %v", err)
+			}
+			if stored, _, _ := store.Get("secret:password"); !strings.HasPrefix(stored, mdata.EncryptedPrefix) || strings.Contains(stored, "hunter2") {
+				t.Fatalf("stored value %q is not encrypted", stored)
+			}
+			if tt.change != nil {
+				tt.change(t, store)
+			}
+
+			reader, err := mdata.NewEncryptedClient(raw, tt.readKey, "secret:")
+			if err != nil {
+				t.Fatalf("NewEncryptedClient: %v", err)
+			}
+			value, err := reader.Get(tt.get)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Get(%s) = %q, %v, want error containing %q", tt.get, value, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || value != tt.want {
+				t.Fatalf("Get(%s) = %q, %v, want %q", tt.get, value, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestSignedClient(t *testing.T) {
+	current := mdata.SigningKey{ID: "2026", Secret: []byte("current secret")}
+	retired := mdata.SigningKey{ID: "2025", Secret: []byte("retired secret")}
+	for _, tt := range []struct {
+		name string
+		// stored is the value of the key read, as stored
+		stored  string
+		get     string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:   "signed value",
+			stored: mdata.SignValue(current, "config:app", "port=8080"),
+			get:    "config:app",
+			want:   "port=8080",
+		},
+		{
+			name:   "value signed with a retired key",
+			stored: mdata.SignValue(retired, "config:app", "port=8080"),
+			get:    "config:app",
+			want:   "port=8080",
+		},
+		{
+			name:    "tampered value",
+			stored:  strings.Replace(mdata.SignValue(current, "config:app", "port=8080"), "8080", "9090", 1),
+			get:     "config:app",
+			wantErr: true,
+		},
+		{
+			name:    "unknown key ID",
+			stored:  mdata.SignValue(mdata.SigningKey{ID: "other", Secret: current.Secret}, "config:app", "port=8080"),
+			get:     "config:app",
+			wantErr: true,
+		},
+		{
+			name:    "value moved to another key",
+			stored:  mdata.SignValue(current, "config:other", "port=8080"),
+			get:     "config:app",
+			wantErr: true,
+		},
+		{
+			name:    "unsigned value",
+			stored:  "port=8080",
+			get:     "config:app",
+			wantErr: true,
+		},
+		{
+			name:   "unselected key",
+			stored: "port=8080",
+			get:    "app",
+			want:   "port=8080",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			store := mdataserver.NewMemoryStore(map[string]string{tt.get: tt.stored})
+			client, err := mdata.NewSignedClient(mdatatest.StartPipe(t, store), []mdata.SigningKey{current, retired}, "config:")
+			if err != nil {
+				t.Fatalf("NewSignedClient: %v", err)
+			}
+			value, err := client.Get(tt.get)
+			if tt.wantErr {
+				if !errors.Is(err, mdata.ErrBadSignature) {
+					t.Fatalf("Get(%s) = %q, %v, want ErrBadSignature", tt.get, value, err)
+				}
+				return
+			}
+			if err != nil || value != tt.want {
+				t.Fatalf("Get(%s) = %q, %v, want %q", tt.get, value, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestSignedClientPut(t *testing.T) {
+	key := mdata.SigningKey{ID: "2026", Secret: []byte("secret")}
+	store := mdataserver.NewMemoryStore(nil)
+	client, err := mdata.NewSignedClient(mdatatest.StartPipe(t, store), []mdata.SigningKey{key})
+	if err != nil {
+		t.Fatalf("NewSignedClient: %v", err)
+	}
+	if err := client.Put("app", "port=8080"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if stored, _, _ := store.Get("app"); stored != mdata.SignValue(key, "app", "port=8080") {
+		t.Errorf("stored value %q is not signed with the first key", stored)
+	}
+	if value, err := client.Get("app"); err != nil || value != "port=8080" {
+		t.Errorf("Get = %q, %v, want the value without its signature", value, err)
+	}
+}
+
+func TestDeleteAll(t *testing.T) {
+	store := mdataserver.NewMemoryStore(map[string]string{
+		"sdc:uuid": "x",
+		"svc:a":    "1",
+		"svc:b":    "2",
+		"other":    "3",
+	})
+	client := mdatatest.StartPipe(t, store)
+
+	// The prefix also matches sdc:uuid, which is skipped
+	deleted, err := mdata.DeleteAll(client, "s")
+	if err != nil {
+		t.Fatalf("DeleteAll: %v", err)
+	}
+	if want := []string{"svc:a", "svc:b"}; !slices.Equal(deleted, want) {
+		t.Errorf("DeleteAll deleted %q, want %q", deleted, want)
+	}
+	keys, _ := store.Keys()
+	if want := []string{"other", "sdc:uuid"}; !slices.Equal(keys, want) {
+		t.Errorf("keys left %q, want %q", keys, want)
+	}
+	for _, prefix := range []string{"", "sdc:"} {
+		if _, err := mdata.DeleteAll(client, prefix); err == nil {
+			t.Errorf("DeleteAll(%q) succeeded, want an error", prefix)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package mdata
+
+// Request is a protocol request on its way through middleware
+type Request struct {
+	Code string // "GET", "KEYS", "PUT", "DELETE", or an extension such as WatchCode
+	Key  string // the key, or for WATCH the prefix, the request names; empty for KEYS
+	// Payload is sent as is: the key for GET and DELETE, and the BASE64
+	// encoded key and value separated by a space for PUT
+	Payload []byte
+}
+
+// RoundTripper sends a request and returns the payload of its SUCCESS reply.
+// Other replies are errors: ErrNotFound for NOTFOUND, ErrNotModified for
+// NOTMODIFIED, ErrFailure for FAILURE, a *TransportError when the connection
+// fails, and an error naming the code otherwise. The payload is only valid
+// until the next request, so copy it to keep it.
+type RoundTripper interface {
+	RoundTrip(req *Request) ([]byte, error)
+}
+
+// RoundTripperFunc adapts a function to the RoundTripper interface
+type RoundTripperFunc func(req *Request) ([]byte, error)
+
+// RoundTrip calls f(req)
+func (f RoundTripperFunc) RoundTrip(req *Request) ([]byte, error) {
+	return f(req)
+}
+
+// Middleware wraps the request path of a client, for logging, caching,
+// metrics or policies such as refusing writes. It may inspect or change the
+// request, answer it without calling next, or inspect the reply.
+//
+//	readOnly := func(next mdata.RoundTripper) mdata.RoundTripper {
+//		return mdata.RoundTripperFunc(func(req *mdata.Request) ([]byte, error) {
+//			if req.Code == "PUT" || req.Code == "DELETE" {
+//				return nil, fmt.Errorf("%s %s: client is read-only", req.Code, req.Key)
+//			}
+//			return next.RoundTrip(req)
+//		})
+//	}
+type Middleware func(next RoundTripper) RoundTripper
+
+// WithMiddleware returns a copy of config whose clients pass every request
+// through mw, after any middleware already configured. The first middleware
+// sees requests first and replies last.
+func (config ClientConfig) WithMiddleware(mw ...Middleware) ClientConfig {
+	config.Middleware = append(config.Middleware[:len(config.Middleware):len(config.Middleware)], mw...)
+	return config
+}
+
+// use builds the middleware chain around the connection
+func (c *MetadataClientImpl) use(mw []Middleware) {
+	if len(mw) == 0 {
+		return
+	}
+	var rt RoundTripper = RoundTripperFunc(func(req *Request) ([]byte, error) {
+		return c.send(req.Code, req.Payload)
+	})
+	for i := len(mw) - 1; i >= 0; i-- {
+		rt = mw[i](rt)
+	}
+	c.transport = rt
+}
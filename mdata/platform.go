@@ -0,0 +1,93 @@
+package mdata
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Hypervisors reported by DetectPlatform
+const (
+	HypervisorUnknown    = ""
+	HypervisorSmartOS    = "SmartOS"
+	HypervisorKVM        = "KVM"
+	HypervisorBhyve      = "bhyve"
+	HypervisorVMware     = "VMware"
+	HypervisorVirtualBox = "VirtualBox"
+	HypervisorHyperV     = "Hyper-V"
+	HypervisorXen        = "Xen"
+	HypervisorEC2        = "Amazon EC2"
+	HypervisorGCE        = "Google Compute Engine"
+	HypervisorBareMetal  = "bare metal"
+)
+
+// Platform describes the machine a guest runs on, as read from its DMI
+// (SMBIOS) data
+type Platform struct {
+	// Hypervisor is one of the Hypervisor constants, or HypervisorUnknown
+	// when the platform could not be identified
+	Hypervisor string
+	Vendor     string // DMI system manufacturer
+	Product    string // DMI product name
+}
+
+// HasMetadataChannel reports whether the platform may provide a SmartOS
+// metadata serial port. SmartOS hypervisors identify as Joyent; plain KVM and
+// bhyve are given the benefit of the doubt, as are unidentified platforms.
+func (p Platform) HasMetadataChannel() bool {
+	switch p.Hypervisor {
+	case HypervisorSmartOS, HypervisorKVM, HypervisorBhyve, HypervisorUnknown:
+		return true
+	}
+	return false
+}
+
+// UnsupportedPlatformError is returned instead of waiting on a serial port
+// on platforms known not to provide a SmartOS metadata channel
+type UnsupportedPlatformError struct {
+	Platform Platform
+}
+
+func (e *UnsupportedPlatformError) Error() string {
+	where := "under " + e.Platform.Hypervisor
+	if e.Platform.Hypervisor == HypervisorBareMetal {
+		where = "on bare metal"
+	}
+	return fmt.Sprintf("running %s: no SmartOS metadata channel available (set MDATA_SOCKET to use a metadata server)", where)
+}
+
+// DetectPlatform identifies the hypervisor from DMI data. It is supported on
+// Linux, Windows and illumos; elsewhere the hypervisor is reported as unknown.
+func DetectPlatform() Platform {
+	p, virtual := readPlatform()
+	p.Hypervisor = classifyPlatform(p.Vendor, p.Product)
+	if p.Hypervisor == HypervisorUnknown && p.Vendor != "" && !virtual {
+		p.Hypervisor = HypervisorBareMetal
+	}
+	return p
+}
+
+// classifyPlatform maps DMI vendor and product names to a hypervisor
+func classifyPlatform(vendor, product string) string {
+	v, p := strings.ToLower(vendor), strings.ToLower(product)
+	switch {
+	case strings.Contains(v, "joyent") || strings.Contains(p, "smartdc"):
+		return HypervisorSmartOS
+	case strings.Contains(v, "vmware") || strings.Contains(p, "vmware"):
+		return HypervisorVMware
+	case strings.Contains(v, "innotek") || strings.Contains(p, "virtualbox"):
+		return HypervisorVirtualBox
+	case strings.Contains(v, "microsoft") && strings.Contains(p, "virtual machine"):
+		return HypervisorHyperV
+	case strings.Contains(v, "xen") || strings.Contains(p, "hvm domu"):
+		return HypervisorXen
+	case strings.Contains(v, "amazon"):
+		return HypervisorEC2
+	case strings.Contains(v, "google"):
+		return HypervisorGCE
+	case strings.Contains(v, "bhyve") || strings.Contains(p, "bhyve"):
+		return HypervisorBhyve
+	case strings.Contains(v, "qemu") || strings.Contains(p, "kvm") || strings.Contains(p, "qemu"):
+		return HypervisorKVM
+	}
+	return HypervisorUnknown
+}
@@ -0,0 +1,30 @@
+package mdata
+
+import (
+	"os/exec"
+	"strings"
+)
+
+// readPlatform reads the SMBIOS system identification with smbios(8). Bare
+// metal cannot be told from an unidentified hypervisor, so the platform is
+// always reported as possibly virtual.
+func readPlatform() (Platform, bool) {
+	out, err := exec.Command("/usr/sbin/smbios", "-t", "SMB_TYPE_SYSTEM").Output()
+	if err != nil {
+		return Platform{}, true
+	}
+	var p Platform
+	for _, line := range strings.Split(string(out), "\n") {
+		name, value, ok := strings.Cut(strings.TrimSpace(line), ":")
+		if !ok {
+			continue
+		}
+		switch name {
+		case "Manufacturer":
+			p.Vendor = strings.TrimSpace(value)
+		case "Product":
+			p.Product = strings.TrimSpace(value)
+		}
+	}
+	return p, true
+}
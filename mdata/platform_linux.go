@@ -0,0 +1,38 @@
+//go:build linux
+
+package mdata
+
+import (
+	"bufio"
+	"os"
+	"strings"
+)
+
+// readPlatform reads the DMI system identification from sysfs and whether
+// the CPU reports running under a hypervisor
+func readPlatform() (Platform, bool) {
+	read := func(name string) string {
+		data, _ := os.ReadFile("/sys/class/dmi/id/" + name)
+		return strings.TrimSpace(string(data))
+	}
+	p := Platform{Vendor: read("sys_vendor"), Product: read("product_name")}
+	return p, cpuHasHypervisor()
+}
+
+// cpuHasHypervisor reports whether /proc/cpuinfo lists the hypervisor flag.
+// An unreadable file counts as virtual, so bare metal is never assumed.
+func cpuHasHypervisor() bool {
+	f, err := os.Open("/proc/cpuinfo")
+	if err != nil {
+		return true
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		name, value, ok := strings.Cut(scanner.Text(), ":")
+		if ok && strings.TrimSpace(name) == "flags" {
+			return strings.Contains(" "+value+" ", " hypervisor ")
+		}
+	}
+	return true
+}
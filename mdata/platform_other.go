@@ -0,0 +1,8 @@
+//go:build !linux && !windows && !illumos
+
+package mdata
+
+// readPlatform is not implemented here; the platform is reported as unknown
+func readPlatform() (Platform, bool) {
+	return Platform{}, true
+}
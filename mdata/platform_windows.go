@@ -0,0 +1,18 @@
+//go:build windows
+
+package mdata
+
+import "golang.org/x/sys/windows/registry"
+
+// readPlatform reads the DMI system identification Windows keeps in the
+// registry. Bare metal cannot be told apart reliably, so it is never reported.
+func readPlatform() (Platform, bool) {
+	k, err := registry.OpenKey(registry.LOCAL_MACHINE, `HARDWARE\DESCRIPTION\System\BIOS`, registry.QUERY_VALUE)
+	if err != nil {
+		return Platform{}, true
+	}
+	defer k.Close()
+	vendor, _, _ := k.GetStringValue("SystemManufacturer")
+	product, _, _ := k.GetStringValue("SystemProductName")
+	return Platform{Vendor: vendor, Product: product}, true
+}
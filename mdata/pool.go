@@ -0,0 +1,374 @@
+package mdata
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrPoolClosed is returned by the requests of a closed Pool
+var ErrPoolClosed = errors.New("connection pool closed")
+
+// DefaultMaxConns is the size of a Pool when neither NewPool nor the
+// SocketConfig gives one
+const DefaultMaxConns = 4
+
+// Pool holds up to a fixed number of connections to the metadata service,
+// opened as needed and shared by concurrent callers. Over TCP and unix
+// sockets, GetMany spreads its requests across them, which makes fetching
+// every key several times faster than on a single connection. The serial
+// port and named pipes carry one conversation at a time, so there a Pool
+// holds a single connection and requests are strictly serialized.
+//
+// The SocketConfig's IdleTimeout and HealthCheckInterval govern idle
+// connections: those idle longer than IdleTimeout are closed, and every
+// HealthCheckInterval those left are checked with a request and closed if it
+// fails. Without a HealthCheckInterval, expired connections are only closed
+// when next needed. Stats reports the pool's usage.
+type Pool struct {
+	config              ClientConfig
+	size                int
+	idleTimeout         time.Duration
+	healthCheckInterval time.Duration
+
+	idle  chan pooledConn // open connections not in use
+	slots chan struct{}   // one token per connection that may be opened
+	done  chan struct{}   // closed by Close
+
+	mu     sync.Mutex
+	closed bool
+
+	open         atomic.Int64
+	acquires     atomic.Uint64
+	waits        atomic.Uint64
+	waitDuration atomic.Int64
+	dials        atomic.Uint64
+	idleClosed   atomic.Uint64
+	failed       atomic.Uint64
+}
+
+// pooledConn is an idle connection of a Pool
+type pooledConn struct {
+	client MetadataClient
+	since  time.Time // when it was last released
+}
+
+// PoolStats describes the usage of a Pool
+type PoolStats struct {
+	MaxConns     int           // Most connections the pool opens
+	Open         int           // Connections open, in use or idle
+	InUse        int           // Connections in use
+	Idle         int           // Connections open and not in use
+	Acquires     uint64        // Times a connection was needed
+	Waits        uint64        // Times a connection had to be waited for
+	WaitDuration time.Duration // Total time spent waiting for connections
+	Dials        uint64        // Connections opened
+	IdleClosed   uint64        // Connections closed for being idle longer than IdleTimeout
+	Failed       uint64        // Connections closed after a transport error or failed health check
+}
+
+// NewPool returns a Pool of up to size connections made as by
+// NewMetadataClient with config, or SocketConfig.MaxConns connections when
+// size is zero. No connection is made until a request needs one. Close must
+// be called to stop the health checks.
+func NewPool(config ClientConfig, size int) *Pool {
+	p := &Pool{config: config, done: make(chan struct{})}
+	if sc := config.SocketConfig; sc != nil {
+		if size < 1 {
+			size = sc.MaxConns
+		}
+		p.idleTimeout, p.healthCheckInterval = sc.IdleTimeout, sc.HealthCheckInterval
+	}
+	if size < 1 {
+		size = DefaultMaxConns
+	}
+	if config.Transport != transportTCP && config.Transport != transportUnix {
+		size = 1
+	}
+	p.size = size
+	p.idle = make(chan pooledConn, size)
+	p.slots = make(chan struct{}, size)
+	for range size {
+		p.slots <- struct{}{}
+	}
+	if p.healthCheckInterval > 0 {
+		go p.healthCheck()
+	}
+	return p
+}
+
+// Size returns the most connections the pool opens
+func (p *Pool) Size() int {
+	return p.size
+}
+
+// Stats returns the pool's usage so far
+func (p *Pool) Stats() PoolStats {
+	open, idle := int(p.open.Load()), len(p.idle)
+	return PoolStats{
+		MaxConns:     p.size,
+		Open:         open,
+		InUse:        max(open-idle, 0),
+		Idle:         idle,
+		Acquires:     p.acquires.Load(),
+		Waits:        p.waits.Load(),
+		WaitDuration: time.Duration(p.waitDuration.Load()),
+		Dials:        p.dials.Load(),
+		IdleClosed:   p.idleClosed.Load(),
+		Failed:       p.failed.Load(),
+	}
+}
+
+// acquire returns an idle connection, or opens one if fewer than the pool's
+// size are open, waiting for one to be released otherwise
+func (p *Pool) acquire() (MetadataClient, error) {
+	p.acquires.Add(1)
+	var start time.Time
+	for {
+		var pc pooledConn
+		select {
+		case <-p.done:
+			return nil, ErrPoolClosed
+		case pc = <-p.idle:
+		default:
+			select {
+			case <-p.slots:
+				p.waited(start)
+				return p.dial()
+			default:
+			}
+			if start.IsZero() {
+				start = time.Now()
+			}
+			select {
+			case <-p.done:
+				return nil, ErrPoolClosed
+			case pc = <-p.idle:
+			case <-p.slots:
+				p.waited(start)
+				return p.dial()
+			}
+		}
+		if p.expired(pc) {
+			p.discard(pc.client, &p.idleClosed)
+			continue
+		}
+		p.waited(start)
+		return pc.client, nil
+	}
+}
+
+// waited records a wait for a connection that started at start, if any
+func (p *Pool) waited(start time.Time) {
+	if !start.IsZero() {
+		p.waits.Add(1)
+		p.waitDuration.Add(int64(time.Since(start)))
+	}
+}
+
+// dial opens a connection in a slot taken from p.slots
+func (p *Pool) dial() (MetadataClient, error) {
+	p.mu.Lock()
+	closed := p.closed
+	p.mu.Unlock()
+	if closed {
+		p.slots <- struct{}{}
+		return nil, ErrPoolClosed
+	}
+	c, err := NewMetadataClient(p.config)
+	if err != nil {
+		p.slots <- struct{}{}
+		return nil, err
+	}
+	p.dials.Add(1)
+	p.open.Add(1)
+	return c, nil
+}
+
+// expired reports whether pc has been idle longer than the idle timeout
+func (p *Pool) expired(pc pooledConn) bool {
+	return p.idleTimeout > 0 && time.Since(pc.since) > p.idleTimeout
+}
+
+// discard closes c, freeing its slot, and counts it in reason
+func (p *Pool) discard(c MetadataClient, reason *atomic.Uint64) {
+	c.Close()
+	p.open.Add(-1)
+	reason.Add(1)
+	p.slots <- struct{}{}
+}
+
+// release returns c to the pool after a request that failed with err. A
+// connection that failed at the transport level, or returned after the pool
+// was closed, is closed instead.
+func (p *Pool) release(c MetadataClient, err error) {
+	var te *TransportError
+	if errors.As(err, &te) {
+		p.discard(c, &p.failed)
+		return
+	}
+	p.putIdle(pooledConn{client: c, since: time.Now()})
+}
+
+// putIdle adds pc to the idle connections, or closes it if the pool is closed
+func (p *Pool) putIdle(pc pooledConn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		pc.client.Close()
+		p.open.Add(-1)
+		p.slots <- struct{}{}
+		return
+	}
+	p.idle <- pc
+}
+
+// healthCheck checks the idle connections every health check interval until
+// the pool is closed
+func (p *Pool) healthCheck() {
+	ticker := time.NewTicker(p.healthCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+			p.checkIdle()
+		}
+	}
+}
+
+// checkIdle closes the idle connections that expired or fail a request,
+// checking each once. Connections released meanwhile may be checked instead
+// of older ones, which only delays their check to the next round.
+func (p *Pool) checkIdle() {
+	for range len(p.idle) {
+		var pc pooledConn
+		select {
+		case pc = <-p.idle:
+		default:
+			return
+		}
+		if p.expired(pc) {
+			p.discard(pc.client, &p.idleClosed)
+			continue
+		}
+		if _, err := pc.client.Get("sdc:uuid"); err != nil && !errors.Is(err, ErrNotFound) {
+			p.discard(pc.client, &p.failed)
+			continue
+		}
+		p.putIdle(pc)
+	}
+}
+
+// Do runs fn with a connection of the pool, which fn must not keep or close
+func (p *Pool) Do(fn func(MetadataClient) error) error {
+	c, err := p.acquire()
+	if err != nil {
+		return err
+	}
+	err = fn(c)
+	p.release(c, err)
+	return err
+}
+
+// Get returns the value of key, as MetadataClient.Get
+func (p *Pool) Get(key string) (value string, err error) {
+	err = p.Do(func(c MetadataClient) error {
+		value, err = c.Get(key)
+		return err
+	})
+	return value, err
+}
+
+// Keys lists the keys, as MetadataClient.Keys
+func (p *Pool) Keys() (keys string, err error) {
+	err = p.Do(func(c MetadataClient) error {
+		keys, err = c.Keys()
+		return err
+	})
+	return keys, err
+}
+
+// GetMany fetches the values of keys, spreading the requests across the
+// pool's connections. Keys that do not exist are left out of the result. The
+// first failure stops the remaining requests and is returned.
+func (p *Pool) GetMany(keys []string) (map[string]string, error) {
+	values := make([]string, len(keys))
+	found := make([]bool, len(keys))
+
+	var (
+		wg       sync.WaitGroup
+		next     atomic.Int64
+		errOnce  sync.Once
+		firstErr error
+		failed   atomic.Bool
+	)
+	for range min(p.size, len(keys)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := p.Do(func(c MetadataClient) error {
+				for !failed.Load() {
+					i := int(next.Add(1) - 1)
+					if i >= len(keys) {
+						return nil
+					}
+					value, err := c.Get(keys[i])
+					if errors.Is(err, ErrNotFound) {
+						continue
+					}
+					if err != nil {
+						return fmt.Errorf("failed to get %s: %w", keys[i], err)
+					}
+					values[i], found[i] = value, true
+				}
+				return nil
+			})
+			if err != nil {
+				errOnce.Do(func() { firstErr = err })
+				failed.Store(true)
+			}
+		}()
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	result := make(map[string]string, len(keys))
+	for i, key := range keys {
+		if found[i] {
+			result[key] = values[i]
+		}
+	}
+	return result, nil
+}
+
+// Close stops the health checks, closes the idle connections and makes
+// further requests fail with ErrPoolClosed. Connections in use are closed
+// when released.
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	close(p.done)
+	var errs []error
+	for {
+		select {
+		case pc := <-p.idle:
+			if err := pc.client.Close(); err != nil {
+				errs = append(errs, err)
+			}
+			p.open.Add(-1)
+			p.slots <- struct{}{}
+		default:
+			return errors.Join(errs...)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package mdata
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// ParseError describes a metadata value that could not be converted to its field's type
+type ParseError struct {
+	KeyName   string
+	FieldName string
+	TypeName  string
+	Value     string
+	Err       error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("mdata: failed to assign %q from key %s to field %s of type %s: %v", e.Value, e.KeyName, e.FieldName, e.TypeName, e.Err)
+}
+
+func (e *ParseError) Unwrap() error { return e.Err }
+
+// RequiredError reports a required key that is missing and has no default
+type RequiredError struct {
+	KeyName   string
+	FieldName string
+}
+
+func (e *RequiredError) Error() string {
+	return fmt.Sprintf("mdata: required key %s missing for field %s", e.KeyName, e.FieldName)
+}
+
+// Process populates spec, a pointer to a struct, from metadata read through a
+// client created with DefaultClientConfig. See ProcessWith.
+func Process(prefix string, spec any) error {
+	client, err := NewMetadataClient(DefaultClientConfig())
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	return ProcessWith(client, prefix, spec)
+}
+
+// ProcessWith populates spec, a pointer to a struct, from metadata in the
+// manner of envconfig. Each exported field is read from the key prefix+NAME,
+// where NAME is the field name in snake_case ("DBHost" becomes "db_host") or
+// the value of its `mdata:"name"` tag. Fields of struct type are processed
+// recursively with prefix+NAME+"_" (embedded structs share the parent prefix).
+//
+// Supported field tags:
+//
+//	mdata:"name"       key name relative to the prefix
+//	default:"value"    value used when the key is missing
+//	required:"true"    fail when the key is missing and there is no default
+//	ignored:"true"     leave the field alone
+//
+// Fields may be strings, bools, integers, floats, time.Duration, pointers to
+// these, encoding.TextUnmarshaler implementations, comma separated slices, and
+// maps written as k1:v1,k2:v2. Every problem is reported, joined into one
+// error of *ParseError and *RequiredError values.
+func ProcessWith(client MetadataClient, prefix string, spec any) error {
+	v := reflect.ValueOf(spec)
+	if v.Kind() != reflect.Pointer || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("mdata: spec must be a non-nil pointer to a struct, got %T", spec)
+	}
+	var errs []error
+	if err := processStruct(client, prefix, v.Elem(), &errs); err != nil {
+		return err
+	}
+	return errors.Join(errs...)
+}
+
+// processStruct fills the fields of s, collecting per-field problems in errs.
+// It returns an error only when metadata cannot be read at all.
+func processStruct(client MetadataClient, prefix string, s reflect.Value, errs *[]error) error {
+	t := s.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		value := s.Field(i)
+		if !field.IsExported() || field.Tag.Get("ignored") == "true" {
+			continue
+		}
+
+		name := field.Tag.Get("mdata")
+		if name == "" {
+			name = snakeCase(field.Name)
+		}
+
+		// Recurse into nested structs unless they decode themselves
+		if value.Kind() == reflect.Struct && !isDecoder(value) && value.Type() != reflect.TypeOf(time.Time{}) {
+			nested := prefix + name + "_"
+			if field.Anonymous {
+				nested = prefix
+			}
+			if err := processStruct(client, nested, value, errs); err != nil {
+				return err
+			}
+			continue
+		}
+
+		key := prefix + name
+		raw, err := client.Get(key)
+		if errors.Is(err, ErrNotFound) {
+			def, hasDefault := field.Tag.Lookup("default")
+			switch {
+			case hasDefault:
+				raw = def
+			case field.Tag.Get("required") == "true":
+				*errs = append(*errs, &RequiredError{KeyName: key, FieldName: field.Name})
+				continue
+			default:
+				continue
+			}
+		} else if err != nil {
+			return fmt.Errorf("mdata: failed to get %s: %w", key, err)
+		}
+
+		if err := assign(value, raw); err != nil {
+			*errs = append(*errs, &ParseError{
+				KeyName:   key,
+				FieldName: field.Name,
+				TypeName:  field.Type.String(),
+				Value:     raw,
+				Err:       err,
+			})
+		}
+	}
+	return nil
+}
+
+// isDecoder reports whether v's address implements encoding.TextUnmarshaler
+func isDecoder(v reflect.Value) bool {
+	if !v.CanAddr() {
+		return false
+	}
+	_, ok := v.Addr().Interface().(encoding.TextUnmarshaler)
+	return ok
+}
+
+// assign converts raw to v's type and stores it in v
+func assign(v reflect.Value, raw string) error {
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return assign(v.Elem(), raw)
+	}
+	if isDecoder(v) {
+		return v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(raw))
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if v.Type() == reflect.TypeOf(time.Duration(0)) {
+			d, err := time.ParseDuration(raw)
+			if err != nil {
+				return err
+			}
+			v.SetInt(int64(d))
+			return nil
+		}
+		n, err := strconv.ParseInt(raw, 0, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(raw, 0, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(raw, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		var parts []string
+		if raw != "" {
+			parts = strings.Split(raw, ",")
+		}
+		slice := reflect.MakeSlice(v.Type(), len(parts), len(parts))
+		for i, part := range parts {
+			if err := assign(slice.Index(i), strings.TrimSpace(part)); err != nil {
+				return fmt.Errorf("element %d: %w", i, err)
+			}
+		}
+		v.Set(slice)
+	case reflect.Map:
+		m := reflect.MakeMap(v.Type())
+		if raw != "" {
+			for _, pair := range strings.Split(raw, ",") {
+				k, val, ok := strings.Cut(pair, ":")
+				if !ok {
+					return fmt.Errorf("invalid map entry %q: expected key:value", pair)
+				}
+				key := reflect.New(v.Type().Key()).Elem()
+				if err := assign(key, strings.TrimSpace(k)); err != nil {
+					return fmt.Errorf("map key %q: %w", k, err)
+				}
+				elem := reflect.New(v.Type().Elem()).Elem()
+				if err := assign(elem, strings.TrimSpace(val)); err != nil {
+					return fmt.Errorf("map value for %q: %w", k, err)
+				}
+				m.SetMapIndex(key, elem)
+			}
+		}
+		v.Set(m)
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+// snakeCase converts a Go field name such as "DBHost" to "db_host"
+func snakeCase(name string) string {
+	runes := []rune(name)
+	var b strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) && i > 0 {
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				b.WriteByte('_')
+			}
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
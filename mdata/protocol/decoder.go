@@ -0,0 +1,310 @@
+package protocol
+
+import (
+	"bufio"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// maxFieldLength bounds the request ID and code of a frame
+const maxFieldLength = 64
+
+// FrameError reports a malformed frame, as opposed to a failure to read one
+type FrameError struct {
+	Err error
+}
+
+func (e *FrameError) Error() string { return e.Err.Error() }
+
+func (e *FrameError) Unwrap() error { return e.Err }
+
+func frameErrorf(format string, args ...any) error {
+	return &FrameError{Err: fmt.Errorf(format, args...)}
+}
+
+// frameState is the part of a frame a Decoder expects next
+type frameState int
+
+const (
+	statePrefix frameState = iota
+	stateLength
+	stateChecksum
+	stateRequestID
+	stateCode
+	statePayload
+)
+
+// Decoder reads frames from a stream, validating the declared body
+// length, the field layout, the payload encoding and the checksum as bytes
+// arrive. A frame is never held in memory as a whole: a corrupt frame is
+// rejected as soon as the problem shows, and a payload is decoded as it is
+// read, so a large value costs its decoded size rather than several copies
+// of its encoding. After a malformed frame, the rest of its line is
+// discarded so the next frame can be read.
+type Decoder struct {
+	r *bufio.Reader
+
+	// Per-frame parse state
+	state      frameState
+	pos        int    // bytes consumed in the current field
+	bodyLength int    // declared body length
+	bodyRead   int    // body bytes seen so far
+	checksum   uint32 // declared checksum
+	crc        uint32 // running checksum of the body
+	quad       [4]byte
+	nquad      int
+	padded     bool
+	sum        [8]byte // checksum in wire form
+
+	// Buffers reused across frames
+	requestID []byte
+	code      []byte
+	payload   []byte
+}
+
+// NewDecoder returns a Decoder reading from r
+func NewDecoder(r *bufio.Reader) *Decoder {
+	return &Decoder{r: r}
+}
+
+// Decode reads the next frame. Malformed frames are reported as *FrameError.
+func (fr *Decoder) Decode() (*Frame, error) {
+	raw, err := fr.DecodeRaw()
+	if err != nil {
+		return nil, err
+	}
+	return &Frame{
+		BodyLength:   raw.BodyLength,
+		BodyChecksum: string(raw.Checksum),
+		RequestID:    string(raw.RequestID),
+		Code:         string(raw.Code),
+		Payload:      append([]byte(nil), raw.Payload...),
+	}, nil
+}
+
+// DecodeRaw reads the next frame like Decode, into the decoder's buffers,
+// which are only valid until the next call
+func (fr *Decoder) DecodeRaw() (RawFrame, error) {
+	fr.reset()
+	for {
+		chunk, err := fr.r.ReadSlice('\n')
+		done, ferr := fr.feed(chunk)
+		if ferr != nil {
+			// Skip the rest of the line unless this chunk already ended it
+			if err == bufio.ErrBufferFull {
+				fr.discardLine()
+			}
+			return RawFrame{}, ferr
+		}
+		if done {
+			putHex32(fr.sum[:], fr.checksum)
+			return RawFrame{
+				RequestID:  fr.requestID,
+				Code:       fr.code,
+				Payload:    fr.payload,
+				BodyLength: fr.bodyLength,
+				Checksum:   fr.sum[:],
+			}, nil
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err == io.EOF && (fr.state != statePrefix || fr.pos > 0) {
+			err = io.ErrUnexpectedEOF
+		}
+		return RawFrame{}, err
+	}
+}
+
+func (fr *Decoder) reset() {
+	fr.state = statePrefix
+	fr.pos = 0
+	fr.bodyLength = 0
+	fr.bodyRead = 0
+	fr.checksum = 0
+	fr.crc = 0
+	fr.nquad = 0
+	fr.padded = false
+	fr.requestID = fr.requestID[:0]
+	fr.code = fr.code[:0]
+	fr.payload = fr.payload[:0]
+}
+
+// discardLine skips the rest of the current line
+func (fr *Decoder) discardLine() {
+	for {
+		_, err := fr.r.ReadSlice('\n')
+		if err != bufio.ErrBufferFull {
+			return
+		}
+	}
+}
+
+// feed consumes the next piece of a line. It reports done once the line's
+// newline has been consumed.
+func (fr *Decoder) feed(chunk []byte) (done bool, err error) {
+	bodyStart := -1
+	if fr.state >= stateRequestID {
+		bodyStart = 0
+	}
+	for i, b := range chunk {
+		if b == '\n' {
+			if bodyStart >= 0 {
+				fr.crc = crc32.Update(fr.crc, crcTable, chunk[bodyStart:i])
+			}
+			return true, fr.finish()
+		}
+		if fr.state >= stateRequestID {
+			fr.bodyRead++
+			if fr.bodyRead > fr.bodyLength {
+				return false, frameErrorf("body length mismatch: declared %d, got more", fr.bodyLength)
+			}
+		}
+
+		switch fr.state {
+		case statePrefix:
+			if b != Prefix[fr.pos] {
+				return false, frameErrorf("invalid frame prefix")
+			}
+			if fr.pos++; fr.pos == len(Prefix) {
+				fr.state, fr.pos = stateLength, 0
+			}
+		case stateLength:
+			switch {
+			case b == ' ':
+				if fr.pos == 0 {
+					return false, frameErrorf("invalid frame format: empty field")
+				}
+				fr.state, fr.pos = stateChecksum, 0
+			case b >= '0' && b <= '9' && fr.bodyLength <= MaxLineLength:
+				fr.bodyLength = fr.bodyLength*10 + int(b-'0')
+				fr.pos++
+			default:
+				return false, frameErrorf("invalid body length")
+			}
+		case stateChecksum:
+			if b == ' ' {
+				if fr.pos != 8 {
+					return false, frameErrorf("invalid checksum format")
+				}
+				fr.state, fr.pos = stateRequestID, 0
+				bodyStart = i + 1
+				continue
+			}
+			nibble := hexValue(b)
+			if nibble < 0 || fr.pos == 8 {
+				return false, frameErrorf("invalid checksum format")
+			}
+			fr.checksum = fr.checksum<<4 | uint32(nibble)
+			fr.pos++
+		case stateRequestID:
+			if b == ' ' {
+				if len(fr.requestID) == 0 {
+					return false, frameErrorf("invalid frame format: empty field")
+				}
+				fr.state = stateCode
+				continue
+			}
+			if len(fr.requestID) == maxFieldLength {
+				return false, frameErrorf("invalid body format")
+			}
+			fr.requestID = append(fr.requestID, b)
+		case stateCode:
+			if b == ' ' {
+				if len(fr.code) == 0 {
+					return false, frameErrorf("invalid frame format: empty field")
+				}
+				fr.state = statePayload
+				continue
+			}
+			if len(fr.code) == maxFieldLength {
+				return false, frameErrorf("invalid body format")
+			}
+			fr.code = append(fr.code, b)
+		case statePayload:
+			if err := fr.decodeByte(b); err != nil {
+				return false, err
+			}
+		}
+	}
+	if bodyStart >= 0 {
+		fr.crc = crc32.Update(fr.crc, crcTable, chunk[bodyStart:])
+	}
+	return false, nil
+}
+
+// decodeByte adds one character of the BASE64 payload, decoding each
+// complete group of four
+func (fr *Decoder) decodeByte(b byte) error {
+	if b == ' ' {
+		return frameErrorf("invalid body format")
+	}
+	if fr.padded || !(isBase64Char(b) || b == '=') {
+		return frameErrorf("invalid payload encoding: unexpected %q", b)
+	}
+	fr.quad[fr.nquad] = b
+	if fr.nquad++; fr.nquad < 4 {
+		return nil
+	}
+	fr.nquad = 0
+	fr.padded = fr.quad[3] == '='
+	var out [3]byte
+	n, err := strictBase64.Decode(out[:], fr.quad[:])
+	if err != nil {
+		return frameErrorf("invalid payload encoding: %w", err)
+	}
+	fr.payload = append(fr.payload, out[:n]...)
+	return nil
+}
+
+// finish validates a frame once its newline has arrived
+func (fr *Decoder) finish() error {
+	switch fr.state {
+	case statePrefix:
+		return frameErrorf("invalid frame prefix")
+	case stateLength, stateChecksum:
+		return frameErrorf("invalid frame format")
+	}
+	if fr.bodyRead != fr.bodyLength {
+		return frameErrorf("body length mismatch: declared %d, got %d", fr.bodyLength, fr.bodyRead)
+	}
+	switch fr.state {
+	case stateRequestID:
+		if len(fr.requestID) == 0 {
+			return frameErrorf("invalid frame format: empty field")
+		}
+		return frameErrorf("invalid body format")
+	case stateCode:
+		if len(fr.code) == 0 {
+			return frameErrorf("invalid frame format: empty field")
+		}
+	case statePayload:
+		if len(fr.payload) == 0 && fr.nquad == 0 {
+			return frameErrorf("invalid frame format: empty field")
+		}
+		if fr.nquad != 0 {
+			return frameErrorf("invalid payload encoding: truncated")
+		}
+	}
+	if fr.crc != fr.checksum {
+		return frameErrorf("checksum mismatch")
+	}
+	return nil
+}
+
+// hexValue returns the value of a lower-case hex digit, or -1
+func hexValue(b byte) int {
+	switch {
+	case b >= '0' && b <= '9':
+		return int(b - '0')
+	case b >= 'a' && b <= 'f':
+		return int(b-'a') + 10
+	}
+	return -1
+}
+
+// isBase64Char reports whether b is in the standard BASE64 alphabet
+func isBase64Char(b byte) bool {
+	return b >= 'A' && b <= 'Z' || b >= 'a' && b <= 'z' || b >= '0' && b <= '9' || b == '+' || b == '/'
+}
@@ -0,0 +1,241 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Clients on a hot path encode and parse frames in reusable buffers, with
+// Encoder, AppendFrame and Decoder.DecodeRaw, rather than through the Frame
+// type, keeping a request down to the allocation of the value it returns.
+// Frame remains the convenient form for servers and tests.
+
+// strictBase64 rejects encodings with non-zero padding bits. Together with
+// rejecting CR and LF, which the decoder skips, it accepts only the
+// canonical encoding of a payload.
+var strictBase64 = base64.StdEncoding.Strict()
+
+const hexDigits = "0123456789abcdef"
+
+// AppendFrame appends the wire form of a frame, including the trailing
+// newline, to dst. The payload is BASE64 encoded; an empty payload is omitted.
+func AppendFrame(dst []byte, requestID, code string, payload []byte) []byte {
+	bodyLen := len(requestID) + 1 + len(code)
+	if len(payload) > 0 {
+		bodyLen += 1 + base64.StdEncoding.EncodedLen(len(payload))
+	}
+
+	dst = append(dst, Prefix...)
+	dst = strconv.AppendInt(dst, int64(bodyLen), 10)
+	dst = append(dst, ' ')
+	sumAt := len(dst)
+	dst = append(dst, "00000000 "...)
+	bodyAt := len(dst)
+	dst = append(dst, requestID...)
+	dst = append(dst, ' ')
+	dst = append(dst, code...)
+	if len(payload) > 0 {
+		dst = append(dst, ' ')
+		dst = base64.StdEncoding.AppendEncode(dst, payload)
+	}
+	putHex32(dst[sumAt:sumAt+8], Checksum(dst[bodyAt:]))
+	return append(dst, '\n')
+}
+
+// putHex32 writes v to b as eight lower-case hex digits
+func putHex32(b []byte, v uint32) {
+	for i := 7; i >= 0; i-- {
+		b[i] = hexDigits[v&0xf]
+		v >>= 4
+	}
+}
+
+// RawFrame is a parsed frame whose fields point into buffers of the parser,
+// valid only until it parses the next frame
+type RawFrame struct {
+	RequestID  []byte
+	Code       []byte
+	Payload    []byte // decoded from BASE64
+	BodyLength int
+	Checksum   []byte // in wire form
+}
+
+// parseFrame parses a wire format frame, decoding the payload into buf,
+// which is returned grown as needed for reuse
+func parseFrame(line, buf []byte) (RawFrame, []byte, error) {
+	var f RawFrame
+	if !bytes.HasPrefix(line, []byte(Prefix)) {
+		return f, buf, fmt.Errorf("invalid frame prefix")
+	}
+	if len(line) > MaxLineLength {
+		return f, buf, fmt.Errorf("frame exceeds %d bytes", MaxLineLength)
+	}
+
+	// Remove prefix and trailing newline
+	trimmed := bytes.TrimSuffix(line[len(Prefix):], []byte("\n"))
+
+	// Split into at most five fields; a sixth marks too many
+	var parts [6][]byte
+	n := 0
+	for rest := trimmed; n < len(parts); n++ {
+		i := bytes.IndexByte(rest, ' ')
+		if i < 0 {
+			parts[n] = rest
+			n++
+			break
+		}
+		parts[n], rest = rest[:i], rest[i+1:]
+	}
+	if n < 3 {
+		return f, buf, fmt.Errorf("invalid frame format")
+	}
+	for _, part := range parts[:n] {
+		if len(part) == 0 {
+			return f, buf, fmt.Errorf("invalid frame format: empty field")
+		}
+	}
+
+	// Parse body length; only plain decimal digits are accepted
+	bodyLength := 0
+	for _, c := range parts[0] {
+		if c < '0' || c > '9' || bodyLength > MaxLineLength {
+			return f, buf, fmt.Errorf("invalid body length %q", parts[0])
+		}
+		bodyLength = bodyLength*10 + int(c-'0')
+	}
+
+	// Validate checksum format
+	checksum := parts[1]
+	if len(checksum) != 8 || len(bytes.Trim(checksum, hexDigits)) != 0 {
+		return f, buf, fmt.Errorf("invalid checksum format")
+	}
+
+	// Parse body fields: request ID, code and an optional payload
+	if n < 4 || n > 5 {
+		return f, buf, fmt.Errorf("invalid body format")
+	}
+	body := trimmed[len(parts[0])+len(parts[1])+2:]
+	if len(body) != bodyLength {
+		return f, buf, fmt.Errorf("body length mismatch: declared %d, got %d", bodyLength, len(body))
+	}
+	f.BodyLength = bodyLength
+	f.RequestID = parts[2]
+	f.Code = parts[3]
+
+	// Parse payload if present
+	if n == 5 {
+		encoded := parts[4]
+		if bytes.ContainsAny(encoded, "\r\n") {
+			return f, buf, fmt.Errorf("invalid payload encoding: not canonical")
+		}
+		if size := base64.StdEncoding.DecodedLen(len(encoded)); cap(buf) < size {
+			buf = make([]byte, size)
+		}
+		decoded, err := strictBase64.Decode(buf[:cap(buf)], encoded)
+		if err != nil {
+			return f, buf, fmt.Errorf("invalid payload encoding: %w", err)
+		}
+		f.Payload = buf[:decoded]
+	}
+
+	// Verify checksum; the payload is canonical, so the body on the wire is
+	// exactly the body the checksum covers
+	var sum [8]byte
+	putHex32(sum[:], Checksum(body))
+	if !bytes.Equal(sum[:], checksum) {
+		return f, buf, fmt.Errorf("checksum mismatch")
+	}
+	f.Checksum = checksum
+	return f, buf, nil
+}
+
+// Frame is a frame in a convenient form for servers and tests
+type Frame struct {
+	RequestID    string
+	Code         string
+	Payload      []byte // Raw payload bytes (not BASE64 encoded)
+	BodyLength   int
+	BodyChecksum string
+}
+
+// NewFrame creates a frame with the given request ID, code and payload,
+// filling in its body length and checksum. A response carries the request
+// ID of the request it answers.
+func NewFrame(requestID, code string, payload []byte) *Frame {
+	f := &Frame{
+		RequestID: requestID,
+		Code:      strings.ToUpper(code),
+		Payload:   payload,
+	}
+	f.updateBodyMetadata()
+	return f
+}
+
+// updateBodyMetadata calculates body length and checksum
+func (f *Frame) updateBodyMetadata() {
+	body := f.buildBodyString()
+	f.BodyLength = len(body)
+	f.BodyChecksum = fmt.Sprintf("%08x", Checksum([]byte(body)))
+}
+
+// buildBodyString constructs the body string for checksum calculation
+func (f *Frame) buildBodyString() string {
+	parts := []string{f.RequestID, f.Code}
+	if len(f.Payload) > 0 {
+		parts = append(parts, base64.StdEncoding.EncodeToString(f.Payload))
+	}
+	return strings.Join(parts, " ")
+}
+
+// Encode converts frame to wire format
+func (f *Frame) Encode() string {
+	body := f.buildBodyString()
+	b := make([]byte, 0, len(Prefix)+len(f.BodyChecksum)+len(body)+12)
+	b = append(b, Prefix...)
+	b = strconv.AppendInt(b, int64(f.BodyLength), 10)
+	b = append(b, ' ')
+	b = append(b, f.BodyChecksum...)
+	b = append(b, ' ')
+	b = append(b, body...)
+	b = append(b, '\n')
+	return string(b)
+}
+
+// ParseFrame parses a wire format frame
+func ParseFrame(data string) (*Frame, error) {
+	raw, _, err := parseFrame([]byte(data), nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Frame{
+		BodyLength:   raw.BodyLength,
+		BodyChecksum: string(raw.Checksum),
+		RequestID:    string(raw.RequestID),
+		Code:         string(raw.Code),
+		Payload:      raw.Payload,
+	}, nil
+}
+
+// Encoder writes frames to a stream, encoding each in a buffer reused
+// across frames
+type Encoder struct {
+	w   io.Writer
+	buf []byte
+}
+
+// NewEncoder returns an Encoder writing to w. Frames are written with one
+// call to w each; a buffered w must be flushed to send them.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Encode writes the frame with the given request ID, code and payload
+func (e *Encoder) Encode(requestID, code string, payload []byte) error {
+	e.buf = AppendFrame(e.buf[:0], requestID, code, payload)
+	_, err := e.w.Write(e.buf)
+	return err
+}
@@ -0,0 +1,137 @@
+// Package protocol implements the wire format of the SmartOS metadata
+// protocol, version 2, without a client: frame encoding and parsing, the
+// negotiation that starts a session, and the request and response codes.
+// The mdata client, the mdataserver server and proxies are built on it, and
+// other implementations of either side can reuse it the same way.
+//
+// A frame is one line:
+//
+//	V2 BODY_LENGTH CHECKSUM REQUEST_ID CODE [PAYLOAD]\n
+//
+// where the body is everything after the checksum, BODY_LENGTH its length in
+// decimal, CHECKSUM its CRC-32 as eight lower-case hex digits and PAYLOAD,
+// when present, BASE64 encoded. Encoder and AppendFrame write frames, and
+// Decoder and ParseFrame read them.
+package protocol
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"hash/crc32"
+)
+
+// Protocol constants
+const (
+	// Prefix starts every frame
+	Prefix = "V2 "
+	// NegotiationReq is sent by a client to start a version 2 session, and
+	// answered with NegotiationResp by servers that support it
+	NegotiationReq  = "NEGOTIATE V2\n"
+	NegotiationResp = "V2_OK\n"
+	// AuthRequired answers negotiation when the server requires the client
+	// to authenticate first
+	AuthRequired  = "AUTH_REQUIRED\n"
+	CRCPolynomial = 0xEDB88320
+	// MaxLineLength bounds a frame or any other protocol line, so a peer
+	// cannot make the other side buffer an unbounded amount of data
+	MaxLineLength = 16 << 20
+	// MaxNegotiationLength bounds negotiation and authentication replies
+	MaxNegotiationLength = 1024
+)
+
+// Request codes
+const (
+	CodeGet    = "GET"
+	CodeKeys   = "KEYS"
+	CodePut    = "PUT"
+	CodeDelete = "DELETE"
+	// CodeGetIfChanged and CodeWatch are extensions served by mdataserver
+	CodeGetIfChanged = "GETIFCHANGED"
+	CodeWatch        = "WATCH"
+)
+
+// Response codes
+const (
+	CodeSuccess  = "SUCCESS"
+	CodeNotFound = "NOTFOUND"
+	CodeFailure  = "FAILURE"
+	// CodeNotModified answers a GETIFCHANGED request for an unchanged value
+	CodeNotModified = "NOTMODIFIED"
+)
+
+var (
+	// ErrLineTooLong is returned when a peer sends a line longer than allowed
+	ErrLineTooLong = errors.New("protocol line too long")
+	// ErrAuthRequired is returned when the server requires authentication but no secret is configured
+	ErrAuthRequired = errors.New("server requires authentication")
+)
+
+// crcTable is the CRC-32 table for CRCPolynomial
+var crcTable = crc32.MakeTable(CRCPolynomial)
+
+// Checksum returns the CRC-32 of data used by the protocol
+func Checksum(data []byte) uint32 {
+	return crc32.Checksum(data, crcTable)
+}
+
+// AppendHex32 appends v to dst as eight lower-case hex digits, the form of
+// checksums and request IDs on the wire
+func AppendHex32(dst []byte, v uint32) []byte {
+	n := len(dst)
+	dst = append(dst, "00000000"...)
+	putHex32(dst[n:], v)
+	return dst
+}
+
+// Negotiate performs the client side of V2 protocol negotiation, reporting
+// whether the server supports the protocol
+func Negotiate(conn *bufio.ReadWriter) (bool, error) {
+	// Send negotiation request
+	if _, err := conn.WriteString(NegotiationReq); err != nil {
+		return false, fmt.Errorf("failed to send negotiation: %w", err)
+	}
+	if err := conn.Flush(); err != nil {
+		return false, fmt.Errorf("failed to flush negotiation: %w", err)
+	}
+
+	// Read response
+	resp, err := ReadLine(conn.Reader, MaxNegotiationLength)
+	if err != nil {
+		return false, fmt.Errorf("failed to read negotiation response: %w", err)
+	}
+	if resp == AuthRequired {
+		return false, ErrAuthRequired
+	}
+
+	return resp == NegotiationResp, nil
+}
+
+// ReadLine reads up to and including the next newline, failing with
+// ErrLineTooLong instead of buffering more than max bytes
+func ReadLine(r *bufio.Reader, max int) (string, error) {
+	line, _, err := readLine(r, max, nil)
+	return string(line), err
+}
+
+// readLine reads up to and including the next newline. Lines that fit the
+// reader's buffer are returned without copying and are only valid until the
+// next read; longer lines are assembled in scratch, which is returned for reuse.
+func readLine(r *bufio.Reader, max int, scratch []byte) ([]byte, []byte, error) {
+	line, err := r.ReadSlice('\n')
+	if err != bufio.ErrBufferFull {
+		if len(line) > max {
+			return nil, scratch, ErrLineTooLong
+		}
+		return line, scratch, err
+	}
+	scratch = append(scratch[:0], line...)
+	for err == bufio.ErrBufferFull {
+		line, err = r.ReadSlice('\n')
+		if len(scratch)+len(line) > max {
+			return nil, scratch, ErrLineTooLong
+		}
+		scratch = append(scratch, line...)
+	}
+	return scratch, scratch, err
+}
@@ -0,0 +1,103 @@
+package mdata
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// rateLimitedClient delays requests to keep to a rate
+type rateLimitedClient struct {
+	MetadataClient
+
+	mu       sync.Mutex
+	interval time.Duration // time to earn one token
+	burst    float64
+	tokens   float64
+	last     time.Time
+}
+
+// NewRateLimitedClient wraps inner so that it sends at most rate requests per
+// second on average, waiting as needed before each one. Up to rate requests,
+// and at least one, may be sent back to back after a quiet period. The
+// metadata channel of an instance is shared by every consumer on it, so this
+// keeps a tight loop in one of them from starving the others.
+//
+// Calls making several requests, such as KeysInfo and All, are limited per
+// request. A rate of zero or less disables the limit.
+func NewRateLimitedClient(inner MetadataClient, rate float64) MetadataClient {
+	if rate <= 0 {
+		return inner
+	}
+	burst := math.Max(1, math.Floor(rate))
+	return &rateLimitedClient{
+		MetadataClient: inner,
+		interval:       time.Duration(float64(time.Second) / rate),
+		burst:          burst,
+		tokens:         burst,
+		last:           time.Now(),
+	}
+}
+
+// wait blocks until a request may be sent and takes its token
+func (c *rateLimitedClient) wait() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	c.tokens = math.Min(c.burst, c.tokens+float64(now.Sub(c.last))/float64(c.interval))
+	c.last = now
+	if c.tokens < 1 {
+		delay := time.Duration((1 - c.tokens) * float64(c.interval))
+		time.Sleep(delay)
+		c.tokens = 1
+		c.last = now.Add(delay)
+	}
+	c.tokens--
+}
+
+// Get implements MetadataClient.Get
+func (c *rateLimitedClient) Get(key string) (string, error) {
+	c.wait()
+	return c.MetadataClient.Get(key)
+}
+
+// GetIfChanged implements ConditionalGetter
+func (c *rateLimitedClient) GetIfChanged(key, lastChecksum string) (string, string, error) {
+	c.wait()
+	return GetIfChanged(c.MetadataClient, key, lastChecksum)
+}
+
+// Keys implements MetadataClient.Keys
+func (c *rateLimitedClient) Keys() (string, error) {
+	c.wait()
+	return c.MetadataClient.Keys()
+}
+
+// Put implements MetadataClient.Put
+func (c *rateLimitedClient) Put(key, value string) error {
+	c.wait()
+	return c.MetadataClient.Put(key, value)
+}
+
+// Delete implements MetadataClient.Delete
+func (c *rateLimitedClient) Delete(key string) error {
+	c.wait()
+	return c.MetadataClient.Delete(key)
+}
+
+// Watch implements Watcher
+func (c *rateLimitedClient) Watch(prefix, state string, timeout time.Duration) (string, error) {
+	c.wait()
+	return watch(c.MetadataClient, prefix, state, timeout)
+}
+
+// SendRaw implements RawSender
+func (c *rateLimitedClient) SendRaw(code string, payload []byte) (ResponseCode, []byte, error) {
+	c.wait()
+	return sendRaw(c.MetadataClient, code, payload)
+}
+
+// unwrap implements wrapper
+func (c *rateLimitedClient) unwrap() MetadataClient {
+	return c.MetadataClient
+}
@@ -0,0 +1,65 @@
+package mdata
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ResponseCode is the code of a protocol response, such as
+// protocol.CodeSuccess or a code of a platform extension
+type ResponseCode string
+
+// RawSender is implemented by clients that can send requests with any code,
+// such as MetadataClientImpl
+type RawSender interface {
+	SendRaw(code string, payload []byte) (ResponseCode, []byte, error)
+}
+
+// sendRaw sends a raw request through c, failing for clients that cannot
+// send one
+func sendRaw(c MetadataClient, code string, payload []byte) (ResponseCode, []byte, error) {
+	s, ok := c.(RawSender)
+	if !ok {
+		return "", nil, fmt.Errorf("client %T does not send raw requests", c)
+	}
+	return s.SendRaw(code, payload)
+}
+
+// SendRaw sends a request with any code and payload and returns the code and
+// payload of the response as they are, for protocol codes the client does not
+// model yet, such as extensions under development on the platform side:
+//
+//	code, payload, err := client.(mdata.RawSender).SendRaw("PING", nil)
+//
+// The payload is sent BASE64 encoded like any other, and the returned one is
+// decoded and owned by the caller. Any response is returned without an error,
+// including NOTFOUND and FAILURE; the error reports a request that could not
+// be sent or answered. Raw requests bypass middleware and auditing, and are
+// not replayed after a reconnect, since the client cannot know whether they
+// change anything, unless their code was registered as idempotent with
+// RegisterExtension.
+func (c *MetadataClientImpl) SendRaw(code string, payload []byte) (ResponseCode, []byte, error) {
+	if code == "" || strings.ContainsFunc(code, isSpaceOrControl) {
+		return "", nil, fmt.Errorf("invalid request code %q", code)
+	}
+	resp, err := c.exchangeRaw(code, payload)
+	if err != nil && c.replays(code, err) {
+		if rerr := c.reconnect(); rerr != nil {
+			return "", nil, &TransportError{Err: fmt.Errorf("%w (reconnecting failed: %v)", err, rerr)}
+		}
+		resp, err = c.exchangeRaw(code, payload)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	var value []byte
+	if len(resp.Payload) > 0 {
+		value = append([]byte(nil), resp.Payload...)
+	}
+	return ResponseCode(resp.Code), value, nil
+}
+
+// isSpaceOrControl reports whether r cannot appear in a request code
+func isSpaceOrControl(r rune) bool {
+	return r <= ' ' || r == 0x7f
+}
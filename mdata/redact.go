@@ -0,0 +1,64 @@
+package mdata
+
+import (
+	"os"
+	"path"
+	"strings"
+)
+
+// Redacted replaces the value of a secret key in output meant for people
+const Redacted = "[REDACTED]"
+
+// DefaultSecretPatterns match the keys usually holding secrets
+var DefaultSecretPatterns = []string{
+	"*password*",
+	"*passwd*",
+	"*secret*",
+	"*token*",
+	"*credential*",
+	"*private_key*",
+	"*private-key*",
+	"*api_key*",
+	"*api-key*",
+}
+
+// Redactor masks the values of keys matching any of its path.Match patterns,
+// compared case-insensitively. Malformed patterns match nothing.
+type Redactor struct {
+	Patterns []string
+}
+
+// DefaultRedactor returns a Redactor using the comma-separated patterns in
+// MDATA_SECRET_PATTERNS, or DefaultSecretPatterns when it is unset
+func DefaultRedactor() Redactor {
+	if env, ok := os.LookupEnv("MDATA_SECRET_PATTERNS"); ok {
+		var patterns []string
+		for _, p := range strings.Split(env, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				patterns = append(patterns, p)
+			}
+		}
+		return Redactor{Patterns: patterns}
+	}
+	return Redactor{Patterns: DefaultSecretPatterns}
+}
+
+// IsSecret reports whether key matches one of the patterns
+func (r Redactor) IsSecret(key string) bool {
+	key = strings.ToLower(key)
+	for _, pattern := range r.Patterns {
+		if ok, _ := path.Match(strings.ToLower(pattern), key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Value returns Redacted in place of the value of a secret key, and value
+// itself otherwise
+func (r Redactor) Value(key, value string) string {
+	if r.IsSecret(key) {
+		return Redacted
+	}
+	return value
+}
@@ -0,0 +1,145 @@
+package mdata
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/Smithx10/go-smartos-mdata/internal/jsonschema"
+)
+
+// SchemaViolation is one way a value fails its schema
+type SchemaViolation struct {
+	Path    string // JSON Pointer to the offending part of the value; empty for the value itself
+	Message string
+}
+
+// SchemaError reports a value that does not satisfy the schema registered
+// for its key
+type SchemaError struct {
+	Key        string
+	Pattern    string // the key or prefix pattern the schema was registered for
+	Violations []SchemaViolation
+}
+
+func (e *SchemaError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "mdata: value of %s does not match schema %s:", e.Key, e.Pattern)
+	for _, v := range e.Violations {
+		b.WriteString("\n  ")
+		if v.Path != "" {
+			b.WriteString(v.Path + ": ")
+		}
+		b.WriteString(v.Message)
+	}
+	return b.String()
+}
+
+// Schemas maps keys to the JSON Schemas their values must satisfy. It is safe
+// for concurrent use; the zero value has no schemas.
+type Schemas struct {
+	mu      sync.RWMutex
+	schemas map[string]*jsonschema.Schema
+}
+
+// Register compiles schema, a JSON Schema document, and applies it to the
+// keys matching pattern: a key, or a prefix followed by "*" such as "app:*".
+// When several patterns match, the key itself wins over prefixes and longer
+// prefixes win over shorter ones. Registering a pattern again replaces its
+// schema.
+//
+// The supported keywords are type, enum, const, properties, required,
+// additionalProperties, items, minItems, maxItems, minLength, maxLength,
+// pattern, minimum, maximum, exclusiveMinimum, exclusiveMaximum, multipleOf,
+// allOf, anyOf, oneOf and not; others, including $ref, are ignored.
+func (s *Schemas) Register(pattern string, schema []byte) error {
+	compiled, err := jsonschema.Compile(schema)
+	if err != nil {
+		return fmt.Errorf("schema for %s: %w", pattern, err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.schemas == nil {
+		s.schemas = make(map[string]*jsonschema.Schema)
+	}
+	s.schemas[pattern] = compiled
+	return nil
+}
+
+// lookup returns the schema applying to key and its pattern
+func (s *Schemas) lookup(key string) (*jsonschema.Schema, string) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if schema, ok := s.schemas[key]; ok && !strings.HasSuffix(key, "*") {
+		return schema, key
+	}
+	var best string
+	for pattern := range s.schemas {
+		prefix, ok := strings.CutSuffix(pattern, "*")
+		if ok && strings.HasPrefix(key, prefix) && len(pattern) > len(best) {
+			best = pattern
+		}
+	}
+	if best == "" {
+		return nil, ""
+	}
+	return s.schemas[best], best
+}
+
+// Validate checks value, which must be JSON, against the schema registered for
+// key. Keys without a schema are not checked. A value that is not JSON or
+// fails the schema yields a *SchemaError.
+func (s *Schemas) Validate(key, value string) error {
+	schema, pattern := s.lookup(key)
+	if schema == nil {
+		return nil
+	}
+	var decoded any
+	if err := json.Unmarshal([]byte(value), &decoded); err != nil {
+		return &SchemaError{Key: key, Pattern: pattern, Violations: []SchemaViolation{{Message: "invalid JSON: " + err.Error()}}}
+	}
+	violations := schema.Validate(decoded)
+	if len(violations) == 0 {
+		return nil
+	}
+	err := &SchemaError{Key: key, Pattern: pattern}
+	for _, v := range violations {
+		err.Violations = append(err.Violations, SchemaViolation{Path: v.Path, Message: v.Message})
+	}
+	return err
+}
+
+// PutJSON encodes v as JSON and writes it to key, after validating it against
+// the schema registered for key in schemas. A nil schemas skips validation.
+func PutJSON(c MetadataClient, schemas *Schemas, key string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %w", key, err)
+	}
+	if schemas != nil {
+		if err := schemas.Validate(key, string(data)); err != nil {
+			return err
+		}
+	}
+	return c.Put(key, string(data))
+}
+
+// GetJSON reads key and decodes its JSON value into v. With non-nil schemas,
+// the value is first validated against the schema registered for key, so that
+// malformed configuration is reported rather than half-applied.
+func GetJSON(c MetadataClient, schemas *Schemas, key string, v any) error {
+	value, err := c.Get(key)
+	if err != nil {
+		return err
+	}
+	if schemas != nil {
+		if err := schemas.Validate(key, value); err != nil {
+			return err
+		}
+	}
+	if err := json.Unmarshal([]byte(value), v); err != nil {
+		return fmt.Errorf("failed to decode %s: %w", key, err)
+	}
+	return nil
+}
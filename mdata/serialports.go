@@ -0,0 +1,85 @@
+package mdata
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+)
+
+// SerialAuto as the serial port name selects the first serial port that
+// answers protocol negotiation among the candidates of the platform
+const SerialAuto = "auto"
+
+// VirtioChannel is the name of the virtio-serial channel carrying the
+// metadata service on hypervisors that do not emulate a UART for it
+const VirtioChannel = "org.smartos.metadata"
+
+// serialProbeTimeout bounds the wait for each port to answer negotiation
+const serialProbeTimeout = 2 * time.Second
+
+// probeSerialPorts returns the first of the candidate ports of config, or
+// of the platform if it has none, other than skip, that negotiates the V2
+// protocol. Each candidate is opened and closed again, so the caller opens
+// the chosen port with its own settings.
+func probeSerialPorts(config *SerialConfig, skip string) (string, error) {
+	ports := slices.Clone(config.Candidates)
+	if len(ports) == 0 {
+		ports = serialCandidates()
+	}
+	ports = slices.DeleteFunc(ports, func(name string) bool { return name == skip })
+	if len(ports) == 0 {
+		return "", fmt.Errorf("no serial ports to probe")
+	}
+	for _, name := range ports {
+		probe := *config
+		probe.Name = name
+		probe.ReadTimeout = min(config.ReadTimeout, serialProbeTimeout)
+		if probe.ReadTimeout <= 0 {
+			probe.ReadTimeout = serialProbeTimeout
+		}
+		conn, err := openSerialPort(&probe)
+		if err != nil {
+			continue
+		}
+		c, err := newClientWithConn(conn, nil, true)
+		if err != nil {
+			continue
+		}
+		c.Close()
+		return name, nil
+	}
+	return "", fmt.Errorf("no metadata service answered on serial ports %v", ports)
+}
+
+// fallBackSerialPort is called when the serial port of config failed with
+// cause, before or during negotiation. Unless the port answered, it probes
+// the other candidate ports, switches config to the first that answers,
+// reporting it to the config's Logger, and returns that port opened.
+func fallBackSerialPort(config *ClientConfig, cause error) (Conn, error) {
+	if errors.Is(cause, ErrAuthFailed) || errors.Is(cause, ErrAuthRequired) {
+		return nil, cause
+	}
+	failed := config.SerialConfig.Name
+	name, err := probeSerialPorts(config.SerialConfig, failed)
+	if err != nil {
+		return nil, fmt.Errorf("%w (%v)", cause, err)
+	}
+	logf(config.Logger, "mdata: serial port %s did not answer; using %s", failed, name)
+	sc := *config.SerialConfig
+	sc.Name = name
+	config.SerialConfig = &sc
+	conn, err := openSerialPort(config.SerialConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open serial port %s: %w", name, err)
+	}
+	return conn, nil
+}
+
+// logf prints to l, if set
+func logf(l *log.Logger, format string, args ...any) {
+	if l != nil {
+		l.Printf(format, args...)
+	}
+}
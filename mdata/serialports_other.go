@@ -0,0 +1,65 @@
+//go:build !windows
+
+package mdata
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// serialCandidates lists the ports that may carry the metadata channel, in
+// the order they are probed: the virtio-serial channel named VirtioChannel,
+// the usual UARTs of the platform, then the other virtio-serial ports of the
+// guest
+func serialCandidates() []string {
+	var ports []string
+	if channel, ok := virtioChannel(VirtioChannel); ok {
+		ports = append(ports, channel)
+	}
+	switch runtime.GOOS {
+	case "solaris", "illumos":
+		ports = append(ports, "/dev/ttyb", "/dev/ttya")
+	default:
+		ports = append(ports, "/dev/ttyS1", "/dev/ttyS0")
+	}
+	named, _ := filepath.Glob("/dev/virtio-ports/*")
+	vports, _ := filepath.Glob("/dev/vport*")
+	return uniquePorts(append(append(ports, named...), vports...))
+}
+
+// virtioChannel returns the device of the virtio-serial channel called name:
+// the link udev makes to it, or else the port sysfs gives that name
+func virtioChannel(name string) (string, bool) {
+	link := filepath.Join("/dev/virtio-ports", name)
+	if _, err := os.Stat(link); err == nil {
+		return link, true
+	}
+	names, _ := filepath.Glob("/sys/class/virtio-ports/*/name")
+	for _, path := range names {
+		b, err := os.ReadFile(path)
+		if err == nil && strings.TrimSpace(string(b)) == name {
+			return filepath.Join("/dev", filepath.Base(filepath.Dir(path))), true
+		}
+	}
+	return "", false
+}
+
+// uniquePorts removes from ports those naming the same device as an earlier
+// one, such as /dev/vport0p1 after the /dev/virtio-ports link to it
+func uniquePorts(ports []string) []string {
+	seen := make(map[string]bool)
+	unique := ports[:0]
+	for _, port := range ports {
+		device, err := filepath.EvalSymlinks(port)
+		if err != nil {
+			device = port
+		}
+		if !seen[device] {
+			seen[device] = true
+			unique = append(unique, port)
+		}
+	}
+	return unique
+}
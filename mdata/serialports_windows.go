@@ -0,0 +1,59 @@
+//go:build windows
+
+package mdata
+
+import (
+	"slices"
+	"sort"
+
+	"golang.org/x/sys/windows/registry"
+)
+
+// serialCandidates lists the ports that may carry the metadata channel, in
+// the order they are probed: the COM ports in the SERIALCOMM device map,
+// which covers the emulated UARTs of KVM and bhyve guests whatever their
+// numbering, then COM1 to COM4 if the map lacks them
+func serialCandidates() []string {
+	ports := serialPorts()
+	for _, port := range []string{"COM1", "COM2", "COM3", "COM4"} {
+		if !slices.Contains(ports, port) {
+			ports = append(ports, port)
+		}
+	}
+	return ports
+}
+
+// virtioChannel returns the device of the virtio-serial channel called name.
+// Windows guests are not searched for one, as their virtio-serial driver does
+// not expose channels as COM ports.
+func virtioChannel(name string) (string, bool) {
+	return "", false
+}
+
+// serialPorts lists the COM ports in the SERIALCOMM device map, or none if it
+// cannot be read
+func serialPorts() []string {
+	k, err := registry.OpenKey(registry.LOCAL_MACHINE, `HARDWARE\DEVICEMAP\SERIALCOMM`, registry.QUERY_VALUE)
+	if err != nil {
+		return nil
+	}
+	defer k.Close()
+	names, err := k.ReadValueNames(0)
+	if err != nil {
+		return nil
+	}
+	var ports []string
+	for _, name := range names {
+		if port, _, err := k.GetStringValue(name); err == nil && port != "" {
+			ports = append(ports, port)
+		}
+	}
+	// COM1 before COM2 before COM10
+	sort.Slice(ports, func(i, j int) bool {
+		if len(ports[i]) != len(ports[j]) {
+			return len(ports[i]) < len(ports[j])
+		}
+		return ports[i] < ports[j]
+	})
+	return ports
+}
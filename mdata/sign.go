@@ -0,0 +1,90 @@
+package mdata
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// SignaturePrefix starts the line a signed value ends with, after a newline
+const SignaturePrefix = "mdsig:v1:"
+
+// ErrBadSignature is returned for a value that is unsigned or whose signature
+// does not verify with any of the client's keys
+var ErrBadSignature = errors.New("value signature is missing or invalid")
+
+// SigningKey is a named HMAC key. The ID is recorded with each signature so
+// keys can be rotated: values signed with a retired key keep verifying for as
+// long as the key is still configured.
+type SigningKey struct {
+	ID     string
+	Secret []byte
+}
+
+// NewSignedClient wraps inner so that values of keys starting with one of
+// prefixes are signed with an HMAC-SHA256 on Put and verified on Get; with no
+// prefixes, every key outside the sdc: namespace is. Get returns the value
+// without its signature, or ErrBadSignature if it does not verify, letting a
+// guest refuse configuration that was tampered with before acting on it.
+//
+// Values are signed with the first of keys and verified with the key named
+// by their signature. To rotate, put the new key first and keep the old one
+// until every value has been signed again.
+func NewSignedClient(inner MetadataClient, keys []SigningKey, prefixes ...string) (MetadataClient, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no signing keys")
+	}
+	for _, k := range keys {
+		if k.ID == "" || strings.ContainsAny(k.ID, ":\n") {
+			return nil, fmt.Errorf("invalid signing key ID %q", k.ID)
+		}
+		if len(k.Secret) == 0 {
+			return nil, fmt.Errorf("signing key %s is empty", k.ID)
+		}
+	}
+	return &codecClient{
+		MetadataClient: inner,
+		prefixes:       prefixes,
+		encode:         func(key, value string) string { return SignValue(keys[0], key, value) },
+		decode:         func(key, value string) (string, error) { return VerifyValue(keys, key, value) },
+	}, nil
+}
+
+// SignValue returns value with a signature line appended, for storing under
+// key. Operators can use it to sign configuration before pushing it.
+func SignValue(k SigningKey, key, value string) string {
+	return value + "\n" + SignaturePrefix + k.ID + ":" + valueMAC(k.Secret, key, value)
+}
+
+// VerifyValue checks the signature of a value stored under key against keys
+// and returns the value without it
+func VerifyValue(keys []SigningKey, key, signed string) (string, error) {
+	i := strings.LastIndexByte(signed, '\n')
+	if i < 0 {
+		return "", fmt.Errorf("%s: %w", key, ErrBadSignature)
+	}
+	value, sig := signed[:i], signed[i+1:]
+	id, mac, ok := strings.Cut(strings.TrimPrefix(sig, SignaturePrefix), ":")
+	if !ok || !strings.HasPrefix(sig, SignaturePrefix) {
+		return "", fmt.Errorf("%s: %w", key, ErrBadSignature)
+	}
+	for _, k := range keys {
+		if k.ID == id && hmac.Equal([]byte(mac), []byte(valueMAC(k.Secret, key, value))) {
+			return value, nil
+		}
+	}
+	return "", fmt.Errorf("%s: %w", key, ErrBadSignature)
+}
+
+// valueMAC authenticates value together with the key it is stored under, so
+// a signed value cannot be moved to another key
+func valueMAC(secret []byte, key, value string) string {
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte(key))
+	h.Write([]byte{0})
+	h.Write([]byte(value))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,41 @@
+package mdata
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// TagsKey is the key holding the instance tags as a JSON object
+const TagsKey = "sdc:tags"
+
+// Tags returns the instance tags. An instance without tags yields an empty map.
+func Tags(c MetadataClient) (map[string]string, error) {
+	raw, err := unprefixed(c).Get(TagsKey)
+	if errors.Is(err, ErrNotFound) {
+		return map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ParseTags(raw)
+}
+
+// ParseTags decodes the value of sdc:tags. Tags may be strings, numbers or
+// booleans; non-string values are returned in their JSON form, e.g. "true".
+func ParseTags(raw string) (map[string]string, error) {
+	var decoded map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(raw), &decoded); err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", TagsKey, err)
+	}
+	tags := make(map[string]string, len(decoded))
+	for name, value := range decoded {
+		var s string
+		if err := json.Unmarshal(value, &s); err == nil {
+			tags[name] = s
+		} else {
+			tags[name] = string(value)
+		}
+	}
+	return tags, nil
+}
@@ -0,0 +1,77 @@
+package mdata
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// Trace event kinds
+const (
+	// TraceNegotiate ends the authentication and negotiation of a connection
+	TraceNegotiate = "negotiate"
+	// TraceSend is a request written to the connection
+	TraceSend = "send"
+	// TraceRecv is the response to a request
+	TraceRecv = "recv"
+	// TraceRetry is a request about to be sent again after it failed
+	TraceRetry = "retry"
+	// TraceError is a request that failed without a response
+	TraceError = "error"
+)
+
+// TraceEvent records one step of the protocol exchanges of a client, for
+// finding where time goes, such as during a slow boot. Values are never
+// recorded, only their sizes.
+type TraceEvent struct {
+	Time  time.Time `json:"time"`
+	Event string    `json:"event"` // one of the Trace* kinds
+	// RequestID and Code are those of the request, and Code that of the
+	// response for TraceRecv events
+	RequestID string `json:"request_id,omitempty"`
+	Code      string `json:"code,omitempty"`
+	// Bytes is the size of the payload sent or received
+	Bytes int `json:"bytes,omitempty"`
+	// Duration is the time since the request was sent for TraceRecv and
+	// TraceError events, and the time negotiation took for TraceNegotiate
+	Duration float64 `json:"duration_seconds,omitempty"`
+	Error    string  `json:"error,omitempty"`
+}
+
+// TraceWriter returns a trace sink writing each event to w as a JSON line.
+// It may be called concurrently. Events that cannot be written are dropped.
+func TraceWriter(w io.Writer) func(TraceEvent) {
+	var mu sync.Mutex
+	return func(ev TraceEvent) {
+		line, err := json.Marshal(ev)
+		if err != nil {
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		w.Write(append(line, '\n'))
+	}
+}
+
+// trace reports an event to the client's trace sink, if any
+func (c *MetadataClientImpl) trace(ev TraceEvent) {
+	if c.traceSink == nil {
+		return
+	}
+	ev.Time = time.Now().UTC()
+	c.traceSink(ev)
+}
+
+// traceNegotiate reports the negotiation of a connection started at start
+// to sink, if set
+func traceNegotiate(sink func(TraceEvent), start time.Time, err error) {
+	if sink == nil {
+		return
+	}
+	ev := TraceEvent{Time: time.Now().UTC(), Event: TraceNegotiate, Duration: time.Since(start).Seconds()}
+	if err != nil {
+		ev.Error = err.Error()
+	}
+	sink(ev)
+}
@@ -0,0 +1,34 @@
+package mdata
+
+import "errors"
+
+// Keys holding cloud-init data, as read by cloud-init's SmartOS datasource
+const (
+	CloudInitUserDataKey = "cloud-init:user-data"
+	LegacyUserDataKey    = "user-data"
+	UserScriptKey        = "user-script"
+	VendorDataKey        = "sdc:vendor-data"
+)
+
+// UserData returns the user data of the instance: cloud-init:user-data,
+// falling back to the legacy user-data key and then to the user-script,
+// which cloud-init runs as a script. ErrNotFound is returned when none is
+// set.
+func UserData(c MetadataClient) (string, error) {
+	c = unprefixed(c)
+	for _, key := range []string{CloudInitUserDataKey, LegacyUserDataKey, UserScriptKey} {
+		value, err := c.Get(key)
+		if !errors.Is(err, ErrNotFound) {
+			return value, err
+		}
+	}
+	return "", ErrNotFound
+}
+
+// VendorData returns sdc:vendor-data, the vendor data cloud-init applies
+// before the user data, or ErrNotFound when it is unset. Without it the
+// datasource installs its own vendor data running the user-script on every
+// boot (see package mdatacloudinit).
+func VendorData(c MetadataClient) (string, error) {
+	return unprefixed(c).Get(VendorDataKey)
+}
@@ -0,0 +1,47 @@
+package mdata
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// VolumesKey is the key listing the volumes attached to the instance
+const VolumesKey = "sdc:volumes"
+
+// Volume is a network volume attached to the instance, such as a Triton NFS
+// volume
+type Volume struct {
+	Name       string `json:"name"`
+	Type       string `json:"type"`       // e.g. "tritonnfs"
+	NFSVolume  string `json:"nfsvolume"`  // the remote path as host:/path
+	Mountpoint string `json:"mountpoint"` // where the volume is mounted in the instance
+	Mode       string `json:"mode"`       // "rw" or "ro"
+}
+
+// ReadOnly reports whether the volume is to be mounted read-only
+func (v Volume) ReadOnly() bool {
+	return v.Mode == "ro"
+}
+
+// Volumes returns the volumes attached to the instance. An instance without
+// volumes yields none.
+func Volumes(c MetadataClient) ([]Volume, error) {
+	raw, err := unprefixed(c).Get(VolumesKey)
+	if errors.Is(err, ErrNotFound) {
+		return []Volume{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ParseVolumes(raw)
+}
+
+// ParseVolumes decodes the value of sdc:volumes
+func ParseVolumes(raw string) ([]Volume, error) {
+	var vols []Volume
+	if err := json.Unmarshal([]byte(raw), &vols); err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", VolumesKey, err)
+	}
+	return vols, nil
+}
@@ -0,0 +1,94 @@
+package mdata
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Delays between attempts of WaitForMetadata, doubling from the first to the last
+const (
+	waitInitialDelay = 100 * time.Millisecond
+	waitMaxDelay     = 5 * time.Second
+)
+
+// WaitForMetadata waits until the metadata service answers, retrying with
+// backoff until ctx is done. Services starting early in boot call it to
+// avoid racing the metadata service: the zone socket may not exist yet, or
+// the host may not yet be listening on the serial port. The transport is
+// detected afresh as by DefaultClientConfig on every attempt, as a zone
+// whose socket has not appeared would otherwise be taken for a hardware VM.
+//
+// It returns early with the error of an attempt that cannot succeed by
+// waiting, such as ErrGlobalZone, an *UnsupportedPlatformError or an
+// authentication failure.
+func WaitForMetadata(ctx context.Context) error {
+	return waitForMetadata(ctx, DefaultClientConfig)
+}
+
+// WaitForMetadataConfig is WaitForMetadata with a fixed client configuration
+func WaitForMetadataConfig(ctx context.Context, config ClientConfig) error {
+	return waitForMetadata(ctx, func() ClientConfig { return config })
+}
+
+func waitForMetadata(ctx context.Context, configFn func() ClientConfig) error {
+	delay := waitInitialDelay
+	for {
+		err := tryMetadata(ctx, configFn())
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return fmt.Errorf("gave up waiting for the metadata service: %w (last error: %v)", ctx.Err(), err)
+		}
+		if permanentWaitError(err) {
+			return err
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("gave up waiting for the metadata service: %w (last error: %v)", ctx.Err(), err)
+		case <-timer.C:
+		}
+		delay = min(2*delay, waitMaxDelay)
+	}
+}
+
+// tryMetadata connects once with config, giving up when ctx is done. A
+// Unix socket that does not exist yet is reported without dialing.
+func tryMetadata(ctx context.Context, config ClientConfig) error {
+	if config.Transport == transportUnix && config.SocketConfig != nil {
+		if _, err := os.Stat(config.SocketConfig.Address); err != nil {
+			return fmt.Errorf("metadata socket not available: %w", err)
+		}
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		client, err := NewMetadataClient(config)
+		if err == nil {
+			client.Close()
+		}
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		// The attempt is abandoned; it closes its client when it completes
+		return ctx.Err()
+	}
+}
+
+// permanentWaitError reports whether err is not cured by waiting
+func permanentWaitError(err error) bool {
+	var unsupported *UnsupportedPlatformError
+	return errors.Is(err, ErrGlobalZone) ||
+		errors.Is(err, ErrAuthFailed) ||
+		errors.Is(err, ErrAuthRequired) ||
+		errors.As(err, &unsupported)
+}
@@ -0,0 +1,129 @@
+package mdata
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Smithx10/go-smartos-mdata/mdata/protocol"
+)
+
+// WatchCode is the request code of the WATCH protocol extension, which lets
+// a client wait for metadata to change instead of polling for it. The
+// request payload is
+//
+//	TIMEOUT_MS SP STATE SP BASE64(PREFIX)
+//
+// where STATE is the StateDigest of the keys starting with PREFIX as last
+// seen by the client, or empty when it has none. The server holds the request
+// until the state of those keys differs from STATE, or for at most TIMEOUT_MS,
+// and answers SUCCESS with the current state as payload. Servers without the
+// extension answer with a failure, which clients take as the cue to poll.
+const WatchCode = protocol.CodeWatch
+
+// ErrWatchUnsupported is returned by Watch when the server does not
+// implement the WATCH extension
+var ErrWatchUnsupported = errors.New("server does not support WATCH")
+
+// Watcher is implemented by clients that can send WATCH requests, such as
+// MetadataClientImpl
+type Watcher interface {
+	Watch(prefix, state string, timeout time.Duration) (string, error)
+}
+
+// watch sends a WATCH request through c, failing with ErrWatchUnsupported
+// for clients that cannot send one
+func watch(c MetadataClient, prefix, state string, timeout time.Duration) (string, error) {
+	w, ok := c.(Watcher)
+	if !ok {
+		return "", ErrWatchUnsupported
+	}
+	return w.Watch(prefix, state, timeout)
+}
+
+// StateDigest identifies a set of keys and their values: it changes when any
+// of them is added, changed or removed
+func StateDigest(values map[string]string) string {
+	h := sha256.New()
+	for _, key := range slices.Sorted(maps.Keys(values)) {
+		h.Write([]byte(key))
+		h.Write([]byte{0})
+		h.Write([]byte(values[key]))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Watch sends a WATCH request, waiting up to timeout for the keys starting
+// with prefix to differ from state, and returns their current state. Once the
+// server has answered FAILURE, as servers without the extension do, Watch
+// fails with ErrWatchUnsupported without asking again; other errors leave the
+// extension in use. Over a serial port, keep timeout below the port's read
+// timeout.
+func (c *MetadataClientImpl) Watch(prefix, state string, timeout time.Duration) (string, error) {
+	if c.watchUnsupported {
+		return "", ErrWatchUnsupported
+	}
+	// The server stays silent while it holds the request
+	if c.readTimeout > 0 {
+		if err := c.conn.SetReadTimeout(timeout + c.readTimeout); err != nil {
+			return "", &TransportError{Err: fmt.Errorf("failed to set read timeout: %w", err)}
+		}
+		defer c.conn.SetReadTimeout(c.readTimeout)
+	}
+
+	c.reqBuf = strconv.AppendInt(c.reqBuf[:0], timeout.Milliseconds(), 10)
+	c.reqBuf = append(c.reqBuf, ' ')
+	c.reqBuf = append(c.reqBuf, state...)
+	c.reqBuf = append(c.reqBuf, ' ')
+	c.reqBuf = appendEncodeString(c.reqBuf, prefix)
+	current, err := c.roundTrip(WatchCode, prefix, c.reqBuf)
+	if errors.Is(err, ErrFailure) {
+		c.watchUnsupported = true
+		return "", ErrWatchUnsupported
+	}
+	if err != nil {
+		return "", err
+	}
+	return string(current), nil
+}
+
+// WaitForChange waits up to timeout for the keys starting with prefix to
+// differ from state, as returned by an earlier call, and returns their
+// current state. An empty state returns at once. It uses the WATCH extension
+// when the server supports it and otherwise reads the keys every interval.
+func WaitForChange(c MetadataClient, prefix, state string, timeout, interval time.Duration) (string, error) {
+	current, err := watch(c, prefix, state, timeout)
+	if !errors.Is(err, ErrWatchUnsupported) {
+		return current, err
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		current, err := prefixState(c, prefix)
+		if err != nil || current != state || !time.Now().Before(deadline) {
+			return current, err
+		}
+		time.Sleep(min(interval, time.Until(deadline)))
+	}
+}
+
+// prefixState reads the keys starting with prefix and returns their StateDigest
+func prefixState(c MetadataClient, prefix string) (string, error) {
+	values := make(map[string]string)
+	all, errf := All(c)
+	for key, value := range all {
+		if strings.HasPrefix(key, prefix) {
+			values[key] = value
+		}
+	}
+	if err := errf(); err != nil {
+		return "", err
+	}
+	return StateDigest(values), nil
+}
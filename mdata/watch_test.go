@@ -0,0 +1,179 @@
+package mdata_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Smithx10/go-smartos-mdata/mdata"
+	"github.com/Smithx10/go-smartos-mdata/mdatatest"
+)
+
+// codeLog records the codes of the requests sent through a client. With
+// legacy set it sends WATCH and GETIFCHANGED requests under a code the server
+// does not know, which it answers with FAILURE like a server predating them.
+type codeLog struct {
+	codes  []string
+	legacy bool
+}
+
+func (l *codeLog) middleware(next mdata.RoundTripper) mdata.RoundTripper {
+	return mdata.RoundTripperFunc(func(req *mdata.Request) ([]byte, error) {
+		l.codes = append(l.codes, req.Code)
+		if l.legacy && (req.Code == mdata.WatchCode || req.Code == mdata.GetIfChangedCode) {
+			req.Code = "UNKNOWN"
+		}
+		return next.RoundTrip(req)
+	})
+}
+
+// count returns how many requests with code were sent
+func (l *codeLog) count(code string) int {
+	n := 0
+	for _, c := range l.codes {
+		if c == code {
+			n++
+		}
+	}
+	return n
+}
+
+// startLoggedClient starts a server and returns it with a client whose
+// requests go through log
+func startLoggedClient(t *testing.T, log *codeLog) (*mdatatest.Server, mdata.MetadataClient) {
+	t.Helper()
+	srv, _ := mdatatest.StartServer(t)
+	client, err := mdata.NewMetadataClient(srv.Config.WithMiddleware(log.middleware))
+	if err != nil {
+		t.Fatalf("NewMetadataClient: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return srv, client
+}
+
+func TestWatch(t *testing.T) {
+	log := &codeLog{}
+	srv, client := startLoggedClient(t, log)
+	other := srv.NewClient()
+	w := client.(mdata.Watcher)
+
+	state, err := w.Watch("app:", "", time.Second)
+	if err != nil {
+		t.Fatalf("Watch without state: %v", err)
+	}
+	if want := mdata.StateDigest(map[string]string{}); state != want {
+		t.Fatalf("Watch without state = %s, want %s", state, want)
+	}
+	if current, err := w.Watch("app:", state, 20*time.Millisecond); err != nil || current != state {
+		t.Fatalf("Watch without changes = %s, %v, want %s", current, err, state)
+	}
+	if err := other.Put("app:port", "8080"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	current, err := w.Watch("app:", state, 5*time.Second)
+	if want := mdata.StateDigest(map[string]string{"app:port": "8080"}); err != nil || current != want {
+		t.Fatalf("Watch after Put = %s, %v, want %s", current, err, want)
+	}
+	if n := log.count(mdata.WatchCode); n != 3 {
+		t.Errorf("WATCH sent %d times, want 3", n)
+	}
+}
+
+func TestWatchWithoutExtension(t *testing.T) {
+	log := &codeLog{legacy: true}
+	srv, client := startLoggedClient(t, log)
+	other := srv.NewClient()
+
+	for range 2 {
+		if _, err := client.(mdata.Watcher).Watch("app:", "", time.Second); !errors.Is(err, mdata.ErrWatchUnsupported) {
+			t.Fatalf("Watch: %v, want ErrWatchUnsupported", err)
+		}
+	}
+	if n := log.count(mdata.WatchCode); n != 1 {
+		t.Errorf("WATCH sent %d times after FAILURE, want once", n)
+	}
+
+	// WaitForChange polls the keys instead
+	state, err := mdata.WaitForChange(client, "app:", "", time.Second, time.Millisecond)
+	if want := mdata.StateDigest(map[string]string{}); err != nil || state != want {
+		t.Fatalf("WaitForChange without state = %s, %v, want %s", state, err, want)
+	}
+	if current, err := mdata.WaitForChange(client, "app:", state, 20*time.Millisecond, time.Millisecond); err != nil || current != state {
+		t.Fatalf("WaitForChange without changes = %s, %v, want %s", current, err, state)
+	}
+	if err := other.Put("app:port", "8080"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	current, err := mdata.WaitForChange(client, "app:", state, 5*time.Second, time.Millisecond)
+	if want := mdata.StateDigest(map[string]string{"app:port": "8080"}); err != nil || current != want {
+		t.Fatalf("WaitForChange after Put = %s, %v, want %s", current, err, want)
+	}
+	if n := log.count(mdata.WatchCode); n != 1 {
+		t.Errorf("WATCH sent %d times while polling, want once", n)
+	}
+}
+
+func TestWatchKeepsExtensionAfterOtherErrors(t *testing.T) {
+	refused := errors.New("refused by policy")
+	refuse := true
+	srv, _ := mdatatest.StartServer(t)
+	client, err := mdata.NewMetadataClient(srv.Config.WithMiddleware(func(next mdata.RoundTripper) mdata.RoundTripper {
+		return mdata.RoundTripperFunc(func(req *mdata.Request) ([]byte, error) {
+			if refuse {
+				refuse = false
+				return nil, refused
+			}
+			return next.RoundTrip(req)
+		})
+	}))
+	if err != nil {
+		t.Fatalf("NewMetadataClient: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	w := client.(mdata.Watcher)
+	if _, err := w.Watch("app:", "", time.Second); !errors.Is(err, refused) {
+		t.Fatalf("first Watch: %v, want the middleware's error", err)
+	}
+	if _, err := w.Watch("app:", "", time.Second); err != nil {
+		t.Fatalf("Watch after a non-FAILURE error: %v", err)
+	}
+}
+
+func TestGetIfChanged(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		legacy   bool
+		wantSent int // GETIFCHANGED requests sent
+	}{
+		{"extension", false, 2},
+		{"without extension", true, 1},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			log := &codeLog{legacy: tt.legacy}
+			srv, client := startLoggedClient(t, log)
+			other := srv.NewClient()
+			if err := other.Put("k", "v1"); err != nil {
+				t.Fatalf("Put: %v", err)
+			}
+
+			value, sum, err := mdata.GetIfChanged(client, "k", "")
+			if err != nil || value != "v1" || sum != mdata.ValueChecksum("v1") {
+				t.Fatalf("GetIfChanged without checksum = %q, %s, %v", value, sum, err)
+			}
+			if _, got, err := mdata.GetIfChanged(client, "k", sum); !errors.Is(err, mdata.ErrNotModified) || got != sum {
+				t.Fatalf("GetIfChanged of unchanged value: %s, %v, want ErrNotModified", got, err)
+			}
+			if err := other.Put("k", "v2"); err != nil {
+				t.Fatalf("Put: %v", err)
+			}
+			value, sum, err = mdata.GetIfChanged(client, "k", sum)
+			if err != nil || value != "v2" || sum != mdata.ValueChecksum("v2") {
+				t.Fatalf("GetIfChanged of changed value = %q, %s, %v", value, sum, err)
+			}
+			if n := log.count(mdata.GetIfChangedCode); n != tt.wantSent {
+				t.Errorf("GETIFCHANGED sent %d times, want %d", n, tt.wantSent)
+			}
+		})
+	}
+}
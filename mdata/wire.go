@@ -0,0 +1,34 @@
+package mdata
+
+import (
+	"bufio"
+
+	"github.com/Smithx10/go-smartos-mdata/mdata/protocol"
+)
+
+// The wire protocol lives in the protocol package. These names remain for
+// existing callers.
+
+// Protocol constants.
+//
+// Deprecated: use the constants of the protocol package.
+const (
+	ProtocolPrefix  = protocol.Prefix
+	NegotiationReq  = protocol.NegotiationReq
+	NegotiationResp = protocol.NegotiationResp
+	CRCPolynomial   = protocol.CRCPolynomial
+)
+
+// ParseFrame parses a wire format frame.
+//
+// Deprecated: use protocol.ParseFrame.
+func ParseFrame(data string) (*protocol.Frame, error) {
+	return protocol.ParseFrame(data)
+}
+
+// Negotiate performs V2 protocol negotiation.
+//
+// Deprecated: use protocol.Negotiate.
+func Negotiate(conn *bufio.ReadWriter) (bool, error) {
+	return protocol.Negotiate(conn)
+}
@@ -0,0 +1,164 @@
+package mdata
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// ErrGlobalZone is returned when connecting from the global zone of a
+// SmartOS host, which has no metadata of its own. illumos guests of hardware
+// virtual machines run in a global zone too, but reach their metadata over
+// the serial port.
+var ErrGlobalZone = errors.New("running in the global zone, which has no metadata service of its own")
+
+// uuidPattern matches zone UUIDs, which zoneadm looks up with -u
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// Zone describes the illumos zone the process runs in
+type Zone struct {
+	Name   string // empty in LX zones without usable zone tools
+	Brand  string // e.g. "joyent", "joyent-minimal" or "lx"; empty in the global zone
+	Global bool
+}
+
+// MetadataSocket returns the path of the zone's metadata socket as seen from
+// inside the zone. LX zones reach it through the /native tree.
+func (z Zone) MetadataSocket() string {
+	if z.Brand == "lx" {
+		return "/native/.zonecontrol/metadata.sock"
+	}
+	return "/.zonecontrol/metadata.sock"
+}
+
+// DetectZone identifies the zone the process runs in using zonename and
+// zoneadm, which LX zones provide under /native. LX zones without them, such
+// as Docker containers whose /native is missing or unusable, are recognized
+// by the kernel version the zone reports. It reports false outside illumos
+// zones, including in hardware virtual machines.
+func DetectZone() (Zone, bool) {
+	switch runtime.GOOS {
+	case "illumos", "solaris":
+		return detectZone("")
+	case "linux":
+		if _, err := os.Stat("/native/usr/bin/zonename"); err == nil {
+			if zone, ok := detectZone("/native"); ok {
+				return zone, true
+			}
+		}
+		if lxBranded() {
+			return Zone{Brand: "lx"}, true
+		}
+	}
+	return Zone{}, false
+}
+
+// smartOSHost reports whether the global zone is that of a SmartOS host,
+// serving metadata to its zones, rather than that of an illumos guest of a
+// bhyve or KVM virtual machine: the host has the joyent zone brand and does
+// not identify as a SmartOS virtual machine
+func smartOSHost() bool {
+	if _, err := os.Stat("/usr/lib/brand/joyent"); err != nil {
+		return false
+	}
+	return DetectPlatform().Hypervisor != HypervisorSmartOS
+}
+
+// lxBranded reports whether the Linux kernel is the emulation of an LX zone,
+// whose version reads "Linux version 4.3.0 (BrandZ virtual linux)"
+func lxBranded() bool {
+	version, err := os.ReadFile("/proc/version")
+	return err == nil && strings.Contains(string(version), "BrandZ virtual linux")
+}
+
+// detectZone runs the zone tools found under root
+func detectZone(root string) (Zone, bool) {
+	out, err := exec.Command(root + "/usr/bin/zonename").Output()
+	if err != nil {
+		return Zone{}, false
+	}
+	zone := Zone{Name: strings.TrimSpace(string(out))}
+	if zone.Name == "global" {
+		zone.Global = true
+		return zone, true
+	}
+	// Inside a zone, zoneadm lists only that zone:
+	// id:name:state:path:uuid:brand:ip-type
+	if out, err := exec.Command(root+"/usr/sbin/zoneadm", "list", "-p").Output(); err == nil {
+		if fields := strings.Split(strings.TrimSpace(string(out)), ":"); len(fields) > 5 {
+			zone.Brand = fields[5]
+		}
+	}
+	if zone.Brand == "" && root == "/native" {
+		zone.Brand = "lx"
+	}
+	return zone, true
+}
+
+// ZoneClientConfig returns the configuration reaching the metadata of
+// another zone from the global zone, through the socket the metadata agent
+// serves for it. zone is a zone name or UUID. The configuration otherwise
+// follows DefaultClientConfig.
+//
+// Hardware virtual machines (bhyve and KVM) are not supported: the agent
+// talks to them over their serial console rather than offering a socket.
+func ZoneClientConfig(zone string) (ClientConfig, error) {
+	if current, ok := DetectZone(); !ok || !current.Global {
+		return ClientConfig{}, fmt.Errorf("reaching zone %s requires running in the global zone", zone)
+	}
+	flag := "-z"
+	if uuidPattern.MatchString(zone) {
+		flag = "-u"
+	}
+	out, err := exec.Command("/usr/sbin/zoneadm", flag, zone, "list", "-p").Output()
+	if err != nil {
+		return ClientConfig{}, fmt.Errorf("zone %s not found", zone)
+	}
+	// id:name:state:path:uuid:brand:ip-type
+	fields := strings.Split(strings.TrimSpace(string(out)), ":")
+	if len(fields) < 6 {
+		return ClientConfig{}, fmt.Errorf("unexpected zoneadm output for zone %s: %q", zone, out)
+	}
+	name, state, zonepath, brand := fields[1], fields[2], fields[3], fields[5]
+	if brand == "bhyve" || brand == "kvm" {
+		return ClientConfig{}, fmt.Errorf("zone %s is a %s virtual machine, whose metadata is only served over its serial console; use vmadm instead", name, brand)
+	}
+	if state != "running" {
+		return ClientConfig{}, fmt.Errorf("zone %s is %s, not running", name, state)
+	}
+
+	config := DefaultClientConfig()
+	config.Transport = transportUnix
+	config.SerialConfig = nil
+	config.SocketConfig = &SocketConfig{
+		Network: "unix",
+		Address: filepath.Join(zonepath, "root", Zone{Brand: brand}.MetadataSocket()),
+		Timeout: 5 * time.Second,
+	}
+	return config, nil
+}
+
+// ListZones returns the running zones other than the global zone, for use
+// from the global zone with ZoneClientConfig
+func ListZones() ([]Zone, error) {
+	out, err := exec.Command("/usr/sbin/zoneadm", "list", "-p").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list zones: %w", err)
+	}
+	var zones []Zone
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		// id:name:state:path:uuid:brand:ip-type
+		fields := strings.Split(line, ":")
+		if len(fields) < 6 || fields[1] == "global" {
+			continue
+		}
+		zones = append(zones, Zone{Name: fields[1], Brand: fields[5]})
+	}
+	return zones, nil
+}
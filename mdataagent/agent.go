@@ -0,0 +1,307 @@
+// Package mdataagent keeps files on disk in sync with instance metadata,
+// rendering templates and running reload commands when content changes.
+package mdataagent
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/Smithx10/go-smartos-mdata/internal/fsutil"
+	"github.com/Smithx10/go-smartos-mdata/internal/systemd"
+	"github.com/Smithx10/go-smartos-mdata/mdata"
+	"github.com/Smithx10/go-smartos-mdata/mdataserver"
+	"github.com/Smithx10/go-smartos-mdata/mdatatmpl"
+)
+
+// Agent polls metadata and keeps the configured files up to date
+type Agent struct {
+	cfg   Config
+	store mdataserver.Store
+
+	// Logger receives progress and error messages; nil uses the log package's standard logger
+	Logger *log.Logger
+	// ErrorLogger, if set, receives the error messages instead of Logger
+	ErrorLogger *log.Logger
+	// Events, if set, is called for every file written, e.g. with mdata.EventWriter
+	Events func(mdata.ChangeEvent)
+
+	syncMu     sync.Mutex // serializes sync passes from the loop and the control socket
+	hooks      []*hookRunner
+	hookHashes map[string]string // hashes of the watched values at the last pass
+	statusMu   sync.Mutex
+	lastSync   time.Time
+	lastErr    error
+}
+
+// New creates an Agent reading metadata from store. cfg must have been validated.
+func New(cfg Config, store mdataserver.Store) *Agent {
+	return &Agent{cfg: cfg, store: store}
+}
+
+// Run syncs immediately and then on every interval until ctx is cancelled.
+// Under systemd it reports readiness after the first pass, sends watchdog
+// pings from the sync loop, and publishes the last result as the unit status.
+func (a *Agent) Run(ctx context.Context) error {
+	ticker := time.NewTicker(time.Duration(a.cfg.Interval))
+	defer ticker.Stop()
+
+	var watchdog <-chan time.Time
+	if interval := systemd.WatchdogInterval(); interval > 0 {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		watchdog = t.C
+	}
+
+	a.startHooks(ctx)
+	ready := false
+	for {
+		err := a.RunOnce()
+		if err != nil {
+			a.errorf("mdata agent: %v", err)
+			// Notify assignments are newline separated, so flatten joined errors
+			a.notify("STATUS=Last sync failed: " + strings.ReplaceAll(err.Error(), "\n", "; "))
+		} else {
+			a.notify("STATUS=Last sync succeeded at " + time.Now().Format(time.RFC3339))
+		}
+		if !ready {
+			a.notify("READY=1")
+			ready = true
+		}
+
+		for waiting := true; waiting; {
+			select {
+			case <-ctx.Done():
+				a.notify("STOPPING=1")
+				return nil
+			case <-watchdog:
+				a.notify("WATCHDOG=1")
+			case <-ticker.C:
+				waiting = false
+			}
+		}
+	}
+}
+
+// CheckRequired reports every key listed in Config.Required that is missing
+func (a *Agent) CheckRequired() error {
+	var missing []string
+	for _, key := range a.cfg.Required {
+		_, ok, err := a.store.Get(key)
+		if err != nil {
+			return fmt.Errorf("failed to get %s: %w", key, err)
+		}
+		if !ok {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("required keys missing: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// RunOnce performs a single sync pass: every file is rendered, changed files
+// are written, and the command of each changed file runs once afterwards,
+// followed by Config.Commands if any file changed.
+// It returns an error describing the files that could not be synced.
+func (a *Agent) RunOnce() error {
+	a.syncMu.Lock()
+	defer a.syncMu.Unlock()
+
+	err := a.syncAll()
+	a.statusMu.Lock()
+	a.lastSync = time.Now()
+	a.lastErr = err
+	a.statusMu.Unlock()
+	return err
+}
+
+// syncAll implements RunOnce; callers must hold syncMu
+func (a *Agent) syncAll() error {
+	var commands []string
+	seen := make(map[string]bool)
+	var errs []error
+	anyChanged := false
+
+	for _, f := range a.cfg.Files {
+		changed, err := a.syncFile(f)
+		if f.Optional && errors.Is(err, mdatatmpl.ErrKeyNotFound) {
+			continue
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", f.Destination, err))
+			continue
+		}
+		if changed {
+			anyChanged = true
+			a.logf("mdata agent: updated %s", f.Destination)
+			if f.Command != "" && !seen[f.Command] {
+				seen[f.Command] = true
+				commands = append(commands, f.Command)
+			}
+		}
+	}
+
+	if anyChanged {
+		for _, command := range a.cfg.Commands {
+			if !seen[command] {
+				seen[command] = true
+				commands = append(commands, command)
+			}
+		}
+	}
+	for _, command := range commands {
+		if err := runCommand(command); err != nil {
+			errs = append(errs, fmt.Errorf("command %q: %w", command, err))
+		}
+	}
+	if err := a.checkHooks(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// syncFile renders f and writes it if its content differs from what is on disk
+func (a *Agent) syncFile(f File) (bool, error) {
+	content, err := a.Render(f)
+	if err != nil {
+		return false, err
+	}
+	mode, err := f.fileMode()
+	if err != nil {
+		return false, err
+	}
+	current, err := os.ReadFile(f.Destination)
+	if err == nil && bytes.Equal(current, content) {
+		// Content is current, but permissions may have been changed underneath us
+		return false, applyPermissions(f, mode)
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return false, fmt.Errorf("failed to read current content: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(f.Destination), 0o755); err != nil {
+		return false, fmt.Errorf("failed to create parent directory: %w", err)
+	}
+	if err := fsutil.WriteFileAtomic(f.Destination, content, mode); err != nil {
+		return false, err
+	}
+	if a.Events != nil {
+		ev := mdata.ChangeEvent{Time: time.Now().UTC(), Type: mdata.EventAdded, Key: f.Key, File: f.Destination, NewSHA256: mdata.HashValue(content)}
+		if current != nil {
+			ev.Type, ev.OldSHA256 = mdata.EventChanged, mdata.HashValue(current)
+		}
+		a.Events(ev)
+	}
+	return true, applyPermissions(f, mode)
+}
+
+// applyPermissions gives f's destination its configured mode and ownership
+func applyPermissions(f File, mode os.FileMode) error {
+	info, err := os.Stat(f.Destination)
+	if err != nil {
+		return err
+	}
+	if info.Mode().Perm() != mode.Perm() {
+		if err := os.Chmod(f.Destination, mode); err != nil {
+			return fmt.Errorf("failed to chmod: %w", err)
+		}
+	}
+	uid, gid, err := f.ownership()
+	if err != nil {
+		return err
+	}
+	if uid != -1 || gid != -1 {
+		if err := os.Chown(f.Destination, uid, gid); err != nil {
+			return fmt.Errorf("failed to chown: %w", err)
+		}
+	}
+	return nil
+}
+
+// Render produces the desired content of f without writing it: the value of
+// its key, or its template rendered with the mdatatmpl functions and include,
+// finding included files next to the template or on Config.TemplatePath
+func (a *Agent) Render(f File) ([]byte, error) {
+	if f.Key != "" {
+		value, ok, err := a.store.Get(f.Key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get %s: %w", f.Key, err)
+		}
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", mdatatmpl.ErrKeyNotFound, f.Key)
+		}
+		return []byte(value), nil
+	}
+
+	text, err := os.ReadFile(f.Template)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read template: %w", err)
+	}
+	tmpl := template.New(filepath.Base(f.Template))
+	// Includes are looked for next to the template first
+	dirs := append([]string{filepath.Dir(f.Template)}, a.cfg.TemplatePath...)
+	tmpl, err = tmpl.Option("missingkey=error").
+		Funcs(mdatatmpl.StoreFuncMap(a.store)).
+		Funcs(template.FuncMap{"include": mdatatmpl.IncludeFunc(tmpl, dirs)}).
+		Parse(string(text))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse template %s: %w", f.Template, err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, nil); err != nil {
+		return nil, fmt.Errorf("failed to render template %s: %w", f.Template, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// notify sends a state update to systemd, logging failures
+func (a *Agent) notify(state string) {
+	if _, err := systemd.Notify(state); err != nil {
+		a.errorf("mdata agent: %v", err)
+	}
+}
+
+func (a *Agent) errorf(format string, args ...any) {
+	if a.ErrorLogger != nil {
+		a.ErrorLogger.Printf(format, args...)
+		return
+	}
+	a.logf(format, args...)
+}
+
+func (a *Agent) logf(format string, args ...any) {
+	if a.Logger != nil {
+		a.Logger.Printf(format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
+
+// runCommand runs command through the platform shell, with env added to the
+// environment, passing through its output
+func runCommand(command string, env ...string) error {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("/bin/sh", "-c", command)
+	}
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
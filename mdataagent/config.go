@@ -0,0 +1,159 @@
+package mdataagent
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// defaultInterval is how often metadata is polled when the config does not say
+const defaultInterval = 30 * time.Second
+
+// Config describes what the agent keeps in sync
+type Config struct {
+	// Interval between metadata polls (e.g. "30s")
+	Interval Duration `yaml:"interval"`
+	// Files are the destinations kept in sync with metadata
+	Files []File `yaml:"files"`
+	// Required lists keys that must exist before any file is written (see Agent.CheckRequired)
+	Required []string `yaml:"required"`
+	// TemplatePath lists directories searched for the files included by
+	// templates with {{ include "name" }}, after the template's own directory
+	TemplatePath []string `yaml:"template-path"`
+	// Commands are run through the shell, once, after a sync pass changed any
+	// file, following the commands of the changed files
+	Commands []string `yaml:"commands"`
+	// Hooks run commands when keys change
+	Hooks []Hook `yaml:"hooks"`
+}
+
+// File is one destination kept in sync with metadata. Exactly one of Key or
+// Template selects the content.
+type File struct {
+	// Key writes the raw value of a metadata key
+	Key string `yaml:"key"`
+	// Template renders a text/template file with the mdatatmpl functions
+	Template string `yaml:"template"`
+	// Destination is the path written when the content changes
+	Destination string `yaml:"destination"`
+	// Mode is the octal file mode of Destination (default "0644")
+	Mode string `yaml:"mode"`
+	// Owner and Group, names or numeric IDs, own Destination when set
+	Owner string `yaml:"owner"`
+	Group string `yaml:"group"`
+	// Optional skips the file instead of failing when a key it needs is missing
+	Optional bool `yaml:"optional"`
+	// Command is run through the shell after Destination changes
+	Command string `yaml:"command"`
+}
+
+// Duration is a time.Duration read from strings such as "30s" or "5m"
+type Duration time.Duration
+
+// UnmarshalYAML implements yaml.Unmarshaler
+func (d *Duration) UnmarshalYAML(node *yaml.Node) error {
+	var s string
+	if err := node.Decode(&s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q: %w", s, err)
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// LoadConfig reads and validates an agent config file (YAML or JSON)
+func LoadConfig(path string) (Config, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, fmt.Errorf("failed to read agent config %s: %w", path, err)
+	}
+	var cfg Config
+	if err := yaml.Unmarshal(raw, &cfg); err != nil {
+		return Config{}, fmt.Errorf("failed to parse agent config %s: %w", path, err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return Config{}, fmt.Errorf("invalid agent config %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// Validate checks the config and fills in defaults
+func (c *Config) Validate() error {
+	if c.Interval == 0 {
+		c.Interval = Duration(defaultInterval)
+	}
+	if c.Interval < 0 {
+		return fmt.Errorf("interval must be positive")
+	}
+	for i, f := range c.Files {
+		if (f.Key == "") == (f.Template == "") {
+			return fmt.Errorf("files[%d]: exactly one of key or template is required", i)
+		}
+		if f.Destination == "" {
+			return fmt.Errorf("files[%d]: destination is required", i)
+		}
+		if _, err := f.fileMode(); err != nil {
+			return fmt.Errorf("files[%d]: %w", i, err)
+		}
+		if _, _, err := f.ownership(); err != nil {
+			return fmt.Errorf("files[%d]: %w", i, err)
+		}
+	}
+	for i := range c.Hooks {
+		if err := c.Hooks[i].validate(); err != nil {
+			return fmt.Errorf("hooks[%d]: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// fileMode parses Mode, defaulting to 0644
+func (f File) fileMode() (os.FileMode, error) {
+	if f.Mode == "" {
+		return 0o644, nil
+	}
+	mode, err := strconv.ParseUint(f.Mode, 8, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid mode %q: %w", f.Mode, err)
+	}
+	return os.FileMode(mode), nil
+}
+
+// ownership resolves Owner and Group to numeric IDs; -1 leaves an ID unchanged
+func (f File) ownership() (int, int, error) {
+	uid, gid := -1, -1
+	if f.Owner != "" {
+		id, err := strconv.Atoi(f.Owner)
+		if err != nil {
+			u, err := user.Lookup(f.Owner)
+			if err != nil {
+				return 0, 0, fmt.Errorf("unknown owner %q: %w", f.Owner, err)
+			}
+			if id, err = strconv.Atoi(u.Uid); err != nil {
+				return 0, 0, fmt.Errorf("owner %q has no numeric ID", f.Owner)
+			}
+		}
+		uid = id
+	}
+	if f.Group != "" {
+		id, err := strconv.Atoi(f.Group)
+		if err != nil {
+			g, err := user.LookupGroup(f.Group)
+			if err != nil {
+				return 0, 0, fmt.Errorf("unknown group %q: %w", f.Group, err)
+			}
+			if id, err = strconv.Atoi(g.Gid); err != nil {
+				return 0, 0, fmt.Errorf("group %q has no numeric ID", f.Group)
+			}
+		}
+		gid = id
+	}
+	return uid, gid, nil
+}
@@ -0,0 +1,95 @@
+package mdataagent
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// Status is the agent state reported on the control socket
+type Status struct {
+	LastSync  time.Time `json:"last_sync,omitempty"`
+	LastError string    `json:"last_error,omitempty"`
+	Files     int       `json:"files"`
+}
+
+// Status returns the outcome of the most recent sync pass
+func (a *Agent) Status() Status {
+	a.statusMu.Lock()
+	defer a.statusMu.Unlock()
+	st := Status{LastSync: a.lastSync, Files: len(a.cfg.Files)}
+	if a.lastErr != nil {
+		st.LastError = a.lastErr.Error()
+	}
+	return st
+}
+
+// ServeControl answers control commands on l until it is closed. The protocol
+// is one command per line: "status" replies with the JSON Status and "sync"
+// runs a sync pass immediately, replying "ok" or "error: <message>".
+func (a *Agent) ServeControl(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return fmt.Errorf("failed to accept control connection: %w", err)
+		}
+		go a.handleControl(conn)
+	}
+}
+
+// handleControl serves the commands sent on one control connection
+func (a *Agent) handleControl(conn net.Conn) {
+	defer conn.Close()
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		var reply string
+		switch cmd := strings.TrimSpace(scanner.Text()); cmd {
+		case "status":
+			encoded, err := json.Marshal(a.Status())
+			if err != nil {
+				reply = "error: " + err.Error()
+			} else {
+				reply = string(encoded)
+			}
+		case "sync":
+			if err := a.RunOnce(); err != nil {
+				reply = "error: " + strings.ReplaceAll(err.Error(), "\n", "; ")
+			} else {
+				reply = "ok"
+			}
+		default:
+			reply = fmt.Sprintf("error: unknown command %q", cmd)
+		}
+		if _, err := fmt.Fprintln(conn, reply); err != nil {
+			return
+		}
+	}
+}
+
+// Control sends command to the agent control socket at address and returns its reply
+func Control(address, command string) (string, error) {
+	conn, err := net.DialTimeout("unix", address, 5*time.Second)
+	if err != nil {
+		return "", fmt.Errorf("failed to connect to agent control socket %s: %w", address, err)
+	}
+	defer conn.Close()
+	if _, err := fmt.Fprintln(conn, command); err != nil {
+		return "", fmt.Errorf("failed to send command: %w", err)
+	}
+	reply, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return "", fmt.Errorf("failed to read reply: %w", err)
+	}
+	reply = strings.TrimSuffix(reply, "\n")
+	if msg, ok := strings.CutPrefix(reply, "error: "); ok {
+		return "", errors.New(msg)
+	}
+	return reply, nil
+}
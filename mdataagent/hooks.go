@@ -0,0 +1,283 @@
+package mdataagent
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Smithx10/go-smartos-mdata/mdata"
+)
+
+// Defaults of the hook retry delays
+const (
+	defaultHookBackoff    = 10 * time.Second
+	defaultHookMaxBackoff = 5 * time.Minute
+)
+
+// Hook runs commands when metadata keys change. Changes are noticed by the
+// sync passes, so a key changed and changed back between two passes goes
+// unnoticed; the first pass only records the current values.
+type Hook struct {
+	// Keys are the patterns of the keys watched, in path.Match syntax (e.g. "app:*")
+	Keys []string `yaml:"keys"`
+	// OnChange are the commands run through the shell, in order, for every
+	// changed key, with MDATA_KEY set to the key, MDATA_EVENT to added,
+	// changed or removed, and MDATA_VALUE_FILE to a file holding the new
+	// value, readable by the agent's user only and removed afterwards
+	OnChange []string `yaml:"on-change"`
+	// Debounce delays the commands until the watched keys have stopped
+	// changing for this long, so that a burst of changes runs them once
+	Debounce Duration `yaml:"debounce"`
+	// Backoff is how long to wait before running the commands again for a
+	// key whose commands failed (default "10s"), doubling after every
+	// further failure up to MaxBackoff (default "5m")
+	Backoff    Duration `yaml:"backoff"`
+	MaxBackoff Duration `yaml:"max-backoff"`
+}
+
+// validate checks the hook and fills in defaults
+func (h *Hook) validate() error {
+	if len(h.Keys) == 0 {
+		return fmt.Errorf("keys is required")
+	}
+	for _, pattern := range h.Keys {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid key pattern %q: %w", pattern, err)
+		}
+	}
+	if len(h.OnChange) == 0 {
+		return fmt.Errorf("on-change is required")
+	}
+	if h.Debounce < 0 || h.Backoff < 0 || h.MaxBackoff < 0 {
+		return fmt.Errorf("debounce, backoff and max-backoff must not be negative")
+	}
+	if h.Backoff == 0 {
+		h.Backoff = Duration(defaultHookBackoff)
+	}
+	if h.MaxBackoff == 0 {
+		h.MaxBackoff = Duration(defaultHookMaxBackoff)
+	}
+	if h.MaxBackoff < h.Backoff {
+		h.MaxBackoff = h.Backoff
+	}
+	return nil
+}
+
+// matches reports whether the hook watches key
+func (h Hook) matches(key string) bool {
+	for _, pattern := range h.Keys {
+		if ok, _ := path.Match(pattern, key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// keyChange is a change of a key waiting for the commands of a hook
+type keyChange struct {
+	event string // mdata.EventAdded, EventChanged or EventRemoved
+	value string
+}
+
+// hookRunner runs the commands of one hook for the changes handed to it
+type hookRunner struct {
+	hook   Hook
+	notify chan struct{} // signalled when a change is added
+
+	mu      sync.Mutex
+	pending map[string]keyChange
+}
+
+// add queues a change of key, replacing any earlier change not yet handled
+func (r *hookRunner) add(key string, change keyChange) {
+	r.mu.Lock()
+	if prev, ok := r.pending[key]; ok && prev.event == mdata.EventAdded && change.event != mdata.EventRemoved {
+		// Still new to the commands, however often it changed since
+		change.event = mdata.EventAdded
+	}
+	r.pending[key] = change
+	r.mu.Unlock()
+	select {
+	case r.notify <- struct{}{}:
+	default:
+	}
+}
+
+// take removes and returns the queued changes
+func (r *hookRunner) take() map[string]keyChange {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	pending := r.pending
+	r.pending = make(map[string]keyChange)
+	return pending
+}
+
+// requeue queues failed changes again, unless a newer change replaced them
+func (r *hookRunner) requeue(failed map[string]keyChange) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for key, change := range failed {
+		if _, ok := r.pending[key]; !ok {
+			r.pending[key] = change
+		}
+	}
+}
+
+// startHooks starts running the hooks of the config until ctx is cancelled.
+// Until it is called, sync passes do not look for changes.
+func (a *Agent) startHooks(ctx context.Context) {
+	if len(a.cfg.Hooks) == 0 {
+		return
+	}
+	runners := make([]*hookRunner, len(a.cfg.Hooks))
+	for i, hook := range a.cfg.Hooks {
+		runners[i] = &hookRunner{hook: hook, notify: make(chan struct{}, 1), pending: make(map[string]keyChange)}
+		go a.runHook(ctx, runners[i])
+	}
+	a.syncMu.Lock()
+	a.hooks = runners
+	a.syncMu.Unlock()
+}
+
+// checkHooks hands the changes of watched keys since the previous pass to
+// their hooks; callers must hold syncMu
+func (a *Agent) checkHooks() error {
+	if len(a.hooks) == 0 {
+		return nil
+	}
+	keys, err := a.store.Keys()
+	if err != nil {
+		return fmt.Errorf("failed to list keys for hooks: %w", err)
+	}
+
+	values := make(map[string]string)
+	hashes := make(map[string]string)
+	for _, key := range keys {
+		watched := false
+		for _, r := range a.hooks {
+			if r.hook.matches(key) {
+				watched = true
+				break
+			}
+		}
+		if !watched {
+			continue
+		}
+		value, ok, err := a.store.Get(key)
+		if err != nil {
+			return fmt.Errorf("failed to get %s for hooks: %w", key, err)
+		}
+		if ok {
+			values[key] = value
+			hashes[key] = mdata.HashValue([]byte(value))
+		}
+	}
+
+	if a.hookHashes != nil {
+		for _, r := range a.hooks {
+			for key, hash := range hashes {
+				if !r.hook.matches(key) {
+					continue
+				}
+				if old, ok := a.hookHashes[key]; !ok {
+					r.add(key, keyChange{event: mdata.EventAdded, value: values[key]})
+				} else if old != hash {
+					r.add(key, keyChange{event: mdata.EventChanged, value: values[key]})
+				}
+			}
+			for key := range a.hookHashes {
+				if _, ok := hashes[key]; !ok && r.hook.matches(key) {
+					r.add(key, keyChange{event: mdata.EventRemoved})
+				}
+			}
+		}
+	}
+	a.hookHashes = hashes
+	return nil
+}
+
+// runHook runs the commands of r once its changes have settled for the
+// debounce delay, retrying failed keys with backoff, until ctx is cancelled
+func (a *Agent) runHook(ctx context.Context, r *hookRunner) {
+	timer := time.NewTimer(0)
+	timer.Stop()
+	backoff := time.Duration(0)
+	for {
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-r.notify:
+			// While backing off, new changes wait for the retry
+			if backoff == 0 {
+				timer.Reset(time.Duration(r.hook.Debounce))
+			}
+		case <-timer.C:
+			failed := a.runHookCommands(r.hook, r.take())
+			if len(failed) == 0 {
+				backoff = 0
+				continue
+			}
+			r.requeue(failed)
+			if backoff == 0 {
+				backoff = time.Duration(r.hook.Backoff)
+			} else {
+				backoff = min(2*backoff, time.Duration(r.hook.MaxBackoff))
+			}
+			a.errorf("mdata agent: hook commands failed for %d key(s); retrying in %s", len(failed), backoff)
+			timer.Reset(backoff)
+		}
+	}
+}
+
+// runHookCommands runs the commands of hook for every change, in key order,
+// and returns the changes whose commands failed
+func (a *Agent) runHookCommands(hook Hook, changes map[string]keyChange) map[string]keyChange {
+	keys := make([]string, 0, len(changes))
+	for key := range changes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	failed := make(map[string]keyChange)
+	for _, key := range keys {
+		change := changes[key]
+		if err := runHookKey(hook, key, change); err != nil {
+			a.errorf("mdata agent: hook for %s: %v", key, err)
+			failed[key] = change
+		}
+	}
+	return failed
+}
+
+// runHookKey runs the commands of hook for the change of key, stopping at
+// the first that fails
+func runHookKey(hook Hook, key string, change keyChange) error {
+	env := []string{"MDATA_KEY=" + key, "MDATA_EVENT=" + change.event}
+	if change.event != mdata.EventRemoved {
+		// CreateTemp makes the file readable by its owner only
+		f, err := os.CreateTemp("", "mdata-hook-*")
+		if err != nil {
+			return fmt.Errorf("failed to create value file: %w", err)
+		}
+		defer os.Remove(f.Name())
+		_, err = f.WriteString(change.value)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return fmt.Errorf("failed to write value file: %w", err)
+		}
+		env = append(env, "MDATA_VALUE_FILE="+f.Name())
+	}
+	for _, command := range hook.OnChange {
+		if err := runCommand(command, env...); err != nil {
+			return fmt.Errorf("command %q: %w", command, err)
+		}
+	}
+	return nil
+}
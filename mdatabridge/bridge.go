@@ -0,0 +1,276 @@
+// Package mdatabridge mirrors instance metadata into external key/value
+// systems such as Consul KV, so tools built around those systems can consume
+// SmartOS metadata. Backends implement mdataserver.Store over the keys they
+// own, and a Bridge keeps such a target in sync with a metadata store.
+//
+// Vault moves secret-like keys from metadata into Vault and publishes
+// non-sensitive facts about Vault secrets back into metadata.
+package mdatabridge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Smithx10/go-smartos-mdata/mdataserver"
+)
+
+// Direction selects which way changes flow
+type Direction string
+
+const (
+	// OneWay copies metadata to the target and removes target keys that are not in metadata
+	OneWay Direction = "one-way"
+	// Bidirectional also copies target changes back into metadata
+	Bidirectional Direction = "bidirectional"
+)
+
+// ConflictPolicy decides a key changed on both sides since the last sync
+type ConflictPolicy string
+
+const (
+	// PreferMetadata keeps the metadata value
+	PreferMetadata ConflictPolicy = "metadata"
+	// PreferTarget keeps the target value
+	PreferTarget ConflictPolicy = "target"
+	// SkipConflicts leaves both sides untouched and reports the conflict
+	SkipConflicts ConflictPolicy = "skip"
+)
+
+// Options configures a Bridge
+type Options struct {
+	// Direction defaults to OneWay
+	Direction Direction
+	// Conflict applies to Bidirectional bridges and defaults to PreferMetadata
+	Conflict ConflictPolicy
+	// ExtraKeys are mirrored in addition to the listed keys, e.g. "sdc:uuid",
+	// which KEYS does not return. They are never written back to metadata.
+	ExtraKeys []string
+	// KeyPrefix, when set, limits the bridge to listed keys starting with it on both sides
+	KeyPrefix string
+	// DryRun logs the changes a pass would make instead of making them
+	DryRun bool
+}
+
+// Bridge keeps a target store in sync with a metadata store
+type Bridge struct {
+	source mdataserver.Store
+	target mdataserver.Store
+	opts   Options
+
+	// Logger receives progress and error messages; nil uses the log package's standard logger
+	Logger *log.Logger
+
+	mu   sync.Mutex
+	base map[string]string // values both sides agreed on after the last sync
+}
+
+// New creates a Bridge syncing target with source (the metadata store)
+func New(source, target mdataserver.Store, opts Options) (*Bridge, error) {
+	if opts.Direction == "" {
+		opts.Direction = OneWay
+	}
+	if opts.Conflict == "" {
+		opts.Conflict = PreferMetadata
+	}
+	switch opts.Direction {
+	case OneWay, Bidirectional:
+	default:
+		return nil, fmt.Errorf("unknown direction %q", opts.Direction)
+	}
+	switch opts.Conflict {
+	case PreferMetadata, PreferTarget, SkipConflicts:
+	default:
+		return nil, fmt.Errorf("unknown conflict policy %q", opts.Conflict)
+	}
+	return &Bridge{source: source, target: target, opts: opts, base: make(map[string]string)}, nil
+}
+
+// Run syncs immediately and then on every interval until ctx is cancelled.
+// Failed passes are logged and retried on the next interval.
+func (b *Bridge) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := b.Sync(); err != nil {
+			b.logf("mdata bridge: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sync performs a single sync pass and returns the keys it could not sync
+func (b *Bridge) Sync() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	src, err := snapshot(b.source, b.opts.ExtraKeys, b.opts.KeyPrefix)
+	if err != nil {
+		return fmt.Errorf("failed to read metadata: %w", err)
+	}
+	dst, err := snapshot(b.target, b.opts.ExtraKeys, b.opts.KeyPrefix)
+	if err != nil {
+		return fmt.Errorf("failed to read target: %w", err)
+	}
+
+	var errs []error
+	for _, key := range unionKeys(src, dst) {
+		if err := b.syncKey(key, src, dst); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", key, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Reset forgets what was last synced, so the next pass treats every difference
+// as new. Use it when the target lost its contents, e.g. when an etcd lease expired,
+// so that bidirectional bridges restore the target rather than delete from metadata.
+func (b *Bridge) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.base = make(map[string]string)
+}
+
+// syncKey reconciles one key; callers must hold mu
+func (b *Bridge) syncKey(key string, src, dst map[string]string) error {
+	s, sok := src[key]
+	d, dok := dst[key]
+	if sok == dok && s == d {
+		b.record(key, s, sok)
+		return nil
+	}
+
+	toTarget := true
+	if b.opts.Direction == Bidirectional && !b.readOnly(key) {
+		base, bok := b.base[key]
+		srcChanged := sok != bok || s != base
+		dstChanged := dok != bok || d != base
+		switch {
+		case srcChanged && dstChanged:
+			switch b.opts.Conflict {
+			case SkipConflicts:
+				return fmt.Errorf("changed in both metadata and target")
+			case PreferTarget:
+				toTarget = false
+			}
+		case dstChanged:
+			toTarget = false
+		}
+	}
+
+	if b.opts.DryRun {
+		side, present := "target", sok
+		if !toTarget {
+			side, present = "metadata", dok
+		}
+		b.logf("mdata bridge: %s would be %s in %s", key, verb(present), side)
+		return nil
+	}
+	if toTarget {
+		if err := apply(b.target, key, s, sok); err != nil {
+			return fmt.Errorf("failed to update target: %w", err)
+		}
+		b.logf("mdata bridge: %s %s in target", key, verb(sok))
+		b.record(key, s, sok)
+		return nil
+	}
+	if err := apply(b.source, key, d, dok); err != nil {
+		return fmt.Errorf("failed to update metadata: %w", err)
+	}
+	b.logf("mdata bridge: %s %s in metadata", key, verb(dok))
+	b.record(key, d, dok)
+	return nil
+}
+
+// readOnly reports whether key may only flow from metadata to the target
+func (b *Bridge) readOnly(key string) bool {
+	if strings.HasPrefix(key, "sdc:") {
+		return true
+	}
+	for _, extra := range b.opts.ExtraKeys {
+		if key == extra {
+			return true
+		}
+	}
+	return false
+}
+
+// record remembers the value both sides now agree on
+func (b *Bridge) record(key, value string, ok bool) {
+	if ok {
+		b.base[key] = value
+	} else {
+		delete(b.base, key)
+	}
+}
+
+func (b *Bridge) logf(format string, args ...any) {
+	if b.Logger != nil {
+		b.Logger.Printf(format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
+
+// snapshot reads every listed key of store starting with prefix, plus extra
+func snapshot(store mdataserver.Store, extra []string, prefix string) (map[string]string, error) {
+	listed, err := store.Keys()
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(listed)+len(extra))
+	for _, key := range listed {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	values := make(map[string]string, len(keys)+len(extra))
+	for _, key := range append(keys, extra...) {
+		value, ok, err := store.Get(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get %s: %w", key, err)
+		}
+		if ok {
+			values[key] = value
+		}
+	}
+	return values, nil
+}
+
+// apply makes key hold value in store, or removes it when ok is false
+func apply(store mdataserver.Store, key, value string, ok bool) error {
+	if ok {
+		return store.Put(key, value)
+	}
+	return store.Delete(key)
+}
+
+func unionKeys(a, b map[string]string) []string {
+	keys := make([]string, 0, len(a)+len(b))
+	for k := range a {
+		keys = append(keys, k)
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func verb(ok bool) string {
+	if ok {
+		return "updated"
+	}
+	return "deleted"
+}
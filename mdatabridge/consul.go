@@ -0,0 +1,163 @@
+package mdatabridge
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// ConsulConfig locates a Consul agent and the KV prefix owned by the bridge
+type ConsulConfig struct {
+	// Address is the agent's HTTP API URL (default $CONSUL_HTTP_ADDR or http://127.0.0.1:8500)
+	Address string
+	// Token is sent as X-Consul-Token (default $CONSUL_HTTP_TOKEN)
+	Token string
+	// Datacenter selects a datacenter other than the agent's own
+	Datacenter string
+	// Prefix is prepended to every key, e.g. "smartos/"
+	Prefix string
+	// Client performs requests; nil uses a client with a 10 second timeout
+	Client *http.Client
+}
+
+// ConsulStore is an mdataserver.Store over the keys under a Consul KV prefix
+type ConsulStore struct {
+	cfg ConsulConfig
+}
+
+// NewConsulStore creates a ConsulStore, filling in defaults from the environment
+func NewConsulStore(cfg ConsulConfig) *ConsulStore {
+	if cfg.Address == "" {
+		cfg.Address = os.Getenv("CONSUL_HTTP_ADDR")
+	}
+	if cfg.Address == "" {
+		cfg.Address = "http://127.0.0.1:8500"
+	}
+	if !strings.Contains(cfg.Address, "://") {
+		cfg.Address = "http://" + cfg.Address
+	}
+	cfg.Address = strings.TrimSuffix(cfg.Address, "/")
+	if cfg.Token == "" {
+		cfg.Token = os.Getenv("CONSUL_HTTP_TOKEN")
+	}
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &ConsulStore{cfg: cfg}
+}
+
+// Get implements mdataserver.Store
+func (s *ConsulStore) Get(key string) (string, bool, error) {
+	resp, err := s.do(http.MethodGet, s.cfg.Prefix+key, url.Values{"raw": {""}}, nil)
+	if err != nil {
+		return "", false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return "", false, nil
+	}
+	if err := checkStatus(resp); err != nil {
+		return "", false, err
+	}
+	value, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to read consul response: %w", err)
+	}
+	return string(value), true, nil
+}
+
+// Keys implements mdataserver.Store, returning keys relative to the prefix
+func (s *ConsulStore) Keys() ([]string, error) {
+	resp, err := s.do(http.MethodGet, s.cfg.Prefix, url.Values{"keys": {""}}, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if err := checkStatus(resp); err != nil {
+		return nil, err
+	}
+	var full []string
+	if err := json.NewDecoder(resp.Body).Decode(&full); err != nil {
+		return nil, fmt.Errorf("failed to decode consul keys: %w", err)
+	}
+	keys := make([]string, 0, len(full))
+	for _, k := range full {
+		// Keys ending in "/" are folders, not values
+		if k = strings.TrimPrefix(k, s.cfg.Prefix); k != "" && !strings.HasSuffix(k, "/") {
+			keys = append(keys, k)
+		}
+	}
+	return keys, nil
+}
+
+// Put implements mdataserver.Store
+func (s *ConsulStore) Put(key, value string) error {
+	resp, err := s.do(http.MethodPut, s.cfg.Prefix+key, nil, strings.NewReader(value))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return checkStatus(resp)
+}
+
+// Delete implements mdataserver.Store
+func (s *ConsulStore) Delete(key string) error {
+	resp, err := s.do(http.MethodDelete, s.cfg.Prefix+key, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return checkStatus(resp)
+}
+
+// do sends a request for a KV path
+func (s *ConsulStore) do(method, key string, query url.Values, body io.Reader) (*http.Response, error) {
+	if s.cfg.Datacenter != "" {
+		if query == nil {
+			query = url.Values{}
+		}
+		query.Set("dc", s.cfg.Datacenter)
+	}
+	u := s.cfg.Address + "/v1/kv/" + escapePath(key)
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create consul request: %w", err)
+	}
+	if s.cfg.Token != "" {
+		req.Header.Set("X-Consul-Token", s.cfg.Token)
+	}
+	resp, err := s.cfg.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("consul request failed: %w", err)
+	}
+	return resp, nil
+}
+
+// escapePath escapes each segment of a slash separated key
+func escapePath(key string) string {
+	segments := strings.Split(key, "/")
+	for i, seg := range segments {
+		segments[i] = url.PathEscape(seg)
+	}
+	return strings.Join(segments, "/")
+}
+
+// checkStatus turns a non-2xx response into an error including the body
+func checkStatus(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return fmt.Errorf("%s %s: %s: %s", resp.Request.Method, resp.Request.URL.Path, resp.Status, strings.TrimSpace(string(msg)))
+}
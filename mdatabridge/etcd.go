@@ -0,0 +1,297 @@
+package mdatabridge
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultLeaseTTL is the lifetime of the lease attached to mirrored keys
+const defaultLeaseTTL = 60 * time.Second
+
+// errUnauthenticated marks a request rejected for a missing or expired auth token
+var errUnauthenticated = errors.New("unauthenticated")
+
+// EtcdConfig locates an etcd cluster and the key prefix owned by the bridge
+type EtcdConfig struct {
+	// Endpoints are client URLs tried in order (default $ETCDCTL_ENDPOINTS or http://127.0.0.1:2379)
+	Endpoints []string
+	// Prefix is prepended to every key, e.g. "/smartos/nodes/<uuid>/"
+	Prefix string
+	// LeaseTTL is the lifetime of the lease attached to every key (default 60s).
+	// Keys disappear this long after the bridge stops refreshing it.
+	LeaseTTL time.Duration
+	// Username and Password enable etcd authentication when set
+	Username, Password string
+	// Client performs requests; nil uses a client with a 10 second timeout
+	Client *http.Client
+}
+
+// EtcdStore is an mdataserver.Store over the keys under an etcd prefix, using
+// the etcd v3 JSON gateway. Every key written is attached to a lease that
+// KeepAlive refreshes, so a node's keys expire when its bridge goes away.
+type EtcdStore struct {
+	cfg EtcdConfig
+
+	grantMu sync.Mutex // serializes lease grants
+	mu      sync.Mutex
+	lease   string // current lease ID, empty until the first Put
+	token   string // auth token when authentication is enabled
+}
+
+// NewEtcdStore creates an EtcdStore, filling in defaults from the environment
+func NewEtcdStore(cfg EtcdConfig) *EtcdStore {
+	if len(cfg.Endpoints) == 0 {
+		if env := os.Getenv("ETCDCTL_ENDPOINTS"); env != "" {
+			cfg.Endpoints = strings.Split(env, ",")
+		} else {
+			cfg.Endpoints = []string{"http://127.0.0.1:2379"}
+		}
+	}
+	for i, ep := range cfg.Endpoints {
+		if !strings.Contains(ep, "://") {
+			ep = "http://" + ep
+		}
+		cfg.Endpoints[i] = strings.TrimSuffix(ep, "/")
+	}
+	if cfg.LeaseTTL <= 0 {
+		cfg.LeaseTTL = defaultLeaseTTL
+	}
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &EtcdStore{cfg: cfg}
+}
+
+// etcdKV is a key/value pair in a range response
+type etcdKV struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+}
+
+// Get implements mdataserver.Store
+func (s *EtcdStore) Get(key string) (string, bool, error) {
+	var resp struct {
+		KVs []etcdKV `json:"kvs"`
+	}
+	if err := s.call("/v3/kv/range", map[string]any{"key": []byte(s.cfg.Prefix + key)}, &resp); err != nil {
+		return "", false, err
+	}
+	if len(resp.KVs) == 0 {
+		return "", false, nil
+	}
+	return string(resp.KVs[0].Value), true, nil
+}
+
+// Keys implements mdataserver.Store, returning keys relative to the prefix
+func (s *EtcdStore) Keys() ([]string, error) {
+	var resp struct {
+		KVs []etcdKV `json:"kvs"`
+	}
+	req := map[string]any{
+		"key":       []byte(s.cfg.Prefix),
+		"range_end": prefixEnd(s.cfg.Prefix),
+		"keys_only": true,
+	}
+	if err := s.call("/v3/kv/range", req, &resp); err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(resp.KVs))
+	for _, kv := range resp.KVs {
+		keys = append(keys, strings.TrimPrefix(string(kv.Key), s.cfg.Prefix))
+	}
+	return keys, nil
+}
+
+// Put implements mdataserver.Store, attaching the key to the store's lease
+func (s *EtcdStore) Put(key, value string) error {
+	lease, err := s.currentLease()
+	if err != nil {
+		return err
+	}
+	req := map[string]any{
+		"key":   []byte(s.cfg.Prefix + key),
+		"value": []byte(value),
+		"lease": lease,
+	}
+	return s.call("/v3/kv/put", req, nil)
+}
+
+// Delete implements mdataserver.Store
+func (s *EtcdStore) Delete(key string) error {
+	return s.call("/v3/kv/deleterange", map[string]any{"key": []byte(s.cfg.Prefix + key)}, nil)
+}
+
+// KeepAlive refreshes the lease at a third of its TTL until ctx is cancelled.
+// When the lease has expired (and with it every key), onExpired is called so
+// the caller can write the keys again; the next Put grants a new lease.
+func (s *EtcdStore) KeepAlive(ctx context.Context, onExpired func()) error {
+	ticker := time.NewTicker(s.cfg.LeaseTTL / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		s.mu.Lock()
+		lease := s.lease
+		s.mu.Unlock()
+		if lease == "" {
+			continue
+		}
+
+		var resp struct {
+			Result struct {
+				TTL string `json:"TTL"`
+			} `json:"result"`
+		}
+		if err := s.call("/v3/lease/keepalive", map[string]any{"ID": lease}, &resp); err != nil {
+			// The lease may survive a transient failure; retry on the next tick
+			continue
+		}
+		if ttl, _ := strconv.Atoi(resp.Result.TTL); ttl > 0 {
+			continue
+		}
+
+		s.mu.Lock()
+		if s.lease == lease {
+			s.lease = ""
+		}
+		s.mu.Unlock()
+		if onExpired != nil {
+			onExpired()
+		}
+	}
+}
+
+// Close revokes the lease, removing every key written through the store
+func (s *EtcdStore) Close() error {
+	s.mu.Lock()
+	lease := s.lease
+	s.lease = ""
+	s.mu.Unlock()
+	if lease == "" {
+		return nil
+	}
+	return s.call("/v3/lease/revoke", map[string]any{"ID": lease}, nil)
+}
+
+// currentLease returns the lease ID, granting one if needed
+func (s *EtcdStore) currentLease() (string, error) {
+	s.grantMu.Lock()
+	defer s.grantMu.Unlock()
+
+	s.mu.Lock()
+	lease := s.lease
+	s.mu.Unlock()
+	if lease != "" {
+		return lease, nil
+	}
+
+	var resp struct {
+		ID string `json:"ID"`
+	}
+	ttl := int64(s.cfg.LeaseTTL / time.Second)
+	if err := s.call("/v3/lease/grant", map[string]any{"TTL": ttl}, &resp); err != nil {
+		return "", fmt.Errorf("failed to grant lease: %w", err)
+	}
+	s.mu.Lock()
+	s.lease = resp.ID
+	s.mu.Unlock()
+	return resp.ID, nil
+}
+
+// call posts a JSON request to the first reachable endpoint, authenticating
+// again once if the auth token has expired
+func (s *EtcdStore) call(path string, in, out any) error {
+	err := s.post(path, in, out)
+	if errors.Is(err, errUnauthenticated) && s.cfg.Username != "" {
+		if err := s.authenticate(); err != nil {
+			return err
+		}
+		err = s.post(path, in, out)
+	}
+	return err
+}
+
+// authenticate obtains a new auth token
+func (s *EtcdStore) authenticate() error {
+	var resp struct {
+		Token string `json:"token"`
+	}
+	req := map[string]string{"name": s.cfg.Username, "password": s.cfg.Password}
+	if err := s.post("/v3/auth/authenticate", req, &resp); err != nil {
+		return fmt.Errorf("failed to authenticate to etcd: %w", err)
+	}
+	s.mu.Lock()
+	s.token = resp.Token
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *EtcdStore) post(path string, in, out any) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return fmt.Errorf("failed to encode etcd request: %w", err)
+	}
+	s.mu.Lock()
+	token := s.token
+	s.mu.Unlock()
+	if token == "" && s.cfg.Username != "" && path != "/v3/auth/authenticate" {
+		return errUnauthenticated
+	}
+
+	var lastErr error
+	for _, ep := range s.cfg.Endpoints {
+		req, err := http.NewRequest(http.MethodPost, ep+path, bytes.NewReader(body))
+		if err != nil {
+			return fmt.Errorf("failed to create etcd request: %w", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", token)
+		}
+		resp, err := s.cfg.Client.Do(req)
+		if err != nil {
+			lastErr = fmt.Errorf("etcd request failed: %w", err)
+			continue
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusUnauthorized {
+			return errUnauthenticated
+		}
+		if err := checkStatus(resp); err != nil {
+			return err
+		}
+		if out != nil {
+			if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+				return fmt.Errorf("failed to decode etcd response: %w", err)
+			}
+		}
+		return nil
+	}
+	return lastErr
+}
+
+// prefixEnd returns the range end matching every key that starts with prefix
+func prefixEnd(prefix string) []byte {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	// Every byte is 0xff: "\x00" means the end of the keyspace
+	return []byte{0}
+}
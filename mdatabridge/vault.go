@@ -0,0 +1,303 @@
+package mdatabridge
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Smithx10/go-smartos-mdata/mdataserver"
+)
+
+// VaultConfig locates a Vault server and the KV version 2 engine used
+type VaultConfig struct {
+	// Address is the server URL (default $VAULT_ADDR or https://127.0.0.1:8200)
+	Address string
+	// Token authenticates requests (default $VAULT_TOKEN)
+	Token string
+	// Namespace is sent as X-Vault-Namespace when set (default $VAULT_NAMESPACE)
+	Namespace string
+	// Mount is the path of the KV version 2 secrets engine (default "secret")
+	Mount string
+	// Client performs requests; nil uses a client with a 10 second timeout
+	Client *http.Client
+	// Audit, when set, records every operation performed against Vault
+	Audit *AuditLog
+}
+
+// Vault moves secrets between metadata and a Vault KV version 2 engine
+type Vault struct {
+	cfg VaultConfig
+}
+
+// VaultSecret is the current version of a KV secret
+type VaultSecret struct {
+	Data        map[string]string
+	Version     int
+	CreatedTime time.Time
+}
+
+// NewVault creates a Vault client, filling in defaults from the environment
+func NewVault(cfg VaultConfig) *Vault {
+	if cfg.Address == "" {
+		cfg.Address = os.Getenv("VAULT_ADDR")
+	}
+	if cfg.Address == "" {
+		cfg.Address = "https://127.0.0.1:8200"
+	}
+	cfg.Address = strings.TrimSuffix(cfg.Address, "/")
+	if cfg.Token == "" {
+		cfg.Token = os.Getenv("VAULT_TOKEN")
+	}
+	if cfg.Namespace == "" {
+		cfg.Namespace = os.Getenv("VAULT_NAMESPACE")
+	}
+	if cfg.Mount == "" {
+		cfg.Mount = "secret"
+	}
+	cfg.Mount = strings.Trim(cfg.Mount, "/")
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &Vault{cfg: cfg}
+}
+
+// ReadSecret returns the current version of the secret at path, or nil if it does not exist
+func (v *Vault) ReadSecret(path string) (*VaultSecret, error) {
+	var body struct {
+		Data struct {
+			Data     map[string]any `json:"data"`
+			Metadata struct {
+				Version     int       `json:"version"`
+				CreatedTime time.Time `json:"created_time"`
+			} `json:"metadata"`
+		} `json:"data"`
+	}
+	found, err := v.do(http.MethodGet, path, nil, &body)
+	if err != nil || !found {
+		return nil, err
+	}
+	secret := &VaultSecret{
+		Data:        make(map[string]string, len(body.Data.Data)),
+		Version:     body.Data.Metadata.Version,
+		CreatedTime: body.Data.Metadata.CreatedTime,
+	}
+	for field, value := range body.Data.Data {
+		if s, ok := value.(string); ok {
+			secret.Data[field] = s
+		} else {
+			raw, _ := json.Marshal(value)
+			secret.Data[field] = string(raw)
+		}
+	}
+	return secret, nil
+}
+
+// WriteSecret writes data as a new version of the secret at path. When cas is
+// non-negative the write only succeeds if the current version equals cas
+// (0 meaning the secret must not exist yet). It returns the new version.
+func (v *Vault) WriteSecret(path string, data map[string]string, cas int) (int, error) {
+	req := map[string]any{"data": data}
+	if cas >= 0 {
+		req["options"] = map[string]int{"cas": cas}
+	}
+	var body struct {
+		Data struct {
+			Version int `json:"version"`
+		} `json:"data"`
+	}
+	if _, err := v.do(http.MethodPost, path, req, &body); err != nil {
+		return 0, err
+	}
+	return body.Data.Version, nil
+}
+
+// Push copies keys from store into fields of the same name in the secret at
+// path, keeping fields already there. Keys missing from store are skipped.
+// With deleteAfter, pushed keys are removed from store once Vault has them,
+// so bootstrap credentials do not linger in metadata. It returns the keys pushed.
+func (v *Vault) Push(store mdataserver.Store, path string, keys []string, deleteAfter bool) ([]string, error) {
+	values := make(map[string]string)
+	var pushed []string
+	for _, key := range keys {
+		value, ok, err := store.Get(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get %s: %w", key, err)
+		}
+		if ok {
+			values[key] = value
+			pushed = append(pushed, key)
+		}
+	}
+	if len(pushed) == 0 {
+		return nil, nil
+	}
+
+	current, err := v.ReadSecret(path)
+	if err != nil {
+		v.audit("push", path, pushed, err)
+		return nil, err
+	}
+	cas := 0
+	if current != nil {
+		cas = current.Version
+		for field, value := range current.Data {
+			if _, ok := values[field]; !ok {
+				values[field] = value
+			}
+		}
+	}
+	version, err := v.WriteSecret(path, values, cas)
+	if err != nil {
+		v.audit("push", path, pushed, err)
+		return nil, err
+	}
+	v.audit("push", path, pushed, nil, "version", strconv.Itoa(version))
+
+	if deleteAfter {
+		var errs []error
+		for _, key := range pushed {
+			err := store.Delete(key)
+			v.audit("delete-metadata", path, []string{key}, err)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("failed to delete %s from metadata: %w", key, err))
+			}
+		}
+		if err := errors.Join(errs...); err != nil {
+			return pushed, err
+		}
+	}
+	return pushed, nil
+}
+
+// Publish writes non-sensitive facts about the secret at path into store:
+// prefix+"version", prefix+"created_time", and prefix+FIELD+".sha256" holding
+// the SHA-256 fingerprint of each field. Secret values are never written.
+func (v *Vault) Publish(store mdataserver.Store, path, prefix string) error {
+	secret, err := v.ReadSecret(path)
+	if err != nil {
+		v.audit("publish", path, nil, err)
+		return err
+	}
+	if secret == nil {
+		err := fmt.Errorf("secret %s/%s not found", v.cfg.Mount, path)
+		v.audit("publish", path, nil, err)
+		return err
+	}
+
+	derived := map[string]string{
+		prefix + "version":      strconv.Itoa(secret.Version),
+		prefix + "created_time": secret.CreatedTime.UTC().Format(time.RFC3339),
+	}
+	fields := make([]string, 0, len(secret.Data))
+	for field, value := range secret.Data {
+		sum := sha256.Sum256([]byte(value))
+		derived[prefix+field+".sha256"] = hex.EncodeToString(sum[:])
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	var errs []error
+	for key, value := range derived {
+		if err := store.Put(key, value); err != nil {
+			errs = append(errs, fmt.Errorf("failed to put %s: %w", key, err))
+		}
+	}
+	err = errors.Join(errs...)
+	v.audit("publish", path, fields, err, "version", strconv.Itoa(secret.Version))
+	return err
+}
+
+// do sends a request for a KV data path and decodes the response into out.
+// It reports false when the secret does not exist.
+func (v *Vault) do(method, path string, in, out any) (bool, error) {
+	var body io.Reader
+	if in != nil {
+		raw, err := json.Marshal(in)
+		if err != nil {
+			return false, fmt.Errorf("failed to encode vault request: %w", err)
+		}
+		body = bytes.NewReader(raw)
+	}
+	u := v.cfg.Address + "/v1/" + v.cfg.Mount + "/data/" + escapePath(strings.Trim(path, "/"))
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return false, fmt.Errorf("failed to create vault request: %w", err)
+	}
+	req.Header.Set("X-Vault-Token", v.cfg.Token)
+	if v.cfg.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.cfg.Namespace)
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := v.cfg.Client.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("vault request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound && method == http.MethodGet {
+		return false, nil
+	}
+	if err := checkStatus(resp); err != nil {
+		return false, err
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return false, fmt.Errorf("failed to decode vault response: %w", err)
+		}
+	}
+	return true, nil
+}
+
+func (v *Vault) audit(action, path string, keys []string, err error, extra ...string) {
+	if v.cfg.Audit != nil {
+		v.cfg.Audit.Record(action, v.cfg.Mount+"/"+strings.Trim(path, "/"), keys, err, extra...)
+	}
+}
+
+// AuditLog records bridge operations as JSON lines. Entries name keys and
+// fields but never include their values.
+type AuditLog struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewAuditLog creates an AuditLog writing to w
+func NewAuditLog(w io.Writer) *AuditLog {
+	return &AuditLog{w: w}
+}
+
+// Record appends an entry; extra holds additional name/value pairs
+func (a *AuditLog) Record(action, path string, keys []string, err error, extra ...string) {
+	entry := map[string]any{
+		"time":   time.Now().UTC().Format(time.RFC3339Nano),
+		"action": action,
+		"path":   path,
+		"keys":   keys,
+		"result": "ok",
+	}
+	if err != nil {
+		entry["result"] = "error"
+		entry["error"] = err.Error()
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		entry[extra[i]] = extra[i+1]
+	}
+	line, _ := json.Marshal(entry)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.w.Write(append(line, '\n'))
+}
@@ -0,0 +1,397 @@
+// Package mdatacloudinit lays out SmartOS metadata for cloud-init on images
+// that lack its native SmartOS datasource. Metadata is mapped the way the
+// SmartOS datasource maps it and written as a NoCloud seed, together with the
+// per-boot user-script and operator-script files the datasource maintains.
+package mdatacloudinit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Smithx10/go-smartos-mdata/internal/fsutil"
+	"github.com/Smithx10/go-smartos-mdata/mdataserver"
+	"gopkg.in/yaml.v3"
+)
+
+// Metadata keys read by the SmartOS datasource
+const (
+	keyUUID           = "sdc:uuid"
+	keyHostname       = "hostname"
+	keySDCHostname    = "sdc:hostname"
+	keyDNSDomain      = "sdc:dns_domain"
+	keyDatacenter     = "sdc:datacenter_name"
+	keyAuthorizedKeys = "root_authorized_keys"
+	keyUserData       = "cloud-init:user-data"
+	keyLegacyUserData = "user-data"
+	keyUserScript     = "user-script"
+	keyOperatorScript = "sdc:operator-script"
+	keyVendorData     = "sdc:vendor-data"
+	keyNICs           = "sdc:nics"
+	keyResolvers      = "sdc:resolvers"
+	keyRoutes         = "sdc:routes"
+)
+
+// defaultScriptBanner is prepended to a user-script without an interpreter line
+const defaultScriptBanner = "#!/bin/bash\n"
+
+// builtinVendorData is used when sdc:vendor-data is unset. As with the SmartOS
+// datasource, it installs a per-boot hook that runs the operator-script and
+// user-script written by Write.
+const builtinVendorData = `#cloud-boothook
+#!/bin/sh
+fname="%[1]s/01_smartos_vendor_data.sh"
+mkdir -p "${fname%%/*}"
+cat > "$fname" <<"END_SCRIPT"
+#!/bin/sh
+##
+# This file is written as part of the default vendor data for SmartOS.
+# The following files are written from metadata on each boot:
+#   sdc:operator-script -> %[2]s
+#   user-script -> %[3]s
+#
+# You can view content with 'mdata get <key>'
+#
+for script in "%[2]s" "%[3]s"; do
+    [ -x "$script" ] || continue
+    echo "executing '$script'" 1>&2
+    "$script"
+done
+END_SCRIPT
+chmod +x "$fname"
+`
+
+// Layout says where the cloud-init files are written
+type Layout struct {
+	// CloudDir is cloud-init's state directory (normally /var/lib/cloud)
+	CloudDir string
+	// SeedDir receives the NoCloud seed (normally CloudDir/seed/nocloud)
+	SeedDir string
+	// LegacyDir receives mdata-user-data and the user-script link (normally /var/db)
+	LegacyDir string
+}
+
+// DefaultLayout returns the paths used by cloud-init and the SmartOS datasource
+func DefaultLayout() Layout {
+	return Layout{
+		CloudDir:  "/var/lib/cloud",
+		SeedDir:   "/var/lib/cloud/seed/nocloud",
+		LegacyDir: "/var/db",
+	}
+}
+
+// dataDir is where per-instance scripts are kept
+func (l Layout) dataDir(instanceID string) string {
+	return filepath.Join(l.CloudDir, "instances", instanceID, "data")
+}
+
+// Seed holds the cloud-init view of the instance metadata
+type Seed struct {
+	// InstanceID is sdc:uuid
+	InstanceID string
+	// MetaData, UserData, VendorData and NetworkConfig are the NoCloud seed
+	// files. NetworkConfig is nil when sdc:nics is unset.
+	MetaData, UserData, VendorData, NetworkConfig []byte
+	// UserScript, OperatorScript and LegacyUserData are nil when unset
+	UserScript, OperatorScript, LegacyUserData []byte
+}
+
+// metaData is the NoCloud meta-data document
+type metaData struct {
+	InstanceID       string   `yaml:"instance-id"`
+	LocalHostname    string   `yaml:"local-hostname"`
+	PublicKeys       []string `yaml:"public-keys,omitempty"`
+	AvailabilityZone string   `yaml:"availability_zone,omitempty"`
+	DNSDomain        string   `yaml:"dns_domain,omitempty"`
+}
+
+// Build reads the metadata cloud-init needs from store. Only sdc:uuid is required.
+func Build(store mdataserver.Store, layout Layout) (*Seed, error) {
+	r := reader{store: store}
+	seed := &Seed{InstanceID: r.get(keyUUID)}
+	md := metaData{
+		InstanceID:       seed.InstanceID,
+		LocalHostname:    firstNonEmpty(r.get(keyHostname), r.get(keySDCHostname), seed.InstanceID),
+		PublicKeys:       splitLines(r.get(keyAuthorizedKeys)),
+		AvailabilityZone: r.get(keyDatacenter),
+		DNSDomain:        r.get(keyDNSDomain),
+	}
+	seed.UserData = r.bytes(keyUserData)
+	seed.VendorData = r.bytes(keyVendorData)
+	seed.LegacyUserData = r.bytes(keyLegacyUserData)
+	seed.OperatorScript = r.bytes(keyOperatorScript)
+	if script := r.bytes(keyUserScript); script != nil {
+		if !bytes.HasPrefix(script, []byte("#!")) {
+			script = append([]byte(defaultScriptBanner), script...)
+		}
+		seed.UserScript = script
+	}
+	nics, resolvers, routes := r.get(keyNICs), r.get(keyResolvers), r.get(keyRoutes)
+	if r.err != nil {
+		return nil, r.err
+	}
+	if seed.InstanceID == "" {
+		return nil, fmt.Errorf("%s is not set", keyUUID)
+	}
+
+	var err error
+	if seed.MetaData, err = yaml.Marshal(md); err != nil {
+		return nil, fmt.Errorf("failed to encode meta-data: %w", err)
+	}
+	if seed.UserData == nil {
+		seed.UserData = []byte{}
+	}
+	if seed.VendorData == nil {
+		data := layout.dataDir(seed.InstanceID)
+		seed.VendorData = []byte(fmt.Sprintf(builtinVendorData,
+			filepath.Join(layout.CloudDir, "scripts", "per-boot"),
+			filepath.Join(data, "operator-script"),
+			filepath.Join(data, "user-script")))
+	}
+	if nics != "" {
+		if seed.NetworkConfig, err = networkConfig(nics, resolvers, routes, md.DNSDomain); err != nil {
+			return nil, err
+		}
+	}
+	return seed, nil
+}
+
+// Write writes the seed and scripts under layout and returns the paths written.
+// Scripts and legacy files whose key is unset are removed, as the datasource does.
+func (s *Seed) Write(layout Layout) ([]string, error) {
+	data := layout.dataDir(s.InstanceID)
+	files := []struct {
+		path    string
+		content []byte
+		mode    os.FileMode
+	}{
+		{filepath.Join(layout.SeedDir, "meta-data"), s.MetaData, 0o644},
+		{filepath.Join(layout.SeedDir, "user-data"), s.UserData, 0o600},
+		{filepath.Join(layout.SeedDir, "vendor-data"), s.VendorData, 0o600},
+		{filepath.Join(layout.SeedDir, "network-config"), s.NetworkConfig, 0o644},
+		{filepath.Join(data, "user-script"), s.UserScript, 0o700},
+		{filepath.Join(data, "operator-script"), s.OperatorScript, 0o700},
+		{filepath.Join(layout.LegacyDir, "mdata-user-data"), s.LegacyUserData, 0o600},
+	}
+
+	var written []string
+	for _, f := range files {
+		if f.content == nil {
+			if err := os.Remove(f.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return written, fmt.Errorf("failed to remove %s: %w", f.path, err)
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(f.path), 0o755); err != nil {
+			return written, fmt.Errorf("failed to create %s: %w", filepath.Dir(f.path), err)
+		}
+		if err := fsutil.WriteFileAtomic(f.path, f.content, f.mode); err != nil {
+			return written, err
+		}
+		written = append(written, f.path)
+	}
+
+	// Existing guest tooling looks for the user-script at its legacy path
+	link := filepath.Join(layout.LegacyDir, "user-script")
+	if err := os.Remove(link); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return written, fmt.Errorf("failed to remove %s: %w", link, err)
+	}
+	if s.UserScript != nil {
+		if err := os.Symlink(filepath.Join(data, "user-script"), link); err != nil {
+			return written, fmt.Errorf("failed to link %s: %w", link, err)
+		}
+		written = append(written, link)
+	}
+	return written, nil
+}
+
+// reader fetches keys from a store, remembering the first error
+type reader struct {
+	store mdataserver.Store
+	err   error
+}
+
+// get returns the value of key, or "" when it is unset or an error occurred
+func (r *reader) get(key string) string {
+	value, _ := r.lookup(key)
+	return value
+}
+
+// bytes returns the value of key, or nil when it is unset or an error occurred
+func (r *reader) bytes(key string) []byte {
+	value, ok := r.lookup(key)
+	if !ok {
+		return nil
+	}
+	return []byte(value)
+}
+
+func (r *reader) lookup(key string) (string, bool) {
+	if r.err != nil {
+		return "", false
+	}
+	value, ok, err := r.store.Get(key)
+	if err != nil {
+		r.err = fmt.Errorf("failed to get %s: %w", key, err)
+		return "", false
+	}
+	return value, ok
+}
+
+// network config version 1 entries (see cloud-init's network config documentation)
+type (
+	netConfig struct {
+		Version int         `yaml:"version"`
+		Config  []netEntity `yaml:"config"`
+	}
+	netEntity struct {
+		Type        string      `yaml:"type"`
+		Name        string      `yaml:"name,omitempty"`
+		MACAddress  string      `yaml:"mac_address,omitempty"`
+		MTU         int         `yaml:"mtu,omitempty"`
+		Subnets     []netSubnet `yaml:"subnets,omitempty"`
+		Address     []string    `yaml:"address,omitempty"`
+		Search      []string    `yaml:"search,omitempty"`
+		Destination string      `yaml:"destination,omitempty"`
+		Gateway     string      `yaml:"gateway,omitempty"`
+	}
+	netSubnet struct {
+		Type    string `yaml:"type"`
+		Address string `yaml:"address,omitempty"`
+		Netmask string `yaml:"netmask,omitempty"`
+		Gateway string `yaml:"gateway,omitempty"`
+	}
+)
+
+// smartosNIC is the subset of an sdc:nics entry used for network config
+type smartosNIC struct {
+	Interface string   `json:"interface"`
+	MAC       string   `json:"mac"`
+	MTU       int      `json:"mtu"`
+	IP        string   `json:"ip"`
+	Netmask   string   `json:"netmask"`
+	IPs       []string `json:"ips"`
+	Gateway   string   `json:"gateway"`
+	Gateways  []string `json:"gateways"`
+	Primary   bool     `json:"primary"`
+}
+
+// smartosRoute is an sdc:routes entry
+type smartosRoute struct {
+	Linklocal bool   `json:"linklocal"`
+	Dst       string `json:"dst"`
+	Gateway   string `json:"gateway"`
+}
+
+// networkConfig converts sdc:nics, sdc:resolvers and sdc:routes to network
+// config version 1. Default gateways are only configured on the primary NIC.
+func networkConfig(rawNICs, rawResolvers, rawRoutes, dnsDomain string) ([]byte, error) {
+	var nics []smartosNIC
+	if err := json.Unmarshal([]byte(rawNICs), &nics); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", keyNICs, err)
+	}
+
+	cfg := netConfig{Version: 1}
+	for _, nic := range nics {
+		iface := netEntity{Type: "physical", Name: nic.Interface, MACAddress: nic.MAC, MTU: nic.MTU}
+		gateways := nic.Gateways
+		if len(gateways) == 0 && nic.Gateway != "" {
+			gateways = []string{nic.Gateway}
+		}
+
+		ips := nic.IPs
+		if len(ips) == 0 && nic.IP != "" {
+			ips = []string{nic.IP}
+		}
+		for _, ip := range ips {
+			switch ip {
+			case "dhcp":
+				iface.Subnets = append(iface.Subnets, netSubnet{Type: "dhcp4"})
+			case "addrconf":
+				iface.Subnets = append(iface.Subnets, netSubnet{Type: "dhcp6"})
+			default:
+				subnet := netSubnet{Type: "static", Address: ip}
+				if !strings.Contains(ip, "/") {
+					subnet.Netmask = nic.Netmask
+				}
+				if nic.Primary {
+					subnet.Gateway = gatewayFor(ip, gateways)
+				}
+				iface.Subnets = append(iface.Subnets, subnet)
+			}
+		}
+		cfg.Config = append(cfg.Config, iface)
+	}
+
+	if rawResolvers != "" {
+		var resolvers []string
+		if err := json.Unmarshal([]byte(rawResolvers), &resolvers); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", keyResolvers, err)
+		}
+		ns := netEntity{Type: "nameserver", Address: resolvers}
+		if dnsDomain != "" {
+			ns.Search = []string{dnsDomain}
+		}
+		cfg.Config = append(cfg.Config, ns)
+	}
+
+	if rawRoutes != "" {
+		var routes []smartosRoute
+		if err := json.Unmarshal([]byte(rawRoutes), &routes); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", keyRoutes, err)
+		}
+		for _, route := range routes {
+			// Link-local routes name an interface rather than a next hop
+			if route.Linklocal {
+				continue
+			}
+			cfg.Config = append(cfg.Config, netEntity{Type: "route", Destination: route.Dst, Gateway: route.Gateway})
+		}
+	}
+
+	out, err := yaml.Marshal(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode network-config: %w", err)
+	}
+	return out, nil
+}
+
+// gatewayFor returns the first gateway of the same address family as ip
+func gatewayFor(ip string, gateways []string) string {
+	host, _, _ := strings.Cut(ip, "/")
+	addr := net.ParseIP(host)
+	if addr == nil {
+		return ""
+	}
+	for _, gw := range gateways {
+		if g := net.ParseIP(gw); g != nil && (g.To4() == nil) == (addr.To4() == nil) {
+			return gw
+		}
+	}
+	return ""
+}
+
+// splitLines returns the non-empty lines of s
+func splitLines(s string) []string {
+	var lines []string
+	for _, line := range strings.Split(s, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,179 @@
+// Package mdataexporter exposes instance metadata and metadata channel health
+// as Prometheus metrics in the text exposition format.
+package mdataexporter
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Smithx10/go-smartos-mdata/mdataserver"
+)
+
+// namespace prefixes every exported metric name
+const namespace = "smartos_mdata"
+
+// invalidLabelChars matches characters not allowed in Prometheus label names
+var invalidLabelChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// Config selects which metadata is exported
+type Config struct {
+	// InfoLabels maps label names on the smartos_mdata_info metric to metadata keys
+	InfoLabels map[string]string
+	// GaugeKeys lists keys exported as smartos_mdata_value gauges when numeric
+	GaugeKeys []string
+}
+
+// DefaultConfig exports the instance identity as info labels
+func DefaultConfig() Config {
+	return Config{
+		InfoLabels: map[string]string{
+			"uuid":       "sdc:uuid",
+			"alias":      "sdc:alias",
+			"hostname":   "sdc:hostname",
+			"image_uuid": "sdc:image_uuid",
+			"datacenter": "sdc:datacenter_name",
+		},
+	}
+}
+
+// Exporter is an http.Handler rendering metrics fetched from a Store on each scrape
+type Exporter struct {
+	store mdataserver.Store
+	cfg   Config
+
+	mu           sync.Mutex
+	requests     uint64
+	failures     uint64
+	lastSuccess  time.Time
+	lastDuration time.Duration
+}
+
+// New creates an Exporter reading metadata from store
+func New(store mdataserver.Store, cfg Config) *Exporter {
+	return &Exporter{store: store, cfg: cfg}
+}
+
+// ServeHTTP implements http.Handler
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Scrapes are serialized so counters describe whole scrapes and the channel isn't flooded
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var buf bytes.Buffer
+	start := time.Now()
+	up := e.collect(&buf)
+	e.lastDuration = time.Since(start)
+	if up {
+		e.lastSuccess = time.Now()
+	}
+
+	writeHeader(&buf, "up", "gauge", "Whether the last scrape reached the metadata channel.")
+	fmt.Fprintf(&buf, "%s_up %d\n", namespace, boolToInt(up))
+	writeHeader(&buf, "scrape_duration_seconds", "gauge", "Time spent fetching metadata for the last scrape.")
+	fmt.Fprintf(&buf, "%s_scrape_duration_seconds %g\n", namespace, e.lastDuration.Seconds())
+	writeHeader(&buf, "requests_total", "counter", "Metadata requests issued by the exporter.")
+	fmt.Fprintf(&buf, "%s_requests_total %d\n", namespace, e.requests)
+	writeHeader(&buf, "request_failures_total", "counter", "Metadata requests that failed other than with NOTFOUND.")
+	fmt.Fprintf(&buf, "%s_request_failures_total %d\n", namespace, e.failures)
+	writeHeader(&buf, "last_success_timestamp_seconds", "gauge", "Unix time of the last scrape that reached the metadata channel.")
+	if e.lastSuccess.IsZero() {
+		fmt.Fprintf(&buf, "%s_last_success_timestamp_seconds 0\n", namespace)
+	} else {
+		fmt.Fprintf(&buf, "%s_last_success_timestamp_seconds %d\n", namespace, e.lastSuccess.Unix())
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(buf.Bytes())
+}
+
+// collect writes the metadata-derived metrics and reports whether every request succeeded
+func (e *Exporter) collect(buf *bytes.Buffer) bool {
+	up := true
+
+	labels := make([]string, 0, len(e.cfg.InfoLabels))
+	for label := range e.cfg.InfoLabels {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+
+	pairs := make([]string, 0, len(labels))
+	for _, label := range labels {
+		value, _, err := e.get(e.cfg.InfoLabels[label])
+		if err != nil {
+			up = false
+			break
+		}
+		pairs = append(pairs, SanitizeLabel(label)+"="+quoteLabelValue(value))
+	}
+	// A partial label set would look like a different instance, so omit the sample instead
+	writeHeader(buf, "info", "gauge", "Instance identity from SmartOS metadata.")
+	if up {
+		fmt.Fprintf(buf, "%s_info{%s} 1\n", namespace, strings.Join(pairs, ","))
+	}
+
+	if len(e.cfg.GaugeKeys) > 0 {
+		writeHeader(buf, "value", "gauge", "Numeric metadata values.")
+	}
+	for _, key := range e.cfg.GaugeKeys {
+		value, ok, err := e.get(key)
+		if err != nil {
+			up = false
+			continue
+		}
+		if !ok {
+			continue
+		}
+		f, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(buf, "%s_value{key=%s} %g\n", namespace, quoteLabelValue(key), f)
+	}
+	return up
+}
+
+// get fetches a key and updates the request counters
+func (e *Exporter) get(key string) (string, bool, error) {
+	e.requests++
+	value, ok, err := e.store.Get(key)
+	if err != nil {
+		e.failures++
+	}
+	return value, ok, err
+}
+
+// SanitizeLabel turns an arbitrary string into a valid Prometheus label name
+func SanitizeLabel(name string) string {
+	name = invalidLabelChars.ReplaceAllString(name, "_")
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		name = "_" + name
+	}
+	return name
+}
+
+// labelValueEscaper escapes label values as the text exposition format requires
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// quoteLabelValue returns value escaped and quoted for use in a label set
+func quoteLabelValue(value string) string {
+	return `"` + labelValueEscaper.Replace(value) + `"`
+}
+
+func writeHeader(buf *bytes.Buffer, name, kind, help string) {
+	fmt.Fprintf(buf, "# HELP %s_%s %s\n", namespace, name, help)
+	fmt.Fprintf(buf, "# TYPE %s_%s %s\n", namespace, name, kind)
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
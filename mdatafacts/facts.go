@@ -0,0 +1,217 @@
+// Package mdatafacts gathers instance metadata into facts for configuration
+// management tools. The sdc: keys describing the instance are always
+// included; customer metadata is included as selected by Options.
+package mdatafacts
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/Smithx10/go-smartos-mdata/mdata"
+	"github.com/Smithx10/go-smartos-mdata/mdataserver"
+)
+
+// SDCKeys are the sdc: keys gathered as instance facts when present
+var SDCKeys = []string{
+	"sdc:uuid",
+	"sdc:server_uuid",
+	"sdc:datacenter_name",
+	"sdc:owner_uuid",
+	"sdc:billing_id",
+	"sdc:image_uuid",
+	"sdc:alias",
+	"sdc:hostname",
+	"sdc:dns_domain",
+	"sdc:brand",
+	"sdc:max_physical_memory",
+	"sdc:max_swap",
+	"sdc:max_lwps",
+	"sdc:quota",
+	"sdc:cpu_cap",
+	"sdc:cpu_shares",
+	"sdc:zfs_io_priority",
+	"sdc:tmpfs",
+	"sdc:nics",
+	"sdc:resolvers",
+	"sdc:routes",
+	"sdc:tags",
+	"sdc:disks",
+	"sdc:volumes",
+}
+
+// invalidNameChars matches characters not allowed in Ansible variable names
+var invalidNameChars = regexp.MustCompile(`[^a-z0-9_]`)
+
+// Options selects the customer metadata included in the facts
+type Options struct {
+	// Prefix limits customer metadata to listed keys starting with it
+	Prefix string
+	// Allow, when set, further limits customer metadata to keys matching one
+	// of these path.Match patterns, e.g. "app:*"
+	Allow []string
+	// NoMetadata leaves customer metadata out entirely
+	NoMetadata bool
+	// Redact masks the values of secret customer metadata keys
+	Redact mdata.Redactor
+}
+
+// allowed reports whether key matches the allowlist
+func (o Options) allowed(key string) (bool, error) {
+	if len(o.Allow) == 0 {
+		return true, nil
+	}
+	for _, pattern := range o.Allow {
+		ok, err := path.Match(pattern, key)
+		if err != nil {
+			return false, fmt.Errorf("invalid allow pattern %q: %w", pattern, err)
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Facts is the metadata of an instance
+type Facts struct {
+	// SDC maps sdc: keys to their values
+	SDC map[string]string
+	// Metadata maps the selected customer metadata keys to their values
+	Metadata map[string]string
+}
+
+// Gather reads the instance facts from store. Absent sdc: keys are skipped,
+// as not every brand and platform provides all of them.
+func Gather(store mdataserver.Store, opts Options) (*Facts, error) {
+	f := &Facts{SDC: make(map[string]string), Metadata: make(map[string]string)}
+	for _, key := range SDCKeys {
+		value, ok, err := store.Get(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get %s: %w", key, err)
+		}
+		if ok {
+			f.SDC[key] = value
+		}
+	}
+	if opts.NoMetadata {
+		return f, nil
+	}
+
+	keys, err := store.Keys()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list keys: %w", err)
+	}
+	for _, key := range keys {
+		// sdc: keys are gathered above; some stores list them too
+		if !strings.HasPrefix(key, opts.Prefix) || strings.HasPrefix(key, "sdc:") {
+			continue
+		}
+		if allowed, err := opts.allowed(key); err != nil {
+			return nil, err
+		} else if !allowed {
+			continue
+		}
+		value, ok, err := store.Get(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get %s: %w", key, err)
+		}
+		if ok {
+			f.Metadata[key] = opts.Redact.Value(key, value)
+		}
+	}
+	return f, nil
+}
+
+// Flat returns every fact under its metadata key with its raw value
+func (f *Facts) Flat() map[string]string {
+	out := make(map[string]string, len(f.SDC)+len(f.Metadata))
+	for k, v := range f.Metadata {
+		out[k] = v
+	}
+	for k, v := range f.SDC {
+		out[k] = v
+	}
+	return out
+}
+
+// Ansible returns the facts structured for Ansible: sdc: keys under "sdc"
+// with JSON values such as sdc:nics decoded, and customer metadata under
+// "metadata". Names are turned into valid variable names, so sdc:server_uuid
+// becomes sdc.server_uuid and the key app:db-host becomes metadata.app_db_host.
+// The result serves as a local facts file (ansible_local.<file>) or as a
+// host_vars file.
+func (f *Facts) Ansible() map[string]any {
+	sdc := make(map[string]any, len(f.SDC))
+	for k, v := range f.SDC {
+		sdc[VarName(strings.TrimPrefix(k, "sdc:"))] = decodeValue(v)
+	}
+	metadata := make(map[string]any, len(f.Metadata))
+	for _, k := range sortedKeys(f.Metadata) {
+		name := VarName(k)
+		if _, dup := metadata[name]; dup {
+			// Keep the first of keys that only differ in invalid characters
+			continue
+		}
+		metadata[name] = f.Metadata[k]
+	}
+	return map[string]any{"sdc": sdc, "metadata": metadata}
+}
+
+// Puppet returns the facts as Facter external facts: each sdc: key becomes a
+// top-level fact named sdc_<name> (sdc:server_uuid becomes sdc_server_uuid)
+// with JSON values decoded, and customer metadata becomes the structured fact
+// smartos_metadata. The result may be written as JSON or YAML under
+// /etc/puppetlabs/facter/facts.d.
+func (f *Facts) Puppet() map[string]any {
+	structured := f.Ansible()
+	out := make(map[string]any, len(f.SDC)+1)
+	for name, v := range structured["sdc"].(map[string]any) {
+		out["sdc_"+name] = v
+	}
+	out["smartos_metadata"] = structured["metadata"]
+	return out
+}
+
+// Ohai returns the facts as an Ohai hint, with the same structure as
+// Ansible. Written to /etc/chef/ohai/hints/smartos.json it is available to
+// Ohai plugins and recipes through hint?("smartos").
+func (f *Facts) Ohai() map[string]any {
+	return f.Ansible()
+}
+
+// VarName turns a metadata key into a lower-case variable name of letters,
+// digits and underscores that does not start with a digit
+func VarName(key string) string {
+	name := invalidNameChars.ReplaceAllString(strings.ToLower(key), "_")
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		name = "_" + name
+	}
+	return name
+}
+
+// decodeValue returns the decoded value of v when it is JSON (an object,
+// array, number or boolean) and v itself otherwise
+func decodeValue(v string) any {
+	var decoded any
+	if err := json.Unmarshal([]byte(v), &decoded); err != nil {
+		return v
+	}
+	if decoded == nil {
+		return v
+	}
+	return decoded
+}
+
+// sortedKeys returns the keys of m in order
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
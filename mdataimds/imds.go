@@ -0,0 +1,296 @@
+// Package mdataimds serves SmartOS metadata through an EC2 instance metadata
+// service (IMDS) compatible HTTP API, so software written against
+// http://169.254.169.254/latest/meta-data/ runs unmodified on SmartOS guests.
+// IAM credential paths are intentionally not provided.
+package mdataimds
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Smithx10/go-smartos-mdata/mdataserver"
+)
+
+// IMDSv2 session token headers
+const (
+	TokenHeader    = "X-aws-ec2-metadata-token"
+	TokenTTLHeader = "X-aws-ec2-metadata-token-ttl-seconds"
+)
+
+// maxTokenTTL is the longest token lifetime EC2 accepts (6 hours)
+const maxTokenTTL = 21600
+
+// Source resolves the value served for a meta-data path
+type Source func(store mdataserver.Store) (string, bool, error)
+
+// Key serves the value of a metadata key verbatim
+func Key(key string) Source {
+	return func(store mdataserver.Store) (string, bool, error) {
+		return store.Get(key)
+	}
+}
+
+// NICField serves a field of the first NIC in sdc:nics matching match
+func NICField(field string, match func(nic map[string]any) bool) Source {
+	return func(store mdataserver.Store) (string, bool, error) {
+		raw, ok, err := store.Get("sdc:nics")
+		if err != nil || !ok {
+			return "", false, err
+		}
+		var nics []map[string]any
+		if err := json.Unmarshal([]byte(raw), &nics); err != nil {
+			return "", false, fmt.Errorf("invalid sdc:nics: %w", err)
+		}
+		for _, nic := range nics {
+			if !match(nic) {
+				continue
+			}
+			if value, ok := nic[field].(string); ok {
+				return value, true, nil
+			}
+		}
+		return "", false, nil
+	}
+}
+
+// primaryNIC matches the NIC flagged primary
+func primaryNIC(nic map[string]any) bool {
+	primary, _ := nic["primary"].(bool)
+	return primary
+}
+
+// externalNIC matches a NIC on the "external" nic tag
+func externalNIC(nic map[string]any) bool {
+	return nic["nic_tag"] == "external"
+}
+
+// DefaultMapping returns the meta-data paths served by default
+func DefaultMapping() map[string]Source {
+	return map[string]Source{
+		"instance-id":                 Key("sdc:uuid"),
+		"ami-id":                      Key("sdc:image_uuid"),
+		"hostname":                    Key("sdc:hostname"),
+		"local-hostname":              Key("sdc:hostname"),
+		"placement/availability-zone": Key("sdc:datacenter_name"),
+		"local-ipv4":                  NICField("ip", primaryNIC),
+		"mac":                         NICField("mac", primaryNIC),
+		"public-ipv4":                 NICField("ip", externalNIC),
+		"public-keys/0/openssh-key":   Key("root_authorized_keys"),
+	}
+}
+
+// Handler is an http.Handler answering IMDS requests from a Store
+type Handler struct {
+	store   mdataserver.Store
+	mapping map[string]Source
+
+	// ErrorLog receives store errors; nil uses the log package's standard logger
+	ErrorLog *log.Logger
+
+	mu     sync.Mutex
+	tokens map[string]time.Time // IMDSv2 session tokens and their expiry
+}
+
+// NewHandler creates a Handler serving mapping (see DefaultMapping) from store
+func NewHandler(store mdataserver.Store, mapping map[string]Source) *Handler {
+	return &Handler{
+		store:   store,
+		mapping: mapping,
+		tokens:  make(map[string]time.Time),
+	}
+}
+
+// ServeHTTP implements http.Handler
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/latest/api/token" {
+		h.serveToken(w, r)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	// IMDSv1 requests carry no token; a token that is present must be valid
+	if token := r.Header.Get(TokenHeader); token != "" && !h.validToken(token) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch path := r.URL.Path; {
+	case path == "/", path == "/latest":
+		h.writeList(w, []string{"latest"})
+	case path == "/latest/":
+		h.writeList(w, []string{"meta-data/", "user-data"})
+	case path == "/latest/user-data":
+		h.serveSource(w, Key("user-data"))
+	case path == "/latest/meta-data/tags", path == "/latest/meta-data/tags/":
+		h.writeList(w, []string{"instance"})
+	case strings.HasPrefix(path, "/latest/meta-data/tags/instance"):
+		h.serveTag(w, strings.Trim(strings.TrimPrefix(path, "/latest/meta-data/tags/instance"), "/"))
+	case path == "/latest/meta-data":
+		http.Redirect(w, r, "/latest/meta-data/", http.StatusMovedPermanently)
+	case strings.HasPrefix(path, "/latest/meta-data/"):
+		h.serveMetaData(w, strings.TrimPrefix(path, "/latest/meta-data/"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// serveMetaData answers a meta-data leaf or directory listing
+func (h *Handler) serveMetaData(w http.ResponseWriter, path string) {
+	if source, ok := h.mapping[path]; ok {
+		h.serveSource(w, source)
+		return
+	}
+
+	// Directory: list the next path component of every mapped entry below it
+	dir := path
+	if dir != "" && !strings.HasSuffix(dir, "/") {
+		dir += "/"
+	}
+	seen := make(map[string]bool)
+	for p := range h.mapping {
+		rest, ok := strings.CutPrefix(p, dir)
+		if !ok {
+			continue
+		}
+		if head, _, isDir := strings.Cut(rest, "/"); isDir {
+			seen[head+"/"] = true
+		} else {
+			seen[rest] = true
+		}
+	}
+	if dir == "" {
+		seen["tags/"] = true
+	}
+	if len(seen) == 0 {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	entries := make([]string, 0, len(seen))
+	for e := range seen {
+		entries = append(entries, e)
+	}
+	sort.Strings(entries)
+	h.writeList(w, entries)
+}
+
+// serveTag answers /latest/meta-data/tags/instance[/<tag>] from sdc:tags
+func (h *Handler) serveTag(w http.ResponseWriter, tag string) {
+	raw, ok, err := h.store.Get("sdc:tags")
+	if err != nil {
+		h.serverError(w, err)
+		return
+	}
+	tags := map[string]any{}
+	if ok && raw != "" {
+		if err := json.Unmarshal([]byte(raw), &tags); err != nil {
+			h.serverError(w, fmt.Errorf("invalid sdc:tags: %w", err))
+			return
+		}
+	}
+
+	if tag == "" {
+		names := make([]string, 0, len(tags))
+		for name := range tags {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		h.writeList(w, names)
+		return
+	}
+	value, ok := tags[tag]
+	if !ok {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	if s, isString := value.(string); isString {
+		h.writeText(w, s)
+		return
+	}
+	encoded, _ := json.Marshal(value)
+	h.writeText(w, string(encoded))
+}
+
+// serveSource writes the value resolved by source
+func (h *Handler) serveSource(w http.ResponseWriter, source Source) {
+	value, ok, err := source(h.store)
+	if err != nil {
+		h.serverError(w, err)
+		return
+	}
+	if !ok {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	h.writeText(w, value)
+}
+
+// serveToken issues an IMDSv2 session token
+func (h *Handler) serveToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		w.Header().Set("Allow", "PUT")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ttl, err := strconv.Atoi(r.Header.Get(TokenTTLHeader))
+	if err != nil || ttl < 1 || ttl > maxTokenTTL {
+		http.Error(w, "invalid token TTL", http.StatusBadRequest)
+		return
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		h.serverError(w, fmt.Errorf("failed to generate token: %w", err))
+		return
+	}
+	token := hex.EncodeToString(b)
+
+	now := time.Now()
+	h.mu.Lock()
+	for t, expiry := range h.tokens {
+		if now.After(expiry) {
+			delete(h.tokens, t)
+		}
+	}
+	h.tokens[token] = now.Add(time.Duration(ttl) * time.Second)
+	h.mu.Unlock()
+
+	w.Header().Set(TokenTTLHeader, strconv.Itoa(ttl))
+	h.writeText(w, token)
+}
+
+// validToken reports whether token was issued and has not expired
+func (h *Handler) validToken(token string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	expiry, ok := h.tokens[token]
+	return ok && time.Now().Before(expiry)
+}
+
+func (h *Handler) writeList(w http.ResponseWriter, entries []string) {
+	h.writeText(w, strings.Join(entries, "\n"))
+}
+
+func (h *Handler) writeText(w http.ResponseWriter, body string) {
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte(body))
+}
+
+func (h *Handler) serverError(w http.ResponseWriter, err error) {
+	if h.ErrorLog != nil {
+		h.ErrorLog.Printf("mdataimds: %v", err)
+	} else {
+		log.Printf("mdataimds: %v", err)
+	}
+	http.Error(w, "metadata unavailable", http.StatusInternalServerError)
+}
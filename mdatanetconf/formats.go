@@ -0,0 +1,323 @@
+package mdatanetconf
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Format names the network manager configuration is rendered for
+type Format string
+
+// Supported formats
+const (
+	Networkd Format = "systemd-networkd"
+	Netplan  Format = "netplan"
+	Ifcfg    Format = "ifcfg"
+	Illumos  Format = "illumos"
+)
+
+// Formats lists the supported formats
+var Formats = []Format{Networkd, Netplan, Ifcfg, Illumos}
+
+// header starts the files that allow comments
+const header = "# Generated by mdata netconf from SmartOS metadata; local changes will be lost.\n"
+
+// Render returns the files configuring n for format. Interfaces are matched
+// by MAC address where the format allows, since the names in sdc:nics are
+// those of the host rather than the guest; ifcfg and illumos files are named
+// after the interface names in sdc:nics.
+func Render(n *Network, format Format) ([]File, error) {
+	switch format {
+	case Networkd:
+		return renderNetworkd(n), nil
+	case Netplan:
+		return renderNetplan(n)
+	case Ifcfg:
+		return renderIfcfg(n), nil
+	case Illumos:
+		return renderIllumos(n), nil
+	}
+	return nil, fmt.Errorf("unknown format %q", format)
+}
+
+// renderNetworkd writes a .network file per NIC
+func renderNetworkd(n *Network) []File {
+	var files []File
+	for _, nic := range n.NICs {
+		var b strings.Builder
+		b.WriteString(header)
+		fmt.Fprintf(&b, "\n[Match]\nMACAddress=%s\n", nic.MAC)
+		if nic.MTU > 0 {
+			fmt.Fprintf(&b, "\n[Link]\nMTUBytes=%d\n", nic.MTU)
+		}
+		b.WriteString("\n[Network]\n")
+		switch {
+		case nic.DHCP4 && nic.Auto6:
+			b.WriteString("DHCP=yes\n")
+		case nic.DHCP4:
+			b.WriteString("DHCP=ipv4\n")
+		case nic.Auto6:
+			b.WriteString("DHCP=ipv6\n")
+		}
+		if nic.Auto6 {
+			b.WriteString("IPv6AcceptRA=yes\n")
+		}
+		for _, addr := range nic.Addresses {
+			fmt.Fprintf(&b, "Address=%s\n", addr)
+		}
+		for _, gw := range nic.gateways() {
+			fmt.Fprintf(&b, "Gateway=%s\n", gw)
+		}
+		if nic.Primary {
+			for _, ns := range n.Resolvers {
+				fmt.Fprintf(&b, "DNS=%s\n", ns)
+			}
+			if len(n.Search) > 0 {
+				fmt.Fprintf(&b, "Domains=%s\n", strings.Join(n.Search, " "))
+			}
+		}
+		for _, route := range n.routesVia(nic) {
+			fmt.Fprintf(&b, "\n[Route]\nDestination=%s\nGateway=%s\n", route.Dst, route.Gateway)
+		}
+		files = append(files, File{Path: "/etc/systemd/network/10-mdata-" + nic.Interface + ".network", Content: []byte(b.String()), Mode: 0o644})
+	}
+	return files
+}
+
+// netplan version 2 configuration (see netplan(5))
+type (
+	netplanConfig struct {
+		Network netplanNetwork `yaml:"network"`
+	}
+	netplanNetwork struct {
+		Version   int                        `yaml:"version"`
+		Ethernets map[string]netplanEthernet `yaml:"ethernets"`
+	}
+	netplanEthernet struct {
+		Match       netplanMatch        `yaml:"match"`
+		MTU         int                 `yaml:"mtu,omitempty"`
+		DHCP4       bool                `yaml:"dhcp4,omitempty"`
+		DHCP6       bool                `yaml:"dhcp6,omitempty"`
+		AcceptRA    bool                `yaml:"accept-ra,omitempty"`
+		Addresses   []string            `yaml:"addresses,omitempty"`
+		Routes      []netplanRoute      `yaml:"routes,omitempty"`
+		Nameservers *netplanNameservers `yaml:"nameservers,omitempty"`
+	}
+	netplanMatch struct {
+		MACAddress string `yaml:"macaddress"`
+	}
+	netplanRoute struct {
+		To  string `yaml:"to"`
+		Via string `yaml:"via"`
+	}
+	netplanNameservers struct {
+		Addresses []string `yaml:"addresses,omitempty"`
+		Search    []string `yaml:"search,omitempty"`
+	}
+)
+
+// renderNetplan writes a single netplan file. It is readable by its owner
+// only, as netplan warns about anything more.
+func renderNetplan(n *Network) ([]File, error) {
+	cfg := netplanConfig{Network: netplanNetwork{Version: 2, Ethernets: make(map[string]netplanEthernet)}}
+	for _, nic := range n.NICs {
+		eth := netplanEthernet{
+			Match:    netplanMatch{MACAddress: nic.MAC},
+			MTU:      nic.MTU,
+			DHCP4:    nic.DHCP4,
+			DHCP6:    nic.Auto6,
+			AcceptRA: nic.Auto6,
+		}
+		for _, addr := range nic.Addresses {
+			eth.Addresses = append(eth.Addresses, addr.String())
+		}
+		for _, gw := range nic.gateways() {
+			eth.Routes = append(eth.Routes, netplanRoute{To: "default", Via: gw.String()})
+		}
+		for _, route := range n.routesVia(nic) {
+			eth.Routes = append(eth.Routes, netplanRoute{To: route.Dst.String(), Via: route.Gateway.String()})
+		}
+		if nic.Primary && (len(n.Resolvers) > 0 || len(n.Search) > 0) {
+			eth.Nameservers = &netplanNameservers{Addresses: n.Resolvers, Search: n.Search}
+		}
+		cfg.Network.Ethernets[nic.Interface] = eth
+	}
+	out, err := yaml.Marshal(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode netplan configuration: %w", err)
+	}
+	return []File{{Path: "/etc/netplan/50-mdata.yaml", Content: append([]byte(header), out...), Mode: 0o600}}, nil
+}
+
+// renderIfcfg writes ifcfg and route files per NIC for the network scripts
+// and NetworkManager on RHEL and its derivatives
+func renderIfcfg(n *Network) []File {
+	const dir = "/etc/sysconfig/network-scripts/"
+	var files []File
+	for _, nic := range n.NICs {
+		var b strings.Builder
+		b.WriteString(header)
+		fmt.Fprintf(&b, "NAME=%s\nHWADDR=%s\nTYPE=Ethernet\nONBOOT=yes\n", nic.Interface, nic.MAC)
+		if nic.DHCP4 {
+			b.WriteString("BOOTPROTO=dhcp\n")
+		} else {
+			b.WriteString("BOOTPROTO=none\n")
+		}
+		if nic.MTU > 0 {
+			fmt.Fprintf(&b, "MTU=%d\n", nic.MTU)
+		}
+		v4, v6 := splitFamilies(nic.Addresses)
+		for i, addr := range v4 {
+			fmt.Fprintf(&b, "IPADDR%d=%s\nPREFIX%d=%d\n", i, addr.Addr(), i, addr.Bits())
+		}
+		if len(v6) > 0 || nic.Auto6 {
+			b.WriteString("IPV6INIT=yes\n")
+			fmt.Fprintf(&b, "IPV6_AUTOCONF=%s\n", yesNo(nic.Auto6))
+		}
+		if len(v6) > 0 {
+			fmt.Fprintf(&b, "IPV6ADDR=%s\n", v6[0])
+		}
+		if len(v6) > 1 {
+			fmt.Fprintf(&b, "IPV6ADDR_SECONDARIES=%q\n", joinPrefixes(v6[1:]))
+		}
+		for _, gw := range nic.gateways() {
+			if gw.Is4() {
+				fmt.Fprintf(&b, "GATEWAY=%s\n", gw)
+			} else {
+				fmt.Fprintf(&b, "IPV6_DEFAULTGW=%s\n", gw)
+			}
+		}
+		if nic.Primary {
+			for i, ns := range n.Resolvers {
+				fmt.Fprintf(&b, "DNS%d=%s\n", i+1, ns)
+			}
+			if len(n.Search) > 0 {
+				fmt.Fprintf(&b, "DOMAIN=%q\n", strings.Join(n.Search, " "))
+			}
+		}
+		files = append(files, File{Path: dir + "ifcfg-" + nic.Interface, Content: []byte(b.String()), Mode: 0o644})
+
+		var routes4, routes6 strings.Builder
+		for _, route := range n.routesVia(nic) {
+			w := &routes4
+			if route.Gateway.Is6() {
+				w = &routes6
+			}
+			if w.Len() == 0 {
+				w.WriteString(header)
+			}
+			fmt.Fprintf(w, "%s via %s\n", route.Dst, route.Gateway)
+		}
+		if routes4.Len() > 0 {
+			files = append(files, File{Path: dir + "route-" + nic.Interface, Content: []byte(routes4.String()), Mode: 0o644})
+		}
+		if routes6.Len() > 0 {
+			files = append(files, File{Path: dir + "route6-" + nic.Interface, Content: []byte(routes6.String()), Mode: 0o644})
+		}
+	}
+	return files
+}
+
+// renderIllumos writes the files read by the illumos network/physical and
+// routing services: /etc/hostname.<interface> and hostname6 for addresses,
+// dhcp.<interface> for DHCP, defaultrouter, inet/static_routes and
+// resolv.conf. IPv6 default routes go to static_routes, as defaultrouter
+// only holds IPv4 routers. The interface and routing files are passed to
+// ifconfig and route line by line, so only resolv.conf carries a header.
+func renderIllumos(n *Network) []File {
+	var (
+		files   []File
+		routers strings.Builder
+		static  strings.Builder
+	)
+	for _, nic := range n.NICs {
+		v4, v6 := splitFamilies(nic.Addresses)
+		if len(v4) > 0 || nic.DHCP4 || nic.MTU > 0 {
+			var b strings.Builder
+			if nic.MTU > 0 {
+				fmt.Fprintf(&b, "mtu %d\n", nic.MTU)
+			}
+			for i, addr := range v4 {
+				if i > 0 {
+					b.WriteString("addif ")
+				}
+				fmt.Fprintf(&b, "%s up\n", addr)
+			}
+			files = append(files, File{Path: "/etc/hostname." + nic.Interface, Content: []byte(b.String()), Mode: 0o644})
+		}
+		if nic.DHCP4 {
+			files = append(files, File{Path: "/etc/dhcp." + nic.Interface, Content: []byte{}, Mode: 0o644})
+		}
+		// An empty hostname6 file configures the link-local address and autoconfiguration
+		if len(v6) > 0 || nic.Auto6 {
+			var b strings.Builder
+			for _, addr := range v6 {
+				fmt.Fprintf(&b, "addif %s up\n", addr)
+			}
+			files = append(files, File{Path: "/etc/hostname6." + nic.Interface, Content: []byte(b.String()), Mode: 0o644})
+		}
+		for _, gw := range nic.gateways() {
+			if gw.Is4() {
+				fmt.Fprintf(&routers, "%s\n", gw)
+			} else {
+				fmt.Fprintf(&static, "-inet6 default %s\n", gw)
+			}
+		}
+	}
+	for _, route := range n.Routes {
+		if route.Gateway.Is6() {
+			static.WriteString("-inet6 ")
+		}
+		fmt.Fprintf(&static, "-net %s %s\n", route.Dst, route.Gateway)
+	}
+
+	if routers.Len() > 0 {
+		files = append(files, File{Path: "/etc/defaultrouter", Content: []byte(routers.String()), Mode: 0o644})
+	}
+	if static.Len() > 0 {
+		files = append(files, File{Path: "/etc/inet/static_routes", Content: []byte(static.String()), Mode: 0o644})
+	}
+	if len(n.Resolvers) > 0 {
+		var b strings.Builder
+		b.WriteString(header)
+		for _, ns := range n.Resolvers {
+			fmt.Fprintf(&b, "nameserver %s\n", ns)
+		}
+		if len(n.Search) > 0 {
+			fmt.Fprintf(&b, "search %s\n", strings.Join(n.Search, " "))
+		}
+		files = append(files, File{Path: "/etc/resolv.conf", Content: []byte(b.String()), Mode: 0o644})
+	}
+	return files
+}
+
+// splitFamilies separates IPv4 and IPv6 addresses
+func splitFamilies(addrs []netip.Prefix) (v4, v6 []netip.Prefix) {
+	for _, addr := range addrs {
+		if addr.Addr().Is4() {
+			v4 = append(v4, addr)
+		} else {
+			v6 = append(v6, addr)
+		}
+	}
+	return v4, v6
+}
+
+// joinPrefixes joins prefixes with spaces
+func joinPrefixes(prefixes []netip.Prefix) string {
+	s := make([]string, len(prefixes))
+	for i, p := range prefixes {
+		s[i] = p.String()
+	}
+	return strings.Join(s, " ")
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
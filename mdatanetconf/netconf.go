@@ -0,0 +1,263 @@
+// Package mdatanetconf renders the network configuration SmartOS describes in
+// sdc:nics, sdc:routes and sdc:resolvers as the files of the guest's network
+// manager: systemd-networkd, netplan, RHEL ifcfg scripts, or the illumos
+// /etc/hostname.<interface> family. It replaces the shell glue images carry
+// to translate these keys.
+package mdatanetconf
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/Smithx10/go-smartos-mdata/internal/fsutil"
+	"github.com/Smithx10/go-smartos-mdata/mdataserver"
+)
+
+// Metadata keys describing the network
+const (
+	keyNICs      = "sdc:nics"
+	keyRoutes    = "sdc:routes"
+	keyResolvers = "sdc:resolvers"
+	keyDNSDomain = "sdc:dns_domain"
+)
+
+// interfaceName matches interface names safe to use in file names
+var interfaceName = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]*$`)
+
+// NIC is a network interface of the instance
+type NIC struct {
+	Interface string // name in sdc:nics, such as net0
+	MAC       string
+	MTU       int            // zero to leave the default
+	Addresses []netip.Prefix // static addresses
+	DHCP4     bool           // configure IPv4 with DHCP
+	Auto6     bool           // configure IPv6 with router advertisements and DHCPv6
+	Gateways  []netip.Addr   // default gateways; only used for the primary NIC
+	Primary   bool
+}
+
+// Route is a static route
+type Route struct {
+	Dst     netip.Prefix
+	Gateway netip.Addr
+}
+
+// Network is the network configuration of an instance
+type Network struct {
+	NICs      []NIC
+	Routes    []Route
+	Resolvers []string
+	Search    []string // DNS search domains
+}
+
+// sdcNIC is the subset of an sdc:nics entry describing its configuration
+type sdcNIC struct {
+	Interface string   `json:"interface"`
+	MAC       string   `json:"mac"`
+	MTU       int      `json:"mtu"`
+	IP        string   `json:"ip"`
+	Netmask   string   `json:"netmask"`
+	IPs       []string `json:"ips"`
+	Gateway   string   `json:"gateway"`
+	Gateways  []string `json:"gateways"`
+	Primary   bool     `json:"primary"`
+}
+
+// sdcRoute is an sdc:routes entry
+type sdcRoute struct {
+	Linklocal bool   `json:"linklocal"`
+	Dst       string `json:"dst"`
+	Gateway   string `json:"gateway"`
+}
+
+// Read reads the network configuration from store. Only sdc:nics is required.
+func Read(store mdataserver.Store) (*Network, error) {
+	values := make(map[string]string)
+	for _, key := range []string{keyNICs, keyRoutes, keyResolvers, keyDNSDomain} {
+		value, ok, err := store.Get(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get %s: %w", key, err)
+		}
+		if ok {
+			values[key] = value
+		}
+	}
+	if values[keyNICs] == "" {
+		return nil, fmt.Errorf("%s is not set", keyNICs)
+	}
+	return Parse(values[keyNICs], values[keyRoutes], values[keyResolvers], values[keyDNSDomain])
+}
+
+// Parse builds the network configuration from the values of sdc:nics,
+// sdc:routes, sdc:resolvers and sdc:dns_domain, any but the first of which
+// may be empty. Link-local routes, which name an interface rather than a
+// next hop, are left out.
+func Parse(nics, routes, resolvers, dnsDomain string) (*Network, error) {
+	var rawNICs []sdcNIC
+	if err := json.Unmarshal([]byte(nics), &rawNICs); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", keyNICs, err)
+	}
+	n := &Network{}
+	for _, raw := range rawNICs {
+		nic, err := parseNIC(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", keyNICs, err)
+		}
+		n.NICs = append(n.NICs, nic)
+	}
+
+	if routes != "" {
+		var rawRoutes []sdcRoute
+		if err := json.Unmarshal([]byte(routes), &rawRoutes); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", keyRoutes, err)
+		}
+		for _, raw := range rawRoutes {
+			if raw.Linklocal {
+				continue
+			}
+			dst, err := parsePrefix(raw.Dst)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", keyRoutes, err)
+			}
+			gw, err := netip.ParseAddr(raw.Gateway)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", keyRoutes, err)
+			}
+			n.Routes = append(n.Routes, Route{Dst: dst, Gateway: gw})
+		}
+	}
+
+	if resolvers != "" {
+		if err := json.Unmarshal([]byte(resolvers), &n.Resolvers); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", keyResolvers, err)
+		}
+	}
+	if dnsDomain != "" {
+		n.Search = []string{dnsDomain}
+	}
+	return n, nil
+}
+
+// parseNIC converts an sdc:nics entry. Addresses without a prefix length
+// take it from the netmask.
+func parseNIC(raw sdcNIC) (NIC, error) {
+	if !interfaceName.MatchString(raw.Interface) {
+		return NIC{}, fmt.Errorf("invalid interface name %q", raw.Interface)
+	}
+	nic := NIC{Interface: raw.Interface, MAC: raw.MAC, MTU: raw.MTU, Primary: raw.Primary}
+
+	ips := raw.IPs
+	if len(ips) == 0 && raw.IP != "" {
+		ips = []string{raw.IP}
+	}
+	for _, ip := range ips {
+		switch ip {
+		case "dhcp":
+			nic.DHCP4 = true
+		case "addrconf":
+			nic.Auto6 = true
+		default:
+			if !strings.Contains(ip, "/") && raw.Netmask != "" {
+				mask := net.ParseIP(raw.Netmask).To4()
+				if mask == nil {
+					return NIC{}, fmt.Errorf("invalid netmask %q of %s", raw.Netmask, raw.Interface)
+				}
+				bits, _ := net.IPMask(mask).Size()
+				ip = fmt.Sprintf("%s/%d", ip, bits)
+			}
+			prefix, err := parsePrefix(ip)
+			if err != nil {
+				return NIC{}, err
+			}
+			nic.Addresses = append(nic.Addresses, prefix)
+		}
+	}
+
+	gateways := raw.Gateways
+	if len(gateways) == 0 && raw.Gateway != "" {
+		gateways = []string{raw.Gateway}
+	}
+	for _, gw := range gateways {
+		addr, err := netip.ParseAddr(gw)
+		if err != nil {
+			return NIC{}, fmt.Errorf("invalid gateway of %s: %w", raw.Interface, err)
+		}
+		nic.Gateways = append(nic.Gateways, addr)
+	}
+	return nic, nil
+}
+
+// parsePrefix parses an address with an optional prefix length; a bare
+// address is a host route or address
+func parsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		return netip.ParsePrefix(s)
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// gateways returns the default gateways of nic, which are only configured on
+// the primary NIC
+func (nic NIC) gateways() []netip.Addr {
+	if !nic.Primary {
+		return nil
+	}
+	return nic.Gateways
+}
+
+// routesVia returns the routes whose gateway is reached through nic: those
+// on one of its subnets, and on the primary NIC those reached through no
+// other NIC
+func (n *Network) routesVia(nic NIC) []Route {
+	var routes []Route
+	for _, route := range n.Routes {
+		owner := -1
+		for i, other := range n.NICs {
+			for _, addr := range other.Addresses {
+				if owner < 0 && addr.Masked().Contains(route.Gateway) {
+					owner = i
+				}
+			}
+		}
+		switch {
+		case owner >= 0 && n.NICs[owner].Interface == nic.Interface:
+			routes = append(routes, route)
+		case owner < 0 && nic.Primary:
+			routes = append(routes, route)
+		}
+	}
+	return routes
+}
+
+// File is a configuration file to install
+type File struct {
+	Path    string
+	Content []byte
+	Mode    os.FileMode
+}
+
+// Install writes files under root, creating their directories
+func Install(files []File, root string) ([]string, error) {
+	var written []string
+	for _, f := range files {
+		path := filepath.Join(root, f.Path)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return written, fmt.Errorf("failed to create %s: %w", filepath.Dir(path), err)
+		}
+		if err := fsutil.WriteFileAtomic(path, f.Content, f.Mode); err != nil {
+			return written, err
+		}
+		written = append(written, path)
+	}
+	return written, nil
+}
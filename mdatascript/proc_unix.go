@@ -0,0 +1,77 @@
+//go:build !windows
+
+package mdatascript
+
+import (
+	"fmt"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// defaultScriptBanner is prepended to a script without an interpreter line
+const defaultScriptBanner = "#!/bin/sh\n"
+
+// scriptExt is appended to the temporary script file name
+const scriptExt = ""
+
+// cleanPath is the PATH given to scripts run with a clean environment
+const cleanPath = "/usr/local/sbin:/usr/local/bin:/opt/local/sbin:/opt/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// scriptCommand runs the script through its interpreter line
+func scriptCommand(path string) *exec.Cmd {
+	return exec.Command(path)
+}
+
+// sysProcAttr starts the script in its own process group, so a timeout can
+// kill everything it started, and under the given credentials. It also
+// returns the numeric IDs, -1 when unchanged.
+func sysProcAttr(userName, groupName string) (*syscall.SysProcAttr, int, int, error) {
+	attr := &syscall.SysProcAttr{Setpgid: true}
+	if userName == "" && groupName == "" {
+		return attr, -1, -1, nil
+	}
+
+	uid, gid := syscall.Getuid(), syscall.Getgid()
+	if userName != "" {
+		u, err := lookupUser(userName)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		uid, _ = strconv.Atoi(u.Uid)
+		gid, _ = strconv.Atoi(u.Gid)
+	}
+	if groupName != "" {
+		id, err := strconv.Atoi(groupName)
+		if err != nil {
+			g, err := user.LookupGroup(groupName)
+			if err != nil {
+				return nil, 0, 0, fmt.Errorf("unknown group %q: %w", groupName, err)
+			}
+			id, _ = strconv.Atoi(g.Gid)
+		}
+		gid = id
+	}
+	attr.Credential = &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)}
+	return attr, uid, gid, nil
+}
+
+// lookupUser finds a user by name or numeric ID
+func lookupUser(name string) (*user.User, error) {
+	if _, err := strconv.Atoi(name); err == nil {
+		if u, err := user.LookupId(name); err == nil {
+			return u, nil
+		}
+	}
+	u, err := user.Lookup(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown user %q: %w", name, err)
+	}
+	return u, nil
+}
+
+// killProcessGroup kills the script and every process in its group
+func killProcessGroup(cmd *exec.Cmd) {
+	syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
@@ -0,0 +1,36 @@
+//go:build windows
+
+package mdatascript
+
+import (
+	"fmt"
+	"os/exec"
+	"syscall"
+)
+
+// defaultScriptBanner is empty: batch files have no interpreter line
+const defaultScriptBanner = ""
+
+// scriptExt makes the temporary script a batch file
+const scriptExt = ".cmd"
+
+// cleanPath is the PATH given to scripts run with a clean environment
+const cleanPath = `C:\Windows\system32;C:\Windows`
+
+// scriptCommand runs the script through cmd.exe
+func scriptCommand(path string) *exec.Cmd {
+	return exec.Command("cmd.exe", "/c", path)
+}
+
+// sysProcAttr returns no attributes; running as another user is not supported
+func sysProcAttr(userName, groupName string) (*syscall.SysProcAttr, int, int, error) {
+	if userName != "" || groupName != "" {
+		return nil, 0, 0, fmt.Errorf("running scripts as another user is not supported on Windows")
+	}
+	return nil, -1, -1, nil
+}
+
+// killProcessGroup kills the script
+func killProcessGroup(cmd *exec.Cmd) {
+	cmd.Process.Kill()
+}
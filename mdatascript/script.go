@@ -0,0 +1,172 @@
+// Package mdatascript runs a script stored in metadata, such as
+// sdc:operator-script, and records its outcome back into metadata.
+package mdatascript
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Smithx10/go-smartos-mdata/mdataserver"
+)
+
+// Defaults for Options
+const (
+	DefaultKey       = "sdc:operator-script"
+	DefaultStatusKey = "operator-script:status"
+)
+
+// Options configures how a script is run
+type Options struct {
+	// Key holds the script (default sdc:operator-script)
+	Key string
+	// StatusKey receives the Status as JSON after the run; "-" disables it
+	// (default operator-script:status)
+	StatusKey string
+	// Timeout kills the script and everything it started after this long; zero means no limit
+	Timeout time.Duration
+	// User and Group run the script under other credentials, by name or numeric ID
+	User, Group string
+	// Dir is the working directory; empty uses a fresh temporary directory
+	// that is removed afterwards
+	Dir string
+	// CleanEnv starts the script with only Env and a minimal PATH instead of
+	// the caller's environment
+	CleanEnv bool
+	// Env holds extra NAME=value variables
+	Env []string
+	// Output receives the script's standard output and error; nil discards them
+	Output io.Writer
+}
+
+// Status is the outcome of a run, as recorded under StatusKey
+type Status struct {
+	// SHA256 identifies the script that ran
+	SHA256   string    `json:"sha256"`
+	Started  time.Time `json:"started"`
+	Duration string    `json:"duration"`
+	ExitCode int       `json:"exit_code"`
+	TimedOut bool      `json:"timed_out,omitempty"`
+	// Error describes a failure to start the script
+	Error string `json:"error,omitempty"`
+}
+
+// Run fetches the script from store and runs it. It returns a nil Status
+// when the key is not set. A script that runs but fails is reported through
+// Status.ExitCode rather than an error.
+func Run(store mdataserver.Store, opts Options) (*Status, error) {
+	if opts.Key == "" {
+		opts.Key = DefaultKey
+	}
+	if opts.StatusKey == "" {
+		opts.StatusKey = DefaultStatusKey
+	}
+	script, ok, err := store.Get(opts.Key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %w", opts.Key, err)
+	}
+	if !ok || strings.TrimSpace(script) == "" {
+		return nil, nil
+	}
+
+	sum := sha256.Sum256([]byte(script))
+	status := &Status{SHA256: hex.EncodeToString(sum[:]), Started: time.Now().UTC()}
+	runErr := run(script, opts, status)
+	if runErr != nil {
+		status.ExitCode = -1
+		status.Error = runErr.Error()
+	}
+
+	if opts.StatusKey != "-" {
+		data, err := json.Marshal(status)
+		if err != nil {
+			return status, fmt.Errorf("failed to encode status: %w", err)
+		}
+		if err := store.Put(opts.StatusKey, string(data)); err != nil {
+			return status, fmt.Errorf("failed to record status in %s: %w", opts.StatusKey, err)
+		}
+	}
+	return status, runErr
+}
+
+// run writes script to a temporary file and executes it, filling in status
+func run(script string, opts Options, status *Status) error {
+	dir := opts.Dir
+	tmp, err := os.MkdirTemp("", "mdata-script-")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(tmp)
+	if dir == "" {
+		dir = tmp
+	}
+
+	if defaultScriptBanner != "" && !strings.HasPrefix(script, "#!") {
+		script = defaultScriptBanner + script
+	}
+	path := filepath.Join(tmp, "script"+scriptExt)
+	if err := os.WriteFile(path, []byte(script), 0o700); err != nil {
+		return fmt.Errorf("failed to write script: %w", err)
+	}
+
+	attr, uid, gid, err := sysProcAttr(opts.User, opts.Group)
+	if err != nil {
+		return err
+	}
+	if uid != -1 || gid != -1 {
+		// The script and the directory holding it must be usable by the new user
+		for _, p := range []string{tmp, path} {
+			if err := os.Chown(p, uid, gid); err != nil {
+				return fmt.Errorf("failed to chown %s: %w", p, err)
+			}
+		}
+	}
+
+	cmd := scriptCommand(path)
+	cmd.Dir = dir
+	cmd.SysProcAttr = attr
+	cmd.Stdout = opts.Output
+	cmd.Stderr = opts.Output
+	if opts.CleanEnv {
+		cmd.Env = append([]string{"PATH=" + cleanPath}, opts.Env...)
+	} else {
+		cmd.Env = append(os.Environ(), opts.Env...)
+	}
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start script: %w", err)
+	}
+	var timer *time.Timer
+	timedOut := make(chan struct{})
+	if opts.Timeout > 0 {
+		timer = time.AfterFunc(opts.Timeout, func() {
+			close(timedOut)
+			killProcessGroup(cmd)
+		})
+	}
+	err = cmd.Wait()
+	if timer != nil {
+		timer.Stop()
+	}
+	status.Duration = time.Since(status.Started).Round(time.Millisecond).String()
+	select {
+	case <-timedOut:
+		status.TimedOut = true
+	default:
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		status.ExitCode = exitErr.ExitCode()
+		return nil
+	}
+	return err
+}
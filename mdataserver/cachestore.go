@@ -0,0 +1,111 @@
+package mdataserver
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// CacheStore is a Store answering Get and Keys from another Store, keeping
+// each answer for a time to live. Repeated reads then cost nothing upstream,
+// at the price of missing changes made elsewhere for up to the TTL; changes
+// made through the CacheStore itself are seen at once. A zero TTL caches
+// nothing.
+type CacheStore struct {
+	store Store
+	ttl   time.Duration
+
+	mu     sync.Mutex
+	values map[string]cachedValue
+	keys   []string
+	keysAt time.Time // when keys was fetched; zero when not cached
+}
+
+// cachedValue is the cached answer to a Get
+type cachedValue struct {
+	value string
+	ok    bool
+	at    time.Time
+}
+
+// NewCacheStore creates a CacheStore caching the answers of store for ttl
+func NewCacheStore(store Store, ttl time.Duration) *CacheStore {
+	return &CacheStore{store: store, ttl: ttl, values: make(map[string]cachedValue)}
+}
+
+// fresh reports whether an answer cached at is still valid
+func (s *CacheStore) fresh(at time.Time) bool {
+	return !at.IsZero() && time.Since(at) < s.ttl
+}
+
+// Get implements Store.Get
+func (s *CacheStore) Get(key string) (string, bool, error) {
+	s.mu.Lock()
+	if v, cached := s.values[key]; cached && s.fresh(v.at) {
+		s.mu.Unlock()
+		return v.value, v.ok, nil
+	}
+	s.mu.Unlock()
+
+	value, ok, err := s.store.Get(key)
+	if err != nil {
+		return "", false, err
+	}
+	if s.ttl > 0 {
+		s.mu.Lock()
+		s.values[key] = cachedValue{value: value, ok: ok, at: time.Now()}
+		s.mu.Unlock()
+	}
+	return value, ok, nil
+}
+
+// Keys implements Store.Keys
+func (s *CacheStore) Keys() ([]string, error) {
+	s.mu.Lock()
+	if s.fresh(s.keysAt) {
+		keys := slices.Clone(s.keys)
+		s.mu.Unlock()
+		return keys, nil
+	}
+	s.mu.Unlock()
+
+	keys, err := s.store.Keys()
+	if err != nil {
+		return nil, err
+	}
+	if s.ttl > 0 {
+		s.mu.Lock()
+		s.keys, s.keysAt = slices.Clone(keys), time.Now()
+		s.mu.Unlock()
+	}
+	return keys, nil
+}
+
+// Put implements Store.Put
+func (s *CacheStore) Put(key, value string) error {
+	err := s.store.Put(key, value)
+	s.update(key, cachedValue{value: value, ok: true}, err)
+	return err
+}
+
+// Delete implements Store.Delete
+func (s *CacheStore) Delete(key string) error {
+	err := s.store.Delete(key)
+	s.update(key, cachedValue{}, err)
+	return err
+}
+
+// update caches v for key after a write, or drops the cached key when the
+// write failed with err, as its outcome is unknown. The cached keys are
+// dropped either way.
+func (s *CacheStore) update(key string, v cachedValue, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keysAt = time.Time{}
+	if err != nil || s.ttl <= 0 {
+		delete(s.values, key)
+		return
+	}
+	v.at = time.Now()
+	s.values[key] = v
+}
@@ -0,0 +1,129 @@
+package mdataserver
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Smithx10/go-smartos-mdata/mdata"
+)
+
+// ClientStore is a Store forwarding every operation to an upstream metadata
+// channel, for bridging a serial device or zone socket to local consumers.
+// Operations are serialized, so a single upstream connection can be shared
+// safely by any number of downstream connections.
+type ClientStore struct {
+	mu         sync.Mutex
+	dial       func() (mdata.MetadataClient, error)
+	persistent bool
+	client     mdata.MetadataClient
+}
+
+// NewClientStore creates a ClientStore using dial to connect upstream.
+// A persistent store keeps one upstream connection open across requests and
+// redials only after a failure; otherwise each request gets a fresh connection.
+func NewClientStore(dial func() (mdata.MetadataClient, error), persistent bool) *ClientStore {
+	return &ClientStore{dial: dial, persistent: persistent}
+}
+
+// Get implements Store.Get
+func (s *ClientStore) Get(key string) (string, bool, error) {
+	var value string
+	err := s.do(func(c mdata.MetadataClient) error {
+		var err error
+		value, err = c.Get(key)
+		return err
+	})
+	if errors.Is(err, mdata.ErrNotFound) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return value, true, nil
+}
+
+// Keys implements Store.Keys
+func (s *ClientStore) Keys() ([]string, error) {
+	var raw string
+	err := s.do(func(c mdata.MetadataClient) error {
+		var err error
+		raw, err = c.Keys()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if raw == "" {
+		return nil, nil
+	}
+	return strings.Split(raw, "\n"), nil
+}
+
+// Put implements Store.Put
+func (s *ClientStore) Put(key, value string) error {
+	return s.do(func(c mdata.MetadataClient) error {
+		return c.Put(key, value)
+	})
+}
+
+// Delete implements Store.Delete
+func (s *ClientStore) Delete(key string) error {
+	return s.do(func(c mdata.MetadataClient) error {
+		return c.Delete(key)
+	})
+}
+
+// Watch waits for the keys starting with prefix to differ from state using
+// the upstream's WATCH extension; see mdata.MetadataClientImpl.Watch. Other
+// operations wait while it is held. Upstream clients that cannot send WATCH
+// requests fail with mdata.ErrWatchUnsupported.
+func (s *ClientStore) Watch(prefix, state string, timeout time.Duration) (string, error) {
+	var current string
+	err := s.do(func(c mdata.MetadataClient) error {
+		w, ok := c.(mdata.Watcher)
+		if !ok {
+			return mdata.ErrWatchUnsupported
+		}
+		var err error
+		current, err = w.Watch(prefix, state, timeout)
+		return err
+	})
+	return current, err
+}
+
+// Close closes the upstream connection, if one is open
+func (s *ClientStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.client == nil {
+		return nil
+	}
+	err := s.client.Close()
+	s.client = nil
+	return err
+}
+
+// do runs op against the upstream connection while holding the lock.
+// Any error other than NOTFOUND drops the connection so the next request
+// starts from a freshly negotiated channel.
+func (s *ClientStore) do(op func(mdata.MetadataClient) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.client == nil {
+		client, err := s.dial()
+		if err != nil {
+			return err
+		}
+		s.client = client
+	}
+
+	err := op(s.client)
+	if !s.persistent || (err != nil && !errors.Is(err, mdata.ErrNotFound)) {
+		s.client.Close()
+		s.client = nil
+	}
+	return err
+}
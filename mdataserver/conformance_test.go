@@ -0,0 +1,20 @@
+package mdataserver_test
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	"github.com/Smithx10/go-smartos-mdata/mdataserver"
+	"github.com/Smithx10/go-smartos-mdata/mdatatest"
+)
+
+func TestServerConformance(t *testing.T) {
+	srv := mdataserver.NewServer(mdataserver.NewMemoryStore(nil))
+	t.Cleanup(func() { srv.Close() })
+	mdatatest.RunServerConformance(t, func() (io.ReadWriteCloser, error) {
+		serverConn, clientConn := net.Pipe()
+		go srv.ServeConn(serverConn)
+		return clientConn, nil
+	})
+}
@@ -0,0 +1,188 @@
+package mdataserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Smithx10/go-smartos-mdata/internal/fsutil"
+)
+
+// DirStore is a Store persisting each key as a file in a directory.
+// File names are the URL path-escaped key, so any key maps to a single file.
+type DirStore struct {
+	mu  sync.RWMutex
+	dir string
+}
+
+// NewDirStore creates a DirStore rooted at dir, creating the directory if needed
+func NewDirStore(dir string) (*DirStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create store directory %s: %w", dir, err)
+	}
+	return &DirStore{dir: dir}, nil
+}
+
+// Get implements Store.Get
+func (s *DirStore) Get(key string) (string, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	data, err := os.ReadFile(s.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("failed to read key %q: %w", key, err)
+	}
+	return string(data), true, nil
+}
+
+// Keys implements Store.Keys, returning keys in sorted order
+func (s *DirStore) Keys() ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list store directory %s: %w", s.dir, err)
+	}
+	keys := make([]string, 0, len(entries))
+	for _, e := range entries {
+		// Skip temporary files from in-flight or interrupted writes
+		if !e.Type().IsRegular() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		key, err := url.PathUnescape(e.Name())
+		if err != nil {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Put implements Store.Put
+func (s *DirStore) Put(key, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return fsutil.WriteFileAtomic(s.path(key), []byte(value), 0o600)
+}
+
+// Delete implements Store.Delete
+func (s *DirStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.Remove(s.path(key)); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("failed to delete key %q: %w", key, err)
+	}
+	return fsutil.SyncDir(s.dir)
+}
+
+// path returns the file holding key
+func (s *DirStore) path(key string) string {
+	name := url.PathEscape(key)
+	// Escape a leading dot so keys never collide with ".", ".." or temporary files
+	if strings.HasPrefix(name, ".") {
+		name = "%2E" + name[1:]
+	}
+	return filepath.Join(s.dir, name)
+}
+
+// JSONFileStore is a Store persisting all keys as a single JSON object.
+// Every mutation rewrites the file atomically.
+type JSONFileStore struct {
+	mu   sync.RWMutex
+	path string
+	data map[string]string
+}
+
+// NewJSONFileStore opens the JSON store at path; a missing file starts an empty store
+func NewJSONFileStore(path string) (*JSONFileStore, error) {
+	s := &JSONFileStore{path: path, data: make(map[string]string)}
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read store file %s: %w", path, err)
+	}
+	if err := json.Unmarshal(raw, &s.data); err != nil {
+		return nil, fmt.Errorf("failed to parse store file %s: %w", path, err)
+	}
+	if s.data == nil {
+		s.data = make(map[string]string)
+	}
+	return s, nil
+}
+
+// Get implements Store.Get
+func (s *JSONFileStore) Get(key string) (string, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	value, ok := s.data[key]
+	return value, ok, nil
+}
+
+// Keys implements Store.Keys, returning keys in sorted order
+func (s *JSONFileStore) Keys() ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keys := make([]string, 0, len(s.data))
+	for k := range s.data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Put implements Store.Put
+func (s *JSONFileStore) Put(key, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, existed := s.data[key]
+	s.data[key] = value
+	if err := s.flush(); err != nil {
+		// Keep memory consistent with what is on disk
+		if existed {
+			s.data[key] = old
+		} else {
+			delete(s.data, key)
+		}
+		return err
+	}
+	return nil
+}
+
+// Delete implements Store.Delete
+func (s *JSONFileStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, existed := s.data[key]
+	if !existed {
+		return nil
+	}
+	delete(s.data, key)
+	if err := s.flush(); err != nil {
+		s.data[key] = old
+		return err
+	}
+	return nil
+}
+
+// flush writes the current contents to disk; callers must hold s.mu
+func (s *JSONFileStore) flush() error {
+	raw, err := json.MarshalIndent(s.data, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode store: %w", err)
+	}
+	return fsutil.WriteFileAtomic(s.path, append(raw, '\n'), 0o600)
+}
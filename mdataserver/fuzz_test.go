@@ -0,0 +1,42 @@
+package mdataserver_test
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/Smithx10/go-smartos-mdata/mdata/protocol"
+	"github.com/Smithx10/go-smartos-mdata/mdataserver"
+	"github.com/Smithx10/go-smartos-mdata/mdatatest"
+)
+
+// FuzzServeConn feeds arbitrary client input to a Server over a MemoryStore
+// and checks that the server neither panics nor hangs and answers every
+// complete line.
+func FuzzServeConn(f *testing.F) {
+	for _, seed := range mdatatest.FrameSeeds {
+		f.Add(protocol.NegotiationReq + seed)
+	}
+	f.Fuzz(func(t *testing.T, input string) {
+		srv := mdataserver.NewServer(mdataserver.NewMemoryStore(map[string]string{"sdc:uuid": "u"}))
+		srv.ErrorLog = log.New(io.Discard, "", 0)
+		conn := &bufferConn{Reader: strings.NewReader(input)}
+		if err := srv.ServeConn(conn); err != nil {
+			t.Fatalf("ServeConn: %v", err)
+		}
+		if lines, replies := strings.Count(input, "\n"), strings.Count(conn.out.String(), "\n"); replies < lines {
+			t.Fatalf("%d request lines produced %d reply lines", lines, replies)
+		}
+	})
+}
+
+// bufferConn is a connection reading from a fixed input and recording output
+type bufferConn struct {
+	io.Reader
+	out bytes.Buffer
+}
+
+func (c *bufferConn) Write(b []byte) (int, error) { return c.out.Write(b) }
+func (c *bufferConn) Close() error                { return nil }
@@ -0,0 +1,126 @@
+package mdataserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultMaxFailures is the number of consecutive failed requests after which
+// a HealthStore reports the metadata channel unhealthy
+const DefaultMaxFailures = 3
+
+// HealthStore is a Store recording the outcome of every request it forwards
+// to another, to report the health of the metadata channel behind it. A key
+// that does not exist counts as a success.
+type HealthStore struct {
+	store Store
+
+	// MaxFailures is the number of consecutive failures after which /healthz
+	// fails; DefaultMaxFailures when zero
+	MaxFailures int
+
+	mu                  sync.Mutex
+	lastSuccess         time.Time
+	lastErr             error
+	consecutiveFailures int
+}
+
+// Health is the state of the metadata channel reported by a HealthStore
+type Health struct {
+	LastSuccess         time.Time `json:"last_success,omitzero"`
+	LastError           string    `json:"last_error,omitempty"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+}
+
+// NewHealthStore creates a HealthStore forwarding to store
+func NewHealthStore(store Store) *HealthStore {
+	return &HealthStore{store: store}
+}
+
+// record notes the outcome of a request
+func (s *HealthStore) record(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		s.lastErr = err
+		s.consecutiveFailures++
+		return
+	}
+	s.lastSuccess = time.Now()
+	s.lastErr = nil
+	s.consecutiveFailures = 0
+}
+
+// Get implements Store.Get
+func (s *HealthStore) Get(key string) (string, bool, error) {
+	value, ok, err := s.store.Get(key)
+	s.record(err)
+	return value, ok, err
+}
+
+// Keys implements Store.Keys
+func (s *HealthStore) Keys() ([]string, error) {
+	keys, err := s.store.Keys()
+	s.record(err)
+	return keys, err
+}
+
+// Put implements Store.Put
+func (s *HealthStore) Put(key, value string) error {
+	err := s.store.Put(key, value)
+	s.record(err)
+	return err
+}
+
+// Delete implements Store.Delete
+func (s *HealthStore) Delete(key string) error {
+	err := s.store.Delete(key)
+	s.record(err)
+	return err
+}
+
+// Health returns the state of the metadata channel
+func (s *HealthStore) Health() Health {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h := Health{LastSuccess: s.lastSuccess, ConsecutiveFailures: s.consecutiveFailures}
+	if s.lastErr != nil {
+		h.LastError = s.lastErr.Error()
+	}
+	return h
+}
+
+// Handler returns an http.Handler serving the health of the metadata channel
+// as JSON, for service monitors:
+//
+//	/healthz  fails with 503 once MaxFailures requests in a row have failed
+//	/readyz   sends a request for sdc:uuid and fails with 503 unless it succeeds
+//
+// Other paths are not found.
+func (s *HealthStore) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ok bool
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/healthz"):
+			maxFailures := s.MaxFailures
+			if maxFailures <= 0 {
+				maxFailures = DefaultMaxFailures
+			}
+			ok = s.Health().ConsecutiveFailures < maxFailures
+		case strings.HasSuffix(r.URL.Path, "/readyz"):
+			_, _, err := s.Get("sdc:uuid")
+			ok = err == nil
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if !ok {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(s.Health())
+	})
+}
@@ -0,0 +1,69 @@
+package mdataserver
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/Smithx10/go-smartos-mdata/mdata"
+)
+
+// PoolStore is a Store forwarding every operation to an upstream metadata
+// service over the connections of an mdata.Pool. Unlike ClientStore, which
+// serializes operations on one connection, it serves up to the pool's size
+// concurrently, for upstreams reached over a socket.
+type PoolStore struct {
+	pool *mdata.Pool
+}
+
+// NewPoolStore creates a PoolStore using pool, which Close closes
+func NewPoolStore(pool *mdata.Pool) *PoolStore {
+	return &PoolStore{pool: pool}
+}
+
+// Get implements Store.Get
+func (s *PoolStore) Get(key string) (string, bool, error) {
+	value, err := s.pool.Get(key)
+	if errors.Is(err, mdata.ErrNotFound) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return value, true, nil
+}
+
+// Keys implements Store.Keys
+func (s *PoolStore) Keys() ([]string, error) {
+	raw, err := s.pool.Keys()
+	if err != nil {
+		return nil, err
+	}
+	if raw == "" {
+		return nil, nil
+	}
+	return strings.Split(raw, "\n"), nil
+}
+
+// Put implements Store.Put
+func (s *PoolStore) Put(key, value string) error {
+	return s.pool.Do(func(c mdata.MetadataClient) error {
+		return c.Put(key, value)
+	})
+}
+
+// Delete implements Store.Delete
+func (s *PoolStore) Delete(key string) error {
+	return s.pool.Do(func(c mdata.MetadataClient) error {
+		return c.Delete(key)
+	})
+}
+
+// Stats returns the usage of the store's pool
+func (s *PoolStore) Stats() mdata.PoolStats {
+	return s.pool.Stats()
+}
+
+// Close closes the pool
+func (s *PoolStore) Close() error {
+	return s.pool.Close()
+}
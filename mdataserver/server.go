@@ -0,0 +1,473 @@
+package mdataserver
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Smithx10/go-smartos-mdata/mdata"
+	"github.com/Smithx10/go-smartos-mdata/mdata/protocol"
+)
+
+// Response codes sent by the server
+const (
+	CodeSuccess  = protocol.CodeSuccess
+	CodeNotFound = protocol.CodeNotFound
+	CodeFailure  = protocol.CodeFailure
+	// CodeNotModified answers a GETIFCHANGED request for an unchanged value
+	CodeNotModified = protocol.CodeNotModified
+)
+
+// invalidCommand is the reply sent for lines that are not V2 frames,
+// matching what the SmartOS metadata agent sends to V1 clients
+const invalidCommand = "invalid command\n"
+
+// maxAuthLineLength bounds the lines of the authentication handshake
+const maxAuthLineLength = 1024
+
+// maxWatchTimeout bounds how long a WATCH request is held
+const maxWatchTimeout = 10 * time.Minute
+
+// ErrServerClosed is returned by Serve after Close has been called
+var ErrServerClosed = errors.New("mdataserver: server closed")
+
+// Server answers V2 metadata protocol requests from a Store
+type Server struct {
+	store Store
+
+	// ErrorLog receives connection and store errors; nil uses the log package's standard logger
+	ErrorLog *log.Logger
+
+	// Secret, when set, requires every connection to complete the shared-secret
+	// authentication handshake (see mdata.Authenticate) before anything else
+	Secret []byte
+
+	// WatchInterval is how often a held WATCH request reads the store for
+	// changes made behind the server's back; zero means every second.
+	// Changes made through the server are noticed at once.
+	WatchInterval time.Duration
+
+	mu        sync.Mutex
+	closed    bool
+	done      chan struct{} // closed by Close
+	changed   chan struct{} // closed and replaced on every change made through the server
+	listeners map[net.Listener]struct{}
+	conns     map[io.Closer]struct{}
+	wg        sync.WaitGroup
+}
+
+// NewServer creates a Server backed by store
+func NewServer(store Store) *Server {
+	return &Server{
+		store:     store,
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[io.Closer]struct{}),
+		done:      make(chan struct{}),
+		changed:   make(chan struct{}),
+	}
+}
+
+// ListenAndServe listens on the given network ("unix" or "tcp") and address and serves connections
+func (s *Server) ListenAndServe(network, address string) error {
+	l, err := net.Listen(network, address)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s %s: %w", network, address, err)
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts connections on l and serves each one in its own goroutine.
+// It always returns a non-nil error; after Close it returns ErrServerClosed.
+func (s *Server) Serve(l net.Listener) error {
+	if !s.trackListener(l) {
+		l.Close()
+		return ErrServerClosed
+	}
+	defer s.untrackListener(l)
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if s.isClosed() {
+				return ErrServerClosed
+			}
+			return fmt.Errorf("failed to accept connection: %w", err)
+		}
+		if !s.addServing() {
+			conn.Close()
+			return ErrServerClosed
+		}
+		go func() {
+			defer s.wg.Done()
+			if err := s.ServeConn(conn); err != nil {
+				s.logf("mdataserver: %s: %v", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+// ServeConn serves requests on a single connection until the peer disconnects.
+// The connection is closed when ServeConn returns.
+func (s *Server) ServeConn(conn io.ReadWriteCloser) error {
+	if !s.trackConn(conn) {
+		conn.Close()
+		return ErrServerClosed
+	}
+	defer s.untrackConn(conn)
+	defer conn.Close()
+
+	rw := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+	if len(s.Secret) > 0 {
+		if err := s.authenticate(rw); err != nil {
+			return err
+		}
+	}
+	for {
+		line, err := protocol.ReadLine(rw.Reader, protocol.MaxLineLength)
+		if err != nil {
+			if errors.Is(err, io.EOF) || s.isClosed() {
+				return nil
+			}
+			return fmt.Errorf("failed to read request: %w", err)
+		}
+		if _, err := rw.WriteString(s.handleLine(line)); err != nil {
+			return fmt.Errorf("failed to send response: %w", err)
+		}
+		if err := rw.Flush(); err != nil {
+			return fmt.Errorf("failed to flush response: %w", err)
+		}
+	}
+}
+
+// Close stops all listeners and closes active connections
+func (s *Server) Close() error {
+	s.mu.Lock()
+	if !s.closed {
+		close(s.done)
+	}
+	s.closed = true
+	var firstErr error
+	for l := range s.listeners {
+		if err := l.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	for c := range s.conns {
+		c.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return firstErr
+}
+
+// authenticate performs the server side of the authentication handshake
+func (s *Server) authenticate(rw *bufio.ReadWriter) error {
+	reply := func(line string) error {
+		if _, err := rw.WriteString(line); err != nil {
+			return fmt.Errorf("failed to send authentication reply: %w", err)
+		}
+		return rw.Flush()
+	}
+
+	line, err := protocol.ReadLine(rw.Reader, maxAuthLineLength)
+	if err != nil {
+		return fmt.Errorf("failed to read authentication request: %w", err)
+	}
+	if line != mdata.AuthReq {
+		if err := reply(mdata.AuthRequired); err != nil {
+			return err
+		}
+		return fmt.Errorf("client did not authenticate")
+	}
+
+	nonce := make([]byte, 32)
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("failed to generate challenge: %w", err)
+	}
+	challenge := hex.EncodeToString(nonce)
+	if err := reply(mdata.AuthChallengePrefix + challenge + "\n"); err != nil {
+		return err
+	}
+
+	line, err = protocol.ReadLine(rw.Reader, maxAuthLineLength)
+	if err != nil {
+		return fmt.Errorf("failed to read authentication response: %w", err)
+	}
+	response, _ := strings.CutPrefix(strings.TrimSuffix(line, "\n"), mdata.AuthResponsePrefix)
+	if !hmac.Equal([]byte(response), []byte(mdata.AuthMAC(s.Secret, challenge))) {
+		if err := reply(mdata.AuthFailed); err != nil {
+			return err
+		}
+		return mdata.ErrAuthFailed
+	}
+	return reply(mdata.AuthOK)
+}
+
+// handleLine turns one request line into the wire-format response
+func (s *Server) handleLine(line string) string {
+	if line == protocol.NegotiationReq {
+		return protocol.NegotiationResp
+	}
+	if !strings.HasPrefix(line, protocol.Prefix) {
+		return invalidCommand
+	}
+	req, err := protocol.ParseFrame(line)
+	if err != nil {
+		s.logf("mdataserver: rejecting request: %v", err)
+		return invalidCommand
+	}
+	code, payload := s.dispatch(req.Code, req.Payload)
+	return protocol.NewFrame(req.RequestID, code, payload).Encode()
+}
+
+// dispatch executes a request against the store and returns the response code and payload
+func (s *Server) dispatch(code string, payload []byte) (string, []byte) {
+	switch code {
+	case protocol.CodeGet:
+		value, ok, err := s.store.Get(string(payload))
+		if err != nil {
+			s.logf("mdataserver: get %q: %v", payload, err)
+			return CodeFailure, nil
+		}
+		if !ok {
+			return CodeNotFound, nil
+		}
+		// An empty value is a SUCCESS without payload, unlike a missing key
+		return CodeSuccess, []byte(value)
+	case protocol.CodeGetIfChanged:
+		checksum, key, ok := strings.Cut(string(payload), " ")
+		if !ok {
+			s.logf("mdataserver: get if changed: malformed payload")
+			return CodeFailure, nil
+		}
+		value, ok, err := s.store.Get(key)
+		if err != nil {
+			s.logf("mdataserver: get %q: %v", key, err)
+			return CodeFailure, nil
+		}
+		if !ok {
+			return CodeNotFound, nil
+		}
+		if mdata.ValueChecksum(value) == checksum {
+			return CodeNotModified, nil
+		}
+		return CodeSuccess, []byte(value)
+	case protocol.CodeKeys:
+		keys, err := s.store.Keys()
+		if err != nil {
+			s.logf("mdataserver: keys: %v", err)
+			return CodeFailure, nil
+		}
+		return CodeSuccess, []byte(strings.Join(keys, "\n"))
+	case protocol.CodePut:
+		key, value, err := decodePutPayload(payload)
+		if err != nil {
+			s.logf("mdataserver: put: %v", err)
+			return CodeFailure, nil
+		}
+		if key == "" || strings.HasPrefix(key, "sdc:") {
+			return CodeFailure, nil
+		}
+		if err := s.store.Put(key, value); err != nil {
+			s.logf("mdataserver: put %q: %v", key, err)
+			return CodeFailure, nil
+		}
+		s.notifyChange()
+		return CodeSuccess, nil
+	case protocol.CodeDelete:
+		key := string(payload)
+		if key == "" || strings.HasPrefix(key, "sdc:") {
+			return CodeFailure, nil
+		}
+		if err := s.store.Delete(key); err != nil {
+			s.logf("mdataserver: delete %q: %v", key, err)
+			return CodeFailure, nil
+		}
+		s.notifyChange()
+		return CodeSuccess, nil
+	case protocol.CodeWatch:
+		return s.watch(payload)
+	default:
+		return CodeFailure, nil
+	}
+}
+
+// decodePutPayload splits a PUT payload into its BASE64 encoded key and
+// value. An empty value leaves nothing after the separating space.
+func decodePutPayload(payload []byte) (string, string, error) {
+	encodedKey, encodedValue, ok := strings.Cut(string(payload), " ")
+	if !ok {
+		return "", "", fmt.Errorf("malformed payload")
+	}
+	key, err := base64.StdEncoding.DecodeString(encodedKey)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid key encoding: %w", err)
+	}
+	value, err := base64.StdEncoding.DecodeString(encodedValue)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid value encoding: %w", err)
+	}
+	return string(key), string(value), nil
+}
+
+// watch holds a WATCH request until the keys it names change or it times out
+func (s *Server) watch(payload []byte) (string, []byte) {
+	prefix, known, timeout, err := decodeWatchPayload(payload)
+	if err != nil {
+		s.logf("mdataserver: watch: %v", err)
+		return CodeFailure, nil
+	}
+	interval := s.WatchInterval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	deadline := time.NewTimer(min(timeout, maxWatchTimeout))
+	defer deadline.Stop()
+
+	for {
+		// Take the signal before reading so a change in between is not missed
+		changed := s.changeSignal()
+		state, err := s.state(prefix)
+		if err != nil {
+			s.logf("mdataserver: watch %q: %v", prefix, err)
+			return CodeFailure, nil
+		}
+		if state != known {
+			return CodeSuccess, []byte(state)
+		}
+		select {
+		case <-changed:
+		case <-ticker.C:
+		case <-deadline.C:
+			return CodeSuccess, []byte(state)
+		case <-s.done:
+			return CodeSuccess, []byte(state)
+		}
+	}
+}
+
+// state returns the StateDigest of the keys starting with prefix
+func (s *Server) state(prefix string) (string, error) {
+	keys, err := s.store.Keys()
+	if err != nil {
+		return "", err
+	}
+	values := make(map[string]string)
+	for _, key := range keys {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		value, ok, err := s.store.Get(key)
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			values[key] = value
+		}
+	}
+	return mdata.StateDigest(values), nil
+}
+
+// decodeWatchPayload splits a WATCH payload into its prefix, the state known
+// to the client and the timeout
+func decodeWatchPayload(payload []byte) (string, string, time.Duration, error) {
+	fields := strings.SplitN(string(payload), " ", 3)
+	if len(fields) != 3 {
+		return "", "", 0, fmt.Errorf("malformed payload")
+	}
+	ms, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil || ms < 0 {
+		return "", "", 0, fmt.Errorf("invalid timeout %q", fields[0])
+	}
+	prefix, err := base64.StdEncoding.DecodeString(fields[2])
+	if err != nil {
+		return "", "", 0, fmt.Errorf("invalid prefix encoding: %w", err)
+	}
+	return string(prefix), fields[1], time.Duration(ms) * time.Millisecond, nil
+}
+
+// changeSignal returns a channel closed by the next change made through the server
+func (s *Server) changeSignal() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.changed
+}
+
+// notifyChange wakes the WATCH requests being held
+func (s *Server) notifyChange() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+func (s *Server) logf(format string, args ...any) {
+	if s.ErrorLog != nil {
+		s.ErrorLog.Printf(format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
+
+func (s *Server) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+func (s *Server) trackListener(l net.Listener) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.listeners[l] = struct{}{}
+	return true
+}
+
+func (s *Server) untrackListener(l net.Listener) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.listeners, l)
+}
+
+func (s *Server) trackConn(c io.Closer) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.conns[c] = struct{}{}
+	return true
+}
+
+func (s *Server) untrackConn(c io.Closer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conns, c)
+}
+
+// addServing counts a goroutine about to serve an accepted connection, which
+// Close waits for, unless the server is already closed
+func (s *Server) addServing() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.wg.Add(1)
+	return true
+}
@@ -0,0 +1,115 @@
+package mdataserver_test
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/Smithx10/go-smartos-mdata/mdata/protocol"
+	"github.com/Smithx10/go-smartos-mdata/mdataserver"
+	"github.com/Smithx10/go-smartos-mdata/mdatatest"
+)
+
+// request sends one frame to a server over store and returns the code of the
+// reply
+func request(t *testing.T, store mdataserver.Store, code string, payload []byte) string {
+	t.Helper()
+	srv := mdataserver.NewServer(store)
+	serverConn, clientConn := net.Pipe()
+	go srv.ServeConn(serverConn)
+	defer clientConn.Close()
+
+	rw := bufio.NewReadWriter(bufio.NewReader(clientConn), bufio.NewWriter(clientConn))
+	if ok, err := protocol.Negotiate(rw); err != nil || !ok {
+		t.Fatalf("negotiation failed: ok=%v err=%v", ok, err)
+	}
+	if _, err := rw.WriteString(protocol.NewFrame("dc4fae17", code, payload).Encode()); err != nil {
+		t.Fatal(err)
+	}
+	if err := rw.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	reply, err := protocol.NewDecoder(rw.Reader).Decode()
+	if err != nil {
+		t.Fatalf("reading reply: %v", err)
+	}
+	return reply.Code
+}
+
+func TestEmptyKeyRefused(t *testing.T) {
+	store := mdataserver.NewMemoryStore(map[string]string{"": "x"})
+	for _, tc := range []struct {
+		code    string
+		payload string
+	}{
+		{"PUT", mdatatest.PutPayload("", "x")},
+		{"DELETE", ""},
+	} {
+		if got := request(t, store, tc.code, []byte(tc.payload)); got != mdataserver.CodeFailure {
+			t.Errorf("%s with empty key: reply %s, want %s", tc.code, got, mdataserver.CodeFailure)
+		}
+	}
+	if value, ok, _ := store.Get(""); !ok || value != "x" {
+		t.Errorf("store changed by refused requests: %q, %v", value, ok)
+	}
+}
+
+func TestEmptyValue(t *testing.T) {
+	store := mdataserver.NewMemoryStore(map[string]string{"empty": ""})
+	if got := request(t, store, "GET", []byte("empty")); got != mdataserver.CodeSuccess {
+		t.Errorf("GET of empty value: reply %s, want %s", got, mdataserver.CodeSuccess)
+	}
+	if got := request(t, store, "GET", []byte("missing")); got != mdataserver.CodeNotFound {
+		t.Errorf("GET of missing key: reply %s, want %s", got, mdataserver.CodeNotFound)
+	}
+}
+
+// lateListener hands out one connection when released, even once closed, as
+// a listener may when a connection arrives while the server closes
+type lateListener struct {
+	net.Listener // unused, for Addr
+	accepting    chan struct{}
+	release      chan net.Conn
+}
+
+func (l *lateListener) Accept() (net.Conn, error) {
+	l.accepting <- struct{}{}
+	conn, ok := <-l.release
+	if !ok {
+		return nil, net.ErrClosed
+	}
+	return conn, nil
+}
+
+func (l *lateListener) Close() error {
+	return nil
+}
+
+func TestConnAcceptedDuringClose(t *testing.T) {
+	l := &lateListener{accepting: make(chan struct{}, 2), release: make(chan net.Conn)}
+	srv := mdataserver.NewServer(mdataserver.NewMemoryStore(nil))
+	served := make(chan error)
+	go func() { served <- srv.Serve(l) }()
+
+	<-l.accepting
+	if err := srv.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	l.release <- serverConn
+	close(l.release)
+
+	if err := <-served; !errors.Is(err, mdataserver.ErrServerClosed) {
+		t.Fatalf("Serve: %v, want ErrServerClosed", err)
+	}
+	// The connection is closed rather than served after Close returned
+	if _, err := clientConn.Read(make([]byte, 1)); !errors.Is(err, io.EOF) {
+		t.Errorf("read from connection accepted after Close: %v, want EOF", err)
+	}
+	if len(l.accepting) != 0 {
+		t.Errorf("Serve accepted again after Close")
+	}
+}
@@ -0,0 +1,122 @@
+package mdataserver
+
+import (
+	"sort"
+	"sync"
+)
+
+// Store is the key/value backend consulted by the Server
+type Store interface {
+	// Get returns the value for key and whether it exists
+	Get(key string) (string, bool, error)
+	// Keys returns all keys in the store
+	Keys() ([]string, error)
+	// Put creates or replaces the value for key
+	Put(key, value string) error
+	// Delete removes key; deleting a missing key is not an error
+	Delete(key string) error
+}
+
+// MemoryStore is a Store held in memory, safe for concurrent use.
+// Snapshots share the underlying map; the first write after a Snapshot or
+// Restore copies it, so both operations are O(1).
+type MemoryStore struct {
+	mu     sync.RWMutex
+	data   map[string]string
+	shared bool // data is referenced by a Snapshot and must be copied before writing
+}
+
+// Snapshot is an immutable point-in-time view of a MemoryStore
+type Snapshot struct {
+	data map[string]string
+}
+
+// Len returns the number of keys in the snapshot
+func (snap Snapshot) Len() int {
+	return len(snap.data)
+}
+
+// NewMemoryStore creates a MemoryStore seeded with a copy of initial
+func NewMemoryStore(initial map[string]string) *MemoryStore {
+	data := make(map[string]string, len(initial))
+	for k, v := range initial {
+		data[k] = v
+	}
+	return &MemoryStore{data: data}
+}
+
+// Get implements Store.Get
+func (s *MemoryStore) Get(key string) (string, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	value, ok := s.data[key]
+	return value, ok, nil
+}
+
+// Keys implements Store.Keys, returning keys in sorted order
+func (s *MemoryStore) Keys() ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keys := make([]string, 0, len(s.data))
+	for k := range s.data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Put implements Store.Put
+func (s *MemoryStore) Put(key, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.unshare()
+	s.data[key] = value
+	return nil
+}
+
+// Delete implements Store.Delete
+func (s *MemoryStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.data[key]; !ok {
+		return nil
+	}
+	s.unshare()
+	delete(s.data, key)
+	return nil
+}
+
+// Snapshot captures the current contents of the store
+func (s *MemoryStore) Snapshot() Snapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.shared = true
+	return Snapshot{data: s.data}
+}
+
+// Restore replaces the contents of the store with snap.
+// Restoring the zero Snapshot empties the store.
+func (s *MemoryStore) Restore(snap Snapshot) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if snap.data == nil {
+		s.data = make(map[string]string)
+		s.shared = false
+		return
+	}
+	s.data = snap.data
+	s.shared = true
+}
+
+// unshare copies data if a Snapshot references it; callers must hold s.mu for writing
+func (s *MemoryStore) unshare() {
+	if !s.shared {
+		return
+	}
+	data := make(map[string]string, len(s.data))
+	for k, v := range s.data {
+		data[k] = v
+	}
+	s.data = data
+	s.shared = false
+}
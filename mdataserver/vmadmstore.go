@@ -0,0 +1,252 @@
+package mdataserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// vmadmScalarKeys maps sdc: keys to the top-level vmadm properties returned verbatim
+var vmadmScalarKeys = map[string]string{
+	"sdc:uuid":                "uuid",
+	"sdc:server_uuid":         "server_uuid",
+	"sdc:datacenter_name":     "datacenter_name",
+	"sdc:billing_id":          "billing_id",
+	"sdc:owner_uuid":          "owner_uuid",
+	"sdc:image_uuid":          "image_uuid",
+	"sdc:alias":               "alias",
+	"sdc:hostname":            "hostname",
+	"sdc:dns_domain":          "dns_domain",
+	"sdc:brand":               "brand",
+	"sdc:max_physical_memory": "max_physical_memory",
+	"sdc:max_swap":            "max_swap",
+	"sdc:max_lwps":            "max_lwps",
+	"sdc:quota":               "quota",
+	"sdc:cpu_cap":             "cpu_cap",
+	"sdc:cpu_shares":          "cpu_shares",
+	"sdc:zfs_io_priority":     "zfs_io_priority",
+	"sdc:tmpfs":               "tmpfs",
+}
+
+// vmadmJSONKeys maps sdc: keys to the top-level vmadm properties returned as JSON
+var vmadmJSONKeys = map[string]string{
+	"sdc:nics":      "nics",
+	"sdc:resolvers": "resolvers",
+	"sdc:tags":      "tags",
+	"sdc:disks":     "disks",
+}
+
+// VMAdmStore is a Store answering from a vmadm-style VM payload, as the SmartOS
+// metadata agent does: sdc: keys are derived from VM properties, keys in an
+// internal_metadata_namespaces namespace come from internal_metadata, and all
+// other keys live in customer_metadata. Only customer_metadata is writable and
+// changes are kept in memory.
+type VMAdmStore struct {
+	mu         sync.RWMutex
+	sdc        map[string]string
+	internal   map[string]string
+	namespaces []string
+	customer   map[string]string
+}
+
+// vmadmPayload is the subset of a vmadm payload that needs structured handling
+type vmadmPayload struct {
+	CustomerMetadata   map[string]string `json:"customer_metadata"`
+	InternalMetadata   map[string]string `json:"internal_metadata"`
+	InternalNamespaces []string          `json:"internal_metadata_namespaces"`
+	Routes             map[string]string `json:"routes"`
+	Nics               []struct {
+		IP      string   `json:"ip"`
+		IPs     []string `json:"ips"`
+		Primary bool     `json:"primary"`
+	} `json:"nics"`
+}
+
+// vmadmRoute is the element type of the sdc:routes value
+type vmadmRoute struct {
+	Linklocal bool   `json:"linklocal"`
+	Dst       string `json:"dst"`
+	Gateway   string `json:"gateway"`
+}
+
+// LoadVMAdmStore reads a vmadm payload (e.g. the output of "vmadm get") from path
+func LoadVMAdmStore(path string) (*VMAdmStore, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read vmadm payload %s: %w", path, err)
+	}
+	return NewVMAdmStore(raw)
+}
+
+// NewVMAdmStore creates a VMAdmStore from a vmadm JSON payload
+func NewVMAdmStore(payload []byte) (*VMAdmStore, error) {
+	var p vmadmPayload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return nil, fmt.Errorf("failed to parse vmadm payload: %w", err)
+	}
+	var props map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &props); err != nil {
+		return nil, fmt.Errorf("failed to parse vmadm payload: %w", err)
+	}
+
+	s := &VMAdmStore{
+		sdc:        make(map[string]string),
+		internal:   make(map[string]string),
+		namespaces: p.InternalNamespaces,
+		customer:   make(map[string]string),
+	}
+	for k, v := range p.CustomerMetadata {
+		s.customer[k] = v
+	}
+	for k, v := range p.InternalMetadata {
+		s.internal[k] = v
+	}
+
+	for key, prop := range vmadmScalarKeys {
+		raw, ok := props[prop]
+		if !ok {
+			continue
+		}
+		value, err := scalarString(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", prop, err)
+		}
+		s.sdc[key] = value
+	}
+	for key, prop := range vmadmJSONKeys {
+		raw, ok := props[prop]
+		if !ok {
+			continue
+		}
+		var compact bytes.Buffer
+		if err := json.Compact(&compact, raw); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", prop, err)
+		}
+		s.sdc[key] = compact.String()
+	}
+
+	routes, err := json.Marshal(convertRoutes(p))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode routes: %w", err)
+	}
+	s.sdc["sdc:routes"] = string(routes)
+
+	if script, ok := p.InternalMetadata["operator-script"]; ok {
+		s.sdc["sdc:operator-script"] = script
+	}
+	if volumes, ok := p.InternalMetadata["sdc:volumes"]; ok {
+		s.sdc["sdc:volumes"] = volumes
+	}
+	return s, nil
+}
+
+// convertRoutes turns the vmadm routes object into the list served as sdc:routes.
+// Gateways of the form "nics[N]" are link-local routes via that NIC's address.
+func convertRoutes(p vmadmPayload) []vmadmRoute {
+	dsts := make([]string, 0, len(p.Routes))
+	for dst := range p.Routes {
+		dsts = append(dsts, dst)
+	}
+	sort.Strings(dsts)
+
+	routes := make([]vmadmRoute, 0, len(dsts))
+	for _, dst := range dsts {
+		gw := p.Routes[dst]
+		route := vmadmRoute{Dst: dst, Gateway: gw}
+		if strings.HasPrefix(gw, "nics[") && strings.HasSuffix(gw, "]") {
+			idx, err := strconv.Atoi(gw[len("nics[") : len(gw)-1])
+			if err != nil || idx < 0 || idx >= len(p.Nics) {
+				continue
+			}
+			route.Linklocal = true
+			route.Gateway = p.Nics[idx].IP
+			if route.Gateway == "" && len(p.Nics[idx].IPs) > 0 {
+				route.Gateway, _, _ = strings.Cut(p.Nics[idx].IPs[0], "/")
+			}
+		}
+		routes = append(routes, route)
+	}
+	return routes
+}
+
+// scalarString renders a JSON scalar the way the metadata agent returns it
+func scalarString(raw json.RawMessage) (string, error) {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s, nil
+	}
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, raw); err != nil {
+		return "", err
+	}
+	return compact.String(), nil
+}
+
+// Get implements Store.Get
+func (s *VMAdmStore) Get(key string) (string, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if strings.HasPrefix(key, "sdc:") {
+		value, ok := s.sdc[key]
+		return value, ok, nil
+	}
+	if s.isInternal(key) {
+		value, ok := s.internal[key]
+		return value, ok, nil
+	}
+	value, ok := s.customer[key]
+	return value, ok, nil
+}
+
+// Keys implements Store.Keys, listing customer_metadata keys in sorted order
+func (s *VMAdmStore) Keys() ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keys := make([]string, 0, len(s.customer))
+	for k := range s.customer {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Put implements Store.Put; keys in internal namespaces are read-only
+func (s *VMAdmStore) Put(key, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.isInternal(key) {
+		return fmt.Errorf("key %q is in a read-only internal namespace", key)
+	}
+	s.customer[key] = value
+	return nil
+}
+
+// Delete implements Store.Delete; keys in internal namespaces are read-only
+func (s *VMAdmStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.isInternal(key) {
+		return fmt.Errorf("key %q is in a read-only internal namespace", key)
+	}
+	delete(s.customer, key)
+	return nil
+}
+
+// isInternal reports whether key belongs to an internal_metadata namespace
+func (s *VMAdmStore) isInternal(key string) bool {
+	ns, _, ok := strings.Cut(key, ":")
+	if !ok {
+		return false
+	}
+	for _, n := range s.namespaces {
+		if n == ns {
+			return true
+		}
+	}
+	return false
+}
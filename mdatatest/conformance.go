@@ -0,0 +1,519 @@
+package mdatatest
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Smithx10/go-smartos-mdata/mdata"
+	"github.com/Smithx10/go-smartos-mdata/mdata/protocol"
+)
+
+// conformancePrefix namespaces the keys written by the server suite so it can
+// run against a live metadata service without touching real configuration
+const conformancePrefix = "mdatatest-conformance:"
+
+// largeValue exceeds any single read buffer to exercise streaming of long frames
+var largeValue = strings.Repeat("0123456789abcdef", 64*1024)
+
+// Exchange is one request sent to a server and the reply it must produce
+type Exchange struct {
+	Code    string // Request code (e.g. "GET")
+	Payload string // Raw request payload; PUT payloads are encoded by PutPayload
+	Raw     string // Sent verbatim instead of a frame built from Code and Payload
+
+	WantCode     string   // Expected response code
+	WantPayload  *string  // Expected response payload; nil skips the check
+	WantContains []string // Lines the response payload must contain (for KEYS)
+	WantRaw      string   // Expected verbatim reply to a Raw request
+}
+
+// ServerVector is a named sequence of exchanges run on one connection
+type ServerVector struct {
+	Name      string
+	Exchanges []Exchange
+}
+
+// PutPayload encodes a key and value the way PUT requests carry them
+func PutPayload(key, value string) string {
+	return base64.StdEncoding.EncodeToString([]byte(key)) + " " + base64.StdEncoding.EncodeToString([]byte(value))
+}
+
+func ptr(s string) *string { return &s }
+
+// ServerVectors is the request/response table every server must satisfy
+var ServerVectors = []ServerVector{
+	{
+		Name: "negotiation is idempotent",
+		Exchanges: []Exchange{
+			{Raw: protocol.NegotiationReq, WantRaw: protocol.NegotiationResp},
+		},
+	},
+	{
+		Name: "get missing key",
+		Exchanges: []Exchange{
+			{Code: "GET", Payload: conformancePrefix + "missing", WantCode: "NOTFOUND"},
+		},
+	},
+	{
+		Name: "put then get",
+		Exchanges: []Exchange{
+			{Code: "PUT", Payload: PutPayload(conformancePrefix+"a", "hello world"), WantCode: "SUCCESS"},
+			{Code: "GET", Payload: conformancePrefix + "a", WantCode: "SUCCESS", WantPayload: ptr("hello world")},
+		},
+	},
+	{
+		Name: "put overwrites",
+		Exchanges: []Exchange{
+			{Code: "PUT", Payload: PutPayload(conformancePrefix+"a", "one"), WantCode: "SUCCESS"},
+			{Code: "PUT", Payload: PutPayload(conformancePrefix+"a", "two"), WantCode: "SUCCESS"},
+			{Code: "GET", Payload: conformancePrefix + "a", WantCode: "SUCCESS", WantPayload: ptr("two")},
+		},
+	},
+	{
+		Name: "value with newlines and spaces",
+		Exchanges: []Exchange{
+			{Code: "PUT", Payload: PutPayload(conformancePrefix+"multi", "line 1\nline 2\n"), WantCode: "SUCCESS"},
+			{Code: "GET", Payload: conformancePrefix + "multi", WantCode: "SUCCESS", WantPayload: ptr("line 1\nline 2\n")},
+		},
+	},
+	{
+		Name: "empty value is distinct from a missing key",
+		Exchanges: []Exchange{
+			{Code: "PUT", Payload: PutPayload(conformancePrefix+"empty", ""), WantCode: "SUCCESS"},
+			{Code: "GET", Payload: conformancePrefix + "empty", WantCode: "SUCCESS", WantPayload: ptr("")},
+			{Code: "KEYS", WantCode: "SUCCESS", WantContains: []string{conformancePrefix + "empty"}},
+		},
+	},
+	{
+		Name: "put empty value overwrites",
+		Exchanges: []Exchange{
+			{Code: "PUT", Payload: PutPayload(conformancePrefix+"a", "x"), WantCode: "SUCCESS"},
+			{Code: "PUT", Payload: PutPayload(conformancePrefix+"a", ""), WantCode: "SUCCESS"},
+			{Code: "GET", Payload: conformancePrefix + "a", WantCode: "SUCCESS", WantPayload: ptr("")},
+		},
+	},
+	{
+		Name: "large payload",
+		Exchanges: []Exchange{
+			{Code: "PUT", Payload: PutPayload(conformancePrefix+"large", largeValue), WantCode: "SUCCESS"},
+			{Code: "GET", Payload: conformancePrefix + "large", WantCode: "SUCCESS", WantPayload: ptr(largeValue)},
+		},
+	},
+	{
+		Name: "keys lists written keys",
+		Exchanges: []Exchange{
+			{Code: "PUT", Payload: PutPayload(conformancePrefix+"k1", "1"), WantCode: "SUCCESS"},
+			{Code: "PUT", Payload: PutPayload(conformancePrefix+"k2", "2"), WantCode: "SUCCESS"},
+			{Code: "KEYS", WantCode: "SUCCESS", WantContains: []string{conformancePrefix + "k1", conformancePrefix + "k2"}},
+		},
+	},
+	{
+		Name: "delete then get",
+		Exchanges: []Exchange{
+			{Code: "PUT", Payload: PutPayload(conformancePrefix+"d", "x"), WantCode: "SUCCESS"},
+			{Code: "DELETE", Payload: conformancePrefix + "d", WantCode: "SUCCESS"},
+			{Code: "GET", Payload: conformancePrefix + "d", WantCode: "NOTFOUND"},
+		},
+	},
+	{
+		Name: "delete missing key succeeds",
+		Exchanges: []Exchange{
+			{Code: "DELETE", Payload: conformancePrefix + "never-set", WantCode: "SUCCESS"},
+		},
+	},
+	{
+		Name: "put to sdc namespace is refused",
+		Exchanges: []Exchange{
+			{Code: "PUT", Payload: PutPayload("sdc:uuid", "x"), WantCode: "FAILURE"},
+		},
+	},
+	{
+		Name: "put with empty key is refused",
+		Exchanges: []Exchange{
+			{Code: "PUT", Payload: PutPayload("", "x"), WantCode: "FAILURE"},
+		},
+	},
+	{
+		Name: "delete with empty key is refused",
+		Exchanges: []Exchange{
+			{Code: "DELETE", Payload: "", WantCode: "FAILURE"},
+		},
+	},
+	{
+		Name: "malformed put payload",
+		Exchanges: []Exchange{
+			{Code: "PUT", Payload: "not-a-pair", WantCode: "FAILURE"},
+		},
+	},
+	{
+		Name: "V1 command is rejected",
+		Exchanges: []Exchange{
+			{Raw: "GET sdc:uuid\n", WantRaw: "invalid command\n"},
+		},
+	},
+	{
+		Name: "bad checksum is rejected",
+		Exchanges: []Exchange{
+			{Raw: "V2 25 00000000 dc4fae17 GET c2RjOnV1aWQ=\n", WantRaw: "invalid command\n"},
+		},
+	},
+	{
+		Name: "connection survives a malformed frame",
+		Exchanges: []Exchange{
+			{Raw: "V2 garbage\n", WantRaw: "invalid command\n"},
+			{Code: "GET", Payload: conformancePrefix + "missing", WantCode: "NOTFOUND"},
+		},
+	},
+}
+
+// RunServerConformance runs ServerVectors against the server reached by dial,
+// each vector on a fresh negotiated connection. Keys are written under a
+// dedicated prefix and deleted afterwards.
+func RunServerConformance(t *testing.T, dial func() (io.ReadWriteCloser, error)) {
+	for _, v := range ServerVectors {
+		t.Run(v.Name, func(t *testing.T) {
+			conn, err := dial()
+			if err != nil {
+				t.Fatalf("dial: %v", err)
+			}
+			defer conn.Close()
+			rw := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+			if ok, err := protocol.Negotiate(rw); err != nil || !ok {
+				t.Fatalf("negotiation failed: ok=%v err=%v", ok, err)
+			}
+			defer cleanupConformanceKeys(t, rw)
+
+			for i, ex := range v.Exchanges {
+				if err := runExchange(rw, ex); err != nil {
+					t.Fatalf("exchange %d: %v", i, err)
+				}
+			}
+		})
+	}
+}
+
+// runExchange performs one exchange and checks the reply
+func runExchange(rw *bufio.ReadWriter, ex Exchange) error {
+	line := ex.Raw
+	var requestID string
+	if line == "" {
+		req := protocol.NewFrame(fmt.Sprintf("%08x", rand.Uint32()), ex.Code, []byte(ex.Payload))
+		requestID = req.RequestID
+		line = req.Encode()
+	}
+	if _, err := rw.WriteString(line); err != nil {
+		return fmt.Errorf("failed to send: %w", err)
+	}
+	if err := rw.Flush(); err != nil {
+		return fmt.Errorf("failed to flush: %w", err)
+	}
+	reply, err := rw.ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("failed to read reply: %w", err)
+	}
+
+	if ex.Raw != "" {
+		if reply != ex.WantRaw {
+			return fmt.Errorf("reply %q, want %q", reply, ex.WantRaw)
+		}
+		return nil
+	}
+
+	resp, err := protocol.ParseFrame(reply)
+	if err != nil {
+		return fmt.Errorf("invalid reply %q: %w", reply, err)
+	}
+	if resp.RequestID != requestID {
+		return fmt.Errorf("reply request ID %s, want %s", resp.RequestID, requestID)
+	}
+	if resp.Code != ex.WantCode {
+		return fmt.Errorf("%s reply code %s, want %s", ex.Code, resp.Code, ex.WantCode)
+	}
+	if ex.WantPayload != nil && string(resp.Payload) != *ex.WantPayload {
+		return fmt.Errorf("%s reply payload %.64q, want %.64q", ex.Code, resp.Payload, *ex.WantPayload)
+	}
+	lines := strings.Split(string(resp.Payload), "\n")
+	for _, want := range ex.WantContains {
+		found := false
+		for _, l := range lines {
+			if l == want {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s reply payload missing %q", ex.Code, want)
+		}
+	}
+	return nil
+}
+
+// cleanupConformanceKeys deletes every key the suite may have written
+func cleanupConformanceKeys(t *testing.T, rw *bufio.ReadWriter) {
+	for _, key := range []string{"a", "multi", "large", "k1", "k2", "d", "empty"} {
+		ex := Exchange{Code: "DELETE", Payload: conformancePrefix + key, WantCode: "SUCCESS"}
+		if err := runExchange(rw, ex); err != nil {
+			t.Logf("cleanup of %s failed: %v", key, err)
+			return
+		}
+	}
+}
+
+// ClientVector is a server reply scripted for one client call and the
+// outcome the client must report
+type ClientVector struct {
+	Name string
+	// Call issues the request under test
+	Call func(mdata.MetadataClient) (string, error)
+	// Reply builds the raw reply line for the request with the given ID
+	Reply func(requestID string) string
+	// WantValue is the expected result when the call succeeds
+	WantValue string
+	// WantErr, if set, is a substring the call's error must contain
+	WantErr string
+	// WantErrIs, if set, must match the call's error via errors.Is
+	WantErrIs error
+}
+
+func get(key string) func(mdata.MetadataClient) (string, error) {
+	return func(c mdata.MetadataClient) (string, error) { return c.Get(key) }
+}
+
+func getIfChanged(key, lastChecksum string) func(mdata.MetadataClient) (string, error) {
+	return func(c mdata.MetadataClient) (string, error) {
+		value, _, err := mdata.GetIfChanged(c, key, lastChecksum)
+		return value, err
+	}
+}
+
+func watch(prefix string) func(mdata.MetadataClient) (string, error) {
+	return func(c mdata.MetadataClient) (string, error) {
+		w, ok := c.(mdata.Watcher)
+		if !ok {
+			return "", mdata.ErrWatchUnsupported
+		}
+		return w.Watch(prefix, "", time.Second)
+	}
+}
+
+func reply(code string, payload string) func(string) string {
+	return func(id string) string {
+		return protocol.NewFrame(id, code, []byte(payload)).Encode()
+	}
+}
+
+// ClientVectors is the reply table every client must handle
+var ClientVectors = []ClientVector{
+	{
+		Name:      "success",
+		Call:      get("sdc:uuid"),
+		Reply:     reply("SUCCESS", "a6d24d52-4f0a-11ee-9e3a-00163e000001"),
+		WantValue: "a6d24d52-4f0a-11ee-9e3a-00163e000001",
+	},
+	{
+		Name:      "large payload",
+		Call:      get("blob"),
+		Reply:     reply("SUCCESS", largeValue),
+		WantValue: largeValue,
+	},
+	{
+		// The metadata agent omits the payload of an empty value
+		Name:      "empty value",
+		Call:      get("empty"),
+		Reply:     reply("SUCCESS", ""),
+		WantValue: "",
+	},
+	{
+		Name:  "put empty value",
+		Call:  func(c mdata.MetadataClient) (string, error) { return "", c.Put("empty", "") },
+		Reply: reply("SUCCESS", ""),
+	},
+	{
+		Name:      "notfound",
+		Call:      get("missing"),
+		Reply:     reply("NOTFOUND", ""),
+		WantErrIs: mdata.ErrNotFound,
+	},
+	{
+		Name:    "failure",
+		Call:    get("x"),
+		Reply:   reply("FAILURE", ""),
+		WantErr: "FAILURE",
+	},
+	{
+		Name:    "eagain",
+		Call:    get("x"),
+		Reply:   reply("EAGAIN", ""),
+		WantErr: "EAGAIN",
+	},
+	{
+		Name:      "keys success",
+		Call:      func(c mdata.MetadataClient) (string, error) { return c.Keys() },
+		Reply:     reply("SUCCESS", "a\nb"),
+		WantValue: "a\nb",
+	},
+	{
+		Name:      "get if changed",
+		Call:      getIfChanged("big", mdata.ValueChecksum("old")),
+		Reply:     reply("SUCCESS", "new"),
+		WantValue: "new",
+	},
+	{
+		Name:      "get if changed not modified",
+		Call:      getIfChanged("big", mdata.ValueChecksum("old")),
+		Reply:     reply("NOTMODIFIED", ""),
+		WantErrIs: mdata.ErrNotModified,
+	},
+	{
+		Name:      "watch",
+		Call:      watch("app:"),
+		Reply:     reply("SUCCESS", "0f1e2d3c"),
+		WantValue: "0f1e2d3c",
+	},
+	{
+		// Servers without the extension, like the metadata agent, fail unknown requests
+		Name:      "watch refused",
+		Call:      watch("app:"),
+		Reply:     reply("FAILURE", ""),
+		WantErrIs: mdata.ErrWatchUnsupported,
+	},
+	{
+		Name:    "invalid command",
+		Call:    get("x"),
+		Reply:   func(string) string { return "invalid command\n" },
+		WantErr: "invalid frame prefix",
+	},
+	{
+		Name: "bad checksum",
+		Call: get("x"),
+		Reply: func(id string) string {
+			f := protocol.NewFrame(id, "SUCCESS", []byte("value"))
+			f.BodyChecksum = "00000000"
+			return f.Encode()
+		},
+		WantErr: "checksum mismatch",
+	},
+	{
+		Name: "wrong body length",
+		Call: get("x"),
+		Reply: func(id string) string {
+			f := protocol.NewFrame(id, "SUCCESS", []byte("value"))
+			f.BodyLength += 5
+			return f.Encode()
+		},
+		WantErr: "body length mismatch",
+	},
+	{
+		Name:    "mismatched request ID",
+		Call:    get("x"),
+		Reply:   func(string) string { return protocol.NewFrame("deadbeef", "SUCCESS", []byte("stale")).Encode() },
+		WantErr: "does not match",
+	},
+	{
+		Name:    "invalid base64 payload",
+		Call:    get("x"),
+		Reply:   func(id string) string { return fakeFrame(id + " SUCCESS !!!notbase64") },
+		WantErr: "invalid payload encoding",
+	},
+	{
+		Name:    "truncated frame",
+		Call:    get("x"),
+		Reply:   func(id string) string { return "V2 12\n" },
+		WantErr: "invalid frame format",
+	},
+	{
+		Name:    "connection closed",
+		Call:    get("x"),
+		Reply:   nil,
+		WantErr: "failed to read response",
+	},
+}
+
+// fakeFrame wraps an arbitrary body in a frame with a correct length and a
+// zeroed checksum, for bodies that must fail before checksum verification
+func fakeFrame(body string) string {
+	return fmt.Sprintf("%s%d %s %s\n", protocol.Prefix, len(body), "00000000", body)
+}
+
+// RunClientConformance runs ClientVectors against clients built by newClient
+// over a scripted server connection. A nil newClient tests mdata.NewMetadataClientWithConn.
+func RunClientConformance(t *testing.T, newClient func(mdata.Conn) (mdata.MetadataClient, error)) {
+	if newClient == nil {
+		newClient = mdata.NewMetadataClientWithConn
+	}
+	for _, v := range ClientVectors {
+		t.Run(v.Name, func(t *testing.T) {
+			serverConn, clientConn := net.Pipe()
+			done := make(chan error, 1)
+			go func() { done <- scriptServer(serverConn, v.Reply) }()
+
+			client, err := newClient(NewPipeConn(clientConn))
+			if err != nil {
+				t.Fatalf("failed to connect: %v", err)
+			}
+			value, err := v.Call(client)
+			client.Close()
+			if serr := <-done; serr != nil {
+				t.Fatalf("scripted server: %v", serr)
+			}
+
+			switch {
+			case v.WantErrIs != nil:
+				if !errors.Is(err, v.WantErrIs) {
+					t.Fatalf("error %v, want %v", err, v.WantErrIs)
+				}
+			case v.WantErr != "":
+				if err == nil || !strings.Contains(err.Error(), v.WantErr) {
+					t.Fatalf("error %v, want one containing %q", err, v.WantErr)
+				}
+			default:
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if value != v.WantValue {
+					t.Fatalf("value %.64q, want %.64q", value, v.WantValue)
+				}
+			}
+		})
+	}
+}
+
+// scriptServer negotiates, reads one valid request and answers it with reply.
+// A nil reply closes the connection instead of answering.
+func scriptServer(conn net.Conn, reply func(string) string) error {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("failed to read negotiation: %w", err)
+	}
+	if line != protocol.NegotiationReq {
+		return fmt.Errorf("unexpected negotiation %q", line)
+	}
+	if _, err := io.WriteString(conn, protocol.NegotiationResp); err != nil {
+		return fmt.Errorf("failed to answer negotiation: %w", err)
+	}
+	line, err = r.ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("failed to read request: %w", err)
+	}
+	req, err := protocol.ParseFrame(line)
+	if err != nil {
+		return fmt.Errorf("client sent invalid frame %q: %w", line, err)
+	}
+	if reply == nil {
+		return nil
+	}
+	if _, err := io.WriteString(conn, reply(req.RequestID)); err != nil {
+		return fmt.Errorf("failed to send reply: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+package mdatatest
+
+import "github.com/Smithx10/go-smartos-mdata/mdata/protocol"
+
+// FrameSeeds are frames seeding the fuzz targets of the mdata and
+// mdataserver packages: valid requests and responses followed by known
+// malformed cases
+var FrameSeeds = []string{
+	protocol.NewFrame("dc4fae17", "GET", []byte("sdc:uuid")).Encode(),
+	protocol.NewFrame("dc4fae17", "KEYS", nil).Encode(),
+	protocol.NewFrame("00000001", "PUT", []byte(PutPayload("a", "line 1\nline 2"))).Encode(),
+	protocol.NewFrame("dc4fae17", "SUCCESS", []byte("value")).Encode(),
+	protocol.NewFrame("dc4fae17", "NOTFOUND", nil).Encode(),
+	"",
+	"V2 ",
+	"V2 \n",
+	"V2 0 00000000\n",
+	"V2 -1 00000000 a b\n",
+	"V2 +12 00000000 dc4fae17 GET\n",
+	"V2 99999999999999999999999 00000000 dc4fae17 GET\n",
+	"V2 12 zzzzzzzz dc4fae17 GET\n",
+	"V2 12 0000000 dc4fae17 GET\n",
+	"V2 16 00000000 dc4fae17  GET\n",
+	"V2 25 00000000 dc4fae17 GET !!!!\n",
+	"V2 28 00000000 dc4fae17 GET c2Rj\nOnV1\n",
+	"V2 27 00000000 dc4fae17 GET a b c d e\n",
+	protocol.NegotiationReq,
+	"NEGOTIATE V2",
+	"invalid command\n",
+}
@@ -0,0 +1,134 @@
+// Package mdatatest provides an in-process metadata server for end-to-end
+// tests of code built on the mdata client.
+package mdatatest
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Smithx10/go-smartos-mdata/mdata"
+	"github.com/Smithx10/go-smartos-mdata/mdataserver"
+)
+
+// Server is a running in-process metadata server
+type Server struct {
+	// Store backs the server; tests may seed or inspect it directly
+	Store mdataserver.Store
+	// Config connects a client to the server
+	Config mdata.ClientConfig
+
+	srv *mdataserver.Server
+	t   testing.TB
+}
+
+// StartServer starts a server with an empty MemoryStore on a temporary unix
+// socket and returns it with a connected client. Both are shut down by t.Cleanup.
+func StartServer(t testing.TB) (*Server, mdata.MetadataClient) {
+	t.Helper()
+	return StartServerWithStore(t, mdataserver.NewMemoryStore(nil))
+}
+
+// StartServerWithStore is like StartServer but serves the given store
+func StartServerWithStore(t testing.TB, store mdataserver.Store) (*Server, mdata.MetadataClient) {
+	t.Helper()
+
+	// Keep the socket path short: sun_path is limited to ~104 bytes on some platforms
+	dir, err := os.MkdirTemp("", "mdatatest")
+	if err != nil {
+		t.Fatalf("mdatatest: failed to create socket directory: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	sock := filepath.Join(dir, "metadata.sock")
+
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatalf("mdatatest: failed to listen on %s: %v", sock, err)
+	}
+
+	s := &Server{
+		Store: store,
+		Config: mdata.ClientConfig{
+			Transport: "unix",
+			SocketConfig: &mdata.SocketConfig{
+				Network: "unix",
+				Address: sock,
+				Timeout: 5 * time.Second,
+			},
+		},
+		srv: mdataserver.NewServer(store),
+		t:   t,
+	}
+	go s.srv.Serve(l)
+	t.Cleanup(func() { s.srv.Close() })
+
+	return s, s.NewClient()
+}
+
+// StartPipe serves store over an in-memory net.Pipe instead of a socket and
+// returns the connected client, for tests that cannot create unix sockets.
+func StartPipe(t testing.TB, store mdataserver.Store) mdata.MetadataClient {
+	t.Helper()
+	srv := mdataserver.NewServer(store)
+	serverConn, clientConn := net.Pipe()
+	go srv.ServeConn(serverConn)
+	t.Cleanup(func() { srv.Close() })
+
+	client, err := mdata.NewMetadataClientWithConn(NewPipeConn(clientConn))
+	if err != nil {
+		t.Fatalf("mdatatest: failed to connect client: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+// NewClient connects an additional client to the server, closed by t.Cleanup
+func (s *Server) NewClient() mdata.MetadataClient {
+	s.t.Helper()
+	client, err := mdata.NewMetadataClient(s.Config)
+	if err != nil {
+		s.t.Fatalf("mdatatest: failed to connect client: %v", err)
+	}
+	s.t.Cleanup(func() { client.Close() })
+	return client
+}
+
+// Close stops the server before the test ends, e.g. to exercise client error paths
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+// NewPipeConn adapts an end of a net.Pipe, or any net.Conn, to mdata.Conn,
+// applying the read timeout to each read as the client's socket connections
+// do, for tests scripting the server side of a connection
+func NewPipeConn(conn net.Conn) mdata.Conn {
+	return &pipeConn{Conn: conn}
+}
+
+// pipeConn adapts a net.Conn to mdata.Conn
+type pipeConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+// Read implements mdata.Conn.Read, applying the read timeout to each call
+func (c *pipeConn) Read(b []byte) (int, error) {
+	if c.timeout > 0 {
+		if err := c.SetReadDeadline(time.Now().Add(c.timeout)); err != nil {
+			return 0, err
+		}
+	}
+	return c.Conn.Read(b)
+}
+
+// SetReadTimeout implements mdata.Conn.SetReadTimeout; a zero timeout
+// disables it
+func (c *pipeConn) SetReadTimeout(timeout time.Duration) error {
+	c.timeout = timeout
+	if timeout == 0 {
+		return c.SetReadDeadline(time.Time{})
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package mdatatmpl
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"text/template"
+)
+
+// maxIncludeDepth bounds nested includes, which would recurse forever on a cycle
+const maxIncludeDepth = 32
+
+// IncludeFunc returns an include function for templates associated with t,
+// to add to their functions before they are parsed:
+//
+//	tmpl := template.New("app")
+//	tmpl.Funcs(mdatatmpl.FuncMap(client)).Funcs(template.FuncMap{"include": mdatatmpl.IncludeFunc(tmpl, dirs)})
+//
+// {{ include NAME }} or {{ include NAME DATA }} executes the template file
+// NAME, with DATA as its dot, and returns its output. NAME is an absolute
+// path or relative to the first of dirs holding it. Included files are parsed
+// once, into the set of t, so they share its functions and options, and
+// templates they define can be called from the including template afterwards.
+func IncludeFunc(t *template.Template, dirs []string) func(name string, data ...any) (string, error) {
+	var depth atomic.Int32
+	return func(name string, data ...any) (string, error) {
+		if len(data) > 1 {
+			return "", fmt.Errorf("include takes at most one data argument")
+		}
+		path, err := findInclude(name, dirs)
+		if err != nil {
+			return "", err
+		}
+		included := t.Lookup(path)
+		if included == nil {
+			text, err := os.ReadFile(path)
+			if err != nil {
+				return "", fmt.Errorf("failed to read included template: %w", err)
+			}
+			if included, err = t.New(path).Parse(string(text)); err != nil {
+				return "", fmt.Errorf("failed to parse included template %s: %w", path, err)
+			}
+		}
+
+		if depth.Add(1) > maxIncludeDepth {
+			depth.Add(-1)
+			return "", fmt.Errorf("includes nested more than %d deep at %s", maxIncludeDepth, name)
+		}
+		defer depth.Add(-1)
+		var dot any
+		if len(data) == 1 {
+			dot = data[0]
+		}
+		var buf bytes.Buffer
+		if err := included.Execute(&buf, dot); err != nil {
+			return "", err
+		}
+		return buf.String(), nil
+	}
+}
+
+// findInclude returns the path of the template file name, absolute or in the
+// first of dirs holding it
+func findInclude(name string, dirs []string) (string, error) {
+	if filepath.IsAbs(name) {
+		return name, nil
+	}
+	for _, dir := range dirs {
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return "", fmt.Errorf("failed to look for included template: %w", err)
+		}
+	}
+	return "", fmt.Errorf("included template %s not found in %v", name, dirs)
+}
@@ -0,0 +1,171 @@
+// Package mdatatmpl provides text/template functions that read instance
+// metadata, for programs that render their own templates (alone or merged
+// with libraries such as sprig):
+//
+//	tmpl := template.New("app").Funcs(mdatatmpl.FuncMap(client))
+//
+// The functions are:
+//
+//	md KEY                 value of KEY; fails if it is missing
+//	mdDefault KEY DEFAULT  value of KEY, or DEFAULT if it is missing
+//	mdJSON KEY             value of KEY decoded as JSON
+//	mdKeys [PREFIX]        sorted listed keys, optionally only those starting with PREFIX
+//	base64decode S         S decoded from standard base64
+//	jsonpath EXPR V        the value at EXPR (e.g. "$.nics[0].ip") in V, JSON text or a decoded value
+//	toJSON V               V encoded as compact JSON
+//	trim S                 S without leading and trailing white space
+//	trimPrefix PREFIX S    S without PREFIX at its start
+//	trimSuffix SUFFIX S    S without SUFFIX at its end
+//	upper S, lower S       S in upper or lower case
+//	replace OLD NEW S      S with every OLD replaced by NEW
+//	split SEP S            the parts of S between each SEP
+//	join SEP LIST          the strings of LIST separated by SEP
+//	pemBlocks S            the PEM blocks of S, such as the certificates of a chain
+//	pemBlock N S           the PEM block of S at index N, counting from 0
+//	documents S            the documents of S separated by "---" lines, as in YAML
+//
+// Functions taking a string take it last, so they chain in pipelines such as
+// {{ md "app:hosts" | trim | split "," | join " " }}. pemBlock and documents
+// split a value holding several documents across files, one template each:
+// {{ md "tls:chain" | pemBlock 1 }} renders the first intermediate
+// certificate of a chain.
+//
+// IncludeFunc adds an include function for template files found on a search
+// path.
+package mdatatmpl
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/Smithx10/go-smartos-mdata/internal/jsonpath"
+	"github.com/Smithx10/go-smartos-mdata/mdata"
+	"github.com/Smithx10/go-smartos-mdata/mdataserver"
+)
+
+// ErrKeyNotFound is wrapped by errors from functions that require a missing key
+var ErrKeyNotFound = errors.New("key not found")
+
+// FuncMap returns the metadata template functions reading through client
+func FuncMap(client mdata.MetadataClient) template.FuncMap {
+	return StoreFuncMap(clientStore{client})
+}
+
+// StoreFuncMap returns the metadata template functions reading from store
+func StoreFuncMap(store mdataserver.Store) template.FuncMap {
+	get := func(key string) (string, error) {
+		value, ok, err := store.Get(key)
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			return "", fmt.Errorf("%w: %s", ErrKeyNotFound, key)
+		}
+		return value, nil
+	}
+
+	return template.FuncMap{
+		"md": get,
+		"mdDefault": func(key, def string) (string, error) {
+			value, err := get(key)
+			if errors.Is(err, ErrKeyNotFound) {
+				return def, nil
+			}
+			return value, err
+		},
+		"mdJSON": func(key string) (any, error) {
+			value, err := get(key)
+			if err != nil {
+				return nil, err
+			}
+			var v any
+			if err := json.Unmarshal([]byte(value), &v); err != nil {
+				return nil, fmt.Errorf("%s is not valid JSON: %w", key, err)
+			}
+			return v, nil
+		},
+		"mdKeys": func(prefix ...string) ([]string, error) {
+			if len(prefix) > 1 {
+				return nil, fmt.Errorf("mdKeys takes at most one prefix")
+			}
+			keys, err := store.Keys()
+			if err != nil {
+				return nil, err
+			}
+			var matched []string
+			for _, key := range keys {
+				if len(prefix) == 0 || strings.HasPrefix(key, prefix[0]) {
+					matched = append(matched, key)
+				}
+			}
+			sort.Strings(matched)
+			return matched, nil
+		},
+		"base64decode": func(s string) (string, error) {
+			decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+			if err != nil {
+				return "", fmt.Errorf("invalid base64: %w", err)
+			}
+			return string(decoded), nil
+		},
+		"jsonpath": func(expr string, v any) (any, error) {
+			if text, ok := v.(string); ok {
+				if err := json.Unmarshal([]byte(text), &v); err != nil {
+					return nil, fmt.Errorf("jsonpath: input is not valid JSON: %w", err)
+				}
+			}
+			return jsonpath.Eval(expr, v)
+		},
+		"toJSON": func(v any) (string, error) {
+			data, err := json.Marshal(v)
+			return string(data), err
+		},
+		"trim":       strings.TrimSpace,
+		"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+		"trimSuffix": func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+		"upper":      strings.ToUpper,
+		"lower":      strings.ToLower,
+		"replace":    func(old, new, s string) string { return strings.ReplaceAll(s, old, new) },
+		"split":      func(sep, s string) []string { return strings.Split(s, sep) },
+		"join":       func(sep string, list []string) string { return strings.Join(list, sep) },
+		"pemBlocks":  pemBlocks,
+		"pemBlock":   pemBlock,
+		"documents":  documents,
+	}
+}
+
+// clientStore adapts a MetadataClient to the read side of mdataserver.Store
+type clientStore struct {
+	client mdata.MetadataClient
+}
+
+func (c clientStore) Get(key string) (string, bool, error) {
+	value, err := c.client.Get(key)
+	if errors.Is(err, mdata.ErrNotFound) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return value, true, nil
+}
+
+func (c clientStore) Keys() ([]string, error) {
+	keys, err := c.client.Keys()
+	if err != nil {
+		return nil, err
+	}
+	if keys == "" {
+		return nil, nil
+	}
+	return strings.Split(keys, "\n"), nil
+}
+
+func (c clientStore) Put(key, value string) error { return c.client.Put(key, value) }
+
+func (c clientStore) Delete(key string) error { return c.client.Delete(key) }
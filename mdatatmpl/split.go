@@ -0,0 +1,53 @@
+package mdatatmpl
+
+import (
+	"encoding/pem"
+	"fmt"
+	"strings"
+)
+
+// pemBlocks returns the PEM blocks of s, such as the certificates of a chain,
+// each re-encoded with its BEGIN and END lines; text around them is ignored
+func pemBlocks(s string) []string {
+	var blocks []string
+	rest := []byte(s)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			return blocks
+		}
+		blocks = append(blocks, string(pem.EncodeToMemory(block)))
+	}
+}
+
+// pemBlock returns the PEM block of s at index n, counting from 0
+func pemBlock(n int, s string) (string, error) {
+	blocks := pemBlocks(s)
+	if n < 0 || n >= len(blocks) {
+		return "", fmt.Errorf("pemBlock: no block %d in a value of %d", n, len(blocks))
+	}
+	return blocks[n], nil
+}
+
+// documents returns the documents of s separated by "---" lines, as in a YAML
+// stream, leaving out documents holding only white space
+func documents(s string) []string {
+	var docs []string
+	var doc strings.Builder
+	flush := func() {
+		if strings.TrimSpace(doc.String()) != "" {
+			docs = append(docs, doc.String())
+		}
+		doc.Reset()
+	}
+	for _, line := range strings.SplitAfter(s, "\n") {
+		if strings.TrimRight(line, " \t\r\n") == "---" {
+			flush()
+			continue
+		}
+		doc.WriteString(line)
+	}
+	flush()
+	return docs
+}